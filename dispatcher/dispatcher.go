@@ -10,9 +10,9 @@ import (
 	"github.com/janpfeifer/gonb/specialcmd"
 	"github.com/pkg/errors"
 	"io"
-	"log"
 	"strings"
 	"sync"
+	"unicode/utf8"
 )
 
 const (
@@ -34,8 +34,8 @@ func RunKernel(k *kernel.Kernel, goExec *goexec.State) {
 				case msg := <-ch:
 					err := fn(msg, goExec)
 					if err != nil {
-						log.Printf("*** Failed to process incoming message: %+v", err)
-						log.Printf("*** Stopping kernel.")
+						kernel.Logf(kernel.LogLevelError, "*** Failed to process incoming message: %+v", err)
+						kernel.Logf(kernel.LogLevelError, "*** Stopping kernel.")
 						k.Stop()
 						return
 					}
@@ -52,7 +52,7 @@ func RunKernel(k *kernel.Kernel, goExec *goexec.State) {
 	})
 	poll(k.Shell(), handleMsg)
 	poll(k.Control(), func(msg kernel.Message, goExec *goexec.State) error {
-		log.Printf("Control MessageImpl: %+v", msg.ComposedMsg())
+		kernel.Debugf("Control MessageImpl: %+v", msg.ComposedMsg())
 		return handleMsg(msg, goExec)
 	})
 	wg.Wait()
@@ -86,7 +86,7 @@ func handleMsg(msg kernel.Message, goExec *goexec.State) (err error) {
 
 	switch msg.ComposedMsg().Header.MsgType {
 	case "kernel_info_request":
-		if err = kernel.SendKernelInfo(msg, Version); err != nil {
+		if err = kernel.SendKernelInfo(msg, Version, goExec.GoVersion, goExec.GoToolchainError()); err != nil {
 			err = errors.WithMessagef(err, "replying to 'kernel_info_request'")
 		}
 	case "shutdown_request":
@@ -102,14 +102,16 @@ func handleMsg(msg kernel.Message, goExec *goexec.State) (err error) {
 			err = errors.WithMessagef(err, "replying to 'inspect_request'")
 		}
 	case "is_complete_request":
-		log.Printf("Received is_complete_request: ignoring, since it's not a console like kernel.")
+		if err = handleIsCompleteRequest(msg, goExec); err != nil {
+			err = errors.WithMessagef(err, "replying to 'is_complete_request'")
+		}
 	case "complete_request":
-		if err := handleCompleteRequest(msg, goExec); err != nil {
-			log.Fatal(err)
+		if err = handleCompleteRequest(msg, goExec); err != nil {
+			err = errors.WithMessagef(err, "replying to 'complete_request'")
 		}
 	default:
 		// Log, ignore, and hope for the best.
-		log.Printf("unhandled shell message %q", msg.ComposedMsg().Header.MsgType)
+		kernel.Logf(kernel.LogLevelInfo, "unhandled shell message %q", msg.ComposedMsg().Header.MsgType)
 	}
 	return
 }
@@ -127,7 +129,7 @@ func handleShutdownRequest(msg kernel.Message) error {
 	if err := msg.Reply("shutdown_reply", reply); err != nil {
 		return errors.WithMessagef(err, "replying shutdown_reply")
 	}
-	log.Printf("Shutting down in response to shutdown_request")
+	kernel.Logf(kernel.LogLevelInfo, "Shutting down in response to shutdown_request")
 	msg.Kernel().Stop()
 	return nil
 }
@@ -137,8 +139,17 @@ type OutErr struct {
 	err io.Writer
 }
 
+// buildErrorRecorder is implemented by messages that record the build error of the execution, if
+// any, while the execution lock is still held -- so it's not overwritten by a following execution.
+type buildErrorRecorder interface {
+	recordBuildError(buildErr *goexec.BuildError)
+}
+
 // handleExecuteRequest runs code from an execute_request method,
 // and sends the various reply messages.
+//
+// Requests are handled one at a time, in the order they arrive (see goexec.State.LockExecution),
+// including the increment of the execution counter, so they can't race on the State.
 func handleExecuteRequest(msg kernel.Message, goExec *goexec.State) error {
 	// Extract the data from the request.
 	content := msg.ComposedMsg().Content.(map[string]interface{})
@@ -146,6 +157,9 @@ func handleExecuteRequest(msg kernel.Message, goExec *goexec.State) error {
 	silent := content["silent"].(bool)
 	storeHistory := content["store_history"].(bool)
 
+	goExec.LockExecution()
+	defer goExec.UnlockExecution()
+
 	// Prepare the map that will hold the reply content.
 	replyContent := make(map[string]interface{})
 	if storeHistory {
@@ -162,15 +176,31 @@ func handleExecuteRequest(msg kernel.Message, goExec *goexec.State) error {
 
 	// Dispatch to various executors.
 	msg.Kernel().Interrupted.Store(false)
+	defer goExec.ResetCell()
 	lines := strings.Split(code, "\n")
 	usedLines := make(map[int]bool)
 	var executionErr error
-	if err := specialcmd.Parse(msg, goExec, true, lines, usedLines); err != nil {
-		executionErr = errors.WithMessagef(err, "executing special commands in cell")
-	}
-	hasMoreToRun := len(usedLines) < len(lines)
-	if executionErr == nil && !msg.Kernel().Interrupted.Load() && hasMoreToRun {
-		executionErr = goExec.ExecuteCell(msg, lines, usedLines)
+	if err := specialcmd.Parse(msg, goExec, false, lines, usedLines); err == nil && specialcmd.IsFormatCell(lines, usedLines) {
+		// `%fmt`: the front-end replaces the cell with the formatted code, nothing is executed -- not
+		// even the other special commands.
+		var formatted string
+		formatted, executionErr = goexec.FormatCell(lines, usedLines)
+		if executionErr == nil {
+			replyContent["payload"] = []map[string]any{{
+				"source":  "set_next_input",
+				"text":    formatted,
+				"replace": true,
+			}}
+		}
+	} else {
+		usedLines = make(map[int]bool)
+		if err := specialcmd.Parse(msg, goExec, true, lines, usedLines); err != nil {
+			executionErr = errors.WithMessagef(err, "executing special commands in cell")
+		}
+		hasMoreToRun := len(usedLines) < len(lines)
+		if executionErr == nil && !msg.Kernel().Interrupted.Load() && hasMoreToRun {
+			executionErr = goExec.ExecuteCell(msg, lines, usedLines)
+		}
 	}
 
 	// Final execution result.
@@ -179,6 +209,9 @@ func handleExecuteRequest(msg kernel.Message, goExec *goexec.State) error {
 		replyContent["status"] = "ok"
 		replyContent["user_expressions"] = make(map[string]string)
 	} else {
+		if recorder, ok := msg.(buildErrorRecorder); ok {
+			recorder.recordBuildError(goExec.LastError())
+		}
 		replyContent["status"] = "error"
 		replyContent["ename"] = "ERROR"
 		replyContent["evalue"] = executionErr.Error()
@@ -208,29 +241,18 @@ func HandleInspectRequest(msg kernel.Message, goExec *goexec.State) error {
 	code := content["code"].(string)
 	cursorPos := int(content["cursor_pos"].(float64))
 	detailLevel := int(content["detail_level"].(float64))
-	log.Printf("inspect_request: cursorPos=%d, detailLevel=%d", cursorPos, detailLevel)
+	kernel.Debugf("inspect_request: cursorPos=%d, detailLevel=%d", cursorPos, detailLevel)
 
-	// Find cursorLine and cursorCol from cursorPos. Both are 0-based.
 	lines := strings.Split(code, "\n")
-	var cursorLine, cursorCol int
-	for pos := 0; cursorLine < len(lines) && pos < cursorPos; {
-		if pos+len(lines[cursorLine]) > cursorPos {
-			cursorCol = cursorPos - pos
-			break
-		}
-		pos += 1 + len(lines[cursorLine])
-		cursorLine++
-	}
+	cursorLine, cursorCol := cursorLineAndCol(lines, cursorPos)
 
-	// Separate special commands from Go commands.
+	// Separate special commands from Go commands. The cell may be incomplete while being edited:
+	// errors are logged, and nothing is found, instead of failing the request.
 	usedLines := make(map[int]bool)
-	if err := specialcmd.Parse(msg, goExec, false, lines, usedLines); err != nil {
-		return errors.WithMessagef(err, "parsing special commands in cell")
-	}
-
-	// Get data contents for reply.
 	var data kernel.MIMEMap
-	if usedLines[cursorLine] {
+	if err := specialcmd.Parse(msg, goExec, false, lines, usedLines); err != nil {
+		kernel.Logf(kernel.LogLevelError, "Failed to parse special commands for inspect(line=%d, col=%d): %+v", cursorLine+1, cursorCol+1, err)
+	} else if usedLines[cursorLine] {
 		// If special command, use our help message as inspect content.
 		data = kernel.MIMEMap{protocol.MIMETextPlain: any(specialcmd.HelpMessage)}
 	} else {
@@ -255,26 +277,91 @@ func HandleInspectRequest(msg kernel.Message, goExec *goexec.State) error {
 	return msg.Reply("inspect_reply", reply)
 }
 
-// handleCompleteRequest replies with a `complete_reply` message, to auto-complete code.
+// handleIsCompleteRequest replies with an `is_complete_reply` message, telling console front-ends
+// whether the code entered so far can be executed, or whether they should keep reading input.
+func handleIsCompleteRequest(msg kernel.Message, goExec *goexec.State) error {
+	content := msg.ComposedMsg().Content.(map[string]interface{})
+	code := content["code"].(string)
+	lines := strings.Split(code, "\n")
+
+	// Separate special commands from Go commands.
+	usedLines := make(map[int]bool)
+	reply := &kernel.IsCompleteReply{}
+	if err := specialcmd.Parse(msg, goExec, false, lines, usedLines); err != nil {
+		reply.Status = goexec.CellInvalid
+		return msg.Reply("is_complete_reply", reply)
+	}
+	var err error
+	reply.Status, reply.Indent, err = goExec.IsComplete(lines, usedLines)
+	if err != nil {
+		kernel.Logf(kernel.LogLevelError, "Failed to check whether cell is complete: %+v", err)
+		reply.Status = "unknown"
+	}
+	return msg.Reply("is_complete_reply", reply)
+}
+
+// cursorLineAndCol converts the position of the cursor in the code of a cell (the lines joined by
+// "\n") to its line and column. Both are 0-based.
+//
+// Jupyter counts cursorPos in Unicode code points (runes), while the returned column is a byte offset
+// into the line, as used to slice Go strings.
+func cursorLineAndCol(lines []string, cursorPos int) (cursorLine, cursorCol int) {
+	for pos := 0; cursorLine < len(lines) && pos < cursorPos; {
+		lineLen := utf8.RuneCountInString(lines[cursorLine])
+		if pos+lineLen >= cursorPos {
+			cursorCol = runeOffsetToByte(lines[cursorLine], cursorPos-pos)
+			break
+		}
+		pos += 1 + lineLen
+		cursorLine++
+	}
+	return
+}
+
+// runeOffsetToByte converts the offset in runes into line to an offset in bytes.
+func runeOffsetToByte(line string, runeOffset int) int {
+	for byteOffset := range line {
+		if runeOffset == 0 {
+			return byteOffset
+		}
+		runeOffset--
+	}
+	return len(line)
+}
+
+// handleCompleteRequest replies with a `complete_reply` message, to auto-complete code: the
+// identifier before the cursor is completed with the symbols of all cells, see
+// goexec.State.CompleteCell.
 func handleCompleteRequest(msg kernel.Message, goExec *goexec.State) error {
-	_ = goExec
-	// TODO: Implementing this likely requiring generating the main.go -- and tracking
-	//       the adjusted cursor position -- and using `gopls` to request contextual information.
-	//       Similar to HandleInspectRequest.
-	// Extract the data from the request.
 	content := msg.ComposedMsg().Content.(map[string]interface{})
 	code := content["code"].(string)
-	cursorPos := content["cursor_pos"].(float64)
-	_ = code
-	_ = cursorPos
+	cursorPos := int(content["cursor_pos"].(float64))
+	kernel.Debugf("complete_request: cursorPos=%d", cursorPos)
 
-	log.Printf("\tCompleteRequest")
+	lines := strings.Split(code, "\n")
+	cursorLine, cursorCol := cursorLineAndCol(lines, cursorPos)
 	reply := &kernel.CompleteReply{
 		Status:      "ok",
 		Matches:     []string{},
-		CursorStart: 0,
-		CursorEnd:   0,
+		CursorStart: cursorPos,
+		CursorEnd:   cursorPos,
 		Metadata:    make(kernel.MIMEMap),
 	}
+
+	// Separate special commands from Go commands. The cell may be incomplete while being edited:
+	// errors are logged, and there are no matches, instead of failing the request.
+	usedLines := make(map[int]bool)
+	if err := specialcmd.Parse(msg, goExec, false, lines, usedLines); err != nil {
+		kernel.Logf(kernel.LogLevelError, "Failed to parse special commands for complete(line=%d, col=%d): %+v", cursorLine+1, cursorCol+1, err)
+		return msg.Reply("complete_reply", reply)
+	}
+	matches, prefixLen, err := goExec.CompleteCell(lines, usedLines, cursorLine, cursorCol)
+	if err != nil {
+		kernel.Logf(kernel.LogLevelError, "Failed to complete(line=%d, col=%d): %+v", cursorLine+1, cursorCol+1, err)
+	} else if len(matches) > 0 {
+		reply.Matches = matches
+		// prefixLen is in bytes, but Jupyter counts positions in runes.
+		reply.CursorStart = cursorPos - utf8.RuneCountInString(lines[cursorLine][cursorCol-prefixLen:cursorCol])
+	}
 	return msg.Reply("complete_reply", reply)
 }
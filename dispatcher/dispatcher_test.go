@@ -0,0 +1,72 @@
+package dispatcher
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/janpfeifer/gonb/goexec"
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursorLineAndCol(t *testing.T) {
+	code := "x := 1\n\ts := \"😀é\" + x\ny"
+	lines := strings.Split(code, "\n")
+	for _, tc := range []struct {
+		cursorPos, line, col int
+	}{
+		{0, 0, 0},
+		{6, 0, 6},                         // End of first line.
+		{7, 1, 0},                         // Start of second line.
+		{8, 1, 1},                         // After the tab.
+		{15, 1, len("\ts := \"😀")},        // After the emoji: 1 rune, 4 bytes.
+		{16, 1, len("\ts := \"😀é")},       // After the "é": 1 rune, 2 bytes.
+		{21, 1, len("\ts := \"😀é\" + x")}, // End of second line.
+		{23, 2, 1},
+	} {
+		line, col := cursorLineAndCol(lines, tc.cursorPos)
+		assert.Equalf(t, tc.line, line, "cursorPos=%d", tc.cursorPos)
+		assert.Equalf(t, tc.col, col, "cursorPos=%d", tc.cursorPos)
+	}
+}
+
+// replyMessage is a kernel.Message for requests, that records the replies. Methods not implemented
+// panic.
+type replyMessage struct {
+	kernel.Message
+	content map[string]any
+	replies []any
+}
+
+func (m *replyMessage) ComposedMsg() kernel.ComposedMsg {
+	return kernel.ComposedMsg{Content: m.content}
+}
+
+func (m *replyMessage) Reply(_ string, content any) error {
+	m.replies = append(m.replies, content)
+	return nil
+}
+
+// TestRequestsWithInvalidCell checks that inspect and complete requests on a cell that can't be
+// parsed (e.g.: while being edited) are answered, instead of returning an error, which stops the kernel.
+func TestRequestsWithInvalidCell(t *testing.T) {
+	goExec, err := goexec.NewState(goexec.WithTempDir(t.TempDir()), goexec.WithAutoGet(false))
+	require.NoError(t, err)
+	for _, code := range []string{
+		"%%if goos == linux\nfmt.Pri",                  // Unbalanced %%if.
+		"%%endif\nfmt.Pri",                             // Unbalanced %%endif.
+		"var x = 1\n%%html\n<b>fmt.Pri</b>\n%%if goos", // Cell transformer after Go code.
+	} {
+		content := map[string]any{"code": code, "cursor_pos": float64(len(code)), "detail_level": float64(0)}
+		msg := &replyMessage{content: content}
+		require.NoError(t, handleCompleteRequest(msg, goExec), "code: %q", code)
+		require.Len(t, msg.replies, 1)
+		assert.Equal(t, "ok", msg.replies[0].(*kernel.CompleteReply).Status)
+
+		msg = &replyMessage{content: content}
+		require.NoError(t, HandleInspectRequest(msg, goExec), "code: %q", code)
+		require.Len(t, msg.replies, 1)
+		assert.Equal(t, "ok", msg.replies[0].(*kernel.InspectReply).Status)
+	}
+}
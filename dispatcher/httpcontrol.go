@@ -0,0 +1,296 @@
+package dispatcher
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/goexec"
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// This file implements an optional HTTP control endpoint, that executes code posted to it and
+// returns the outputs, bypassing the Jupyter (ZeroMQ) transport. It is meant for integration
+// tests and remote tooling.
+//
+// Requests are POSTed to "/execute", with a JSON object like `{"code": "..."}`. The reply is a
+// JSON object with the "status" ("ok" or "error"), the "error" if any, the list of "outputs"
+// that would have been published to Jupyter -- each with its "msg_type" (e.g.: "stream",
+// "display_data", "error") and "content" -- the result "metadata" if any, and the compiler
+// "diagnostics" if the compilation failed.
+//
+// Since the endpoint executes arbitrary code, requests must carry the kernel's token in the
+// HTTPControlTokenHeader header (see HTTPControl.Token), have a JSON Content-Type, and a Host
+// header with an IP address, localhost or the host the endpoint listens to. The latter two protect
+// it from pages opened in a browser (cross-site requests and DNS rebinding).
+
+// HTTPControlPath is the path of the HTTP control endpoint that executes code.
+const HTTPControlPath = "/execute"
+
+// HTTPControlTokenHeader is the header of the requests to the HTTP control endpoint with its token.
+const HTTPControlTokenHeader = "X-Gonb-Token"
+
+// HTTPControlTokenEnv is the environment variable with the token of the HTTP control endpoint. If
+// not set, a random token is generated for each kernel.
+const HTTPControlTokenEnv = "GONB_HTTP_CONTROL_TOKEN"
+
+// HTTPControlRequest is the JSON body of a request to the HTTP control endpoint.
+type HTTPControlRequest struct {
+	Code string `json:"code"`
+}
+
+// HTTPControlOutput is one of the outputs published while executing a HTTPControlRequest.
+type HTTPControlOutput struct {
+	MsgType string `json:"msg_type"`
+	Content any    `json:"content"`
+}
+
+// HTTPControlResponse is the JSON body of the response of the HTTP control endpoint.
+type HTTPControlResponse struct {
+	Status  string              `json:"status"`
+	Error   string              `json:"error,omitempty"`
+	Outputs []HTTPControlOutput `json:"outputs"`
+
+	// Metadata reported by the program, see gonbui.SetResultMetadata.
+	Metadata map[string]any `json:"metadata,omitempty"`
+
+	// Diagnostics of the compilation, if it failed. See goexec.State.LastError.
+	Diagnostics []goexec.Diagnostic `json:"diagnostics,omitempty"`
+}
+
+// HTTPControl serves the HTTP control endpoint, see ServeHTTPControl.
+type HTTPControl struct {
+	k        *kernel.Kernel
+	goExec   *goexec.State
+	listener net.Listener
+	server   *http.Server
+
+	// token required in the HTTPControlTokenHeader of the requests, and host the endpoint was
+	// asked to listen to.
+	token, host string
+}
+
+// ServeHTTPControl starts serving the HTTP control endpoint on the given address (e.g.:
+// "localhost:8888"). Code is executed with goExec, the same way as cells in the notebook,
+// and the kernel k is used for interruptions.
+//
+// If the host of the address is empty, it binds to "localhost": the endpoint executes arbitrary
+// code, so it shouldn't be exposed to other hosts unless explicitly asked for. A port "0" picks
+// a free port, see Addr.
+//
+// The token required by the requests is taken from the environment variable HTTPControlTokenEnv,
+// or randomly generated otherwise. The address and the token are logged.
+func ServeHTTPControl(k *kernel.Kernel, goExec *goexec.State, address string) (*HTTPControl, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid address %q for HTTP control endpoint", address)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	c := &HTTPControl{k: k, goExec: goExec, host: host, token: os.Getenv(HTTPControlTokenEnv)}
+	if c.token == "" {
+		tokenBytes := make([]byte, 16)
+		if _, err = rand.Read(tokenBytes); err != nil {
+			return nil, errors.Wrapf(err, "failed to generate token for HTTP control endpoint")
+		}
+		c.token = hex.EncodeToString(tokenBytes)
+	}
+	c.listener, err = net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, errors.Wrapf(err, "listening on %q for HTTP control endpoint", address)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(HTTPControlPath, c.handleExecute)
+	c.server = &http.Server{Handler: mux}
+	go func() {
+		if err := c.server.Serve(c.listener); err != nil && err != http.ErrServerClosed {
+			kernel.Logf(kernel.LogLevelError, "HTTP control endpoint failed: %+v", err)
+		}
+	}()
+	kernel.Logf(kernel.LogLevelInfo, "HTTP control endpoint serving on http://%s%s, with %s: %s", c.Addr(), HTTPControlPath,
+		HTTPControlTokenHeader, c.token)
+	return c, nil
+}
+
+// Token returns the token required in the HTTPControlTokenHeader header of the requests.
+func (c *HTTPControl) Token() string {
+	return c.token
+}
+
+// Addr returns the address the HTTP control endpoint is listening to.
+func (c *HTTPControl) Addr() string {
+	return c.listener.Addr().String()
+}
+
+// Close stops serving the HTTP control endpoint.
+func (c *HTTPControl) Close() error {
+	return c.server.Shutdown(context.Background())
+}
+
+// handleExecute executes the code of a HTTPControlRequest, and replies with a HTTPControlResponse.
+func (c *HTTPControl) handleExecute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("method %s not allowed, use POST", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	if !c.isAllowedHost(r.Host) {
+		http.Error(w, fmt.Sprintf("host %q not allowed", r.Host), http.StatusForbidden)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(HTTPControlTokenHeader)), []byte(c.token)) != 1 {
+		http.Error(w, fmt.Sprintf("missing or invalid %s header", HTTPControlTokenHeader), http.StatusUnauthorized)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, fmt.Sprintf("unsupported Content-Type %q, use \"application/json\"", r.Header.Get("Content-Type")),
+			http.StatusUnsupportedMediaType)
+		return
+	}
+	var req HTTPControlRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	msg := newHTTPMessage(c.k, req.Code)
+	if err := handleExecuteRequest(msg, c.goExec); err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute: %+v", err), http.StatusInternalServerError)
+		return
+	}
+	resp := msg.response()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		kernel.Logf(kernel.LogLevelError, "HTTP control endpoint failed to write response: %+v", err)
+	}
+}
+
+// isAllowedHost returns whether the Host header of a request names the endpoint: localhost, an IP
+// address, or the host it was asked to listen to. Other names may be pointing to the endpoint only
+// to circumvent the browser's same-origin policy (DNS rebinding).
+func (c *HTTPControl) isAllowedHost(hostHeader string) bool {
+	host := hostHeader
+	if h, _, err := net.SplitHostPort(hostHeader); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.EqualFold(host, "localhost") || strings.EqualFold(host, c.host) {
+		return true
+	}
+	return net.ParseIP(host) != nil
+}
+
+// httpMessage implements kernel.Message for requests to the HTTP control endpoint: instead of
+// sending the outputs to Jupyter, it records them to be returned in the HTTP response.
+type httpMessage struct {
+	k        *kernel.Kernel
+	composed kernel.ComposedMsg
+
+	mu             sync.Mutex
+	outputs        []HTTPControlOutput
+	reply          map[string]any
+	resultMetadata map[string]any
+	buildErr       *goexec.BuildError
+}
+
+var _ kernel.Message = (*httpMessage)(nil)
+
+// newHTTPMessage creates an "execute_request" message for the given code.
+func newHTTPMessage(k *kernel.Kernel, code string) *httpMessage {
+	m := &httpMessage{k: k}
+	m.composed.Header.MsgType = "execute_request"
+	m.composed.Content = map[string]any{
+		"code":   code,
+		"silent": true, // The input is not echoed back.
+		// Executions from the HTTP endpoint don't increment the execution counter of the notebook.
+		"store_history": false,
+	}
+	return m
+}
+
+// Error implements kernel.Message. It is always nil.
+func (m *httpMessage) Error() error { return nil }
+
+// Ok implements kernel.Message. It is always true.
+func (m *httpMessage) Ok() bool { return true }
+
+// ComposedMsg implements kernel.Message.
+func (m *httpMessage) ComposedMsg() kernel.ComposedMsg { return m.composed }
+
+// Kernel implements kernel.Message.
+func (m *httpMessage) Kernel() *kernel.Kernel { return m.k }
+
+// Publish implements kernel.Message, by recording the output.
+func (m *httpMessage) Publish(msgType string, content interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outputs = append(m.outputs, HTTPControlOutput{MsgType: msgType, Content: content})
+	return nil
+}
+
+// PromptInput implements kernel.Message. Input is not supported by the HTTP control endpoint.
+func (m *httpMessage) PromptInput(_ string, _ bool, _ kernel.OnInputFn) error {
+	return errors.New("input is not supported by the HTTP control endpoint")
+}
+
+// CancelInput implements kernel.Message. It is a no-op.
+func (m *httpMessage) CancelInput() error { return nil }
+
+// DeliverInput implements kernel.Message. It is a no-op.
+func (m *httpMessage) DeliverInput() error { return nil }
+
+// Reply implements kernel.Message, by recording the reply content.
+func (m *httpMessage) Reply(_ string, content interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reply, _ = content.(map[string]any)
+	return nil
+}
+
+// MergeResultMetadata implements kernel.Message.
+func (m *httpMessage) MergeResultMetadata(metadata map[string]any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.resultMetadata == nil {
+		m.resultMetadata = make(map[string]any)
+	}
+	for key, value := range metadata {
+		m.resultMetadata[key] = value
+	}
+}
+
+// recordBuildError implements buildErrorRecorder.
+func (m *httpMessage) recordBuildError(buildErr *goexec.BuildError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buildErr = buildErr
+}
+
+// response returns the HTTPControlResponse with what was recorded.
+func (m *httpMessage) response() *HTTPControlResponse {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	resp := &HTTPControlResponse{Status: "ok", Outputs: m.outputs, Metadata: m.resultMetadata}
+	if status, ok := m.reply["status"].(string); ok {
+		resp.Status = status
+	}
+	if evalue, ok := m.reply["evalue"].(string); ok {
+		resp.Error = evalue
+	}
+	if m.buildErr != nil && resp.Status == "error" {
+		resp.Diagnostics = m.buildErr.Diagnostics
+	}
+	if resp.Outputs == nil {
+		resp.Outputs = []HTTPControlOutput{}
+	}
+	return resp
+}
@@ -0,0 +1,136 @@
+package dispatcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/janpfeifer/gonb/goexec"
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPControl(t *testing.T) {
+	goExec, err := goexec.NewState(goexec.WithTempDir(t.TempDir()), goexec.WithAutoGet(false))
+	require.NoError(t, err)
+	c, err := ServeHTTPControl(&kernel.Kernel{}, goExec, ":0")
+	require.NoError(t, err)
+	defer func() { _ = c.Close() }()
+	assert.Contains(t, c.Addr(), "127.0.0.1:")
+	url := "http://" + c.Addr() + HTTPControlPath
+
+	assert.Len(t, c.Token(), 32)
+	post := func(code string, setHeaders func(req *http.Request)) *http.Response {
+		body, err := json.Marshal(&HTTPControlRequest{Code: code})
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		req.Header.Set(HTTPControlTokenHeader, c.Token())
+		if setHeaders != nil {
+			setHeaders(req)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	execute := func(code string) *HTTPControlResponse {
+		resp := post(code, nil)
+		defer func() { _ = resp.Body.Close() }()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var controlResp HTTPControlResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&controlResp))
+		return &controlResp
+	}
+
+	resp := execute("%help")
+	assert.Equal(t, "ok", resp.Status)
+	require.Len(t, resp.Outputs, 1)
+	assert.Equal(t, "stream", resp.Outputs[0].MsgType)
+
+	resp = execute("%display missing")
+	assert.Equal(t, "error", resp.Status)
+	assert.Contains(t, resp.Error, "missing")
+
+	httpResp, err := http.Get(url)
+	require.NoError(t, err)
+	_ = httpResp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, httpResp.StatusCode)
+
+	// Requests without the token, not in JSON, or for other hosts are rejected.
+	for _, tc := range []struct {
+		setHeaders func(req *http.Request)
+		status     int
+	}{
+		{func(req *http.Request) { req.Header.Del(HTTPControlTokenHeader) }, http.StatusUnauthorized},
+		{func(req *http.Request) { req.Header.Set(HTTPControlTokenHeader, "wrong") }, http.StatusUnauthorized},
+		{func(req *http.Request) { req.Header.Set("Content-Type", "text/plain") }, http.StatusUnsupportedMediaType},
+		{func(req *http.Request) { req.Header.Del("Content-Type") }, http.StatusUnsupportedMediaType},
+		{func(req *http.Request) { req.Host = "attacker.example.com" }, http.StatusForbidden},
+	} {
+		httpResp = post("%help", tc.setHeaders)
+		_ = httpResp.Body.Close()
+		assert.Equal(t, tc.status, httpResp.StatusCode)
+	}
+	httpResp = post("%help", func(req *http.Request) { req.Host = "localhost" })
+	_ = httpResp.Body.Close()
+	assert.Equal(t, http.StatusOK, httpResp.StatusCode)
+}
+
+func TestHTTPControlTokenEnv(t *testing.T) {
+	goExec, err := goexec.NewState(goexec.WithTempDir(t.TempDir()), goexec.WithAutoGet(false))
+	require.NoError(t, err)
+	t.Setenv(HTTPControlTokenEnv, "my-token")
+	c, err := ServeHTTPControl(&kernel.Kernel{}, goExec, "localhost:0")
+	require.NoError(t, err)
+	defer func() { _ = c.Close() }()
+	assert.Equal(t, "my-token", c.Token())
+}
+
+// TestConcurrentExecutions checks that cells executed concurrently are serialized, and none of
+// their declarations are lost.
+func TestConcurrentExecutions(t *testing.T) {
+	goExec, err := goexec.NewState(goexec.WithTempDir(t.TempDir()), goexec.WithAutoGet(false))
+	require.NoError(t, err)
+	if goExec.GoToolchainError() != nil {
+		t.Skipf("go toolchain not available: %v", goExec.GoToolchainError())
+	}
+	goExec.SkipGoImports = true // goimports may not be installed.
+	k := &kernel.Kernel{}
+	const numCells = 4
+	var wg sync.WaitGroup
+	messages := make([]*httpMessage, numCells)
+	for ii := range messages {
+		messages[ii] = newHTTPMessage(k, fmt.Sprintf("func f%d() int { return %d }", ii, ii))
+		wg.Add(1)
+		go func(msg *httpMessage) {
+			defer wg.Done()
+			assert.NoError(t, handleExecuteRequest(msg, goExec))
+		}(messages[ii])
+	}
+	wg.Wait()
+	for ii, msg := range messages {
+		assert.Equal(t, "ok", msg.response().Status, "cell #%d", ii)
+		assert.Contains(t, goExec.Decls.Functions, fmt.Sprintf("f%d", ii))
+	}
+}
+
+// TestFormatCell checks that a cell with `%fmt` is formatted, without executing anything.
+func TestFormatCell(t *testing.T) {
+	goExec, err := goexec.NewState(goexec.WithTempDir(t.TempDir()), goexec.WithAutoGet(false))
+	require.NoError(t, err)
+	touched := filepath.Join(t.TempDir(), "touched")
+	msg := newHTTPMessage(&kernel.Kernel{}, fmt.Sprintf("%%fmt\n!touch %s\n%%env GONB_TEST_FMT 1\nvar  x=1", touched))
+	require.NoError(t, handleExecuteRequest(msg, goExec))
+	assert.Equal(t, "ok", msg.response().Status)
+	payload := msg.reply["payload"].([]map[string]any)
+	require.Len(t, payload, 1)
+	assert.Equal(t, fmt.Sprintf("!touch %s\n%%env GONB_TEST_FMT 1\nvar x = 1", touched), payload[0]["text"])
+	assert.NoFileExists(t, touched)
+	assert.Empty(t, goExec.Env)
+}
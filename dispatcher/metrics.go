@@ -0,0 +1,78 @@
+package dispatcher
+
+import (
+	"context"
+	"fmt"
+	"github.com/janpfeifer/gonb/goexec"
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+)
+
+// This file implements an optional HTTP endpoint exposing the metrics of the kernel (see
+// goexec.Metrics) in the Prometheus text format, for operators monitoring deployments of GoNB.
+
+// MetricsPath is the path of the HTTP endpoint serving the metrics.
+const MetricsPath = "/metrics"
+
+// MetricsServer serves the metrics of the kernel, see ServeMetrics.
+type MetricsServer struct {
+	goExec   *goexec.State
+	listener net.Listener
+	server   *http.Server
+}
+
+// ServeMetrics starts serving the metrics of goExec on the given address (e.g.: ":9090"), under
+// MetricsPath.
+//
+// If the host of the address is empty, it binds to "localhost", like ServeHTTPControl. To expose
+// the metrics to other hosts (e.g.: a Prometheus server), give the host explicitly, e.g.: "0.0.0.0:9090".
+// The port "0" picks a free port, see Addr. The samples are labeled with the kernel id
+// (goexec.State.UniqueID).
+func ServeMetrics(goExec *goexec.State, address string) (*MetricsServer, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid address %q for metrics endpoint", address)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	m := &MetricsServer{goExec: goExec}
+	m.listener, err = net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, errors.Wrapf(err, "listening on %q for metrics endpoint", address)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(MetricsPath, m.handleMetrics)
+	m.server = &http.Server{Handler: mux}
+	go func() {
+		if err := m.server.Serve(m.listener); err != nil && err != http.ErrServerClosed {
+			kernel.Logf(kernel.LogLevelError, "Metrics endpoint failed: %+v", err)
+		}
+	}()
+	kernel.Logf(kernel.LogLevelInfo, "Metrics endpoint serving on http://%s%s", m.Addr(), MetricsPath)
+	return m, nil
+}
+
+// Addr returns the address the metrics endpoint is listening to.
+func (m *MetricsServer) Addr() string {
+	return m.listener.Addr().String()
+}
+
+// Close stops serving the metrics endpoint.
+func (m *MetricsServer) Close() error {
+	return m.server.Shutdown(context.Background())
+}
+
+// handleMetrics replies with the metrics in the Prometheus text format.
+func (m *MetricsServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, fmt.Sprintf("method %s not allowed, use GET", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := m.goExec.Metrics.WritePrometheus(w, m.goExec.UniqueID); err != nil {
+		kernel.Logf(kernel.LogLevelError, "Metrics endpoint failed to write response: %+v", err)
+	}
+}
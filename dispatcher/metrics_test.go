@@ -0,0 +1,36 @@
+package dispatcher
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/janpfeifer/gonb/goexec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeMetrics(t *testing.T) {
+	goExec, err := goexec.NewState(goexec.WithTempDir(t.TempDir()), goexec.WithAutoGet(false), goexec.WithUniqueID("abc123"))
+	require.NoError(t, err)
+	m, err := ServeMetrics(goExec, ":0")
+	require.NoError(t, err)
+	defer func() { _ = m.Close() }()
+	assert.Contains(t, m.Addr(), "127.0.0.1:")
+
+	goExec.Metrics.CellsExecuted.Add(3)
+	resp, err := http.Get("http://" + m.Addr() + MetricsPath)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "gonb_cells_executed_total{kernel_id=\"abc123\"} 3\n")
+
+	// A second kernel with the same address ":0" listens to another port.
+	m2, err := ServeMetrics(goExec, ":0")
+	require.NoError(t, err)
+	defer func() { _ = m2.Close() }()
+	assert.NotEqual(t, m.Addr(), m2.Addr())
+}
@@ -8,6 +8,7 @@ require (
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.8.1
 	golang.org/x/exp v0.0.0-20230210204819-062eb4c674ab
+	golang.org/x/mod v0.13.0
 )
 
 require (
@@ -0,0 +1,69 @@
+package goexec
+
+import (
+	"github.com/pkg/errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// appendToFunction implements `%append <funcName>`: it returns the lines of a cell that redefines
+// the function State.Cell.Append, with the Go lines of the cell (those not in skipLines) appended
+// to the end of its body.
+//
+// The new definition is executed as any other cell, so it is only committed to State.Decls if it
+// compiles. Methods are given as `Type.Method`.
+func (s *State) appendToFunction(lines []string, skipLines map[int]bool) ([]string, map[int]bool, error) {
+	key := strings.Replace(s.Cell.Append, ".", "~", 1)
+	funcDecl, found := s.Decls.Functions[key]
+	if !found {
+		return nil, nil, errors.Errorf("%%append: function %q not defined in previous cells", s.Cell.Append)
+	}
+	var body []string
+	for ii, line := range lines {
+		if !skipLines[ii] {
+			body = append(body, line)
+		}
+	}
+	definition, err := appendToFunctionBody(funcDecl.Definition, body)
+	if err != nil {
+		return nil, nil, errors.WithMessagef(err, "%%append to function %q", s.Cell.Append)
+	}
+	return strings.Split(definition, "\n"), make(map[int]bool), nil
+}
+
+// appendToFunctionBody returns the function definition with the lines inserted (indented) before
+// the closing brace of its body.
+func appendToFunctionBody(definition string, lines []string) (string, error) {
+	const header = "package main\n\n"
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, "", header+definition, parser.SkipObjectResolution)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse function definition")
+	}
+	if len(file.Decls) != 1 {
+		return "", errors.Errorf("expected one function definition, found %d declarations", len(file.Decls))
+	}
+	funcDecl, ok := file.Decls[0].(*ast.FuncDecl)
+	if !ok || funcDecl.Body == nil {
+		return "", errors.Errorf("definition is not a function with a body")
+	}
+	rbrace := fileSet.Position(funcDecl.Body.Rbrace).Offset - len(header)
+
+	var sb strings.Builder
+	before := strings.TrimRight(definition[:rbrace], " \t")
+	sb.WriteString(before)
+	if !strings.HasSuffix(before, "\n") {
+		sb.WriteString("\n")
+	}
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			sb.WriteString("\t")
+			sb.WriteString(line)
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString(definition[rbrace:])
+	return sb.String(), nil
+}
@@ -0,0 +1,35 @@
+package goexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendToFunctionBody(t *testing.T) {
+	got, err := appendToFunctionBody("func setup() {\n\tfmt.Println(\"}\")\n}", []string{"a := 1", "", "_ = a"})
+	require.NoError(t, err)
+	assert.Equal(t, "func setup() {\n\tfmt.Println(\"}\")\n\ta := 1\n\n\t_ = a\n}", got)
+
+	got, err = appendToFunctionBody("func (p *Point) Reset() {}", []string{"p.X = 0"})
+	require.NoError(t, err)
+	assert.Equal(t, "func (p *Point) Reset() {\n\tp.X = 0\n}", got)
+
+	_, err = appendToFunctionBody("var x = 1", nil)
+	assert.Error(t, err)
+}
+
+func TestAppendToFunction(t *testing.T) {
+	s := &State{Decls: NewDeclarations()}
+	s.Decls.Functions["Point~Reset"] = &Function{Key: "Point~Reset", Definition: "func (p *Point) Reset() {\n}"}
+	s.Cell.Append = "Point.Reset"
+	lines, skipLines, err := s.appendToFunction([]string{"%append Point.Reset", "p.Y = 0"}, map[int]bool{0: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"func (p *Point) Reset() {", "\tp.Y = 0", "}"}, lines)
+	assert.Empty(t, skipLines)
+
+	s.Cell.Append = "undefined"
+	_, _, err = s.appendToFunction(nil, nil)
+	assert.Error(t, err)
+}
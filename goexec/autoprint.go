@@ -0,0 +1,66 @@
+package goexec
+
+import (
+	"github.com/pkg/errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+)
+
+// This file implements `%autoprint on|off` (see State.AutoPrint): the value of an expression at
+// the end of the program is printed, REPL style.
+//
+// Only expressions whose value would otherwise be discarded -- and hence rejected by the compiler --
+// qualify: that is, a last statement of the main function that is an expression but not a function
+// call (e.g.: `x`, `a+b`, `m["k"]`). Function calls are executed as usual, since they may have no
+// value, and assignments are never printed.
+
+// autoPrintMain returns mainDecl changed to print the value of its last statement, if it qualifies
+// for auto-printing. Otherwise, mainDecl is returned unchanged.
+func autoPrintMain(mainDecl *Function) (*Function, error) {
+	const header = "package main\n"
+	fileSet := token.NewFileSet()
+	f, err := parser.ParseFile(fileSet, "", header+mainDecl.Definition, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing main function for %%autoprint")
+	}
+	var funcDecl *ast.FuncDecl
+	for _, decl := range f.Decls {
+		if fd, ok := decl.(*ast.FuncDecl); ok && fd.Name.Name == "main" && fd.Recv == nil {
+			funcDecl = fd
+		}
+	}
+	if funcDecl == nil || funcDecl.Body == nil || len(funcDecl.Body.List) == 0 {
+		return mainDecl, nil
+	}
+	exprStmt, ok := funcDecl.Body.List[len(funcDecl.Body.List)-1].(*ast.ExprStmt)
+	if !ok || !isAutoPrintable(exprStmt.X) {
+		return mainDecl, nil
+	}
+	start := fileSet.Position(exprStmt.Pos()).Offset - len(header)
+	end := fileSet.Position(exprStmt.End()).Offset - len(header)
+	definition := mainDecl.Definition
+	newMainDecl := *mainDecl
+	newMainDecl.Definition = definition[:start] + "fmt.Println(" + definition[start:end] + ")" + definition[end:]
+	return &newMainDecl, nil
+}
+
+// isAutoPrintable returns whether the expression qualifies for auto-printing: function calls and
+// channel receives are valid statements on their own, so they are not printed.
+func isAutoPrintable(expr ast.Expr) bool {
+	for {
+		paren, ok := expr.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		expr = paren.X
+	}
+	switch typedExpr := expr.(type) {
+	case *ast.CallExpr:
+		return false
+	case *ast.UnaryExpr:
+		return typedExpr.Op != token.ARROW
+	default:
+		return true
+	}
+}
@@ -0,0 +1,29 @@
+package goexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoPrintMain(t *testing.T) {
+	for _, tc := range []struct{ definition, want string }{
+		{"func main() {\n\tx := 1\n\tx+1\n}", "func main() {\n\tx := 1\n\tfmt.Println(x+1)\n}"},
+		{"func main() {\n\tm[\"k\"]  // Comment.\n}", "func main() {\n\tfmt.Println(m[\"k\"])  // Comment.\n}"},
+		// Not printed: calls, channel receives, assignments and empty main.
+		{"func main() {\n\tmath.Sqrt(2)\n}", ""},
+		{"func main() {\n\t<-ch\n}", ""},
+		{"func main() {\n\tx = 2\n}", ""},
+		{"func main() {}", ""},
+	} {
+		mainDecl := &Function{Key: "main", Name: "main", Definition: tc.definition}
+		got, err := autoPrintMain(mainDecl)
+		require.NoError(t, err)
+		if tc.want == "" {
+			assert.Equal(t, mainDecl, got)
+		} else {
+			assert.Equal(t, tc.want, got.Definition)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package goexec
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// This file implements BuildError, to expose the errors of the last compilation to callers embedding
+// the engine (e.g.: the HTTP control endpoint), without parsing what was displayed.
+
+// BuildError holds the output of a failed compilation, and the diagnostics parsed from it.
+type BuildError struct {
+	// Output of `go build`, with secrets redacted.
+	Output string
+
+	// Diagnostics parsed from Output, in the order they were reported.
+	Diagnostics []Diagnostic
+}
+
+// Error implements the error interface.
+func (e *BuildError) Error() string {
+	return e.Output
+}
+
+// Diagnostic is one error reported by the compiler.
+type Diagnostic struct {
+	File    string `json:"file"`   // File relative to State.TempDir, usually "main.go".
+	Line    int    `json:"line"`   // 1-based.
+	Column  int    `json:"column"` // 1-based, or 0 if not reported.
+	Message string `json:"message"`
+
+	// CellLine is the line (1-based) in the cell of the error, or 0 if it is not in the cell being
+	// executed (e.g.: in a declaration of a previous cell).
+	CellLine int `json:"cell_line,omitempty"`
+}
+
+// reDiagnostic matches the `file.go:line:col: message` lines of the compiler output.
+var reDiagnostic = regexp.MustCompile(`^(\S+\.go):(\d+)(?::(\d+))?: (.+)$`)
+
+// parseDiagnostics returns the diagnostics in the compiler output. Lines that are not diagnostics
+// (e.g.: the package name, or "too many errors") are ignored.
+func parseDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, line := range strings.Split(output, "\n") {
+		matches := reDiagnostic.FindStringSubmatch(strings.TrimSpace(line))
+		if matches == nil {
+			continue
+		}
+		d := Diagnostic{File: filepath.Clean(matches[1]), Message: matches[4]}
+		d.Line, _ = strconv.Atoi(matches[2])
+		if matches[3] != "" {
+			d.Column, _ = strconv.Atoi(matches[3])
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
+
+// withCellLines sets the Diagnostic.CellLine of the diagnostics in main.go, and returns them.
+func (s *State) withCellLines(diagnostics []Diagnostic) []Diagnostic {
+	for ii := range diagnostics {
+		if filepath.Base(diagnostics[ii].File) != "main.go" {
+			continue
+		}
+		if cellLine, found := s.cellLinesInMain[diagnostics[ii].Line-1]; found {
+			diagnostics[ii].CellLine = cellLine + 1
+		}
+	}
+	return diagnostics
+}
+
+// LastError returns the error of the compilation of the last cell executed, or nil if it compiled
+// successfully (or didn't get to be compiled).
+func (s *State) LastError() *BuildError {
+	return s.lastBuildError
+}
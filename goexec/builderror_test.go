@@ -0,0 +1,22 @@
+package goexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	output := `# gonb_12345678
+./main.go:10:2: undefined: x
+./main.go:12:9: cannot use "a" (untyped string constant) as int value in return statement
+	have (string)
+other.go:3: some error without column
+too many errors`
+	assert.Equal(t, []Diagnostic{
+		{File: "main.go", Line: 10, Column: 2, Message: "undefined: x"},
+		{File: "main.go", Line: 12, Column: 9, Message: `cannot use "a" (untyped string constant) as int value in return statement`},
+		{File: "other.go", Line: 3, Message: "some error without column"},
+	}, parseDiagnostics(output))
+	assert.Empty(t, parseDiagnostics("exit status 1"))
+}
@@ -0,0 +1,51 @@
+package goexec
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+	"sort"
+)
+
+// This file implements `%%capture-display <name>`, that captures the rich content (HTML, images, etc.)
+// displayed by the program of the cell, instead of displaying it, and `%display <name>` that
+// displays it later.
+
+// executeCapturingDisplays executes the compiled program, capturing the content it displays in
+// State.CapturedDisplays[State.Cell.CaptureDisplay].
+func (s *State) executeCapturingDisplays(msg kernel.Message) error {
+	if s.Cell.Background {
+		return errors.Errorf("%%%%capture-display can't be used with %%%%background")
+	}
+	capture := kernel.CaptureDisplayData(msg)
+	err := s.Execute(msg)
+	if s.CapturedDisplays == nil {
+		s.CapturedDisplays = make(map[string][]kernel.Data)
+	}
+	s.CapturedDisplays[s.Cell.CaptureDisplay] = capture.Stop()
+	return err
+}
+
+// DisplayCaptured displays the content captured with `%%capture-display <name>`.
+func (s *State) DisplayCaptured(msg kernel.Message, name string) error {
+	captured, found := s.CapturedDisplays[name]
+	if !found {
+		return errors.Errorf("no displays captured with name %q, see %%%%capture-display", name)
+	}
+	for _, data := range captured {
+		if err := kernel.PublishDisplayData(msg, data); err != nil {
+			return errors.WithMessagef(err, "displaying captured %q", name)
+		}
+	}
+	return nil
+}
+
+// ListCaptured returns the names of the captured displays, with the number of items each holds.
+func (s *State) ListCaptured() []string {
+	names := make([]string, 0, len(s.CapturedDisplays))
+	for name, captured := range s.CapturedDisplays {
+		names = append(names, fmt.Sprintf("%s (%d items)", name, len(captured)))
+	}
+	sort.Strings(names)
+	return names
+}
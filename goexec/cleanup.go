@@ -0,0 +1,141 @@
+package goexec
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+)
+
+// This file implements `%%cleanup`: teardown code for resources (files, temporary directories,
+// external processes) that outlive the execution of a cell, run when the kernel shuts down.
+//
+// Since each cell is compiled to its own program, which exits at the end of its execution, there is
+// no live program to call back at shutdown. Instead, the cleanup is compiled when registered --
+// with the declarations of the cells at that moment -- into its own binary, kept in State.TempDir,
+// and executed by Finalize. Like Go's `defer`, the latest registered cleanup runs first.
+
+// DefaultCleanupTimeout is the default for State.CleanupTimeout.
+const DefaultCleanupTimeout = 30 * time.Second
+
+// CleanupProgram is a program registered with `%%cleanup`, to be executed when the kernel shuts
+// down.
+type CleanupProgram struct {
+	// Lines of the `%%cleanup` cell, for display.
+	Lines []string
+
+	// BinaryPath of the compiled program.
+	BinaryPath string
+}
+
+// AddCleanup compiles the lines of a `%%cleanup` cell into a program executed when the kernel
+// shuts down, and appends it to State.Cleanups.
+//
+// The lines are a cell, with access to the declarations of the previous cells: if they don't define
+// a main function (or use `%%`), they are all wrapped in one. Declarations in the lines are only
+// used by the cleanup program, they are not added to the State.
+func (s *State) AddCleanup(msg kernel.Message, lines []string) error {
+	if err := s.GoToolchainError(); err != nil {
+		return err
+	}
+	if isCellEmpty(lines, nil) {
+		return errors.Errorf("`%%%%cleanup` requires the code to execute at shutdown in the rest of the cell")
+	}
+	cellLines := lines
+	if !hasMainMarker(lines) {
+		cellLines = append([]string{"%%"}, lines...)
+	}
+	if _, err := s.createGoFileFromLines(s.MainPath(), cellLines, nil, NoCursor); err != nil {
+		return errors.WithMessagef(err, "in goexec.AddCleanup()")
+	}
+	newDecls := NewDeclarations()
+	if err := s.ParseImportsFromMainGo(msg, NoCursor, newDecls); err != nil {
+		return errors.WithMessagef(err, "in goexec.AddCleanup() while parsing cell")
+	}
+	mainDecl, hasMain := newDecls.Functions["main"]
+	if !hasMain {
+		return errors.Errorf("`%%%%cleanup` cell has no code to execute")
+	}
+	if hasMainMagic(cellLines) {
+		mainDecl.generatedImports = []string{"flag"}
+	}
+	delete(newDecls.Functions, "main")
+	decls := s.Decls.Copy()
+	decls.MergeFrom(newDecls)
+
+	// The cleanup program is compiled as usual, and then moved out of the way of the next compilation.
+	// It is never written to OutputPath.
+	outputPath := s.OutputPath
+	s.OutputPath = ""
+	defer func() { s.OutputPath = outputPath }()
+	if _, err := s.createMainFromDecls(s.withImportPreferences(decls), mainDecl); err != nil {
+		return errors.WithMessagef(err, "in goexec.AddCleanup() while generating main.go with all declarations")
+	}
+	if err := s.GoImports(msg); err != nil {
+		return errors.WithMessagef(err, "goimports failed")
+	}
+	if err := s.Compile(msg); err != nil {
+		return err
+	}
+	cleanup := &CleanupProgram{
+		Lines:      lines,
+		BinaryPath: filepath.Join(s.TempDir, fmt.Sprintf("%s_cleanup_%d%s", s.Package, time.Now().UnixNano(), binaryExt())),
+	}
+	if err := os.Rename(s.BinaryPath(), cleanup.BinaryPath); err != nil {
+		return errors.Wrapf(err, "failed to move cleanup program to %q", cleanup.BinaryPath)
+	}
+	s.Cleanups = append(s.Cleanups, cleanup)
+	return nil
+}
+
+// hasMainMarker returns whether the lines have a `%%` or `%main` line, or a main function.
+func hasMainMarker(lines []string) bool {
+	for _, line := range lines {
+		line = strings.TrimRight(line, " ")
+		if line == "%%" || line == "%main" || strings.HasPrefix(line, "func main()") {
+			return true
+		}
+	}
+	return false
+}
+
+// ClearCleanups removes all the programs registered with `%%cleanup`, without executing them.
+func (s *State) ClearCleanups() {
+	for _, cleanup := range s.Cleanups {
+		_ = os.Remove(cleanup.BinaryPath)
+	}
+	s.Cleanups = nil
+}
+
+// RunCleanups executes the programs registered with `%%cleanup`, the latest registered first, and
+// removes them. Each is killed if it runs for longer than State.CleanupTimeout.
+//
+// There is no front-end to display the output at shutdown, so it is logged instead. Errors are
+// logged, and don't prevent the remaining cleanups from running.
+func (s *State) RunCleanups() {
+	for ii := len(s.Cleanups) - 1; ii >= 0; ii-- {
+		cleanup := s.Cleanups[ii]
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if s.CleanupTimeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, s.CleanupTimeout)
+		}
+		cmd := exec.CommandContext(ctx, cleanup.BinaryPath, s.Args...)
+		cmd.Env = append(os.Environ(), s.programEnv()...)
+		output, err := cmd.CombinedOutput()
+		cancel()
+		if len(output) > 0 {
+			s.logf("Output of cleanup #%d:\n%s", ii+1, s.RedactSecrets(string(output)))
+		}
+		if err != nil {
+			s.logf("Cleanup #%d failed: %+v", ii+1, err)
+		}
+		_ = os.Remove(cleanup.BinaryPath)
+	}
+	s.Cleanups = nil
+}
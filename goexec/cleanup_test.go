@@ -0,0 +1,50 @@
+package goexec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanup(t *testing.T) {
+	s := newTestState(t)
+	logPath := filepath.Join(t.TempDir(), "cleanup.log")
+
+	// Cleanups use the declarations of the cells at the time they are registered.
+	require.NoError(t, s.ExecuteCell(newTestMessage(), []string{
+		`import "os"`,
+		fmt.Sprintf("var logPath = %q", logPath),
+		`func logCleanup(name string) {`,
+		`	f, _ := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)`,
+		`	f.WriteString(name + "\n")`,
+		`	f.Close()`,
+		`}`,
+	}, nil))
+	require.NoError(t, s.AddCleanup(newTestMessage(), []string{`logCleanup("first")`}))
+	require.NoError(t, s.AddCleanup(newTestMessage(), []string{`const name = "second"`, "%%", "logCleanup(name)"}))
+	assert.NotContains(t, s.Decls.Constants, "name")
+	require.Len(t, s.Cleanups, 2)
+	assert.Error(t, s.AddCleanup(newTestMessage(), []string{"undefinedFunc()"}))
+	assert.Error(t, s.AddCleanup(newTestMessage(), []string{"// Nothing."}))
+	require.Len(t, s.Cleanups, 2)
+
+	// Cells executed after don't affect the cleanups.
+	require.NoError(t, s.ExecuteCell(newTestMessage(), []string{"%%", `logCleanup("cell")`}, nil))
+	s.Finalize()
+	contents, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Equal(t, "cell\nsecond\nfirst\n", string(contents))
+	assert.Empty(t, s.Cleanups)
+
+	// Cleared cleanups are not executed.
+	require.NoError(t, s.AddCleanup(newTestMessage(), []string{`logCleanup("cleared")`}))
+	s.ClearCleanups()
+	s.Finalize()
+	contents, err = os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Equal(t, "cell\nsecond\nfirst\n", string(contents))
+}
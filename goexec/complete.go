@@ -0,0 +1,188 @@
+package goexec
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements auto-completion of identifiers (`complete_request` message from Jupyter):
+// the cell being edited is rendered along with the declarations of all previous cells (see
+// renderCellWithCursor), so symbols defined in any cell are completed.
+//
+// The completion is syntactic: it doesn't use `gopls` (see the TODO in the README), so members of
+// packages and types are not completed.
+
+// CompleteCell returns the identifiers that complete the one being typed at the cursor, given by
+// line and col in the cell (0-based), sorted. They include the declarations of all cells, the
+// imported packages, the variables declared in the enclosing function, Go's builtins and keywords.
+//
+// It also returns the length in bytes of the partial identifier before the cursor, which the matches
+// replace. Members of packages and types (after a ".") are not completed.
+//
+// It holds the execution lock (see LockExecution), since it reads the declarations of the State.
+func (s *State) CompleteCell(lines []string, skipLines map[int]bool, line, col int) (matches []string, prefixLen int, err error) {
+	if skipLines[line] {
+		return nil, 0, nil
+	}
+	s.LockExecution()
+	defer s.UnlockExecution()
+	cursorInFile, err := s.renderCellWithCursor(lines, skipLines, line, col)
+	if err != nil {
+		return nil, 0, errors.WithMessagef(err, "in goexec.CompleteCell()")
+	}
+	if !cursorInFile.HasCursor() {
+		return nil, 0, nil
+	}
+	content, err := os.ReadFile(s.InspectPath())
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "reading %q", s.InspectPath())
+	}
+	fileLines := strings.Split(string(content), "\n")
+	if int(cursorInFile.Line) >= len(fileLines) {
+		return nil, 0, nil
+	}
+	prefix, isMember := identifierBeforeCursor(fileLines[cursorInFile.Line], int(cursorInFile.Col))
+	if isMember {
+		return nil, 0, nil
+	}
+
+	fileSet := token.NewFileSet()
+	file, _ := parser.ParseFile(fileSet, s.InspectPath(), content, parser.SkipObjectResolution)
+	if file == nil {
+		return nil, 0, nil
+	}
+	var cursorPos token.Pos
+	if tokFile := fileSet.File(file.Pos()); tokFile != nil {
+		lineStart := tokFile.LineStart(int(cursorInFile.Line) + 1)
+		cursorPos = lineStart + token.Pos(cursorInFile.Col)
+	}
+	candidates := make(map[string]bool)
+	for _, name := range completionCandidates(file, cursorPos) {
+		candidates[name] = true
+	}
+	for _, name := range types.Universe.Names() {
+		candidates[name] = true
+	}
+	for tok := token.BREAK; tok <= token.VAR; tok++ {
+		if tok.IsKeyword() {
+			candidates[tok.String()] = true
+		}
+	}
+	for name := range candidates {
+		if name != prefix && strings.HasPrefix(name, prefix) && !isGeneratedName(name) {
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+	return matches, len(prefix), nil
+}
+
+// identifierBeforeCursor returns the partial identifier that ends at col (in bytes) of line, and
+// whether it is a member, i.e.: it follows a ".".
+func identifierBeforeCursor(line string, col int) (prefix string, isMember bool) {
+	if col > len(line) {
+		col = len(line)
+	}
+	start := col
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(line[:start])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		start -= size
+	}
+	return line[start:col], start > 0 && line[start-1] == '.'
+}
+
+// completionCandidates returns the names of the top-level declarations and imports of file, and of
+// the parameters and variables declared in the function enclosing cursorPos.
+func completionCandidates(file *ast.File, cursorPos token.Pos) []string {
+	var names []string
+	addIdent := func(ident *ast.Ident) {
+		if ident != nil && ident.Name != "_" {
+			names = append(names, ident.Name)
+		}
+	}
+	for _, spec := range file.Imports {
+		if spec.Name != nil {
+			addIdent(spec.Name)
+		} else if importPath, err := strconv.Unquote(spec.Path.Value); err == nil {
+			names = append(names, path.Base(importPath))
+		}
+	}
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				addIdent(decl.Name)
+			}
+			if decl.Body != nil && decl.Pos() <= cursorPos && cursorPos <= decl.End() {
+				names = append(names, localNames(decl)...)
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					addIdent(spec.Name)
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						addIdent(name)
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// localNames returns the names of the parameters, results and variables declared in the function.
+func localNames(decl *ast.FuncDecl) (names []string) {
+	ast.Inspect(decl, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.Field:
+			for _, name := range node.Names {
+				names = append(names, name.Name)
+			}
+		case *ast.ValueSpec:
+			for _, name := range node.Names {
+				names = append(names, name.Name)
+			}
+		case *ast.AssignStmt:
+			if node.Tok == token.DEFINE {
+				for _, lhs := range node.Lhs {
+					if ident, ok := lhs.(*ast.Ident); ok {
+						names = append(names, ident.Name)
+					}
+				}
+			}
+		case *ast.RangeStmt:
+			for _, expr := range []ast.Expr{node.Key, node.Value} {
+				if ident, ok := expr.(*ast.Ident); ok && node.Tok == token.DEFINE {
+					names = append(names, ident.Name)
+				}
+			}
+		}
+		return true
+	})
+	return
+}
+
+// isGeneratedName returns whether name is used by the code GoNB generates, and shouldn't be completed.
+func isGeneratedName(name string) bool {
+	if name == "_" || name == "main" {
+		return true
+	}
+	suffix, found := strings.CutPrefix(name, "gonb")
+	return found && suffix != "" && unicode.IsUpper(rune(suffix[0])) // E.g.: gonbProfiledMain.
+}
@@ -0,0 +1,78 @@
+package goexec
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompleteCell(t *testing.T) {
+	s := &State{TempDir: t.TempDir(), Decls: NewDeclarations(), StubMainBody: DefaultStubMainBody}
+	// Declarations of previous cells.
+	parseCellIntoState(t, s, []string{`import str "strings"`, "func myFunction() int { return 1 }"})
+	parseCellIntoState(t, s, []string{"type myType struct{}", "const myConst = 3", "var myVar = myConst"})
+
+	lines := []string{"%%", "myLocal := 1", "x := my"}
+	matches, prefixLen, err := s.CompleteCell(lines, nil, 2, len(lines[2]))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"myConst", "myFunction", "myLocal", "myType", "myVar"}, matches)
+	assert.Equal(t, 2, prefixLen)
+
+	// Packages, builtins and keywords.
+	lines = []string{"func f() {", "\tfmt.Println(s)", "}"}
+	matches, prefixLen, err = s.CompleteCell(lines, nil, 1, len("\tfmt.Println(s"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"select", "str", "string", "struct", "switch"}, matches)
+	assert.Equal(t, 1, prefixLen)
+
+	// Members are not completed, nor special commands.
+	matches, _, err = s.CompleteCell(lines, nil, 1, len("\tfmt.Pri"))
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+	matches, _, err = s.CompleteCell([]string{"%env X 1"}, map[int]bool{0: true}, 0, 3)
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+
+	// Tabs and multibyte runes before the cursor: columns are in bytes.
+	lines = []string{"%%", "\ts := \"😀é\" + my"}
+	matches, prefixLen, err = s.CompleteCell(lines, nil, 1, len(lines[1]))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"myConst", "myFunction", "myType", "myVar"}, matches)
+	assert.Equal(t, 2, prefixLen)
+	lines = []string{"%%", "\tδx := \"😀\"", "_ = δ"}
+	matches, prefixLen, err = s.CompleteCell(lines, nil, 2, len(lines[2]))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"δx"}, matches)
+	assert.Equal(t, len("δ"), prefixLen)
+}
+
+// TestCompleteCellKeepsMain checks that completion doesn't change main.go (e.g.: the program last
+// executed), and waits for the cell being executed.
+func TestCompleteCellKeepsMain(t *testing.T) {
+	s := &State{TempDir: t.TempDir(), Decls: NewDeclarations(), StubMainBody: DefaultStubMainBody}
+	parseCellIntoState(t, s, []string{"var myVar = 1"})
+	const mainContent = "package main\n\nfunc main() {}\n"
+	require.NoError(t, os.WriteFile(s.MainPath(), []byte(mainContent), 0600))
+
+	s.LockExecution()
+	done := make(chan []string, 1)
+	go func() {
+		matches, _, err := s.CompleteCell([]string{"%%", "_ = my"}, nil, 1, len("_ = my"))
+		assert.NoError(t, err)
+		done <- matches
+	}()
+	select {
+	case <-done:
+		t.Fatal("CompleteCell didn't wait for the execution lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	s.UnlockExecution()
+	assert.Equal(t, []string{"myVar"}, <-done)
+
+	content, err := os.ReadFile(s.MainPath())
+	require.NoError(t, err)
+	assert.Equal(t, mainContent, string(content))
+}
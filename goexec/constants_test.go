@@ -0,0 +1,70 @@
+package goexec
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func renderConstantsForTest(t *testing.T, s *State) string {
+	buf := bytes.NewBuffer(nil)
+	_, _, err := s.Decls.RenderConstants(0, buf)
+	require.NoError(t, err)
+	return buf.String()
+}
+
+func TestConstantBlocks(t *testing.T) {
+	s := &State{Package: "gonb_constants_test", TempDir: t.TempDir(), Decls: NewDeclarations()}
+	parseCellIntoState(t, s, []string{
+		`type Color int`,
+		`const (`,
+		`	Red Color = iota`,
+		`	Green`,
+		`	Blue`,
+		`)`,
+		`const (`,
+		`	_ = iota`,
+		`	X, Y = iota, -iota`,
+		`	Z, W`,
+		`)`,
+		`const (`,
+		`	_ = 10 * iota`,
+		`	Ten`,
+		`)`,
+	})
+	rendered := renderConstantsForTest(t, s)
+	assert.Contains(t, rendered, "const (\n\tRed Color = iota\n\tGreen\n\tBlue\n)\n")
+	assert.Contains(t, rendered, "\tX, Y = iota, -iota\n\tZ, W\n)\n")
+	assert.Contains(t, rendered, "\tTen\n)\n")
+
+	// Referencing the constants in another cell.
+	mainDecl := &Function{Key: "main", Name: "main", Definition: "func main() { print(Red, Green, Blue, X, Y, Z, W, Ten) }"}
+	_, err := s.createMainFromDecls(s.Decls, mainDecl)
+	require.NoError(t, err)
+	if _, err := exec.LookPath("go"); err == nil {
+		s.GoBinary, _ = exec.LookPath("go")
+		require.NoError(t, s.initGoToolchain())
+		output, err := s.GoCommand("build", "-o", s.BinaryPath()).CombinedOutput()
+		require.NoError(t, err, "go build output: %s", output)
+		output, err = exec.Command(filepath.Clean(s.BinaryPath())).CombinedOutput()
+		require.NoError(t, err)
+		assert.Equal(t, "0121-12-210", strings.TrimSpace(string(output)))
+	}
+
+	// Redefining a block replaces it entirely: Blue is no longer defined.
+	parseCellIntoState(t, s, []string{
+		`const (`,
+		`	Red Color = iota + 10`,
+		`	Green`,
+		`)`,
+	})
+	rendered = renderConstantsForTest(t, s)
+	assert.Contains(t, rendered, "const (\n\tRed Color = iota + 10\n\tGreen\n)\n")
+	assert.NotContains(t, rendered, "Blue")
+	assert.NotContains(t, s.Decls.Constants, "Blue")
+}
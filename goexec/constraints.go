@@ -0,0 +1,157 @@
+package goexec
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"go/build/constraint"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+)
+
+// This file implements cells with build constraints: a cell starting with a `//go:build <expr>`
+// line (or `// +build` lines) holds platform-specific declarations. They are kept apart from the other declarations, one
+// set per constraint (see State.ConstrainedDecls), and rendered to their own files, starting with
+// the constraint, so `go build` selects the declarations of the platform being built for. E.g.: the
+// same function can be defined in a cell with `//go:build linux` and in another with
+// `//go:build !linux`.
+
+// constrainedFilePrefix is the prefix of the files, in State.TempDir, with constrained declarations.
+const constrainedFilePrefix = "main_constrained_"
+
+// cellBuildConstraint returns the lines with the build constraints of the cell (see
+// leadingBuildConstraints) and the constraint they define, normalized. As in Go files, a
+// `//go:build` line takes precedence over `// +build` lines. It returns an empty expr if the cell
+// has no build constraints.
+func cellBuildConstraint(lines []string, skipLines map[int]bool) (constraintLines map[int]bool, expr string, err error) {
+	constraintLines = leadingBuildConstraints(lines, skipLines)
+	var goBuild, plusBuild constraint.Expr
+	for ii, line := range lines {
+		if !constraintLines[ii] {
+			continue
+		}
+		line = strings.TrimSpace(line)
+		parsed, err := constraint.Parse(line)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "invalid build constraint %q", line)
+		}
+		switch {
+		case constraint.IsGoBuild(line):
+			goBuild = parsed
+		case plusBuild == nil:
+			plusBuild = parsed
+		default:
+			plusBuild = &constraint.AndExpr{X: plusBuild, Y: parsed}
+		}
+	}
+	if goBuild == nil {
+		goBuild = plusBuild
+	}
+	if goBuild == nil {
+		return nil, "", nil
+	}
+	return constraintLines, goBuild.String(), nil
+}
+
+// ConstrainedFilePath returns the path of the file with the declarations for the build constraint expr.
+func (s *State) ConstrainedFilePath(expr string) string {
+	h := fnv.New32a()
+	_, _ = io.WriteString(h, expr)
+	return filepath.Join(s.TempDir, fmt.Sprintf("%s%08x.go", constrainedFilePrefix, h.Sum32()))
+}
+
+// constrainedFilePaths returns the paths of the files of State.ConstrainedDecls, sorted.
+func (s *State) constrainedFilePaths() []string {
+	paths := make([]string, 0, len(s.ConstrainedDecls))
+	for expr := range s.ConstrainedDecls {
+		paths = append(paths, s.ConstrainedFilePath(expr))
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// writeConstrainedFiles renders State.ConstrainedDecls to their files, and removes the files of
+// constraints no longer declared.
+func (s *State) writeConstrainedFiles() error {
+	current := make(map[string]bool, len(s.ConstrainedDecls))
+	for expr, decls := range s.ConstrainedDecls {
+		filePath := s.ConstrainedFilePath(expr)
+		current[filePath] = true
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "//go:build %s\n\npackage main\n\n", expr)
+		for _, render := range []func(int, io.Writer) (int, Cursor, error){
+			decls.RenderImports, decls.RenderTypes, decls.RenderConstants, decls.RenderVariables, decls.RenderFunctions,
+		} {
+			if _, _, err := render(0, &buf); err != nil {
+				return errors.WithMessagef(err, "rendering declarations for build constraint %q", expr)
+			}
+		}
+		if err := os.WriteFile(filePath, buf.Bytes(), 0600); err != nil {
+			return errors.Wrapf(err, "writing %q", filePath)
+		}
+	}
+	stale, _ := filepath.Glob(filepath.Join(s.TempDir, constrainedFilePrefix+"*.go"))
+	for _, filePath := range stale {
+		if !current[filePath] {
+			_ = os.Remove(filePath)
+		}
+	}
+	return nil
+}
+
+// buildContext returns the build context of the platform programs are built for: GOOS and GOARCH
+// can be set with `%env`.
+func buildContext() build.Context {
+	ctx := build.Default
+	ctx.GOOS, ctx.GOARCH = runtime.GOOS, runtime.GOARCH
+	if goos := os.Getenv("GOOS"); goos != "" {
+		ctx.GOOS = goos
+	}
+	if goarch := os.Getenv("GOARCH"); goarch != "" {
+		ctx.GOARCH = goarch
+	}
+	return ctx
+}
+
+// executeConstrainedCell memorizes the declarations of a cell with the build constraint expr, and
+// compiles the program to validate them. If it fails to compile, they are discarded. Declarations
+// whose constraint is not satisfied by the platform being built for are not compiled.
+func (s *State) executeConstrainedCell(msg kernel.Message, expr string, newDecls *Declarations) error {
+	if _, hasMain := newDecls.Functions["main"]; hasMain {
+		return errors.Errorf("the main function can't have build constraints (%q)", expr)
+	}
+	previous := s.ConstrainedDecls
+	s.ConstrainedDecls = make(map[string]*Declarations, len(previous)+1)
+	for previousExpr, decls := range previous {
+		s.ConstrainedDecls[previousExpr] = decls.Copy()
+	}
+	if s.ConstrainedDecls[expr] == nil {
+		s.ConstrainedDecls[expr] = NewDeclarations()
+	}
+	s.ConstrainedDecls[expr].MergeFrom(newDecls)
+
+	err := s.compileDecls(msg)
+	if err != nil {
+		s.ConstrainedDecls = previous
+		if restoreErr := s.writeConstrainedFiles(); restoreErr != nil {
+			s.logf("Failed to restore the files with constrained declarations: %+v", restoreErr)
+		}
+		return err
+	}
+
+	ctx := buildContext()
+	if matches, _ := ctx.MatchFile(s.TempDir, filepath.Base(s.ConstrainedFilePath(expr))); !matches {
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf(
+			"* Declarations for %q memorized, not compiled: the constraint is not satisfied by %s/%s.\n",
+			expr, ctx.GOOS, ctx.GOARCH))
+	}
+	return nil
+}
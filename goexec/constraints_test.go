@@ -0,0 +1,65 @@
+package goexec
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCellBuildConstraint(t *testing.T) {
+	lines, expr, err := cellBuildConstraint([]string{"%env X 1", "", "// Linux or Mac.", "//go:build linux ||   darwin", "func f() {}"}, map[int]bool{0: true})
+	require.NoError(t, err)
+	assert.Equal(t, map[int]bool{3: true}, lines)
+	assert.Equal(t, "linux || darwin", expr)
+
+	lines, expr, err = cellBuildConstraint([]string{"// +build linux darwin", "// +build amd64", "func f() {}"}, nil)
+	require.NoError(t, err)
+	assert.Len(t, lines, 2)
+	assert.Equal(t, "(linux || darwin) && amd64", expr)
+
+	_, expr, err = cellBuildConstraint([]string{"func f() {}", "//go:build linux"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "", expr)
+
+	_, _, err = cellBuildConstraint([]string{"//go:build linux &&"}, nil)
+	assert.Error(t, err)
+}
+
+func TestConstrainedDeclarations(t *testing.T) {
+	s := newTestState(t)
+	msg := newTestMessage()
+
+	// Two definitions of the same function, for different platforms.
+	require.NoError(t, s.ExecuteCell(msg, []string{"//go:build linux", `func platform() string { return "linux" }`}, nil))
+	require.NoError(t, s.ExecuteCell(msg, []string{"//go:build !linux", `func platform() string { return "other" }`}, nil))
+	assert.Len(t, s.ConstrainedDecls, 2)
+	assert.Empty(t, s.Decls.Functions)
+	content, err := os.ReadFile(s.ConstrainedFilePath("!linux"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "//go:build !linux\n\npackage main\n"))
+	assert.Contains(t, string(content), `return "other"`)
+
+	msg = newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{`import "fmt"`, "%%", "fmt.Println(platform())"}, nil))
+	want := "other"
+	if runtime.GOOS == "linux" {
+		want = "linux"
+	}
+	assert.Contains(t, strings.Join(msg.Published(), ""), want+"\n")
+
+	// Invalid declarations are discarded.
+	require.Error(t, s.ExecuteCell(newTestMessage(), []string{"//go:build " + runtime.GOOS, "func broken() { undefinedFunc() }"}, nil))
+	assert.Len(t, s.ConstrainedDecls, 2)
+	require.Error(t, s.ExecuteCell(newTestMessage(), []string{"//go:build linux", "func main() {}"}, nil))
+
+	// Reset removes the constrained declarations, and their files.
+	s.Reset()
+	require.NoError(t, s.ExecuteCell(newTestMessage(), []string{"var x = 1"}, nil))
+	files, _ := filepath.Glob(filepath.Join(s.TempDir, constrainedFilePrefix+"*"))
+	assert.Empty(t, files)
+}
@@ -0,0 +1,49 @@
+package goexec
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+)
+
+// This file implements `%debug`: programs are compiled without optimizations and inlining, and
+// executed under Delve (dlv) in headless mode, so an external debugger (e.g.: `dlv connect` or an
+// IDE) can attach to them.
+
+// DefaultDebugAddress is the address Delve listens on for debuggers, if none is given to `%debug on`.
+const DefaultDebugAddress = "127.0.0.1:2345"
+
+// debugBuildFlags are the `go build` flags used in debug mode: they disable optimizations and
+// inlining, so the debugger can inspect all variables and step through all functions.
+var debugBuildFlags = []string{"-gcflags=all=-N -l"}
+
+// dlvNotInstalledMessage is the error message when debugging is requested but Delve is not installed.
+const dlvNotInstalledMessage = `program dlv (Delve) is not installed. It is needed to debug the
+programs with "%%debug on". You can install it from the notebook with:
+
+!go install github.com/go-delve/delve/cmd/dlv@latest
+
+Or use "%%debug off" to execute the programs normally`
+
+// debugCommand returns the command that executes binaryPath under Delve in headless mode, listening
+// for debuggers on State.DebugAddress.
+func (s *State) debugCommand(binaryPath string) (name string, args []string, err error) {
+	dlvPath, err := exec.LookPath("dlv")
+	if err != nil {
+		return "", nil, errors.Errorf(dlvNotInstalledMessage)
+	}
+	args = []string{"exec", binaryPath, "--headless", "--api-version=2", "--listen=" + s.DebugAddress}
+	if len(s.Args) > 0 {
+		args = append(append(args, "--"), s.Args...)
+	}
+	return dlvPath, args, nil
+}
+
+// reportDebugging tells the user how to attach a debugger to the program about to be executed.
+func (s *State) reportDebugging(msg kernel.Message) {
+	_ = kernel.PublishWriteStream(msg, kernel.StreamStderr, fmt.Sprintf(
+		"* Debugging: the program is paused until a debugger attaches to %s, e.g.: `dlv connect %s`.\n"+
+			"  Interrupt the cell to stop it.\n", s.DebugAddress, s.DebugAddress))
+}
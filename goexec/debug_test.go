@@ -0,0 +1,27 @@
+package goexec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugCommand(t *testing.T) {
+	binDir := t.TempDir()
+	t.Setenv("PATH", binDir)
+	s := &State{DebugAddress: DefaultDebugAddress, Args: []string{"-n", "3"}}
+	_, _, err := s.debugCommand("/tmp/gonb/gonb_main")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "go install github.com/go-delve/delve/cmd/dlv@latest")
+
+	dlvPath := filepath.Join(binDir, "dlv")
+	require.NoError(t, os.WriteFile(dlvPath, []byte("#!/bin/sh\n"), 0755))
+	name, args, err := s.debugCommand("/tmp/gonb/gonb_main")
+	require.NoError(t, err)
+	assert.Equal(t, dlvPath, name)
+	assert.Equal(t, []string{"exec", "/tmp/gonb/gonb_main", "--headless", "--api-version=2",
+		"--listen=127.0.0.1:2345", "--", "-n", "3"}, args)
+}
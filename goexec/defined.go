@@ -0,0 +1,60 @@
+package goexec
+
+import "strings"
+
+// Kinds of declarations returned by State.IsDefined and State.DefinedKinds.
+const (
+	KindType     = "type"
+	KindFunction = "func"
+	KindMethod   = "method"
+	KindVariable = "var"
+	KindConstant = "const"
+	KindImport   = "import"
+)
+
+// IsDefined returns whether name is currently declared by the cells executed so far (State.Decls),
+// and the kind of the declaration holding it: one of KindType, KindFunction, KindMethod, KindVariable,
+// KindConstant or KindImport (for the name under which a package is imported).
+//
+// Methods are given as "Type.Method" (or "*Type.Method"). If more than one kind of declaration holds
+// the name -- a redeclaration, which fails to compile -- the first kind in the order above is returned,
+// see DefinedKinds to get all of them.
+func (s *State) IsDefined(name string) (kind string, ok bool) {
+	kinds := s.DefinedKinds(name)
+	if len(kinds) == 0 {
+		return "", false
+	}
+	return kinds[0], true
+}
+
+// DefinedKinds returns all the kinds of the declarations of State.Decls holding name, see IsDefined.
+// It returns more than one kind only for names redeclared with different kinds of declarations.
+func (s *State) DefinedKinds(name string) []string {
+	if s.Decls == nil || name == "" {
+		return nil
+	}
+	if typeName, method, found := strings.Cut(name, "."); found {
+		typeName = strings.TrimPrefix(typeName, "*")
+		if _, found := s.Decls.Functions[typeName+"~"+method]; found {
+			return []string{KindMethod}
+		}
+		return nil
+	}
+	var kinds []string
+	if _, found := s.Decls.Types[name]; found {
+		kinds = append(kinds, KindType)
+	}
+	if _, found := s.Decls.Functions[name]; found {
+		kinds = append(kinds, KindFunction)
+	}
+	if _, found := s.Decls.Variables[name]; found {
+		kinds = append(kinds, KindVariable)
+	}
+	if _, found := s.Decls.Constants[name]; found {
+		kinds = append(kinds, KindConstant)
+	}
+	if _, found := s.Decls.Imports[name]; found {
+		kinds = append(kinds, KindImport)
+	}
+	return kinds
+}
@@ -0,0 +1,50 @@
+package goexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsDefined(t *testing.T) {
+	s := &State{TempDir: t.TempDir(), Decls: NewDeclarations()}
+	parseCellIntoState(t, s, []string{
+		`import str "strings"`,
+		`import _ "embed"`,
+		"type Point struct{ X, Y int }",
+		"func (p *Point) Norm() int { return p.X*p.X + p.Y*p.Y }",
+		"func (p Point) String() string { return str.Repeat(\"x\", p.X) }",
+		"func newPoint() *Point { return &Point{} }",
+		"var origin = newPoint()",
+		"const scale = 2",
+	})
+
+	for name, want := range map[string]string{
+		"Point":        KindType,
+		"Point.Norm":   KindMethod,
+		"*Point.Norm":  KindMethod,
+		"Point.String": KindMethod,
+		"newPoint":     KindFunction,
+		"origin":       KindVariable,
+		"scale":        KindConstant,
+		"str":          KindImport,
+	} {
+		kind, ok := s.IsDefined(name)
+		assert.Truef(t, ok, "%q should be defined", name)
+		assert.Equalf(t, want, kind, "kind of %q", name)
+	}
+	for _, name := range []string{"", "strings", "_", "embed", "Norm", "String", "Point.Missing", "Other.Norm", "undefined"} {
+		_, ok := s.IsDefined(name)
+		assert.Falsef(t, ok, "%q should not be defined", name)
+	}
+
+	// Collisions across kinds: each kind of declaration is kept separately, so a name redeclared
+	// with another kind is held by both -- which fails to compile.
+	parseCellIntoState(t, s, []string{"func origin() {}", "type scale int"})
+	assert.Equal(t, []string{KindFunction, KindVariable}, s.DefinedKinds("origin"))
+	assert.Equal(t, []string{KindType, KindConstant}, s.DefinedKinds("scale"))
+	kind, ok := s.IsDefined("scale")
+	assert.True(t, ok)
+	assert.Equal(t, KindType, kind)
+	assert.Equal(t, []string{KindType}, s.DefinedKinds("Point"))
+}
@@ -0,0 +1,92 @@
+package goexec
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements `%dot-import`: a convenience for interactive exploration, it adds a dot
+// import (`import . "path"`) to the declarations, so the names exported by the package can be used
+// unqualified in the following cells.
+//
+// Since an unused import is a compilation error, and the cells of a notebook may not all use the
+// dot-imported packages, dot imports reported unused by the compiler are dropped from main.go and
+// the program is compiled again -- they are kept in the declarations. goimports doesn't touch dot
+// imports, nor the unqualified names they provide.
+
+// reUnusedImport matches the compiler's error message for unused imports.
+var reUnusedImport = regexp.MustCompile(`^"([^"]+)" imported and not used$`)
+
+// DotImport adds a dot import of importPath to the declarations.
+func (s *State) DotImport(importPath string) error {
+	if importPath == "" || strings.ContainsAny(importPath, "\" \t") {
+		return errors.Errorf("invalid import path %q for %%dot-import", importPath)
+	}
+	importDecl := NewImport(importPath, ".")
+	s.Decls.Imports[importDecl.Key] = importDecl
+	return nil
+}
+
+// RemoveDotImport removes the dot import of importPath from the declarations.
+func (s *State) RemoveDotImport(importPath string) error {
+	importDecl := NewImport(importPath, ".")
+	if _, found := s.Decls.Imports[importDecl.Key]; !found {
+		return errors.Errorf("%q is not dot-imported", importPath)
+	}
+	delete(s.Decls.Imports, importDecl.Key)
+	return nil
+}
+
+// DotImports returns the import paths of the dot imports declared, sorted.
+func (s *State) DotImports() []string {
+	var paths []string
+	for _, info := range s.CurrentImports() {
+		if info.Dot {
+			paths = append(paths, info.Path)
+		}
+	}
+	return paths
+}
+
+// dropUnusedDotImports removes from main.go the dot imports reported as unused in the compiler output.
+// The lines are blanked, as opposed to removed, so the lines of the remaining code don't change. It
+// returns whether any dot import was dropped, in which case the program should be compiled again.
+func (s *State) dropUnusedDotImports(output string) bool {
+	var unused []Diagnostic
+	for _, d := range parseDiagnostics(output) {
+		if d.File == "main.go" && reUnusedImport.MatchString(d.Message) {
+			unused = append(unused, d)
+		}
+	}
+	if len(unused) == 0 {
+		return false
+	}
+	content, err := os.ReadFile(s.MainPath())
+	if err != nil {
+		return false
+	}
+	lines := strings.Split(string(content), "\n")
+	dropped := false
+	for _, d := range unused {
+		importPath := reUnusedImport.FindStringSubmatch(d.Message)[1]
+		if d.Line < 1 || d.Line > len(lines) {
+			continue
+		}
+		line := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(lines[d.Line-1]), "import"))
+		if line == `. "`+importPath+`"` {
+			lines[d.Line-1] = ""
+			dropped = true
+		}
+	}
+	if !dropped {
+		return false
+	}
+	if err := os.WriteFile(s.MainPath(), []byte(strings.Join(lines, "\n")), 0600); err != nil {
+		s.logf("Failed to drop unused dot imports from %q: %+v", s.MainPath(), err)
+		return false
+	}
+	return true
+}
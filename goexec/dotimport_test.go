@@ -0,0 +1,28 @@
+package goexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDotImport(t *testing.T) {
+	s := newTestState(t)
+	msg := newTestMessage()
+
+	require.NoError(t, s.DotImport("math"))
+	assert.Error(t, s.DotImport(`"math"`))
+	assert.Equal(t, []string{"math"}, s.DotImports())
+	require.NoError(t, s.ExecuteCell(msg, []string{"var root2 = Sqrt(2)"}, nil))
+
+	// Cells not using the dot import still compile, and it is kept.
+	s.Reset()
+	require.NoError(t, s.DotImport("math"))
+	require.NoError(t, s.ExecuteCell(msg, []string{"func triple(x int) int { return 3 * x }"}, nil))
+	assert.Equal(t, []string{"math"}, s.DotImports())
+
+	require.NoError(t, s.RemoveDotImport("math"))
+	assert.Empty(t, s.DotImports())
+	assert.Error(t, s.RemoveDotImport("math"))
+}
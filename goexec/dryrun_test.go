@@ -0,0 +1,30 @@
+package goexec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDryRun checks that `%%dryrun` displays the generated main.go, without compiling or keeping
+// the declarations of the cell.
+func TestDryRun(t *testing.T) {
+	s, err := NewState(WithTempDir(t.TempDir()), WithAutoGet(false))
+	require.NoError(t, err)
+	s.SkipGoImports = true // goimports may not be installed.
+	s.Cell.DryRun = true
+	msg := newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{
+		`func dryRunOnly() int { return 1 }`,
+		`%%`,
+		`undefinedButNotCompiled()`,
+	}, nil))
+	output := strings.Join(msg.Published(), "")
+	assert.Contains(t, output, "```go\n")
+	assert.Contains(t, output, "func dryRunOnly() int { return 1 }")
+	assert.Contains(t, output, "undefinedButNotCompiled()")
+	assert.NotContains(t, s.Decls.Functions, "dryRunOnly")
+	assert.Nil(t, s.LastError())
+}
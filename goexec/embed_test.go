@@ -0,0 +1,47 @@
+package goexec
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbedDirective(t *testing.T) {
+	s := &State{Package: "gonb_embed_test", TempDir: t.TempDir(), Decls: NewDeclarations()}
+	require.NoError(t, s.WriteFile("data.txt", []string{"embedded content"}))
+	parseCellIntoState(t, s, []string{
+		`import _ "embed"`,
+		``,
+		`//go:embed data.txt`,
+		`var data string`,
+		``,
+		`var (`,
+		`	// Not an embed directive.`,
+		`	count int`,
+		`)`,
+	})
+	require.Contains(t, s.Decls.Variables, "data")
+	assert.Equal(t, "//go:embed data.txt", s.Decls.Variables["data"].EmbedDirective)
+	assert.Empty(t, s.Decls.Variables["count"].EmbedDirective)
+
+	mainDecl := &Function{Key: "main", Name: "main", Definition: "func main() { print(data, count) }"}
+	_, err := s.createMainFromDecls(s.Decls, mainDecl)
+	require.NoError(t, err)
+	mainGo, err := os.ReadFile(s.MainPath())
+	require.NoError(t, err)
+	assert.Contains(t, string(mainGo), "\t//go:embed data.txt\n\tdata string\n")
+	assert.Contains(t, string(mainGo), "\tcount int\n")
+
+	// Compile and run, if the go toolchain is available.
+	useGoToolchain(t, s)
+	output, err := s.GoCommand("build", "-o", s.BinaryPath()).CombinedOutput()
+	require.NoError(t, err, "go build output: %s", output)
+	output, err = exec.Command(filepath.Clean(s.BinaryPath())).CombinedOutput()
+	require.NoError(t, err)
+	assert.Equal(t, "embedded content\n0", strings.TrimSpace(string(output)))
+}
@@ -8,7 +8,6 @@ import (
 	"golang.org/x/exp/constraints"
 	"html"
 	"io"
-	"log"
 	"os"
 	"regexp"
 	"strconv"
@@ -19,6 +18,9 @@ import (
 // errorReport is the structure to feed templateErrorReport
 type errorReport struct {
 	Lines []errorLine
+
+	// Source is the HTML of the generated source code, if State.SourceOnError is enabled.
+	Source string
 }
 
 type errorLine struct {
@@ -26,6 +28,8 @@ type errorLine struct {
 	Message    string // Error message, what comes after the `file:line_number:col_number`
 	Location   string // `file:line_number:col_number` prefix, only if HasContext == true.
 	Context    string // Context to display on a mouse-over window, only if HasContext == true.
+
+	lineNum int // Line number (0-based) in main.go of the error, only if HasContext == true.
 }
 
 var templateErrorReport = template.Must(template.New("error_report").Parse(`
@@ -72,6 +76,11 @@ var templateErrorReport = template.Must(template.New("error_report").Parse(`
 {{end}}
 <br/>
 {{end}}
+{{if .Source}}
+<details><summary>Generated source</summary>
+<pre>{{.Source}}</pre>
+</details>
+{{end}}
 </div>
 `))
 
@@ -83,23 +92,24 @@ var templateErrorReport = template.Must(template.New("error_report").Parse(`
 // Any errors within here are logged and simply ignored, since this is already
 // used to report errors
 func (s *State) DisplayErrorWithContext(msg kernel.Message, errorMsg string) {
+	errorMsg = s.RedactSecrets(errorMsg)
 	// Default report, and makes sure display is called at the end.
 	reportHTML := "<pre>" + errorMsg + "</pre>" // If anything goes wrong, simply display the error message.
 	defer func() {
 		// Display HTML report on exit.
 		err := kernel.PublishDisplayDataWithHTML(msg, reportHTML)
 		if err != nil {
-			log.Printf("Failed to publish data in DisplayErrorWithContext: %+v", err)
+			s.logf("Failed to publish data in DisplayErrorWithContext: %+v", err)
 		}
 	}()
 
 	// Read main.go into lines.
 	mainGo, err := s.readMainGo()
 	if err != nil {
-		log.Printf("DisplayErrorWithContext: %+v", err)
+		s.logf("DisplayErrorWithContext: %+v", err)
 		return
 	}
-	codeLines := strings.Split(mainGo, "\n")
+	codeLines := strings.Split(s.RedactSecrets(mainGo), "\n")
 
 	// Parse error lines.
 	lines := strings.Split(errorMsg, "\n")
@@ -107,11 +117,14 @@ func (s *State) DisplayErrorWithContext(msg kernel.Message, errorMsg string) {
 	for ii, line := range lines {
 		report.Lines[ii] = s.parseErrorLine(line, codeLines)
 	}
+	if s.SourceOnError != SourceOnErrorOff {
+		report.Source = s.sourceForErrorReport(codeLines, report.Lines)
+	}
 
 	// Render error block.
 	buf := bytes.NewBuffer(make([]byte, 0, 512*len(lines)))
 	if err := templateErrorReport.Execute(buf, report); err != nil {
-		log.Printf("Failed to execute template in DisplayErrorWithContext: %+v", err)
+		s.logf("Failed to execute template in DisplayErrorWithContext: %+v", err)
 		return
 	}
 	reportHTML = buf.String()
@@ -155,6 +168,10 @@ func (s *State) parseErrorLine(lineStr string, codeLines []string) (l errorLine)
 
 	lineNum, _ := strconv.Atoi(matches[2])
 	lineNum -= 1 // Error messages start at line 1 (as opposed to 0)
+	l.lineNum = lineNum
+	if cellLine, found := s.cellLinesInMain[lineNum]; found {
+		l.Message = fmt.Sprintf("%s (cell line %d)", l.Message, cellLine+1)
+	}
 	//colNum, _ := strconv.Atoi(matches[3])
 	fromLines := lineNum - LinesForErrorContext
 	fromLines = inBetween(fromLines, 0, len(codeLines)-1)
@@ -173,6 +190,57 @@ func (s *State) parseErrorLine(lineStr string, codeLines []string) (l errorLine)
 	return
 }
 
+// SourceOnErrorMode defines whether the generated source code is displayed along errors, see
+// State.SourceOnError.
+type SourceOnErrorMode int
+
+const (
+	// SourceOnErrorOff doesn't display the generated source code along errors. This is the default.
+	SourceOnErrorOff SourceOnErrorMode = iota
+
+	// SourceOnErrorContext displays the lines of the generated source code around the errors.
+	SourceOnErrorContext
+
+	// SourceOnErrorFull displays the whole generated source code along errors.
+	SourceOnErrorFull
+)
+
+// sourceForErrorReport returns the HTML (escaped) of the lines of code, numbered, to be included
+// in the error report. If State.SourceOnError is SourceOnErrorContext, only lines around the
+// errors are included -- all lines are included if no error refers to a line in main.go.
+func (s *State) sourceForErrorReport(codeLines []string, errLines []errorLine) string {
+	include := func(int) bool { return true }
+	if s.SourceOnError == SourceOnErrorContext {
+		inContext := make(map[int]bool)
+		for _, l := range errLines {
+			if !l.HasContext {
+				continue
+			}
+			for ii := l.lineNum - LinesForErrorContext; ii <= l.lineNum+LinesForErrorContext; ii++ {
+				inContext[ii] = true
+			}
+		}
+		if len(inContext) > 0 {
+			include = func(ii int) bool { return inContext[ii] }
+		}
+	}
+
+	var sb strings.Builder
+	skipped := false
+	for ii, line := range codeLines {
+		if !include(ii) {
+			skipped = true
+			continue
+		}
+		if skipped {
+			sb.WriteString("...\n")
+			skipped = false
+		}
+		fmt.Fprintf(&sb, "%4d  %s\n", ii+1, html.EscapeString(line))
+	}
+	return sb.String()
+}
+
 // readMainGo reads the contents of main.go file.
 func (s *State) readMainGo() (string, error) {
 	f, err := os.Open(s.MainPath())
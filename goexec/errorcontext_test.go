@@ -0,0 +1,21 @@
+package goexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceForErrorReport(t *testing.T) {
+	codeLines := []string{"l1", "l2", "l3", "l4", "l5", "l6", "l7", "l8", "l9", "<l10>"}
+	s := &State{SourceOnError: SourceOnErrorContext}
+	errLines := []errorLine{
+		s.parseErrorLine("./main.go:10:2: undefined: x", codeLines),
+		s.parseErrorLine("some other message", codeLines),
+	}
+	assert.Equal(t, "...\n   7  l7\n   8  l8\n   9  l9\n  10  &lt;l10&gt;\n", s.sourceForErrorReport(codeLines, errLines))
+
+	s.SourceOnError = SourceOnErrorFull
+	got := s.sourceForErrorReport(codeLines[:2], errLines)
+	assert.Equal(t, "   1  l1\n   2  l2\n", got)
+}
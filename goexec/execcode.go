@@ -4,20 +4,80 @@ import (
 	"fmt"
 	"github.com/janpfeifer/gonb/kernel"
 	"github.com/pkg/errors"
+	"go/scanner"
+	"go/token"
 	"io"
-	"log"
 	"os"
 	"os/exec"
-	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
+	"time"
 )
 
 // ExecuteCell takes the contents of a cell, parses it, merges new declarations with the ones
 // from previous definitions, render a final main.go code with the whole content,
 // compiles and runs it.
+//
+// Cells with only declarations (no `%%`, `%main` or `func main`) are compiled with a stub main
+// function (see State.StubMainBody), to validate them. They are only executed if the stub was
+// customized with `%stubmain`.
 func (s *State) ExecuteCell(msg kernel.Message, lines []string, skipLines map[int]bool) error {
+	s.Metrics.CellsExecuted.Add(1)
+	if err := s.GoToolchainError(); err != nil {
+		return err
+	}
+	s.lastBuildError = nil
+	s.cellLinesInMain = nil
+
+	// Terminate anything left running by the previous program, freeing resources (e.g.: ports).
+	if err := s.KillProgram(); err != nil {
+		s.logf("Failed to kill previous program: %+v", err)
+	}
+
+	if s.Cell.Watch != "" {
+		if err := s.startWatch(msg, lines, skipLines); err != nil {
+			return err
+		}
+	}
+
+	// Cells starting with a registered `%%<name>` are transformed to Go code first.
+	lines, skipLines, err := s.transformCell(lines, skipLines)
+	if err != nil {
+		return err
+	}
+	if s.Cell.Append != "" {
+		if lines, skipLines, err = s.appendToFunction(lines, skipLines); err != nil {
+			return err
+		}
+	}
+
+	// Cells with only comments (e.g.: notes) or whitespace have nothing to compile or run.
+	if isCellEmpty(lines, skipLines) {
+		s.logf("Cell has only comments or whitespace, nothing to execute.")
+		return nil
+	}
+
+	// Cells starting with a `//go:build` constraint hold platform-specific declarations.
+	constraintLines, constraintExpr, err := cellBuildConstraint(lines, skipLines)
+	if err != nil {
+		return err
+	}
+	if constraintExpr != "" {
+		// The constraint is rendered to the file of the constrained declarations instead.
+		newSkipLines := make(map[int]bool, len(skipLines)+len(constraintLines))
+		for ii := range skipLines {
+			newSkipLines[ii] = true
+		}
+		for ii := range constraintLines {
+			newSkipLines[ii] = true
+		}
+		skipLines = newSkipLines
+	}
+
 	// Find declarations on unchanged cell contents.
-	_, err := s.createGoFileFromLines(s.MainPath(), lines, skipLines, NoCursor)
+	_, err = s.createGoFileFromLines(s.MainPath(), lines, skipLines, NoCursor)
 	if err != nil {
 		return errors.WithMessagef(err, "in goexec.ExecuteCell()")
 	}
@@ -25,6 +85,15 @@ func (s *State) ExecuteCell(msg kernel.Message, lines []string, skipLines map[in
 	if err = s.ParseImportsFromMainGo(msg, NoCursor, newDecls); err != nil {
 		return errors.WithMessagef(err, "in goexec.ExecuteCell() while parsing cell")
 	}
+	if constraintExpr != "" {
+		return s.executeConstrainedCell(msg, constraintExpr, newDecls)
+	}
+
+	if s.Cell.Parameters {
+		if err = s.applyParameters(newDecls); err != nil {
+			return err
+		}
+	}
 
 	// Checks whether there is a "main" function defined in the code.
 	mainDecl, hasMain := newDecls.Functions["main"]
@@ -32,25 +101,82 @@ func (s *State) ExecuteCell(msg kernel.Message, lines []string, skipLines map[in
 		// Remove "main" from newDecls: this should not be stored from one cell execution from
 		// another.
 		delete(newDecls.Functions, "main")
+		if hasMainMagic(lines) {
+			mainDecl.generatedImports = []string{"flag"}
+		}
 	} else {
 		// Declare a stub main function, just so we can try to compile the final code.
-		mainDecl = &Function{Key: "main", Name: "main", Definition: "func main() { flag.Parse() }"}
+		mainDecl = s.stubMain()
+	}
+	if hasMain && s.AutoPrint {
+		if mainDecl, err = autoPrintMain(mainDecl); err != nil {
+			return err
+		}
+	}
+	cellMainDecl := mainDecl
+	if s.Cell.Profile != "" {
+		if !hasMain {
+			return errors.Errorf("%%%%pprof requires a program to execute: use %%%% or define a main function")
+		}
+		if s.Cell.Background {
+			return errors.Errorf("%%%%pprof can't be used with %%%%background")
+		}
+		if mainDecl, err = s.profiledMain(mainDecl); err != nil {
+			return err
+		}
+	}
+	if s.Cell.MemStats {
+		switch {
+		case !hasMain:
+			return errors.Errorf("%%%%memstats requires a program to execute: use %%%% or define a main function")
+		case s.Cell.Background:
+			return errors.Errorf("%%%%memstats can't be used with %%%%background")
+		case s.Cell.Profile != "":
+			return errors.Errorf("%%%%memstats can't be used with %%%%pprof")
+		}
+		if mainDecl, err = s.memStatsMain(mainDecl); err != nil {
+			return err
+		}
 	}
-	_ = mainDecl
 
 	// Merge cell declarations with a copy of the current state: we don't want to commit the new
 	// declarations until they compile successfully.
 	tmpDecls := s.Decls.Copy()
 	tmpDecls.MergeFrom(newDecls)
 
+	if s.Cell.Fuzz != "" {
+		if err = s.fuzz(msg, tmpDecls, mainDecl); err != nil {
+			return err
+		}
+		s.Decls = tmpDecls
+		return nil
+	}
+	if s.Cell.Plugin != "" {
+		if err = s.buildPlugin(msg, tmpDecls, mainDecl); err != nil {
+			return err
+		}
+		s.Decls = tmpDecls
+		return nil
+	}
+
 	// Render declarations to main.go.
-	if _, err = s.createMainFromDecls(tmpDecls, mainDecl); err != nil {
+	if _, err = s.createMainFromDecls(s.withImportPreferences(tmpDecls), mainDecl); err != nil {
 		return errors.WithMessagef(err, "in goexec.ExecuteCell() while generating main.go with all declarations")
 	}
+	// Report syntax errors before the heavier goimports and compilation.
+	if err = s.checkSyntax(msg); err != nil {
+		return err
+	}
 	// Run goimports (or the code that implements it)
 	if err = s.GoImports(msg); err != nil {
 		return errors.WithMessagef(err, "goimports failed")
 	}
+	// goimports may have added or removed lines, so errors are mapped to the cell only now.
+	s.mapCellLinesInMain()
+	if s.Cell.DryRun {
+		// Declarations are not committed to the State.
+		return s.displayMainGo(msg)
+	}
 
 	// And then compile it.
 	if err := s.Compile(msg); err != nil {
@@ -59,42 +185,265 @@ func (s *State) ExecuteCell(msg kernel.Message, lines []string, skipLines map[in
 
 	// Compilation successful: save merged declarations into current State.
 	s.Decls = tmpDecls
+	s.checkMainSize(msg)
+	if hasMain {
+		s.lastMainDecl = cellMainDecl
+	}
+
+	if !hasMain && !s.hasCustomStubMain() {
+		// Only declarations: nothing to execute.
+		return nil
+	}
+	if s.Cell.Skip {
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, "* Program compiled, not executed (%%skip).\n")
+	}
 
 	// Execute compiled code.
-	return s.Execute(msg)
+	if s.Cell.CaptureDisplay != "" {
+		err = s.executeCapturingDisplays(msg)
+	} else {
+		err = s.Execute(msg)
+	}
+	if err != nil {
+		return err
+	}
+	if s.Cell.Profile != "" {
+		return s.reportProfile(msg)
+	}
+	if s.Cell.MemStats {
+		return s.reportMemStats(msg)
+	}
+	return nil
+}
+
+// Rebuild renders main.go from the current declarations, without any new cell content, and compiles
+// it -- without executing it. It is used to rebuild the program after changes that don't alter the
+// source code but affect the binary (e.g.: the go toolchain, or environment variables).
+func (s *State) Rebuild(msg kernel.Message) error {
+	if err := s.GoToolchainError(); err != nil {
+		return err
+	}
+	if _, err := s.createMainFromDecls(s.withImportPreferences(s.Decls), s.stubMain()); err != nil {
+		return errors.WithMessagef(err, "in goexec.Rebuild() while generating main.go with all declarations")
+	}
+	if err := s.GoImports(msg); err != nil {
+		return errors.WithMessagef(err, "goimports failed")
+	}
+	if err := s.Compile(msg); err != nil {
+		return err
+	}
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout, "* Rebuilt successfully.\n")
+}
+
+// compileDecls renders main.go with the current declarations and compiles it.
+func (s *State) compileDecls(msg kernel.Message) error {
+	if _, err := s.createMainFromDecls(s.withImportPreferences(s.Decls), s.stubMain()); err != nil {
+		return errors.WithMessagef(err, "while generating main.go with all declarations")
+	}
+	if err := s.GoImports(msg); err != nil {
+		return errors.WithMessagef(err, "goimports failed")
+	}
+	return s.Compile(msg)
+}
+
+// Refresh is like Rebuild, but it also refreshes the dependencies: `go get` is run even if AutoGet
+// is disabled, and all packages are rebuilt (`go build -a`), instead of reusing the ones in the
+// build cache. It is used after external changes to dependencies, e.g.: a local module used with a
+// `replace` directive or `%gowork` was changed on disk.
+func (s *State) Refresh(msg kernel.Message) error {
+	if err := s.GoToolchainError(); err != nil {
+		return err
+	}
+	if _, err := s.createMainFromDecls(s.withImportPreferences(s.Decls), s.stubMain()); err != nil {
+		return errors.WithMessagef(err, "in goexec.Refresh() while generating main.go with all declarations")
+	}
+	autoGet := true
+	s.Cell.AutoGet = &autoGet
+	if err := s.GoImports(msg); err != nil {
+		return errors.WithMessagef(err, "goimports failed")
+	}
+	if err := s.compile(msg, "-a"); err != nil {
+		return err
+	}
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout, "* Refreshed successfully.\n")
+}
+
+// displayMainGo displays the generated main.go, as Go code in Markdown (so it is highlighted).
+func (s *State) displayMainGo(msg kernel.Message) error {
+	mainGo, err := s.readMainGo()
+	if err != nil {
+		return err
+	}
+	return kernel.PublishDisplayDataWithMarkdown(msg, "```go\n"+s.RedactSecrets(mainGo)+"```\n")
 }
 
+// BinaryPath is the path of the compiled program: it includes the ".exe" extension on Windows.
+// It is State.OutputPath, if set.
 func (s *State) BinaryPath() string {
-	return path.Join(s.TempDir, s.Package)
+	if s.OutputPath != "" {
+		return s.OutputPath
+	}
+	return filepath.Join(s.TempDir, s.Package+binaryExt())
+}
+
+// binaryExt returns the extension of executable files in the current platform.
+func binaryExt() string {
+	if runtime.GOOS == "windows" {
+		return ".exe"
+	}
+	return ""
 }
 
 func (s *State) MainPath() string {
-	return path.Join(s.TempDir, "main.go")
+	return filepath.Join(s.TempDir, "main.go")
+}
+
+// isCellEmpty returns whether the lines of a cell, except those in skipLines, only have comments
+// and whitespace.
+func isCellEmpty(lines []string, skipLines map[int]bool) bool {
+	var src strings.Builder
+	for ii, line := range lines {
+		if !skipLines[ii] {
+			src.WriteString(line)
+			src.WriteString("\n")
+		}
+	}
+	var sc scanner.Scanner
+	file := token.NewFileSet().AddFile("", -1, src.Len())
+	sc.Init(file, []byte(src.String()), nil, scanner.ScanComments)
+	for {
+		_, tok, lit := sc.Scan()
+		switch {
+		case tok == token.EOF:
+			return sc.ErrorCount == 0 // E.g.: unterminated comments are reported by the compiler.
+		case tok == token.COMMENT, tok == token.SEMICOLON && lit == "\n":
+			// Comments and automatically inserted semicolons.
+		default:
+			return false
+		}
+	}
 }
 
+// Execute the compiled program. If State.Cell.Background is set, it returns as soon as the program
+// is started, and its output is streamed to the notebook as it comes.
 func (s *State) Execute(msg kernel.Message) error {
-	return kernel.PipeExecToJupyter(msg, "", s.BinaryPath(), s.Args...)
+	defer observe(&s.Metrics.Executions, &s.Metrics.ExecuteTime, time.Now())
+	if s.DebugAddress != "" && s.Cell.Background {
+		return errors.Errorf("%%debug can't be used with programs executed in the background")
+	}
+	binaryPath := s.BinaryPath()
+	if s.Cell.Background {
+		// The binary is overwritten by the next compilation, so run a copy of it.
+		var err error
+		if binaryPath, err = s.copyBinaryForBackground(); err != nil {
+			return err
+		}
+	}
+	name, args := binaryPath, s.Args
+	if s.DebugAddress != "" {
+		var err error
+		if name, args, err = s.debugCommand(binaryPath); err != nil {
+			return err
+		}
+		s.reportDebugging(msg)
+	}
+	env := s.programEnv()
+	s.reportExec(msg, "", env, append([]string{name}, args...)...)
+	builder := kernel.NewPipeExecToJupyterBuilder(msg, name, args...).
+		WithEnv(env...).
+		WithOutputLimits(s.OutputLimits).
+		WithANSIMode(s.ANSIMode).
+		WithResourceLimits(s.ResourceLimits).
+		WithDisplaySync()
+	if s.Cell.Terminal != nil {
+		builder.WithPTY(*s.Cell.Terminal)
+	}
+	if s.Cell.Background {
+		builder.InBackground().OnStart(s.addBackgroundProgram).OnExit(func(cmd *exec.Cmd) {
+			s.removeBackgroundProgram(cmd, binaryPath)
+		})
+	} else {
+		builder.OnStart(s.setLastProgram)
+		if s.GoroutineDump && s.DebugAddress == "" {
+			builder.WithGoroutineDump()
+		}
+	}
+	return builder.Exec()
 }
 
+// maxCompileAttempts is the hard limit on the number of times `go build` is run by one compilation,
+// see State.compile.
+const maxCompileAttempts = 3
+
 // Compile compiles the currently generate go files in State.TempDir to a binary named State.Package.
 //
 // If errors in compilation happen, linesPos is used to adjust line numbers to their content in the
 // current cell.
 func (s *State) Compile(msg kernel.Message) error {
-	cmd := exec.Command("go", "build", "-o", s.BinaryPath())
-	cmd.Dir = s.TempDir
-	var output []byte
-	output, err := cmd.CombinedOutput()
+	return s.compile(msg)
+}
+
+// compile implements Compile, with extra flags for `go build`.
+func (s *State) compile(msg kernel.Message, extraFlags ...string) error {
+	defer observe(&s.Metrics.Compilations, &s.Metrics.CompileTime, time.Now())
+	if s.DebugAddress != "" {
+		extraFlags = append(append([]string(nil), debugBuildFlags...), extraFlags...)
+	}
+	buildFlags, err := s.goBuildFlags()
 	if err != nil {
-		s.DisplayErrorWithContext(msg, string(output))
+		return err
+	}
+	args := append(append(append([]string{"build"}, buildFlags...), extraFlags...), "-o", s.BinaryPath())
+	if s.Incremental {
+		if output, ok := s.compileIncremental(msg, args); ok {
+			return s.compiled(msg, output)
+		}
+	}
+	cmd := s.GoCommand(args...)
+	s.reportCommand(msg, cmd)
+	output, err := runGoCommand(msg, cmd)
+	// Retry with unused dot imports dropped: each attempt blanks some lines of main.go, so it ends
+	// on its own, but it is capped anyway, so the kernel can never be wedged recompiling.
+	for attempt := 1; err != nil && attempt < maxCompileAttempts && s.dropUnusedDotImports(output); attempt++ {
+		cmd = s.GoCommand(args...)
+		output, err = runGoCommand(msg, cmd)
+	}
+	if err != nil {
+		s.Metrics.CompileFailures.Add(1)
+		redacted := s.RedactSecrets(output)
+		s.lastBuildError = &BuildError{Output: redacted, Diagnostics: s.withCellLines(parseDiagnostics(redacted))}
+		s.DisplayErrorWithContext(msg, output)
 		return errors.Wrapf(err, "failed to run %q", cmd.String())
 	}
+	return s.compiled(msg, output)
+}
+
+// compiled reports the warnings of a successful build, given its output.
+func (s *State) compiled(msg kernel.Message, output string) error {
+	s.lastBuildError = nil
+	s.displayWarnings(msg, "go build", buildWarnings(output))
+	s.vet(msg)
+	s.reportOutputPath(msg)
 	return nil
 }
 
 // GoImports execute `goimports` which adds imports to non-declared imports automatically.
 // It also runs "go get" to download any missing dependencies.
+//
+// goimports runs exactly once per call, and is never re-run on its own output or after "go get":
+// when its choices and the code disagree (e.g.: an ambiguous package name), the compilation errors
+// are reported to the user, instead of iterating until it stabilizes -- which may never happen.
 func (s *State) GoImports(msg kernel.Message) error {
+	defer observe(&s.Metrics.GoImportsRuns, &s.Metrics.GoImportsTime, time.Now())
+	s.goImportsAdded = nil
+	if s.SkipGoImports {
+		if err := s.addGeneratedCodeImports(); err != nil {
+			return err
+		}
+		return s.autoGet(msg)
+	}
+
+	// exec.LookPath also resolves "goimports.exe" on Windows.
 	goimportsPath, err := exec.LookPath("goimports")
 	if err != nil {
 		_ = kernel.PublishWriteStream(msg, kernel.StreamStderr, `
@@ -107,27 +456,42 @@ can install it from the notebook with:
 `)
 		return errors.WithMessagef(err, "while trying to run goimports\n")
 	}
-	cmd := exec.Command(goimportsPath, "-w", s.MainPath())
+	files := append([]string{s.MainPath()}, s.constrainedFilePaths()...)
+	if s.Cell.Fuzz != "" {
+		files = append(files, s.FuzzPath())
+	}
+	// Keep main.go before goimports, to check it didn't change the declarations.
+	mainBefore, err := os.ReadFile(s.MainPath())
+	if err != nil {
+		return errors.Wrapf(err, "reading %q", s.MainPath())
+	}
+	cmd := exec.Command(goimportsPath, append([]string{"-w"}, files...)...)
 	cmd.Dir = s.TempDir
+	cmd.Env = s.goToolsEnv()
+	s.reportCommand(msg, cmd)
 	var output []byte
 	output, err = cmd.CombinedOutput()
 	if err != nil {
 		s.DisplayErrorWithContext(msg, string(output)+"\n"+err.Error())
 		return errors.Wrapf(err, "failed to run %q", cmd.String())
 	}
+	s.reportGoImportsChanges(msg, mainBefore)
+	s.goImportsAdded = s.goImportsAddedImports(mainBefore)
 
-	// Download missing dependencies.
-	if !s.AutoGet {
-		return nil
+	return s.autoGet(msg)
+}
+
+// autoGet downloads missing dependencies, if State.AutoGet is set -- or if overridden for the cell
+// by `%%autoget`.
+func (s *State) autoGet(msg kernel.Message) error {
+	autoGet := s.AutoGet
+	if s.Cell.AutoGet != nil {
+		autoGet = *s.Cell.AutoGet
 	}
-	cmd = exec.Command("go", "get")
-	cmd.Dir = s.TempDir
-	output, err = cmd.CombinedOutput()
-	if err != nil {
-		s.DisplayErrorWithContext(msg, string(output)+"\n"+err.Error())
-		return errors.Wrapf(err, "failed to run %q", cmd.String())
+	if !autoGet {
+		return nil
 	}
-	return nil
+	return s.goGet(msg)
 }
 
 func (s *State) writeLinesToFile(filePath string, lines <-chan string) (err error) {
@@ -155,18 +519,37 @@ func (s *State) writeLinesToFile(filePath string, lines <-chan string) (err erro
 	return err
 }
 
+// hasMainMagic returns whether the cell has a `%%` (or `%main`) line, for which createGoFileFromLines
+// generates a main function that calls `flag.Parse()`.
+func hasMainMagic(lines []string) bool {
+	insideLiterals := LinesInsideLiterals(lines)
+	for ii, line := range lines {
+		line = strings.TrimRight(line, " ")
+		if !insideLiterals[ii] && (line == "%main" || line == "%%") {
+			return true
+		}
+	}
+	return false
+}
+
 // createGoFileFromLines implements CreateMainGo with no extra functionality (like auto-import).
 func (s *State) createGoFileFromLines(filePath string, lines []string, skipLines map[int]bool, cursorInCell Cursor) (cursorInFile Cursor, err error) {
 	linesChan := make(chan string, 1)
 
 	cursorInFile = cursorInCell
 	lineInFile := int32(0)
+	cellLinesInFile := make(map[int]int, len(lines))
+	fileLines := make([]string, 0, len(lines))
 	go func() {
 		defer close(linesChan)
 		// addLine checks for the new cursorInFile position.
 		addLine := func(line string, lineInCell int32, deltaColumn int32) {
 			linesChan <- line
+			fileLines = append(fileLines, line)
 			lineInFile++
+			if lineInCell != NoCursorLine {
+				cellLinesInFile[int(lineInFile-1)] = int(lineInCell)
+			}
 
 			if !cursorInCell.HasCursor() || lineInCell == NoCursorLine {
 				return
@@ -180,19 +563,41 @@ func (s *State) createGoFileFromLines(filePath string, lines []string, skipLines
 				} else {
 					modLine = line + "*"
 				}
-				log.Printf("Cursor in parse file line %d (cell line %d): %s", cursorInFile.Line, lineInCell, modLine)
+				s.debugf("Cursor in parse file line %d (cell line %d): %s", cursorInFile.Line, lineInCell, modLine)
 			}
 		}
 		addEmptyLine := func() {
 			addLine("", NoCursorLine, 0)
 		}
 
+		// Build constraints must come before the package clause.
+		constraintLines := leadingBuildConstraints(lines, skipLines)
+		for ii := range lines {
+			if constraintLines[ii] {
+				addLine(lines[ii], int32(ii), 0)
+			}
+		}
+		if len(constraintLines) > 0 {
+			addEmptyLine()
+		}
+
 		// Insert package.
 		addLine("package main", NoCursorLine, 0)
 		addEmptyLine()
 
 		var createdFuncMain bool
+		insideLiterals := LinesInsideLiterals(lines)
 		for ii, line := range lines {
+			if constraintLines[ii] {
+				continue
+			}
+			if insideLiterals[ii] {
+				// Contents of multi-line raw strings and comments are preserved as is.
+				if !skipLines[ii] {
+					addLine(line, int32(ii), 0)
+				}
+				continue
+			}
 			line = strings.TrimRight(line, " ")
 			if line == "%main" || line == "%%" {
 				addEmptyLine()
@@ -219,6 +624,8 @@ func (s *State) createGoFileFromLines(filePath string, lines []string, skipLines
 
 	// Pipe linesChan to main.go file.
 	err = s.writeLinesToFile(filePath, linesChan)
+	s.cellLinesInFile = cellLinesInFile
+	s.cellFileLines = fileLines
 
 	// Check for any error only at the end.
 	if err != nil {
@@ -227,11 +634,44 @@ func (s *State) createGoFileFromLines(filePath string, lines []string, skipLines
 	return
 }
 
+var reBuildConstraint = regexp.MustCompile(`^//(go:build|\s*\+build)\s`)
+
+// leadingBuildConstraints returns the lines with build constraints (`//go:build` or `// +build`)
+// in the leading comments of the cell, that is, before any Go code.
+func leadingBuildConstraints(lines []string, skipLines map[int]bool) map[int]bool {
+	constraintLines := make(map[int]bool)
+	for ii, line := range lines {
+		if skipLines[ii] {
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+		if reBuildConstraint.MatchString(line + " ") {
+			constraintLines[ii] = true
+		}
+	}
+	return constraintLines
+}
+
 func (s *State) createMainFromDecls(decls *Declarations, mainDecl *Function) (cursor Cursor, err error) {
+	if err = s.writeConstrainedFiles(); err != nil {
+		return NoCursor, err
+	}
+	return s.createMainFileFromDecls(s.MainPath(), decls, mainDecl)
+}
+
+// createMainFileFromDecls implements createMainFromDecls, writing the program to filePath.
+func (s *State) createMainFileFromDecls(filePath string, decls *Declarations, mainDecl *Function) (cursor Cursor, err error) {
 	cursor = NoCursor
+	decls = s.withPrelude(decls)
 
 	var f *os.File
-	f, err = os.Create(s.MainPath())
+	f, err = os.Create(filePath)
 	if err != nil {
 		return
 	}
@@ -302,5 +742,7 @@ func (s *State) createMainFromDecls(decls *Declarations, mainDecl *Function) (cu
 		//log.Printf("Cursor in \"main\": %v", cursor)
 	}
 	w("%s\n", mainDecl.Definition)
+	s.mainLines, s.mainDecls = lineNum, decls.numDecls()
+	s.mainGeneratedImports = mainDecl.generatedImports
 	return
 }
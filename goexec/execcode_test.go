@@ -0,0 +1,184 @@
+package goexec
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaths(t *testing.T) {
+	s := &State{Package: "gonb_12345678", TempDir: filepath.Join("tmp", "gonb_12345678")}
+	if runtime.GOOS == "windows" {
+		assert.Equal(t, `tmp\gonb_12345678\gonb_12345678.exe`, s.BinaryPath())
+		assert.Equal(t, `tmp\gonb_12345678\main.go`, s.MainPath())
+	} else {
+		assert.Equal(t, "tmp/gonb_12345678/gonb_12345678", s.BinaryPath())
+		assert.Equal(t, "tmp/gonb_12345678/main.go", s.MainPath())
+	}
+}
+
+func TestBuildConstraints(t *testing.T) {
+	s := &State{TempDir: t.TempDir(), Decls: NewDeclarations()}
+	lines := []string{
+		`// Cell only for linux.`,
+		`//go:build linux`,
+		`// +build linux`,
+		``,
+		`func f() int { return 1 }`,
+		`//go:build ignored, not leading`,
+	}
+	_, err := s.createGoFileFromLines(s.MainPath(), lines, nil, NoCursor)
+	require.NoError(t, err)
+	content, err := os.ReadFile(s.MainPath())
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "//go:build linux\n// +build linux\n\npackage main\n\n// Cell only for linux.\n"),
+		"got:\n%s", content)
+	assert.Contains(t, string(content), "\n//go:build ignored, not leading\n")
+	assert.Equal(t, 1, s.cellLinesInFile[0])
+
+	decls := NewDeclarations()
+	require.NoError(t, s.ParseImportsFromMainGo(nil, NoCursor, decls))
+	assert.Contains(t, decls.Functions, "f")
+}
+
+// testMessage is a kernel.Message that records what is published, for tests that don't run a
+// kernel. Methods not implemented panic.
+type testMessage struct {
+	kernel.Message
+	kernel *kernel.Kernel
+
+	// mu protects published: programs' output is published from other goroutines.
+	mu        sync.Mutex
+	published []string
+}
+
+func newTestMessage() *testMessage {
+	return &testMessage{kernel: &kernel.Kernel{}}
+}
+
+// newTestState returns a State with its own temporary directory, for tests that compile and
+// execute cells. It skips the test if the go toolchain is not available. Goimports is not used: it
+// may not be installed.
+func newTestState(tb testing.TB, options ...Option) *State {
+	tb.Helper()
+	if _, err := exec.LookPath("go"); err != nil {
+		tb.Skipf("go toolchain not available: %v", err)
+	}
+	s, err := NewState(append([]Option{WithTempDir(tb.TempDir()), WithAutoGet(false)}, options...)...)
+	require.NoError(tb, err)
+	s.SkipGoImports = true
+	return s
+}
+
+// useGoToolchain configures s, created without NewState, to compile with the go toolchain found in
+// PATH. It skips the test if it is not available.
+func useGoToolchain(tb testing.TB, s *State) {
+	tb.Helper()
+	goBinary, err := exec.LookPath("go")
+	if err != nil {
+		tb.Skipf("go toolchain not available: %v", err)
+	}
+	s.GoBinary = goBinary
+	require.NoError(tb, s.initGoToolchain())
+}
+
+func (m *testMessage) Kernel() *kernel.Kernel { return m.kernel }
+
+func (m *testMessage) Publish(msgType string, content any) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.published = append(m.published, fmt.Sprintf("%s: %+v", msgType, content))
+	return nil
+}
+
+// Published returns a copy of the messages published so far.
+func (m *testMessage) Published() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.published...)
+}
+
+// TestExecuteCellAfterFailures checks that failing cells don't leave the State in a state that
+// breaks the following cells.
+func TestExecuteCellAfterFailures(t *testing.T) {
+	s := newTestState(t)
+	msg := newTestMessage()
+	execute := func(lines ...string) error {
+		return s.ExecuteCell(msg, lines, nil)
+	}
+
+	require.NoError(t, execute(`func good() int { return 1 }`))
+
+	// Compilation error: none of the declarations of the cell are kept.
+	require.Error(t, execute(`var y = 1`, `func bad() int { return "x" }`))
+	require.NotNil(t, s.LastError())
+	require.Len(t, s.LastError().Diagnostics, 1)
+	assert.Equal(t, "main.go", s.LastError().Diagnostics[0].File)
+	assert.NotContains(t, s.Decls.Variables, "y")
+	assert.NotContains(t, s.Decls.Functions, "bad")
+	require.NoError(t, execute(`func useGood() int { return good() }`))
+	assert.Nil(t, s.LastError())
+
+	// Syntax error.
+	require.Error(t, execute(`func broken( {`))
+	require.NoError(t, execute(`func useGood2() int { return useGood() }`))
+
+	// A file left behind in the module directory (e.g.: by an interrupted %fuzz) is not parsed as
+	// part of the cell.
+	require.NoError(t, os.WriteFile(s.FuzzPath(), []byte("package main\n\nimport \"testing\"\n\nfunc FuzzX(f *testing.F) {}\n"), 0600))
+	require.NoError(t, execute(`func useGood3() int { return useGood2() }`))
+	assert.NotContains(t, s.Decls.Functions, "FuzzX")
+	assert.Contains(t, s.Decls.Functions, "useGood3")
+}
+
+// TestGroupedVariables checks that variables declared together keep the order of their
+// initialization, even if not sorted by name.
+func TestGroupedVariables(t *testing.T) {
+	s := newTestState(t)
+	msg := newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{
+		`var counter int`,
+		`func next() int { counter++; return counter }`,
+		`var (`,
+		`	zeta = next()`,
+		`	alpha = next()`,
+		`	middle = zeta + alpha + offset`,
+		`	offset = 100`,
+		`)`,
+	}, nil))
+	assert.Equal(t, s.Decls.Variables["zeta"].Group, s.Decls.Variables["offset"].Group)
+	assert.Empty(t, s.Decls.Variables["counter"].Group)
+
+	// Referenced by a later cell.
+	require.NoError(t, s.ExecuteCell(msg, []string{`var digits = zeta*1000 + alpha*100 + middle`}, nil))
+	require.NoError(t, s.Freeze(msg, []string{"digits"}))
+	assert.Equal(t, "1303", s.Decls.Variables["digits"].ValueDefinition)
+}
+
+func TestIsCellEmpty(t *testing.T) {
+	assert.True(t, isCellEmpty(nil, nil))
+	assert.True(t, isCellEmpty([]string{"", "  // Some notes.", "\t", "/* More", "notes. */"}, nil))
+	assert.True(t, isCellEmpty([]string{"%env A 1", "// Notes."}, map[int]bool{0: true}))
+	assert.False(t, isCellEmpty([]string{"// Notes.", "var x = 1"}, nil))
+	assert.False(t, isCellEmpty([]string{"%%", "// Notes."}, nil))
+	assert.False(t, isCellEmpty([]string{"/* Unterminated"}, nil))
+
+	// Nothing is compiled for cells with only comments.
+	s, err := NewState(WithTempDir(t.TempDir()), WithAutoGet(false))
+	require.NoError(t, err)
+	if s.GoToolchainError() != nil {
+		t.Skipf("go toolchain not available: %v", s.GoToolchainError())
+	}
+	require.NoError(t, s.ExecuteCell(newTestMessage(), []string{"// Just a note.", ""}, nil))
+	_, err = os.Stat(s.MainPath())
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,42 @@
+package goexec
+
+import "sync"
+
+// executionQueue is a lock that is granted in the order it was requested (FIFO), so cells requested
+// to execute concurrently (e.g.: by the front-end, the HTTP control endpoint and `%watch`) are
+// executed one at a time, in order. See State.LockExecution.
+type executionQueue struct {
+	mu      sync.Mutex
+	locked  bool
+	waiting []chan struct{}
+}
+
+// Lock waits for the previous holders of the lock to release it.
+func (q *executionQueue) Lock() {
+	q.mu.Lock()
+	if !q.locked {
+		q.locked = true
+		q.mu.Unlock()
+		return
+	}
+	turn := make(chan struct{})
+	q.waiting = append(q.waiting, turn)
+	q.mu.Unlock()
+	<-turn
+}
+
+// Unlock passes the lock to the next in line, if any.
+func (q *executionQueue) Unlock() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.locked {
+		panic("goexec: unlock of unlocked execution queue")
+	}
+	if len(q.waiting) == 0 {
+		q.locked = false
+		return
+	}
+	next := q.waiting[0]
+	q.waiting = q.waiting[1:]
+	close(next) // The lock is handed over, it remains locked.
+}
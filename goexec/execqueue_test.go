@@ -0,0 +1,46 @@
+package goexec
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecutionQueue(t *testing.T) {
+	var (
+		q     executionQueue
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		order []int
+	)
+	q.Lock()
+	const numWaiting = 5
+	for ii := 0; ii < numWaiting; ii++ {
+		wg.Add(1)
+		go func(ii int) {
+			defer wg.Done()
+			q.Lock()
+			mu.Lock()
+			order = append(order, ii)
+			mu.Unlock()
+			q.Unlock()
+		}(ii)
+		// Wait for it to be in line, before requesting the next one.
+		for {
+			q.mu.Lock()
+			inLine := len(q.waiting) == ii+1
+			q.mu.Unlock()
+			if inLine {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	q.Unlock()
+	wg.Wait()
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, order)
+	assert.False(t, q.locked)
+	assert.Panics(t, q.Unlock)
+}
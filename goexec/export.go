@@ -0,0 +1,118 @@
+package goexec
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// This file implements `%export <dir>`: the program assembled from the current declarations is
+// written as a standalone Go project, that can be built outside gonb.
+
+// Export writes the program with the current declarations, and the main function of the last
+// program executed (or a stub main, see State.StubMainBody), as a standalone Go project in dir.
+// It includes the module files (go.mod and go.sum), the packages defined with `%%package`, and
+// the files written with `%%file` and `%%asm`.
+//
+// dir must not exist, or be empty. The module keeps the name of the notebook's module (State.Package),
+// since it's used in the import path of the packages.
+func (s *State) Export(msg kernel.Message, dir string) error {
+	if err := s.GoToolchainError(); err != nil {
+		return err
+	}
+	dir, err := s.checkExportDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// Generate main.go.
+	mainDecl := s.lastMainDecl
+	if mainDecl == nil {
+		mainDecl = s.stubMain()
+	}
+	if _, err = s.createMainFromDecls(s.withImportPreferences(s.Decls), mainDecl); err != nil {
+		return errors.WithMessagef(err, "in goexec.Export() while generating main.go with all declarations")
+	}
+	if err = s.GoImports(msg); err != nil {
+		return errors.WithMessagef(err, "goimports failed")
+	}
+	if err = s.exportProject(dir); err != nil {
+		return err
+	}
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout,
+		fmt.Sprintf("* Exported module %s to %s: build it with \"go build\" in the directory.\n", s.Package, dir))
+}
+
+// checkExportDir returns the absolute path of dir, if it can be exported to.
+func (s *State) checkExportDir(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid export directory %q", dir)
+	}
+	if rel, err := filepath.Rel(s.TempDir, dir); err == nil && !strings.HasPrefix(rel, "..") {
+		return "", errors.Errorf("can't export to %q, it's inside the notebook's module directory", dir)
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return "", errors.Errorf("can't export to %q, directory is not empty", dir)
+	}
+	return dir, nil
+}
+
+// exportProject copies the project files, with the main.go already generated, to dir.
+func (s *State) exportProject(dir string) error {
+	files := []string{"main.go", "go.mod", "go.sum"}
+	files = append(files, s.ListFiles()...)
+	packages, err := s.listPackages()
+	if err != nil {
+		return err
+	}
+	for _, name := range packages {
+		files = append(files, filepath.ToSlash(filepath.Join(name, name+".go")))
+	}
+	for _, relPath := range files {
+		if err = copyProjectFile(filepath.Join(s.TempDir, filepath.FromSlash(relPath)), filepath.Join(dir, filepath.FromSlash(relPath))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listPackages returns the names of the packages defined with `%%package` (see WritePackage).
+func (s *State) listPackages() ([]string, error) {
+	entries, err := os.ReadDir(s.TempDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing %q", s.TempDir)
+	}
+	var packages []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(s.PackagePath(entry.Name())); err == nil {
+			packages = append(packages, entry.Name())
+		}
+	}
+	return packages, nil
+}
+
+// copyProjectFile copies the file at src to dst, creating its directory if needed. It's a no-op if src
+// doesn't exist (e.g.: go.sum before any dependency is added).
+func copyProjectFile(src, dst string) error {
+	content, err := os.ReadFile(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "reading %q", src)
+	}
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return errors.Wrapf(err, "creating directory for %q", dst)
+	}
+	if err = os.WriteFile(dst, content, 0644); err != nil {
+		return errors.Wrapf(err, "writing %q", dst)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package goexec
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportProject(t *testing.T) {
+	s := &State{Package: "gonb_export_test", TempDir: t.TempDir(), Decls: NewDeclarations()}
+	require.NoError(t, s.WriteGoMod([]string{"go 1.20"}))
+	require.NoError(t, s.WriteFile("data/hello.txt", []string{"hello"}))
+	_, err := s.WritePackage("greet", []string{`func Hello() string { return "hello" }`})
+	require.NoError(t, err)
+	parseCellIntoState(t, s, []string{`import "gonb_export_test/greet"`, `func f() string { return greet.Hello() }`})
+	mainDecl := &Function{Key: "main", Name: "main", Definition: "func main() { print(f()) }"}
+	_, err = s.createMainFromDecls(s.Decls, mainDecl)
+	require.NoError(t, err)
+
+	_, err = s.checkExportDir(filepath.Join(s.TempDir, "export"))
+	assert.Error(t, err, "exporting inside TempDir should fail")
+	_, err = s.checkExportDir(s.TempDir)
+	assert.Error(t, err, "exporting to a non-empty directory should fail")
+	dir, err := s.checkExportDir(filepath.Join(t.TempDir(), "project"))
+	require.NoError(t, err)
+	require.NoError(t, s.exportProject(dir))
+	for _, relPath := range []string{"main.go", "go.mod", "data/hello.txt", "greet/greet.go"} {
+		assert.FileExists(t, filepath.Join(dir, relPath))
+	}
+	assert.NoFileExists(t, filepath.Join(dir, "go.sum"))
+
+	// Build the exported project, if the go toolchain is available.
+	goBinary, err := exec.LookPath("go")
+	if err != nil {
+		t.Skipf("go toolchain not available: %v", err)
+	}
+	cmd := exec.Command(goBinary, "build", "-o", filepath.Join(t.TempDir(), "project"))
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod")
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, "go build output: %s", output)
+}
@@ -0,0 +1,81 @@
+package goexec
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// This file implements extra files, defined with `%%file <path>`, written in the notebook's module
+// directory (State.TempDir), so they can be used by the program -- e.g.: with `//go:embed <path>`.
+//
+// Files remain defined across cells, until removed with ClearFiles (`%files clear`).
+
+// WriteFile writes the lines as the contents of the file at relPath, relative to State.TempDir.
+//
+// relPath must be local to State.TempDir, and can't be a Go file (use `%%package` for those) or one
+// of the files managed by gonb (e.g.: "go.mod").
+func (s *State) WriteFile(relPath string, lines []string) error {
+	relPath = filepath.Clean(filepath.FromSlash(relPath))
+	if relPath == "." || filepath.IsAbs(relPath) || relPath == ".." ||
+		strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return errors.Errorf("invalid file path %q: it must be relative to the notebook's module directory", relPath)
+	}
+	if filepath.Ext(relPath) == ".go" {
+		return errors.Errorf("invalid file path %q: Go files are not supported, use %%%%package instead", relPath)
+	}
+	if relPath == "go.mod" || relPath == "go.sum" {
+		return errors.Errorf("invalid file path %q: it is managed by gonb, use %%%%go.mod or !go commands instead", relPath)
+	}
+
+	filePath := filepath.Join(s.TempDir, relPath)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+		return errors.Wrapf(err, "creating directory for file %q", relPath)
+	}
+	if err := os.WriteFile(filePath, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return errors.Wrapf(err, "writing file %q", filePath)
+	}
+	if s.Files == nil {
+		s.Files = make(map[string]bool)
+	}
+	s.Files[filepath.ToSlash(relPath)] = true
+	return nil
+}
+
+// WriteAssembly writes the lines as the assembly file (Go's Plan 9 assembly) fileName, in the
+// notebook's main package directory, so it is assembled and linked with the program by `go build`.
+// The functions it implements must be declared (without a body) in Go, e.g.:
+//
+//	func add(a, b int64) int64
+//
+// The file is listed and removed along the ones written with WriteFile.
+func (s *State) WriteAssembly(fileName string, lines []string) error {
+	if filepath.Ext(fileName) != ".s" || strings.ContainsAny(fileName, `/\`) {
+		return errors.Errorf("invalid assembly file name %q: it must be a \".s\" file, without directories", fileName)
+	}
+	return s.WriteFile(fileName, lines)
+}
+
+// ListFiles returns the paths (relative to State.TempDir) of the files written with WriteFile, sorted.
+func (s *State) ListFiles() []string {
+	files := make([]string, 0, len(s.Files))
+	for relPath := range s.Files {
+		files = append(files, relPath)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// ClearFiles removes the files written with WriteFile.
+func (s *State) ClearFiles() error {
+	for relPath := range s.Files {
+		filePath := filepath.Join(s.TempDir, filepath.FromSlash(relPath))
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "removing file %q", filePath)
+		}
+		delete(s.Files, relPath)
+	}
+	return nil
+}
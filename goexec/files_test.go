@@ -0,0 +1,62 @@
+package goexec
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFile(t *testing.T) {
+	s := &State{TempDir: t.TempDir()}
+	require.NoError(t, s.WriteFile("data.txt", []string{"hello", "world"}))
+	require.NoError(t, s.WriteFile("assets/x.json", []string{"{}"}))
+	content, err := os.ReadFile(filepath.Join(s.TempDir, "data.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello\nworld\n", string(content))
+	assert.Equal(t, []string{"assets/x.json", "data.txt"}, s.ListFiles())
+
+	for _, invalid := range []string{"../x.txt", "/tmp/x.txt", "a/../../x", "x.go", "go.mod", "."} {
+		assert.Error(t, s.WriteFile(invalid, nil), "path %q", invalid)
+	}
+
+	require.NoError(t, s.ClearFiles())
+	assert.Empty(t, s.ListFiles())
+	assert.NoFileExists(t, filepath.Join(s.TempDir, "data.txt"))
+}
+
+func TestWriteAssembly(t *testing.T) {
+	s := &State{Package: "gonb_asm_test", TempDir: t.TempDir(), Decls: NewDeclarations()}
+	for _, invalid := range []string{"add.c", "dir/add.s", "add"} {
+		assert.Error(t, s.WriteAssembly(invalid, nil), "file name %q", invalid)
+	}
+	if runtime.GOARCH != "amd64" {
+		t.Skipf("assembly test only for amd64")
+	}
+	require.NoError(t, s.WriteAssembly("add_amd64.s", []string{
+		`#include "textflag.h"`,
+		``,
+		`TEXT ·add(SB), NOSPLIT, $0-24`,
+		`	MOVQ a+0(FP), AX`,
+		`	ADDQ b+8(FP), AX`,
+		`	MOVQ AX, ret+16(FP)`,
+		`	RET`,
+	}))
+	assert.Equal(t, []string{"add_amd64.s"}, s.ListFiles())
+	parseCellIntoState(t, s, []string{`func add(a, b int64) int64`})
+
+	// Compile and run, if the go toolchain is available.
+	useGoToolchain(t, s)
+	mainDecl := &Function{Key: "main", Name: "main", Definition: "func main() { print(add(40, 2)) }"}
+	_, err := s.createMainFromDecls(s.Decls, mainDecl)
+	require.NoError(t, err)
+	output, err := s.GoCommand("build", "-o", s.BinaryPath()).CombinedOutput()
+	require.NoError(t, err, "go build output: %s", output)
+	output, err = exec.Command(filepath.Clean(s.BinaryPath())).CombinedOutput()
+	require.NoError(t, err)
+	assert.Equal(t, "42", string(output))
+}
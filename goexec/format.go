@@ -0,0 +1,109 @@
+package goexec
+
+import (
+	"fmt"
+	"go/format"
+	"go/scanner"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// formatMainFunc replaces the main marker ("%%" or "%main") of the cell in the Go source being formatted.
+const formatMainFunc = "func gonbFormatMain() {"
+
+// formatKeepPrefix is the prefix of the comments replacing the lines that are not Go code (special
+// commands) in the Go source being formatted, followed by the line number in the cell.
+const formatKeepPrefix = "//gonb:fmt:"
+
+// FormatCell formats the Go code of the cell with `go/format` (the same as `gofmt`), and returns
+// the formatted cell, see `%fmt`. Lines in skipLines (special commands, already handled) are kept
+// as they are, and the `%fmt` command itself is removed.
+//
+// If the code has syntax errors, they are returned with the line numbers of the cell.
+func FormatCell(lines []string, skipLines map[int]bool) (string, error) {
+	// Build a Go file with one line per line of the cell, after a package line.
+	src := make([]string, 0, len(lines)+2)
+	src = append(src, "package main")
+	hasMain := false
+	insideLiterals := LinesInsideLiterals(lines)
+	for ii, line := range lines {
+		trimmed := strings.TrimRight(line, " ")
+		switch {
+		case insideLiterals[ii]:
+			src = append(src, line)
+		case !hasMain && (trimmed == "%%" || trimmed == "%main"):
+			hasMain = true
+			src = append(src, formatMainFunc)
+		case skipLines[ii]:
+			src = append(src, formatKeepPrefix+strconv.Itoa(ii))
+		default:
+			src = append(src, line)
+		}
+	}
+	if hasMain {
+		src = append(src, "}")
+	}
+	formatted, err := format.Source([]byte(strings.Join(src, "\n")))
+	if err != nil {
+		return "", cellSyntaxError(err)
+	}
+
+	// Convert the formatted Go file back to a cell.
+	formattedLines := strings.Split(strings.TrimRight(string(formatted), "\n"), "\n")
+	formattedLines = formattedLines[1:] // Package line.
+	for len(formattedLines) > 0 && formattedLines[0] == "" {
+		formattedLines = formattedLines[1:]
+	}
+	if hasMain {
+		formattedLines = formattedLines[:len(formattedLines)-1] // Closing "}" of the main function.
+	}
+	insideLiterals = LinesInsideLiterals(formattedLines)
+	cellLines := make([]string, 0, len(formattedLines))
+	insideMain := false
+	for ii, line := range formattedLines {
+		if insideLiterals[ii] {
+			cellLines = append(cellLines, line)
+			continue
+		}
+		if line == formatMainFunc {
+			insideMain = true
+			for _, mainLine := range lines {
+				if trimmed := strings.TrimRight(mainLine, " "); trimmed == "%%" || trimmed == "%main" {
+					cellLines = append(cellLines, trimmed)
+					break
+				}
+			}
+			continue
+		}
+		if insideMain {
+			line = strings.TrimPrefix(line, "\t")
+		}
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, formatKeepPrefix) {
+			lineNum, err := strconv.Atoi(trimmed[len(formatKeepPrefix):])
+			if err == nil && lineNum >= 0 && lineNum < len(lines) {
+				if strings.TrimSpace(lines[lineNum]) == "%fmt" {
+					continue
+				}
+				line = lines[lineNum]
+			}
+		}
+		cellLines = append(cellLines, line)
+	}
+	return strings.Join(cellLines, "\n"), nil
+}
+
+// cellSyntaxError converts the errors of `go/format` to errors with the line numbers of the cell
+// formatted by FormatCell, which is one line before the Go source.
+func cellSyntaxError(err error) error {
+	var errList scanner.ErrorList
+	if !errors.As(err, &errList) || len(errList) == 0 {
+		return errors.Wrap(err, "formatting cell")
+	}
+	parts := make([]string, 0, len(errList))
+	for _, syntaxErr := range errList {
+		parts = append(parts, fmt.Sprintf("line %d:%d: %s", syntaxErr.Pos.Line-1, syntaxErr.Pos.Column, syntaxErr.Msg))
+	}
+	return errors.Errorf("syntax error, cell not formatted:\n%s", strings.Join(parts, "\n"))
+}
@@ -0,0 +1,40 @@
+package goexec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatCell(t *testing.T) {
+	lines := []string{
+		"%fmt",
+		`import "fmt"`,
+		"func  add(a,b int)int{return a+b}",
+		"%env FOO bar",
+		"%%",
+		"x:=add(1,2)",
+		"  fmt.Println(`raw",
+		"  string`, x)",
+	}
+	formatted, err := FormatCell(lines, map[int]bool{0: true, 3: true})
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		`import "fmt"`,
+		"",
+		"func add(a, b int) int { return a + b }",
+		"",
+		"%env FOO bar",
+		"%%",
+		"x := add(1, 2)",
+		"fmt.Println(`raw",
+		"  string`, x)",
+	}, "\n"), formatted)
+
+	// Syntax errors are reported with the line of the cell.
+	_, err = FormatCell([]string{"%fmt", "func f() {", "  x := ", "}"}, map[int]bool{0: true})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 4:1:")
+}
@@ -0,0 +1,191 @@
+package goexec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+)
+
+// This file implements `%freeze`: package-level variables are initialized at the start of every
+// program compiled afterwards -- Go orders the initialization by dependencies, so initializers can
+// call functions declared in other cells. For expensive initializers, Freeze evaluates them once
+// and replaces the declarations by the values obtained, as Go literals.
+
+const (
+	// freezeMarker prefixes the lines with the values printed by the program built by Freeze, to
+	// distinguish them from any other output of the initializers.
+	freezeMarker = "gonb_freeze:"
+
+	// freezeFmtAlias is the alias of the "fmt" import used by the program built by Freeze, so it
+	// doesn't conflict with the cells' imports.
+	freezeFmtAlias = "gonbFreezeFmt"
+)
+
+// DefaultFreezeTimeout is the default for State.FreezeTimeout.
+const DefaultFreezeTimeout = 5 * time.Minute
+
+// reUnfreezable matches values printed with `%#v` that are not valid Go literals: pointers (other
+// than to composite literals), functions, channels and unsafe pointers are printed as addresses.
+var reUnfreezable = regexp.MustCompile(`\)\(0x[0-9a-f]+\)|^0x[0-9a-f]+$`)
+
+// Freeze evaluates the initializers of the given package-level variables once, and replaces their
+// declarations by the values obtained, as Go literals (printed with `%#v`), with their types. So the
+// initializers are no longer executed at the start of the following programs.
+//
+// Only values that can be represented as Go literals can be frozen: e.g.: not functions, channels
+// or types with unexported fields from other packages. The frozen declarations are compiled before
+// being committed, and an error is returned if they are not valid.
+//
+// The program evaluating the variables (and anything it spawns) is killed if the kernel is
+// interrupted, or if it runs for longer than State.FreezeTimeout.
+func (s *State) Freeze(msg kernel.Message, names []string) error {
+	if err := s.GoToolchainError(); err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return errors.Errorf("%%freeze requires the names of the variables to freeze")
+	}
+	var body strings.Builder
+	for _, name := range names {
+		v, found := s.Decls.Variables[name]
+		if !found || name == "_" {
+			return errors.Errorf("variable %q is not declared", name)
+		}
+		if v.ValueDefinition == "" {
+			return errors.Errorf("variable %q has no initializer to freeze", name)
+		}
+		fmt.Fprintf(&body, "\t%s.Printf(\"%s%%T\\n%s%%#v\\n\", %s, %s)\n",
+			freezeFmtAlias, freezeMarker, freezeMarker, name, name)
+	}
+
+	// Build and execute a program that prints the type and value of the variables.
+	decls := s.Decls.Copy()
+	fmtImport := NewImport("fmt", freezeFmtAlias)
+	decls.Imports[fmtImport.Key] = fmtImport
+	mainDecl := &Function{Key: "main", Name: "main", Definition: "func main() {\n" + body.String() + "}"}
+	if _, err := s.createMainFromDecls(s.withImportPreferences(decls), mainDecl); err != nil {
+		return errors.WithMessagef(err, "in goexec.Freeze() while generating main.go")
+	}
+	if err := s.GoImports(msg); err != nil {
+		return errors.WithMessagef(err, "goimports failed")
+	}
+	if err := s.Compile(msg); err != nil {
+		return err
+	}
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if s.FreezeTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), s.FreezeTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	if k := msg.Kernel(); k != nil {
+		defer k.OnInterrupt(cancel)()
+	}
+	cmd := exec.CommandContext(ctx, s.BinaryPath(), s.Args...)
+	kernel.SetProcessGroup(cmd)
+	cmd.Cancel = func() error { return kernel.KillProcessGroup(cmd) }
+	cmd.Env = append(os.Environ(), s.secretsEnv()...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	s.reportExec(msg, "", s.secretsEnv(), cmd.Args...)
+	output, err := cmd.Output()
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return errors.Errorf("evaluating the variables to freeze took longer than %s, it was killed", s.FreezeTimeout)
+	case ctx.Err() != nil:
+		return errors.Errorf("evaluating the variables to freeze was interrupted")
+	case err != nil:
+		return errors.Wrapf(err, "failed to evaluate the variables to freeze: %s", s.RedactSecrets(stderr.String()))
+	}
+	var printed []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if value, found := strings.CutPrefix(line, freezeMarker); found {
+			printed = append(printed, value)
+		}
+	}
+	if len(printed) != 2*len(names) {
+		return errors.Errorf("failed to read the values of the variables to freeze, got %q", output)
+	}
+
+	// Replace the declarations, and check they compile.
+	frozenDecls := s.Decls.Copy()
+	var report strings.Builder
+	for ii, name := range names {
+		typeName, value := printed[2*ii], printed[2*ii+1]
+		if reUnfreezable.MatchString(value) {
+			return errors.Errorf("variable %q can't be frozen, its value %s of type %s can't be written as a Go literal",
+				name, value, typeName)
+		}
+		v := frozenDecls.Variables[name]
+		if v.TypeDefinition == "" {
+			v.TypeDefinition = removeMainQualifier(typeName)
+		}
+		v.ValueDefinition = removeMainQualifier(value)
+		fmt.Fprintf(&report, "* Frozen %s %s = %s\n", name, v.TypeDefinition, truncateForDisplay(v.ValueDefinition))
+	}
+	if _, err := s.createMainFromDecls(s.withImportPreferences(frozenDecls), s.stubMain()); err != nil {
+		return errors.WithMessagef(err, "in goexec.Freeze() while generating main.go")
+	}
+	if err := s.GoImports(msg); err != nil {
+		return errors.WithMessagef(err, "goimports failed")
+	}
+	if err := s.Compile(msg); err != nil {
+		return errors.WithMessagef(err, "the frozen values of %q are not valid Go code, the variables are left unchanged", names)
+	}
+	s.Decls = frozenDecls
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout, report.String())
+}
+
+// removeMainQualifier removes the "main." package qualifier that `%T` and `%#v` prefix to the
+// types declared in the cells, except inside string and rune literals.
+func removeMainQualifier(value string) string {
+	var result strings.Builder
+	for pos := 0; pos < len(value); pos++ {
+		c := value[pos]
+		switch {
+		case c == '"' || c == '\'' || c == '`':
+			end := pos + 1
+			for ; end < len(value) && value[end] != c; end++ {
+				if value[end] == '\\' && c != '`' {
+					end++
+				}
+			}
+			if end >= len(value) {
+				end = len(value) - 1
+			}
+			result.WriteString(value[pos : end+1])
+			pos = end
+		case strings.HasPrefix(value[pos:], "main.") && (pos == 0 || !isIdentifierByte(value[pos-1])):
+			pos += len("main.") - 1
+		default:
+			result.WriteByte(c)
+		}
+	}
+	return result.String()
+}
+
+// isIdentifierByte returns whether c can be part of a Go identifier (ASCII only).
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// truncateForDisplay shortens long values displayed in reports, to at most 80 runes.
+func truncateForDisplay(value string) string {
+	const maxLen = 80
+	if runes := []rune(value); len(runes) > maxLen {
+		return string(runes[:maxLen]) + "..."
+	}
+	return value
+}
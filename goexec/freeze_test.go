@@ -0,0 +1,62 @@
+package goexec
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveMainQualifier(t *testing.T) {
+	assert.Equal(t, "[]Point{Point{X:1, Y:2}}", removeMainQualifier("[]main.Point{main.Point{X:1, Y:2}}"))
+	assert.Equal(t, `map[string]Celsius{"main.go":10, "domain.com":20}`,
+		removeMainQualifier(`map[string]main.Celsius{"main.go":10, "domain.com":20}`))
+	assert.Equal(t, `time.Duration`, removeMainQualifier(`time.Duration`))
+	assert.Equal(t, `&Node{Name:"a\"main.b"}`, removeMainQualifier(`&main.Node{Name:"a\"main.b"}`))
+}
+
+func TestFreeze(t *testing.T) {
+	s := newTestState(t)
+	msg := newTestMessage()
+	execute := func(lines ...string) {
+		require.NoError(t, s.ExecuteCell(msg, lines, nil))
+	}
+
+	// Initializers calling functions of other cells, and depending on variables declared later.
+	execute(`func computeDefault() int { return 42 }`)
+	execute(`type Point struct { X, Y int }`)
+	execute(`var x = computeDefault()`)
+	execute(`var a = Point{X: x * 2, Y: 1}`, `var names = []string{"main.go", computeName()}`,
+		`func computeName() string { return "b" }`)
+
+	require.NoError(t, s.Freeze(msg, []string{"x", "a", "names"}))
+	assert.Equal(t, "int", s.Decls.Variables["x"].TypeDefinition)
+	assert.Equal(t, "42", s.Decls.Variables["x"].ValueDefinition)
+	assert.Equal(t, "Point", s.Decls.Variables["a"].TypeDefinition)
+	assert.Equal(t, "Point{X:84, Y:1}", s.Decls.Variables["a"].ValueDefinition)
+	assert.Equal(t, `[]string{"main.go", "b"}`, s.Decls.Variables["names"].ValueDefinition)
+
+	// Values that can't be frozen, or variables without initializers.
+	execute(`var f = computeDefault`, `var empty int`)
+	assert.ErrorContains(t, s.Freeze(msg, []string{"f"}), "can't be frozen")
+	assert.ErrorContains(t, s.Freeze(msg, []string{"empty"}), "no initializer")
+	assert.ErrorContains(t, s.Freeze(msg, []string{"missing"}), "not declared")
+	assert.Equal(t, "computeDefault", s.Decls.Variables["f"].ValueDefinition)
+
+	// Initializers that take too long are killed.
+	s.FreezeTimeout = 200 * time.Millisecond
+	execute(`import "time"`, `var slow = func() int { time.Sleep(time.Hour); return 1 }()`)
+	start := time.Now()
+	assert.ErrorContains(t, s.Freeze(msg, []string{"slow"}), "took longer than 200ms")
+	assert.Less(t, time.Since(start), time.Minute)
+}
+
+func TestTruncateForDisplay(t *testing.T) {
+	assert.Equal(t, "short", truncateForDisplay("short"))
+	truncated := truncateForDisplay(strings.Repeat("😀", 100))
+	assert.True(t, utf8.ValidString(truncated))
+	assert.Equal(t, strings.Repeat("😀", 80)+"...", truncated)
+}
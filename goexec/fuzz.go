@@ -0,0 +1,135 @@
+package goexec
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultFuzzTime is the default duration of fuzzing with `%fuzz`.
+const DefaultFuzzTime = 10 * time.Second
+
+// FuzzPath is the path of the test file holding the fuzz target, while fuzzing.
+func (s *State) FuzzPath() string {
+	return filepath.Join(s.TempDir, "main_fuzz_test.go")
+}
+
+// fuzz runs the fuzz target State.Cell.Fuzz, defined in decls, with `go test -fuzz`.
+//
+// The fuzz target is written to a test file (FuzzPath), and main.go holds the rest of the
+// declarations, so the target can use anything defined in previous cells. The test file is removed
+// afterwards, since it is only used while fuzzing. The seed corpus and failing inputs are stored
+// in "testdata/fuzz/<FuzzName>" under State.TempDir.
+func (s *State) fuzz(msg kernel.Message, decls *Declarations, mainDecl *Function) error {
+	name := s.Cell.Fuzz
+	fuzzDecl, found := decls.Functions[name]
+	if !found {
+		return errors.Errorf("fuzz target %q not defined, it should be a function like `func %s(f *testing.F)`", name, name)
+	}
+	mainDecls := decls.Copy()
+	delete(mainDecls.Functions, name)
+	if _, err := s.createMainFromDecls(s.withImportPreferences(mainDecls), mainDecl); err != nil {
+		return errors.WithMessagef(err, "while generating main.go with all declarations")
+	}
+	var fuzzImports []string
+	if s.SkipGoImports {
+		// Without goimports, the imports used by the fuzz target are added to its file, and the ones
+		// only it uses are removed from main.go.
+		var err error
+		if fuzzImports, err = s.splitFuzzImports(fuzzDecl, mainDecls); err != nil {
+			return err
+		}
+		if _, err = s.createMainFromDecls(s.withImportPreferences(mainDecls), mainDecl); err != nil {
+			return errors.WithMessagef(err, "while generating main.go with all declarations")
+		}
+	}
+	content := "package main\n\n" + strings.Join(fuzzImports, "") + fuzzDecl.Definition + "\n"
+	if err := os.WriteFile(s.FuzzPath(), []byte(content), 0600); err != nil {
+		return errors.Wrapf(err, "writing fuzz target to %q", s.FuzzPath())
+	}
+	defer func() {
+		if err := os.Remove(s.FuzzPath()); err != nil {
+			s.logf("Failed to remove fuzz test file %q: %+v", s.FuzzPath(), err)
+		}
+	}()
+	if err := s.GoImports(msg); err != nil {
+		return errors.WithMessagef(err, "goimports failed")
+	}
+
+	fuzzTime := s.Cell.FuzzTime
+	if fuzzTime <= 0 {
+		fuzzTime = DefaultFuzzTime
+	}
+	_ = kernel.PublishWriteStream(msg, kernel.StreamStdout,
+		fmt.Sprintf("* Fuzzing %s for %s, corpus in %s\n", name, fuzzTime,
+			filepath.Join(s.TempDir, "testdata", "fuzz", name)))
+	args := []string{"test", "-run=^$", "-fuzz=^" + name + "$", "-fuzztime=" + fuzzTime.String(), "."}
+	env := s.secretsEnv()
+	s.reportExec(msg, s.TempDir, env, append([]string{s.GoBinary}, args...)...)
+	return kernel.NewPipeExecToJupyterBuilder(msg, s.GoBinary, args...).
+		InDir(s.TempDir).
+		WithEnv(env...).
+		WithOutputLimits(s.OutputLimits).
+		OnStart(s.setLastProgram).
+		Exec()
+}
+
+// splitFuzzImports returns the import lines for the packages used by the fuzz target, and removes
+// from mainDecls the imports not used by main.go (as last generated from mainDecls).
+func (s *State) splitFuzzImports(fuzzDecl *Function, mainDecls *Declarations) ([]string, error) {
+	fuzzUses, err := packageNamesUsed("package main\n\n" + fuzzDecl.Definition)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "parsing fuzz target %q", fuzzDecl.Key)
+	}
+	mainContent, err := os.ReadFile(s.MainPath())
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %q", s.MainPath())
+	}
+	mainUses, err := packageNamesUsed(string(mainContent))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "parsing %q", s.MainPath())
+	}
+	var importLines []string
+	for key, imp := range mainDecls.Imports {
+		if !fuzzUses[key] {
+			continue
+		}
+		if imp.Alias != "" {
+			importLines = append(importLines, fmt.Sprintf("import %s %q\n", imp.Alias, imp.Path))
+		} else {
+			importLines = append(importLines, fmt.Sprintf("import %q\n", imp.Path))
+		}
+		if !mainUses[key] {
+			delete(mainDecls.Imports, key)
+		}
+	}
+	sort.Strings(importLines)
+	return importLines, nil
+}
+
+// packageNamesUsed returns the names of the packages referenced in the Go source: identifiers not
+// declared in the source, used in a selector expression (e.g.: "testing" in "testing.F").
+func packageNamesUsed(src string) (map[string]bool, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	ast.Inspect(f, func(node ast.Node) bool {
+		if sel, ok := node.(*ast.SelectorExpr); ok {
+			if ident, ok := sel.X.(*ast.Ident); ok && ident.Obj == nil {
+				names[ident.Name] = true
+			}
+		}
+		return true
+	})
+	return names, nil
+}
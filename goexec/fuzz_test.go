@@ -0,0 +1,39 @@
+package goexec
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFuzz(t *testing.T) {
+	s := newTestState(t)
+	s.Cell.Fuzz = "FuzzLen"
+	s.Cell.FuzzTime = time.Second
+	msg := newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{
+		`import "testing"`,
+		``,
+		`func FuzzLen(f *testing.F) {`,
+		`	f.Add("abc")`,
+		`	f.Fuzz(func(t *testing.T, s string) {`,
+		`		if len(s) < 0 {`,
+		`			t.Fatal("negative length")`,
+		`		}`,
+		`	})`,
+		`}`,
+	}, nil))
+	output := strings.Join(msg.Published(), "")
+	assert.Contains(t, output, "Fuzzing FuzzLen for 1s")
+	assert.Contains(t, output, "PASS")
+	_, err := os.Stat(s.FuzzPath())
+	assert.True(t, os.IsNotExist(err), "fuzz test file should be removed, got %v", err)
+
+	// Unknown fuzz target.
+	s.Cell.Fuzz = "FuzzMissing"
+	assert.Error(t, s.ExecuteCell(msg, []string{`func f() {}`}, nil))
+}
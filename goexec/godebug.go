@@ -0,0 +1,48 @@
+package goexec
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements `%godebug`: runtime settings (e.g.: "gctrace=1", "schedtrace=1000") passed to
+// the executed programs in the GODEBUG environment variable, to observe the GC, the scheduler and other
+// runtime diagnostics.
+
+// SetGoDebug adds the given settings, in the form "name=value", to State.GoDebug. A setting with a
+// name already set replaces the previous value.
+func (s *State) SetGoDebug(settings []string) error {
+	for _, setting := range settings {
+		name, _, found := strings.Cut(setting, "=")
+		if !found || name == "" || strings.ContainsAny(setting, ", \t") {
+			return errors.Errorf("invalid GODEBUG setting %q, it must be in the form \"name=value\"", setting)
+		}
+	}
+	for _, setting := range settings {
+		name, _, _ := strings.Cut(setting, "=")
+		kept := s.GoDebug[:0]
+		for _, previous := range s.GoDebug {
+			if !strings.HasPrefix(previous, name+"=") {
+				kept = append(kept, previous)
+			}
+		}
+		s.GoDebug = append(kept, setting)
+	}
+	return nil
+}
+
+// goDebugEnv returns the GODEBUG environment variable setting for executed programs, or nil if no
+// settings were given with `%godebug`. Any GODEBUG already in the kernel's environment (e.g.: set
+// with `%env`) is kept, with the `%godebug` settings taking precedence.
+func (s *State) goDebugEnv() []string {
+	if len(s.GoDebug) == 0 {
+		return nil
+	}
+	value := strings.Join(s.GoDebug, ",")
+	if current := os.Getenv("GODEBUG"); current != "" {
+		value = current + "," + value
+	}
+	return []string{"GODEBUG=" + value}
+}
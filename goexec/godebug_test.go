@@ -0,0 +1,27 @@
+package goexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetGoDebug(t *testing.T) {
+	t.Setenv("GODEBUG", "")
+	s := &State{}
+	assert.Nil(t, s.goDebugEnv())
+
+	require.NoError(t, s.SetGoDebug([]string{"gctrace=1", "schedtrace=1000"}))
+	require.NoError(t, s.SetGoDebug([]string{"gctrace=2"}))
+	assert.Equal(t, []string{"schedtrace=1000", "gctrace=2"}, s.GoDebug)
+	assert.Equal(t, []string{"GODEBUG=schedtrace=1000,gctrace=2"}, s.goDebugEnv())
+
+	t.Setenv("GODEBUG", "madvdontneed=1")
+	assert.Equal(t, []string{"GODEBUG=madvdontneed=1,schedtrace=1000,gctrace=2"}, s.goDebugEnv())
+
+	for _, invalid := range []string{"gctrace", "=1", "a=1,b=2"} {
+		assert.Error(t, s.SetGoDebug([]string{invalid}), "setting %q", invalid)
+	}
+	assert.Len(t, s.GoDebug, 2)
+}
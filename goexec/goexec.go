@@ -5,27 +5,303 @@
 package goexec
 
 import (
-	"github.com/pkg/errors"
+	"github.com/janpfeifer/gonb/kernel"
 	"log"
 	"os"
 	"os/exec"
-	"path"
+	"path/filepath"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
 )
 
 type State struct {
 	// Temporary directory where Go program is build at each execution.
 	UniqueID, Package, TempDir string
 
+	// GoBinary is the path to the `go` binary used for all go commands (`go build`, `go get`, etc.).
+	// It defaults to the one found in PATH, and can be changed with `%gobin`.
+	GoBinary string
+
+	// GoVersion is the version reported by `go version`, or empty if the go toolchain is not
+	// available, see GoToolchainError.
+	GoVersion        string
+	goToolchainError error
+
 	// Building and executing go code configuration:
 	Args    []string // Args to be passed to the program, after being executed.
 	AutoGet bool     // Whether to do a "go get" before compiling, to fetch missing external modules.
 
+	// Env holds the environment variables set with `%env`: their names are saved with the session.
+	Env map[string]string
+
+	// SessionsDir is the directory where sessions are saved, see `%session` and WithSessionsDir. If
+	// empty, "gonb/sessions" in the user's configuration directory is used.
+	SessionsDir string
+
+	// lastBuildError holds the error of the last compilation, see LastError.
+	lastBuildError *BuildError
+
+	// goImportsAdded holds the import paths added by goimports in the last run of GoImports, to
+	// explain `go get` failures to fetch them.
+	goImportsAdded []string
+
+	// mainGeneratedImports holds the import paths used by the code gonb generated in the main
+	// function of the last main.go rendered, see addGeneratedCodeImports.
+	mainGeneratedImports []string
+
+	// GoWork is the path of the go.work file whose workspace the notebook's module is part of, or
+	// empty if none. See SetGoWork.
+	GoWork string
+
+	// BuildFlags are extra flags passed to `go build`, see WithBuildFlags.
+	BuildFlags []string
+
+	// LinkerVars maps the names of package-level string variables to the values stamped into them at
+	// build time, with the linker flag `-X main.<name>=<value>`. See `%ldvar` and SetLinkerVar.
+	LinkerVars map[string]string
+
+	// SkipGoImports disables goimports: imports are used as declared in the cells, and missing or
+	// unused ones are reported by the compiler. See `%goimports`.
+	SkipGoImports bool
+
+	// AutoPrint prints the value of an expression at the end of the main function, REPL style.
+	// See `%autoprint` and autoPrintMain.
+	AutoPrint bool
+
+	// Verbose displays the external commands (`go build`, `goimports`, `go get`, etc.) before they
+	// are run, with their arguments, directory and environment overrides. See `%verbose`.
+	Verbose bool
+
+	// DebugAddress, if set, enables the debug mode: programs are compiled without optimizations and
+	// executed under Delve (dlv) in headless mode, listening for debuggers on this address. See `%debug`.
+	DebugAddress string
+
+	// OutputPath, if set, is where the compiled program is written, instead of State.TempDir. It is
+	// not removed when the kernel exits. See `%output` and SetOutputPath.
+	OutputPath string
+
+	// Vet runs `go vet` after each successful compilation, and displays its findings as warnings,
+	// without failing the execution. See `%vet`.
+	Vet bool
+
+	// GoroutineDump makes the first interruption of a running program dump the stack of all its
+	// goroutines, and the second one kill it. See `%goroutinedump`.
+	GoroutineDump bool
+
+	// WarnGoImportsRewrites reports any change goimports makes to the declarations of the program,
+	// other than imports and formatting. See `%goimports warn`.
+	WarnGoImportsRewrites bool
+
+	// GoGetRetries is the number of times "go get" is retried on transient (network) errors.
+	GoGetRetries int
+
+	// StubMainBody is the body of the main function used when the cells don't define one, see
+	// `%stubmain`. It defaults to DefaultStubMainBody.
+	StubMainBody string
+
+	// OutputLimits for the output of executed programs (and shell commands).
+	OutputLimits kernel.OutputLimits
+
+	// ANSIMode configures how ANSI escape sequences (e.g.: colors) in the output of executed programs
+	// and shell commands are handled. See `%ansi`.
+	ANSIMode kernel.ANSIMode
+
+	// ResourceLimits on the memory and CPU time of executed programs, see `%limit`.
+	ResourceLimits kernel.ResourceLimits
+
+	// GoDebug holds the runtime settings ("name=value") passed in the GODEBUG environment variable
+	// to executed programs, in the order they were set. See `%godebug` and SetGoDebug.
+	GoDebug []string
+
+	// Seed, if set, is the value of the variable SeedVariable, and of the environment variable
+	// SeedEnv of the executed programs. SeedGoMaxProcs, if > 0, sets GOMAXPROCS for the executed
+	// programs. See `%seed` and SetSeed.
+	Seed           *int64
+	SeedGoMaxProcs int
+
+	// ImportPreferences maps package names to the import path to use, when not explicitly imported.
+	// See `%importpref` and SetImportPreference.
+	ImportPreferences map[string]string
+
+	// SourceOnError configures whether the generated source code is displayed along compilation
+	// errors, see `%source_on_error`.
+	SourceOnError SourceOnErrorMode
+
+	// Secrets are passed as environment variables to the executed program only. They are never
+	// written to the generated source code. See SetSecret.
+	Secrets map[string]string
+
+	// Parameters maps variable names to the values (Go literals) that override their declarations in
+	// the cell marked with `%parameters`. They are read from the environment variable ParametersEnv.
+	Parameters map[string]string
+
+	// Files written to TempDir with `%%file`, by their path relative to TempDir. See WriteFile.
+	Files map[string]bool
+
+	// CapturedDisplays holds the content displayed by programs, captured by name with
+	// `%%capture-display <name>`.
+	CapturedDisplays map[string][]kernel.Data
+
 	// Global elements defined mapped by their keys.
 	Decls *Declarations
+
+	// Prelude holds declarations that are part of every compilation, under the declarations of the
+	// cells. See `%%prelude` and AddPrelude. It is nil if there is no prelude.
+	Prelude *Declarations
+
+	// ConstrainedDecls holds the declarations of the cells with a `//go:build` constraint, by the
+	// constraint. Each set is rendered to its own file, so `go build` selects the declarations of the
+	// platform being built for.
+	ConstrainedDecls map[string]*Declarations
+
+	// Cleanups are the programs registered with `%%cleanup`, executed in reverse order when the kernel
+	// shuts down. See AddCleanup.
+	Cleanups []*CleanupProgram
+
+	// CleanupTimeout is the time each of the Cleanups is allowed to run at shutdown, before it is killed.
+	CleanupTimeout time.Duration
+
+	// FreezeTimeout is the time the program evaluating the variables of `%freeze` is allowed to run,
+	// before it is killed. Zero means no limit. See Freeze.
+	FreezeTimeout time.Duration
+
+	// SizeWarning configures when to suggest cleaning up the notebook, because the generated program
+	// grew large. See `%size_warning`.
+	SizeWarning SizeWarning
+
+	// Incremental enables incremental builds (a prototype): the declarations that don't change are
+	// moved to a separate package, so `go build` can reuse it from its cache. See `%incremental`.
+	// The moved types change their reflected names, and the moved code its stack traces: see
+	// IncrementalPackage. It is ignored while debugging (DebugAddress).
+	Incremental bool
+
+	// Metrics counts the cells executed, compilations, etc. See `--metrics`.
+	Metrics Metrics
+
+	// Cell holds options for the execution of the current cell only.
+	Cell CellOptions
+
+	// lastMainDecl is the main function of the last program compiled successfully, used by Export.
+	lastMainDecl *Function
+
+	// mainLines and mainDecls are the number of lines and of declarations of the last main.go
+	// generated by createMainFromDecls, and sizeWarned whether they crossed SizeWarning and the user
+	// was told. See checkMainSize.
+	mainLines, mainDecls int
+	sizeWarned           bool
+
+	// cellLinesInFile maps the lines of the last file generated from a cell (see
+	// createGoFileFromLines) to the lines in the cell, to report errors. cellFileLines are the
+	// contents of that file.
+	cellLinesInFile map[int]int
+	cellFileLines   []string
+
+	// cellLinesInMain maps the lines of main.go (after goimports) to the lines of the cell being
+	// executed, to report compilation errors. See mapCellLinesInMain.
+	cellLinesInMain map[int]int
+
+	// logger used by the State, see WithLogger. If nil, the standard logger is used.
+	logger *log.Logger
+
+	// lastProgram executed and programs executing in background, kept so they can be killed.
+	// Protected by muProgram.
+	muProgram          sync.Mutex
+	lastProgram        *exec.Cmd
+	backgroundPrograms []*exec.Cmd
+
+	// execQueue serializes the execution of cells, in order, see LockExecution. muWatch protects
+	// watcher, the directory watched with `%watch`.
+	execQueue executionQueue
+	muWatch   sync.Mutex
+	watcher   *watcher
+}
+
+// CellOptions holds configuration set by special commands (usually cell magics, `%%<name>`) for
+// the execution of the current cell only. They are reset with State.ResetCell after each cell
+// execution.
+type CellOptions struct {
+	// Background indicates the program should be executed in the background, see `%%background`.
+	Background bool
+
+	// Profile is the kind of profile (see ProfileKinds) to collect while executing the program,
+	// see `%%pprof`.
+	Profile string
+
+	// MemStats indicates the memory allocation stats of the program should be collected and
+	// displayed after its execution, see `%%memstats`.
+	MemStats bool
+
+	// DryRun indicates the program should only be generated and displayed, but not compiled or
+	// executed, see `%%dryrun`.
+	DryRun bool
+
+	// Skip indicates the program should be compiled, and its declarations kept, but not executed,
+	// see `%%skip`.
+	Skip bool
+
+	// Fuzz is the name of the fuzz target to run with `go test -fuzz` instead of executing the
+	// program, for FuzzTime (DefaultFuzzTime if 0). See `%fuzz`.
+	Fuzz     string
+	FuzzTime time.Duration
+
+	// Watch is the directory to watch for changes, re-executing the cell whenever they happen.
+	// See `%watch`.
+	Watch string
+
+	// Parameters indicates the cell declares the parameters of the notebook, whose values are
+	// overridden by State.Parameters. See `%parameters`.
+	Parameters bool
+
+	// CaptureDisplay is the name under which to capture the content displayed by the program,
+	// instead of displaying it. See `%%capture-display`.
+	CaptureDisplay string
+
+	// AutoGet, if not nil, overrides State.AutoGet for the execution of the cell. See `%%autoget`.
+	AutoGet *bool
+
+	// Append is the name of a function (or method, as `Type.Method`) defined in previous cells,
+	// to which the Go code of the cell is appended. See `%append`.
+	Append string
+
+	// Terminal, if set, is the size of the pseudo-terminal the program is attached to, instead of
+	// pipes. See `%%pty`.
+	Terminal *kernel.TerminalSize
+
+	// Plugin is the path where to write the program, built as a Go plugin (`-buildmode=plugin`),
+	// instead of executing it. See `%%plugin`.
+	Plugin string
+}
+
+// ResetCell resets the options that only apply to the execution of one cell.
+func (s *State) ResetCell() {
+	s.Cell = CellOptions{}
+}
+
+// DefaultStubMainBody is the default body of the main function used to compile the declarations
+// of cells that don't define one.
+const DefaultStubMainBody = "flag.Parse()"
+
+// hasCustomStubMain returns whether the body of the stub main function was changed with `%stubmain`,
+// in which case cells with only declarations are also executed, with the stub.
+func (s *State) hasCustomStubMain() bool {
+	return s.StubMainBody != "" && s.StubMainBody != DefaultStubMainBody
+}
+
+// stubMain returns the main function used to compile the declarations when a cell doesn't define one.
+func (s *State) stubMain() *Function {
+	mainDecl := &Function{Cursor: NoCursor, Key: "main", Name: "main", Definition: "func main() {\n\t" + s.StubMainBody + "\n}"}
+	if s.StubMainBody == DefaultStubMainBody {
+		mainDecl.generatedImports = []string{"flag"}
+	}
+	return mainDecl
 }
 
 // Declarations is a collection of declarations that we carry over from one cell to another.
+//
+// They are rendered (see RenderFunctions, RenderTypes, etc.) sorted by their keys, so the same
+// declarations always generate the same code.
 type Declarations struct {
 	Functions map[string]*Function
 	Variables map[string]*Variable
@@ -35,33 +311,35 @@ type Declarations struct {
 }
 
 // New returns an empty State object, that can be used to execute Cells.
+//
+// Each State gets its own module directory (TempDir) named after uniqueID, and it fails if the
+// directory already exists, so concurrent kernels never share generated files. Go's own caches
+// (GOCACHE, GOMODCACHE) are shared, and are safe for concurrent use.
+//
+// See NewState for more configuration options.
 func New(uniqueID string) (*State, error) {
-	s := &State{
-		UniqueID: uniqueID,
-		Package:  "gonb_" + uniqueID,
-		Decls:    NewDeclarations(),
-		AutoGet:  true,
-	}
-
-	// Create directory.
-	s.TempDir = path.Join(os.TempDir(), s.Package)
-	err := os.Mkdir(s.TempDir, 0700)
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to create temporary directory %q", s.TempDir)
-	}
+	return NewState(WithUniqueID(uniqueID))
+}
 
-	// Run go mod init on given directory.
-	cmd := exec.Command("go", "mod", "init", s.Package)
+// GoCommand returns an *exec.Cmd that runs the `go` tool (State.GoBinary) with the given
+// arguments, in State.TempDir.
+func (s *State) GoCommand(args ...string) *exec.Cmd {
+	cmd := exec.Command(s.GoBinary, args...)
 	cmd.Dir = s.TempDir
-	var output []byte
-	output, err = cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("Failed to run `go mod init %s`:\n%s", s.Package, output)
-		return nil, errors.Wrapf(err, "failed to run %q", cmd.String())
+	if env := s.goWorkEnv(); env != nil {
+		cmd.Env = append(os.Environ(), env...)
 	}
+	return cmd
+}
 
-	log.Printf("Initialized goexec.State in %s", s.TempDir)
-	return s, nil
+// goToolsEnv returns the environment for tools that call `go` themselves (e.g.: goimports), with the
+// directory of State.GoBinary first in PATH, so they use the same `go`.
+func (s *State) goToolsEnv() []string {
+	pathEnv := filepath.Dir(s.GoBinary)
+	if currentPath := os.Getenv("PATH"); currentPath != "" {
+		pathEnv += string(os.PathListSeparator) + currentPath
+	}
+	return append(append(os.Environ(), "PATH="+pathEnv), s.goWorkEnv()...)
 }
 
 func NewDeclarations() *Declarations {
@@ -74,7 +352,8 @@ func NewDeclarations() *Declarations {
 	}
 }
 
-// Copy returns a new deep copy of the declarations.
+// Copy returns a new deep copy of the declarations: changes to the copy (including to the
+// individual declarations, e.g.: their cursors) don't affect the original.
 func (d *Declarations) Copy() *Declarations {
 	d2 := &Declarations{
 		Imports:   make(map[string]*Import, len(d.Imports)),
@@ -83,17 +362,82 @@ func (d *Declarations) Copy() *Declarations {
 		Types:     make(map[string]*TypeDecl, len(d.Types)),
 		Constants: make(map[string]*Constant, len(d.Constants)),
 	}
-	d2.MergeFrom(d)
+	copyElements(d2.Imports, d.Imports)
+	copyElements(d2.Functions, d.Functions)
+	copyElements(d2.Variables, d.Variables)
+	copyElements(d2.Types, d.Types)
+
+	// Constants are linked within their blocks, and the links must point to the copies.
+	copies := make(map[*Constant]*Constant, len(d.Constants))
+	for key, c := range d.Constants {
+		c2 := *c
+		copies[c] = &c2
+		d2.Constants[key] = &c2
+	}
+	for _, c2 := range copies {
+		if c2.Next != nil {
+			c2.Next = copies[c2.Next]
+		}
+		if c2.Prev != nil {
+			c2.Prev = copies[c2.Prev]
+		}
+	}
 	return d2
 }
 
+// copyElements copies each element of src (pointers) into a new element in dst.
+func copyElements[K comparable, V any](dst, src map[K]*V) {
+	for k, v := range src {
+		v2 := *v
+		dst[k] = &v2
+	}
+}
+
 // MergeFrom declarations in d2.
 func (d *Declarations) MergeFrom(d2 *Declarations) {
 	copyMap(d.Imports, d2.Imports)
+	d.mergeTypes(d2.Types)
 	copyMap(d.Functions, d2.Functions)
 	copyMap(d.Variables, d2.Variables)
-	copyMap(d.Types, d2.Types)
-	copyMap(d.Constants, d2.Constants)
+	d.mergeConstants(d2.Constants)
+}
+
+// mergeTypes from types. A type that changes kind -- from a type definition to an alias or vice versa --
+// also removes the methods previously declared with it as receiver: for an alias they are methods of the
+// aliased type, so they don't carry over to the new kind.
+func (d *Declarations) mergeTypes(types map[string]*TypeDecl) {
+	for key, typeDecl := range types {
+		if old, found := d.Types[key]; found && old.Alias != typeDecl.Alias {
+			prefix := key + "~"
+			for funcKey := range d.Functions {
+				if strings.HasPrefix(funcKey, prefix) {
+					delete(d.Functions, funcKey)
+				}
+			}
+		}
+		d.Types[key] = typeDecl
+	}
+}
+
+// mergeConstants from constants. A constant that redefines one declared in a `const` block replaces
+// the whole block: the other constants of the old block are removed, since they may depend on its
+// position (`iota`) or on its expression (implicitly repeated).
+func (d *Declarations) mergeConstants(constants map[string]*Constant) {
+	for key, c := range constants {
+		old, found := d.Constants[key]
+		if !found || old == c {
+			continue
+		}
+		for head := old; head != nil; head = head.Prev {
+			old = head
+		}
+		for ; old != nil; old = old.Next {
+			if d.Constants[old.Key] == old {
+				delete(d.Constants, old.Key)
+			}
+		}
+	}
+	copyMap(d.Constants, constants)
 }
 
 func copyMap[K comparable, V any](dst, src map[K]V) {
@@ -161,18 +505,35 @@ type Function struct {
 	Name, Receiver string
 	Definition     string // Multi-line definition, includes comments preceding definition.
 
+	// generatedImports are the import paths used by code generated by gonb in Definition (e.g.:
+	// the `flag.Parse()` of `%%`), added to main.go if missing when goimports is disabled.
+	generatedImports []string
 }
 
 type Variable struct {
 	Cursor
 	Key, Name                       string
 	TypeDefinition, ValueDefinition string // Type definition may be empty.
+
+	// EmbedDirective holds the `//go:embed` directive preceding the variable, if any.
+	EmbedDirective string
+
+	// Group identifies the variables declared together, in one `var ( ... )` block or spec (e.g.:
+	// `var x, y = 1, 2`), empty if declared alone. Variables of a group are rendered together, in the
+	// order they were declared (GroupIndex), so that independent initializers (e.g.: with side
+	// effects) still run in the order they were written.
+	Group      string
+	GroupIndex int
 }
 
 type TypeDecl struct {
 	Cursor
 	Key            string // Same as the name here.
 	TypeDefinition string // Type definition may be empty.
+
+	// Alias indicates the type is an alias (`type Foo = bar.Baz`) of TypeDefinition, as opposed
+	// to a new type. Aliases share the method set of the aliased type.
+	Alias bool
 }
 
 // Constant represents the declaration of a constant. Because when appearing in block
@@ -180,9 +541,10 @@ type TypeDecl struct {
 // For this we use Next/Prev links.
 type Constant struct {
 	Cursor
-	Key                             string
+	Key, Name                       string
 	TypeDefinition, ValueDefinition string    // Can be empty, if used as iota.
 	Next, Prev                      *Constant // Next and previous declaration in same Const block.
+	SameSpec                        bool      // Whether it's declared in the same line (spec) as Prev.
 }
 
 // Import represents an import to be included -- if not used it's automatically removed by
@@ -206,13 +568,16 @@ func NewImport(importPath, alias string) *Import {
 		} else {
 			key = parts[1]
 		}
-	} else if key == "." {
-		// More than one import can be moved to the current namespace.
-		key = ".~" + importPath
+	} else if key == "." || key == "_" {
+		// More than one import can be moved to the current namespace, or imported only for its
+		// side effects.
+		key = key + "~" + importPath
 	}
 	return &Import{Key: key, Path: importPath, Alias: alias}
 }
 
 func (s *State) Reset() {
 	s.Decls = NewDeclarations()
+	s.ConstrainedDecls = nil
+	s.lastMainDecl = nil
 }
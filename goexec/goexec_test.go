@@ -0,0 +1,95 @@
+package goexec
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConcurrentStates checks that separate States (e.g.: of different kernels) don't share any of
+// the generated files, even when compiling at the same time.
+func TestConcurrentStates(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skipf("go toolchain not available: %v", err)
+	}
+	const numStates = 2
+	states := make([]*State, numStates)
+	for ii := range states {
+		var err error
+		states[ii], err = New(fmt.Sprintf("test%d_%x", ii, time.Now().UnixNano()))
+		require.NoError(t, err)
+		defer func(s *State) { _ = os.RemoveAll(s.TempDir) }(states[ii])
+		require.NoError(t, states[ii].GoToolchainError())
+	}
+	assert.NotEqual(t, states[0].TempDir, states[1].TempDir)
+
+	// A State can't reuse the directory of another one.
+	_, err := New(strings.TrimPrefix(states[0].Package, "gonb_"))
+	assert.Error(t, err)
+
+	for ii, s := range states {
+		parseCellIntoState(t, s, []string{fmt.Sprintf("const id = %d", ii)})
+	}
+	var wg sync.WaitGroup
+	outputs := make([]string, numStates)
+	errs := make([]error, numStates)
+	for ii, s := range states {
+		wg.Add(1)
+		go func(ii int, s *State) {
+			defer wg.Done()
+			mainDecl := &Function{Key: "main", Name: "main", Definition: "func main() { print(id) }"}
+			if _, err := s.createMainFromDecls(s.Decls, mainDecl); err != nil {
+				errs[ii] = err
+				return
+			}
+			if output, err := s.GoCommand("build", "-o", s.BinaryPath()).CombinedOutput(); err != nil {
+				errs[ii] = fmt.Errorf("go build failed: %w\n%s", err, output)
+				return
+			}
+			output, err := exec.Command(s.BinaryPath()).CombinedOutput()
+			outputs[ii], errs[ii] = string(output), err
+		}(ii, s)
+	}
+	wg.Wait()
+	for ii := range states {
+		require.NoError(t, errs[ii])
+		assert.Equal(t, fmt.Sprint(ii), outputs[ii])
+	}
+}
+
+func TestStubMain(t *testing.T) {
+	s := &State{StubMainBody: DefaultStubMainBody}
+	assert.Equal(t, "func main() {\n\tflag.Parse()\n}", s.stubMain().Definition)
+	s.StubMainBody = `fmt.Println("declared")`
+	assert.Equal(t, "func main() {\n\tfmt.Println(\"declared\")\n}", s.stubMain().Definition)
+}
+
+// TestStubMainExecution checks that cells with only declarations are executed only with a custom stub main.
+func TestStubMainExecution(t *testing.T) {
+	s := newTestState(t)
+	require.NoError(t, s.ExecuteCell(newTestMessage(), []string{`import "fmt"`, `var _ = fmt.Sprint`}, nil))
+
+	// Default stub: only compiled.
+	msg := newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{`func greeting() string { return "hello" }`}, nil))
+	assert.Empty(t, msg.Published())
+
+	// Custom stub: executed.
+	s.StubMainBody = `fmt.Println("stub:", greeting())`
+	msg = newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{`func other() {}`}, nil))
+	assert.Contains(t, strings.Join(msg.Published(), ""), "stub: hello")
+
+	// Unless the cell is skipped.
+	s.Cell.Skip = true
+	msg = newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{`func another() {}`}, nil))
+	assert.NotContains(t, strings.Join(msg.Published(), ""), "stub: hello")
+}
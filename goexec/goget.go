@@ -0,0 +1,213 @@
+package goexec
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file implements running `go get`, with retries for transient (network) failures.
+
+const (
+	// DefaultGoGetRetries is the default number of times `go get` is retried, if it fails
+	// with a transient (network) error.
+	DefaultGoGetRetries = 3
+
+	// MaxGoGetRetries is the hard limit on the number of times `go get` is retried, whatever the
+	// value of State.GoGetRetries, so a misconfiguration can't keep the kernel retrying for hours.
+	MaxGoGetRetries = 10
+
+	// GoGetInitialBackoff is the time waited before the first retry of `go get`. It is
+	// doubled at each following retry, up to GoGetMaxBackoff.
+	GoGetInitialBackoff = time.Second
+
+	// GoGetMaxBackoff is the longest time waited before a retry of `go get`.
+	GoGetMaxBackoff = 30 * time.Second
+
+	// GoGetMaxTotalBackoff is the limit on the total time waited between retries of `go get`: the
+	// execution lock is held meanwhile.
+	GoGetMaxTotalBackoff = 2 * time.Minute
+)
+
+// goGetAfter returns the channel that fires when it is time to retry `go get`. It is replaced in
+// tests.
+var goGetAfter = time.After
+
+// waitGoGetRetry waits for backoff before retrying `go get`. It returns false if the kernel is
+// interrupted before.
+func waitGoGetRetry(k *kernel.Kernel, backoff time.Duration) bool {
+	interrupted := make(chan struct{})
+	var once sync.Once
+	defer k.OnInterrupt(func() { once.Do(func() { close(interrupted) }) })()
+	if k.Interrupted.Load() {
+		return false
+	}
+	select {
+	case <-goGetAfter(backoff):
+		return true
+	case <-interrupted:
+		return false
+	}
+}
+
+var (
+	// reGoGetTransientError matches `go get` output for errors that are likely to be transient.
+	reGoGetTransientError = regexp.MustCompile(
+		`(?i)(i/o timeout|connection (refused|reset|timed out)|TLS handshake timeout|` +
+			`temporary failure in name resolution|unexpected EOF|` +
+			`(502|503|504) (Bad Gateway|Service Unavailable|Gateway Timeout))`)
+
+	// reGoGetPermanentError matches `go get` output for errors that won't be fixed by retrying.
+	reGoGetPermanentError = regexp.MustCompile(
+		`(?i)(no matching versions|cannot find module|unknown revision|malformed module path|` +
+			`no required module provides|invalid version|404 Not Found|410 Gone)`)
+)
+
+// isTransientGoGetError returns whether the output of a failed `go get` indicates a transient
+// error (e.g. network timeout), that may succeed if retried.
+func isTransientGoGetError(output string) bool {
+	return reGoGetTransientError.MatchString(output) && !reGoGetPermanentError.MatchString(output)
+}
+
+// isOffline returns whether the Go toolchain is configured not to access the network.
+func isOffline() bool {
+	return os.Getenv("GOPROXY") == "off"
+}
+
+// goGet runs `go get` to download missing dependencies, displaying the modules downloaded as a
+// compact progress indicator (see goGetProgress). If it fails with a transient error, it
+// is retried up to State.GoGetRetries times (at most MaxGoGetRetries), with exponential backoff
+// capped by GoGetMaxBackoff and GoGetMaxTotalBackoff -- except if offline (`GOPROXY=off`). Waiting
+// between retries is interrupted by the kernel's interruptions.
+func (s *State) goGet(msg kernel.Message) error {
+	retries := s.GoGetRetries
+	if retries > MaxGoGetRetries {
+		retries = MaxGoGetRetries
+	}
+	backoff, waited := GoGetInitialBackoff, time.Duration(0)
+	for attempt := 0; ; attempt++ {
+		cmd := s.GoCommand("get")
+		s.reportCommand(msg, cmd)
+		s.Metrics.GoGetRuns.Add(1)
+		progress := newGoGetProgress(msg, fmt.Sprintf("gonb_goget_%s_%d", s.UniqueID, time.Now().UnixNano()))
+		output, err := runGoCommandWithProgress(cmd, progress.publish)
+		progress.done(err)
+		if err == nil {
+			return nil
+		}
+		retry := attempt < retries && waited+backoff <= GoGetMaxTotalBackoff && !isOffline() &&
+			isTransientGoGetError(output) && !msg.Kernel().Interrupted.Load()
+		if !retry {
+			if report := goGetFailureForAddedImports(output, s.goImportsAdded); report != "" {
+				_ = kernel.PublishWriteStream(msg, kernel.StreamStderr, report)
+			} else {
+				s.DisplayErrorWithContext(msg, output+"\n"+err.Error())
+			}
+			return errors.Wrapf(err, "failed to run %q", cmd.String())
+		}
+		s.logf("`go get` failed with transient error, retrying in %s: %s", backoff, output)
+		_ = kernel.PublishWriteStream(msg, kernel.StreamStderr,
+			fmt.Sprintf("`go get` failed (attempt %d of %d), likely a network error, retrying in %s ...\n",
+				attempt+1, retries+1, backoff))
+		if !waitGoGetRetry(msg.Kernel(), backoff) {
+			return errors.Wrapf(err, "failed to run %q, interrupted before retrying", cmd.String())
+		}
+		waited += backoff
+		backoff *= 2
+		if backoff > GoGetMaxBackoff {
+			backoff = GoGetMaxBackoff
+		}
+	}
+}
+
+// goGetFailureForAddedImports correlates the output of a failed `go get` with the import paths
+// added by goimports (see State.goImportsAdded): if `go get` failed to fetch any of them, it returns
+// a single message explaining the likely cause and how to fix it, to be displayed instead of the
+// output. The errors not attributed to any of them are included as is. Otherwise, it returns "".
+func goGetFailureForAddedImports(output string, added []string) string {
+	var report strings.Builder
+	lines := strings.Split(output, "\n")
+	attributed := make([]bool, len(lines))
+	for _, importPath := range added {
+		var errLines []string
+		for ii, line := range lines {
+			line = strings.TrimSpace(line)
+			if !containsImportPath(line, importPath) {
+				continue
+			}
+			attributed[ii] = true
+			if !strings.Contains(line, "finding module for package") {
+				errLines = append(errLines, line)
+			}
+		}
+		if len(errLines) == 0 {
+			continue
+		}
+		fmt.Fprintf(&report, "goimports added the import %q, but `go get` failed to fetch it:\n", importPath)
+		for _, line := range errLines {
+			fmt.Fprintf(&report, "    %s\n", line)
+		}
+		firstElement, _, _ := strings.Cut(importPath, "/")
+		switch {
+		case strings.Contains("/"+importPath+"/", "/internal/"):
+			fmt.Fprintf(&report, "%q is an internal package, it can only be imported from within its own module.\n",
+				importPath)
+		case !strings.Contains(firstElement, "."):
+			fmt.Fprintf(&report, "%q is not a standard library package, and it doesn't start with a domain "+
+				"name, so it can't be fetched: likely a typo or a local package.\n", importPath)
+		default:
+			fmt.Fprintf(&report, "%q is likely a typo, or a private or unpublished module.\n", importPath)
+		}
+		fmt.Fprintf(&report, "Import the intended package explicitly, or choose it with \"%%importpref %s=<path>\". "+
+			"For a local module use \"%%gowork <path>\", and for a private one set GOPRIVATE with \"%%env\".\n\n",
+			path.Base(importPath))
+	}
+	if report.Len() == 0 {
+		return ""
+	}
+
+	// Errors not attributed to the imports added by goimports.
+	var otherLines []string
+	for ii, line := range lines {
+		line = strings.TrimSpace(line)
+		if attributed[ii] || line == "" || strings.HasSuffix(line, " imports") ||
+			strings.HasPrefix(line, "go: downloading ") || strings.HasPrefix(line, "go: finding module for package ") {
+			continue
+		}
+		otherLines = append(otherLines, line)
+	}
+	if len(otherLines) > 0 {
+		report.WriteString("Other errors of `go get`:\n")
+		for _, line := range otherLines {
+			fmt.Fprintf(&report, "    %s\n", line)
+		}
+	}
+	return report.String()
+}
+
+// containsImportPath returns whether line mentions importPath as a whole: not as part of a longer
+// path (e.g.: "foo" is not in "foobar" nor in "example.com/foo").
+func containsImportPath(line, importPath string) bool {
+	isPathChar := func(r byte) bool {
+		return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+			strings.IndexByte("./-_~+", r) >= 0
+	}
+	for start := 0; ; {
+		pos := strings.Index(line[start:], importPath)
+		if pos < 0 {
+			return false
+		}
+		pos += start
+		end := pos + len(importPath)
+		if (pos == 0 || !isPathChar(line[pos-1])) && (end == len(line) || !isPathChar(line[end])) {
+			return true
+		}
+		start = pos + 1
+	}
+}
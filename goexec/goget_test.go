@@ -0,0 +1,164 @@
+package goexec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsTransientGoGetError(t *testing.T) {
+	assert.True(t, isTransientGoGetError(
+		`go: github.com/foo/bar@v1.0.0: Get "https://proxy.golang.org/github.com/foo/bar/@v/v1.0.0.mod": dial tcp 142.250.0.1:443: i/o timeout`))
+	assert.True(t, isTransientGoGetError(`go: downloading example.com/x: 503 Service Unavailable`))
+	assert.False(t, isTransientGoGetError(
+		`go: module github.com/foo/bar: no matching versions for query "latest"`))
+	assert.False(t, isTransientGoGetError(`go: github.com/foo/bar@v9.9.9: unknown revision v9.9.9`))
+	assert.False(t, isTransientGoGetError(`main.go:3:2: no required module provides package foo/bar`))
+}
+
+func TestGoGetFailureForAddedImports(t *testing.T) {
+	output := `go: finding module for package github.com/janpfeifer/gonb/kernell
+go: main imports
+	github.com/janpfeifer/gonb/kernell: cannot find module providing package github.com/janpfeifer/gonb/kernell: module github.com/janpfeifer/gonb/kernell: 404 Not Found`
+	report := goGetFailureForAddedImports(output, []string{"fmt", "github.com/janpfeifer/gonb/kernell"})
+	assert.Contains(t, report, `goimports added the import "github.com/janpfeifer/gonb/kernell"`)
+	assert.Contains(t, report, "cannot find module providing package")
+	assert.NotContains(t, report, "finding module for package")
+	assert.Contains(t, report, "likely a typo, or a private or unpublished module")
+	assert.Contains(t, report, `%importpref kernell=<path>`)
+	assert.NotContains(t, report, `"fmt"`)
+
+	report = goGetFailureForAddedImports(
+		"main.go:3:2: use of internal package example.com/x/internal/util not allowed",
+		[]string{"example.com/x/internal/util"})
+	assert.Contains(t, report, "is an internal package")
+
+	report = goGetFailureForAddedImports(
+		"main.go:3:2: package mylib/util is not in std", []string{"mylib/util"})
+	assert.Contains(t, report, "doesn't start with a domain name")
+
+	// Failures unrelated to the imports added by goimports are not reported.
+	assert.Empty(t, goGetFailureForAddedImports(output, []string{"example.com/other"}))
+	assert.Empty(t, goGetFailureForAddedImports(output, nil))
+
+	// Import paths are matched as a whole.
+	assert.Empty(t, goGetFailureForAddedImports(output, []string{"github.com/janpfeifer/gonb/kern"}))
+	assert.Empty(t, goGetFailureForAddedImports(output, []string{"gonb/kernell"}))
+	assert.True(t, containsImportPath("module foo@v1.0.0: not found", "foo"))
+	assert.False(t, containsImportPath("module foobar: not found", "foo"))
+	assert.False(t, containsImportPath("module x/foo: not found", "foo"))
+
+	// Other errors are reported as well.
+	report = goGetFailureForAddedImports(output+"\ngo: example.com/other@v1.2.3: invalid version",
+		[]string{"github.com/janpfeifer/gonb/kernell"})
+	assert.Contains(t, report, `goimports added the import "github.com/janpfeifer/gonb/kernell"`)
+	assert.Contains(t, report, "Other errors of `go get`:\n    go: example.com/other@v1.2.3: invalid version\n")
+	assert.NotContains(t, report, "main imports")
+}
+
+// writeFailingGoBinary writes a fake go, that always fails with a transient error, and counts how
+// many times it was run in the returned file.
+func writeFailingGoBinary(t *testing.T, dir string) (goPath, countPath string) {
+	countPath = filepath.Join(dir, "count")
+	goPath = filepath.Join(dir, "go")
+	require.NoError(t, os.WriteFile(goPath, []byte(fmt.Sprintf(
+		"#!/bin/sh\necho x >> %q\necho 'dial tcp: i/o timeout'\nexit 1\n", countPath)), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0600))
+	return goPath, countPath
+}
+
+func TestGoGetRetriesAreCapped(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the go binary")
+	}
+	var backoffs []time.Duration
+	goGetAfter = func(backoff time.Duration) <-chan time.Time {
+		backoffs = append(backoffs, backoff)
+		return time.After(0)
+	}
+	defer func() { goGetAfter = time.After }()
+	t.Setenv("GOPROXY", "https://proxy.golang.org")
+
+	dir := t.TempDir()
+	goPath, countPath := writeFailingGoBinary(t, dir)
+	s := &State{GoBinary: goPath, TempDir: dir, GoGetRetries: 1000}
+	require.Error(t, s.goGet(newTestMessage()))
+	content, err := os.ReadFile(countPath)
+	require.NoError(t, err)
+	runs := strings.Count(string(content), "x")
+	assert.True(t, runs <= MaxGoGetRetries+1, "%d runs", runs)
+	require.Len(t, backoffs, runs-1)
+	var total time.Duration
+	for _, backoff := range backoffs {
+		assert.True(t, backoff <= GoGetMaxBackoff, "backoff %s", backoff)
+		total += backoff
+	}
+	assert.True(t, total <= GoGetMaxTotalBackoff, "total backoff %s", total)
+	assert.Equal(t, GoGetMaxBackoff, backoffs[len(backoffs)-1])
+}
+
+func TestGoGetRetryInterrupted(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the go binary, and sends itself SIGINT")
+	}
+	waiting := make(chan struct{}, 1)
+	goGetAfter = func(time.Duration) <-chan time.Time {
+		waiting <- struct{}{}
+		return nil // Never fires.
+	}
+	defer func() { goGetAfter = time.After }()
+	t.Setenv("GOPROXY", "https://proxy.golang.org")
+
+	dir := t.TempDir()
+	goPath, _ := writeFailingGoBinary(t, dir)
+	s := &State{GoBinary: goPath, TempDir: dir, GoGetRetries: 3}
+	msg := newTestMessage()
+	msg.Kernel().HandleInterrupt()
+	done := make(chan error, 1)
+	go func() { done <- s.goGet(msg) }()
+	<-waiting
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGINT))
+	select {
+	case err := <-done:
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "interrupted before retrying")
+	case <-time.After(time.Minute):
+		t.Fatal("waiting to retry `go get` was not interrupted")
+	}
+}
+
+// TestCompileTerminates checks that compiling programs whose imports need fixing -- unused dot
+// imports, or unused ambiguous ones -- ends after a bounded number of attempts.
+func TestCompileTerminates(t *testing.T) {
+	s := newTestState(t)
+	for _, importPath := range []string{"strings", "sort", "math"} {
+		require.NoError(t, s.DotImport(importPath))
+	}
+	msg := newTestMessage()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ExecuteCell(msg, []string{`import "math/rand"`, `import crand "crypto/rand"`, "var x = 1"}, nil)
+	}()
+	select {
+	case err := <-done:
+		assert.Error(t, err) // The explicit imports are not used.
+	case <-time.After(2 * time.Minute):
+		t.Fatal("compilation didn't terminate")
+	}
+	done = make(chan error, 1)
+	go func() { done <- s.ExecuteCell(msg, []string{"var y = Sqrt(2)"}, nil) }()
+	select {
+	case err := <-done:
+		assert.NoError(t, err) // Unused dot imports "strings" and "sort" are dropped.
+	case <-time.After(2 * time.Minute):
+		t.Fatal("compilation didn't terminate")
+	}
+}
@@ -0,0 +1,190 @@
+package goexec
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// This file implements checking the changes goimports makes to main.go: it should only add or remove
+// imports, and reformat the code. Declarations dropped or added are always reported, and, with
+// `%goimports warn` (see State.WarnGoImportsRewrites), so are other changes to the declarations.
+//
+// The imports added by goimports are also kept, to explain `go get` failures to fetch them, see
+// goGetFailureForAddedImports.
+
+// topLevelDecls parses the Go source in content and returns its top-level declarations, except
+// imports, keyed by kind and name (e.g.: "func main", "method Kg.Weight", "var x"), mapped to their
+// source as printed by go/printer -- so differences in formatting are normalized away.
+func topLevelDecls(content []byte) (map[string]string, error) {
+	fileSet := token.NewFileSet()
+	f, err := parser.ParseFile(fileSet, "", content, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	decls := make(map[string]string)
+	add := func(key string, node any) error {
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, fileSet, node); err != nil {
+			return err
+		}
+		// Repeated keys (e.g.: `var _ = ...`) are numbered.
+		uniqueKey := key
+		for ii := 2; ; ii++ {
+			if _, found := decls[uniqueKey]; !found {
+				break
+			}
+			uniqueKey = fmt.Sprintf("%s#%d", key, ii)
+		}
+		decls[uniqueKey] = buf.String()
+		return nil
+	}
+	for _, decl := range f.Decls {
+		switch typedDecl := decl.(type) {
+		case *ast.FuncDecl:
+			key := "func " + typedDecl.Name.Name
+			if typedDecl.Recv != nil && len(typedDecl.Recv.List) > 0 {
+				var buf bytes.Buffer
+				_ = printer.Fprint(&buf, fileSet, typedDecl.Recv.List[0].Type)
+				key = fmt.Sprintf("method %s.%s", strings.TrimPrefix(buf.String(), "*"), typedDecl.Name.Name)
+			}
+			if err = add(key, typedDecl); err != nil {
+				return nil, err
+			}
+		case *ast.GenDecl:
+			if typedDecl.Tok == token.IMPORT {
+				continue
+			}
+			for _, spec := range typedDecl.Specs {
+				switch typedSpec := spec.(type) {
+				case *ast.TypeSpec:
+					err = add("type "+typedSpec.Name.Name, typedSpec)
+				case *ast.ValueSpec:
+					for _, name := range typedSpec.Names {
+						if err = add(fmt.Sprintf("%s %s", typedDecl.Tok, name.Name), typedSpec); err != nil {
+							break
+						}
+					}
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return decls, nil
+}
+
+// goImportsChanges compares the top-level declarations (see topLevelDecls) of the source before and
+// after running goimports. It returns the keys of the declarations removed, added and, if
+// checkContent is true, changed.
+func goImportsChanges(before, after []byte, checkContent bool) (removed, added, changed []string, err error) {
+	beforeDecls, err := topLevelDecls(before)
+	if err != nil {
+		return nil, nil, nil, errors.WithMessagef(err, "parsing code before goimports")
+	}
+	afterDecls, err := topLevelDecls(after)
+	if err != nil {
+		return nil, nil, nil, errors.WithMessagef(err, "parsing code after goimports")
+	}
+	for key, src := range beforeDecls {
+		afterSrc, found := afterDecls[key]
+		if !found {
+			removed = append(removed, key)
+		} else if checkContent && afterSrc != src {
+			changed = append(changed, key)
+		}
+	}
+	for key := range afterDecls {
+		if _, found := beforeDecls[key]; !found {
+			added = append(added, key)
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(added)
+	sort.Strings(changed)
+	return
+}
+
+// reportGoImportsChanges reports to the notebook the changes made by goimports to main.go, other than
+// to the imports, given its contents before goimports was run.
+//
+// Failures to check are only logged: they shouldn't prevent the execution of the cell.
+func (s *State) reportGoImportsChanges(msg kernel.Message, before []byte) {
+	after, err := os.ReadFile(s.MainPath())
+	if err != nil {
+		s.logf("Failed to read %q to check goimports changes: %+v", s.MainPath(), err)
+		return
+	}
+	removed, added, changed, err := goImportsChanges(before, after, s.WarnGoImportsRewrites)
+	if err != nil {
+		s.logf("Failed to check goimports changes: %+v", err)
+		return
+	}
+	var report []string
+	if len(removed) > 0 {
+		report = append(report, fmt.Sprintf("goimports removed declarations: %s", strings.Join(removed, ", ")))
+	}
+	if len(added) > 0 {
+		report = append(report, fmt.Sprintf("goimports added declarations: %s", strings.Join(added, ", ")))
+	}
+	if len(changed) > 0 {
+		report = append(report, fmt.Sprintf("goimports changed declarations: %s", strings.Join(changed, ", ")))
+	}
+	if len(report) == 0 {
+		return
+	}
+	_ = kernel.PublishWriteStream(msg, kernel.StreamStderr, "Warning: "+strings.Join(report, "\nWarning: ")+"\n")
+}
+
+// importPaths returns the paths imported by the Go source in content.
+func importPaths(content []byte) (map[string]bool, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), "", content, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]bool, len(f.Imports))
+	for _, spec := range f.Imports {
+		if importPath, err := strconv.Unquote(spec.Path.Value); err == nil {
+			paths[importPath] = true
+		}
+	}
+	return paths, nil
+}
+
+// goImportsAddedImports returns the import paths added by goimports to main.go, sorted, given its
+// contents before goimports was run. Failures are only logged.
+func (s *State) goImportsAddedImports(before []byte) []string {
+	after, err := os.ReadFile(s.MainPath())
+	if err != nil {
+		s.logf("Failed to read %q to check imports added by goimports: %+v", s.MainPath(), err)
+		return nil
+	}
+	beforePaths, err := importPaths(before)
+	if err != nil {
+		s.logf("Failed to parse imports before goimports: %+v", err)
+		return nil
+	}
+	afterPaths, err := importPaths(after)
+	if err != nil {
+		s.logf("Failed to parse imports after goimports: %+v", err)
+		return nil
+	}
+	var added []string
+	for importPath := range afterPaths {
+		if !beforePaths[importPath] {
+			added = append(added, importPath)
+		}
+	}
+	sort.Strings(added)
+	return added
+}
@@ -0,0 +1,110 @@
+package goexec
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoImportsChanges(t *testing.T) {
+	before := []byte(`package main
+
+type Kg   int
+func (k *Kg) Weight() float64 { return float64(*k)*9.8 }
+var x, y = 1,   2
+var _ = x
+var _ = y
+func main() { fmt.Println(x) }
+`)
+	after := []byte(`package main
+
+import "fmt"
+
+type Kg int
+
+func (k *Kg) Weight() float64 { return float64(*k) * 9.8 }
+
+var x, y = 1, 2
+var _ = x
+var _ = y
+
+func main() { fmt.Println(x) }
+`)
+	removed, added, changed, err := goImportsChanges(before, after, true)
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+	assert.Empty(t, added)
+	assert.Empty(t, changed)
+
+	rewritten := []byte(`package main
+
+type Kg int
+
+var x, y = 1, 3
+var _ = x
+
+func main() { fmt.Println(x) }
+func extra() {}
+`)
+	removed, added, changed, err = goImportsChanges(before, rewritten, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"method Kg.Weight", "var _#2"}, removed)
+	assert.Equal(t, []string{"func extra"}, added)
+	assert.Equal(t, []string{"var x", "var y"}, changed)
+
+	// Without checking content, only declarations removed or added are reported.
+	_, _, changed, err = goImportsChanges(before, rewritten, false)
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+}
+
+// TestGoImportsKeepsDeclarations checks that goimports doesn't merge or drop declarations of a
+// program generated from cells.
+func TestGoImportsKeepsDeclarations(t *testing.T) {
+	goimportsPath, err := exec.LookPath("goimports")
+	if err != nil {
+		t.Skipf("goimports not available: %v", err)
+	}
+	s := &State{TempDir: t.TempDir(), Decls: NewDeclarations()}
+	parseCellIntoState(t, s, []string{
+		`var (`,
+		`	a = strings.ToUpper("a")`,
+		`	b, c = 1, 2`,
+		`)`,
+		`const (`,
+		`	K0 = iota`,
+		`	K1`,
+		`)`,
+		`type Kg int`,
+		`func (k Kg) String() string { return fmt.Sprint(int(k)) }`,
+	})
+	_, err = s.createMainFromDecls(s.Decls, &Function{Key: "main", Name: "main", Definition: "func main() { fmt.Println(a, b, c, K0, K1) }"})
+	require.NoError(t, err)
+	before, err := os.ReadFile(s.MainPath())
+	require.NoError(t, err)
+	cmd := exec.Command(goimportsPath, "-w", s.MainPath())
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+	after, err := os.ReadFile(s.MainPath())
+	require.NoError(t, err)
+
+	beforeDecls, err := topLevelDecls(before)
+	require.NoError(t, err)
+	afterDecls, err := topLevelDecls(after)
+	require.NoError(t, err)
+	assert.Len(t, afterDecls, len(beforeDecls))
+	removed, added, changed, err := goImportsChanges(before, after, true)
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+	assert.Empty(t, added)
+	assert.Empty(t, changed)
+}
+
+func TestImportPaths(t *testing.T) {
+	paths, err := importPaths([]byte("package main\n\nimport (\n\t\"fmt\"\n\tr \"math/rand\"\n)\n\nfunc main() {}\n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"fmt": true, "math/rand": true}, paths)
+}
@@ -0,0 +1,46 @@
+package goexec
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/mod/modfile"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// This file implements `%%go.mod`, to write the notebook's module file directly -- e.g.: to add
+// `replace`, `exclude` or `retract` directives, or a specific `go` directive.
+
+// GoModPath returns the path of the notebook's module file.
+func (s *State) GoModPath() string {
+	return filepath.Join(s.TempDir, "go.mod")
+}
+
+// WriteGoMod validates the lines as a module file, and writes them as the notebook's go.mod,
+// replacing the current one. Subsequent `go get` (see AutoGet) and `go build` use it.
+//
+// If the module directive is missing, it is added. It must be the notebook's module (State.Package),
+// since it's used in the import path of the packages defined with `%%package`.
+//
+// If the contents are invalid, an error is returned and the current go.mod is kept.
+func (s *State) WriteGoMod(lines []string) error {
+	content := []byte(strings.Join(lines, "\n") + "\n")
+	f, err := modfile.Parse("go.mod", content, nil)
+	if err != nil {
+		return errors.Wrapf(err, "invalid go.mod contents, keeping the previous one")
+	}
+	if f.Module == nil {
+		if err = f.AddModuleStmt(s.Package); err != nil {
+			return errors.Wrapf(err, "adding module directive to go.mod")
+		}
+		if content, err = f.Format(); err != nil {
+			return errors.Wrapf(err, "formatting go.mod")
+		}
+	} else if f.Module.Mod.Path != s.Package {
+		return errors.Errorf("go.mod module must be %q, got %q: keeping the previous go.mod", s.Package, f.Module.Mod.Path)
+	}
+	if err = os.WriteFile(s.GoModPath(), content, 0600); err != nil {
+		return errors.Wrapf(err, "writing %q", s.GoModPath())
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package goexec
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteGoMod(t *testing.T) {
+	s := &State{Package: "gonb_gomod_test", TempDir: t.TempDir()}
+	require.NoError(t, s.WriteGoMod([]string{
+		`go 1.20`,
+		``,
+		`replace example.com/lib => ../lib`,
+	}))
+	content, err := os.ReadFile(s.GoModPath())
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "module gonb_gomod_test\n")
+	assert.Contains(t, string(content), "replace example.com/lib => ../lib")
+
+	// Invalid contents or a different module keep the previous go.mod.
+	assert.Error(t, s.WriteGoMod([]string{`module gonb_gomod_test`, `requires x`}))
+	assert.Error(t, s.WriteGoMod([]string{`module other`}))
+	newContent, err := os.ReadFile(s.GoModPath())
+	require.NoError(t, err)
+	assert.Equal(t, string(content), string(newContent))
+}
@@ -0,0 +1,123 @@
+package goexec
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+	"os"
+	"path/filepath"
+)
+
+// This file implements `%gowork`, to make the notebook's module part of a go.work workspace, so it can
+// import the local modules of the workspace.
+//
+// The notebook's module is not part of the original workspace, so a copy of the go.work with the
+// notebook's module added is written to State.TempDir, and all go commands are run with GOWORK pointing
+// to it.
+
+// WorkModule is a module used by a go.work workspace.
+type WorkModule struct {
+	// Path is the module path declared in its go.mod, and Dir is the directory of the module.
+	Path, Dir string
+}
+
+// GoWorkPath returns the path of the go.work generated for the notebook, see SetGoWork.
+func (s *State) GoWorkPath() string {
+	return filepath.Join(s.TempDir, "go.work")
+}
+
+// SetGoWork validates the go.work file in goWorkPath and makes the notebook's module part of its
+// workspace. It returns the modules the workspace exposes.
+//
+// If the file is invalid, an error is returned and the current workspace (if any) is kept.
+func (s *State) SetGoWork(goWorkPath string) ([]WorkModule, error) {
+	goWorkPath, err := filepath.Abs(goWorkPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "finding absolute path of %q", goWorkPath)
+	}
+	content, err := os.ReadFile(goWorkPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading go.work file %q", goWorkPath)
+	}
+	f, err := modfile.ParseWork(goWorkPath, content, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid go.work file %q", goWorkPath)
+	}
+
+	// Paths in the go.work are relative to its directory: make them absolute, since the copy is
+	// written to a different directory.
+	workDir := filepath.Dir(goWorkPath)
+	absPath := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(workDir, path)
+	}
+	var modules []WorkModule
+	uses := append([]*modfile.Use(nil), f.Use...)
+	for _, use := range uses {
+		dir := absPath(use.Path)
+		goMod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading go.mod of module %q used in %q", use.Path, goWorkPath)
+		}
+		modulePath := modfile.ModulePath(goMod)
+		if err = f.DropUse(use.Path); err != nil {
+			return nil, errors.Wrapf(err, "updating use of %q", use.Path)
+		}
+		if err = f.AddUse(dir, modulePath); err != nil {
+			return nil, errors.Wrapf(err, "updating use of %q", use.Path)
+		}
+		modules = append(modules, WorkModule{Path: modulePath, Dir: dir})
+	}
+	replaces := append([]*modfile.Replace(nil), f.Replace...)
+	for _, replace := range replaces {
+		if replace.New.Version != "" || filepath.IsAbs(replace.New.Path) {
+			continue
+		}
+		if err = f.DropReplace(replace.Old.Path, replace.Old.Version); err != nil {
+			return nil, errors.Wrapf(err, "updating replace of %q", replace.Old.Path)
+		}
+		if err = f.AddReplace(replace.Old.Path, replace.Old.Version, absPath(replace.New.Path), ""); err != nil {
+			return nil, errors.Wrapf(err, "updating replace of %q", replace.Old.Path)
+		}
+	}
+	if err = f.AddUse(s.TempDir, s.Package); err != nil {
+		return nil, errors.Wrapf(err, "adding notebook's module to workspace")
+	}
+	// The workspace requires a go version at least as new as its modules, including the notebook's.
+	if goMod, err := os.ReadFile(s.GoModPath()); err == nil {
+		if modFile, err := modfile.ParseLax(s.GoModPath(), goMod, nil); err == nil && modFile.Go != nil &&
+			(f.Go == nil || semver.Compare("v"+modFile.Go.Version, "v"+f.Go.Version) > 0) {
+			if err = f.AddGoStmt(modFile.Go.Version); err != nil {
+				return nil, errors.Wrapf(err, "updating go version of workspace")
+			}
+		}
+	}
+	f.Cleanup()
+	if err = os.WriteFile(s.GoWorkPath(), modfile.Format(f.Syntax), 0600); err != nil {
+		return nil, errors.Wrapf(err, "writing %q", s.GoWorkPath())
+	}
+	s.GoWork = goWorkPath
+	return modules, nil
+}
+
+// ResetGoWork removes the notebook's module from the workspace set with SetGoWork, if any.
+func (s *State) ResetGoWork() error {
+	if s.GoWork == "" {
+		return nil
+	}
+	if err := os.Remove(s.GoWorkPath()); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "removing %q", s.GoWorkPath())
+	}
+	s.GoWork = ""
+	return nil
+}
+
+// goWorkEnv returns the environment variable to use the notebook's workspace, or empty if not using one.
+func (s *State) goWorkEnv() []string {
+	if s.GoWork == "" {
+		return nil
+	}
+	return []string{"GOWORK=" + s.GoWorkPath()}
+}
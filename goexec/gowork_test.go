@@ -0,0 +1,53 @@
+package goexec
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetGoWork(t *testing.T) {
+	workDir := t.TempDir()
+	libDir := filepath.Join(workDir, "lib")
+	require.NoError(t, os.Mkdir(libDir, 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(libDir, "go.mod"), []byte("module example.com/lib\n\ngo 1.20\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(libDir, "lib.go"), []byte("package lib\n\nconst Answer = 42\n"), 0600))
+	goWorkPath := filepath.Join(workDir, "go.work")
+	require.NoError(t, os.WriteFile(goWorkPath, []byte("go 1.20\n\nuse ./lib\n"), 0600))
+
+	s, err := NewState(WithTempDir(t.TempDir()), WithAutoGet(false))
+	require.NoError(t, err)
+	modules, err := s.SetGoWork(goWorkPath)
+	require.NoError(t, err)
+	assert.Equal(t, []WorkModule{{Path: "example.com/lib", Dir: libDir}}, modules)
+	assert.Equal(t, goWorkPath, s.GoWork)
+	content, err := os.ReadFile(s.GoWorkPath())
+	require.NoError(t, err)
+	assert.Contains(t, string(content), libDir)
+	assert.Contains(t, string(content), s.TempDir)
+
+	// Invalid go.work files keep the current workspace.
+	badPath := filepath.Join(workDir, "bad.work")
+	require.NoError(t, os.WriteFile(badPath, []byte("use ./missing\n"), 0600))
+	_, err = s.SetGoWork(badPath)
+	assert.Error(t, err)
+	assert.Equal(t, goWorkPath, s.GoWork)
+
+	// The notebook can import the modules of the workspace.
+	if _, err := exec.LookPath("go"); err == nil && s.GoToolchainError() == nil {
+		t.Setenv("GOFLAGS", "-mod=readonly") // -mod=mod is not accepted in workspace mode.
+		parseCellIntoState(t, s, []string{`import "example.com/lib"`})
+		_, err = s.createMainFromDecls(s.Decls, &Function{Key: "main", Name: "main", Definition: "func main() { println(lib.Answer) }"})
+		require.NoError(t, err)
+		output, err := s.GoCommand("build", "-o", s.BinaryPath()).CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+
+	require.NoError(t, s.ResetGoWork())
+	assert.Empty(t, s.GoWork)
+	assert.NoFileExists(t, s.GoWorkPath())
+}
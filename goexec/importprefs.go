@@ -0,0 +1,71 @@
+package goexec
+
+import (
+	"github.com/pkg/errors"
+	"go/token"
+	"sort"
+)
+
+// This file implements import preferences, set with `%importpref name=path`, used to disambiguate
+// packages that goimports would otherwise have to guess (e.g.: "math/rand" vs "crypto/rand").
+//
+// Preferences are rendered as explicit imports in `main.go` before goimports runs, which then
+// removes the ones not used. They are never stored in State.Decls, and imports explicitly declared
+// in the cells (State.Decls) with the same name take precedence over the preferences.
+
+// SetImportPreference sets the package path to import when `name` is used but not imported. An
+// empty importPath removes the preference.
+func (s *State) SetImportPreference(name, importPath string) error {
+	if !token.IsIdentifier(name) {
+		return errors.Errorf("invalid package name %q for import preference", name)
+	}
+	if importPath == "" {
+		delete(s.ImportPreferences, name)
+		return nil
+	}
+	if s.ImportPreferences == nil {
+		s.ImportPreferences = make(map[string]string)
+	}
+	s.ImportPreferences[name] = importPath
+	return nil
+}
+
+// ListImportPreferences returns the import preferences in the form "name=path", sorted.
+func (s *State) ListImportPreferences() []string {
+	prefs := make([]string, 0, len(s.ImportPreferences))
+	for name, importPath := range s.ImportPreferences {
+		prefs = append(prefs, name+"="+importPath)
+	}
+	sort.Strings(prefs)
+	return prefs
+}
+
+// withImportPreferences returns decls with the import preferences whose name is not yet imported.
+// decls is not modified: if there are preferences to add, a copy is returned.
+//
+// Preferences are not used if goimports is disabled (State.SkipGoImports), since it is goimports
+// that removes the ones not used.
+func (s *State) withImportPreferences(decls *Declarations) *Declarations {
+	if s.SkipGoImports {
+		return decls
+	}
+	var toAdd []*Import
+	for name, importPath := range s.ImportPreferences {
+		if _, found := decls.Imports[name]; found {
+			continue
+		}
+		importDecl := NewImport(importPath, "")
+		if importDecl.Key != name {
+			importDecl = NewImport(importPath, name)
+		}
+		toAdd = append(toAdd, importDecl)
+	}
+	if len(toAdd) == 0 {
+		return decls
+	}
+	decls = decls.Copy()
+	for _, importDecl := range toAdd {
+		decls.Imports[importDecl.Key] = importDecl
+	}
+	return decls
+}
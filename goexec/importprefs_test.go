@@ -0,0 +1,35 @@
+package goexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithImportPreferences(t *testing.T) {
+	s := &State{}
+	require.NoError(t, s.SetImportPreference("rand", "crypto/rand"))
+	require.NoError(t, s.SetImportPreference("yaml", "gopkg.in/yaml.v3"))
+	require.NoError(t, s.SetImportPreference("fmt", "fmt"))
+	assert.Error(t, s.SetImportPreference("1x", "x"))
+	assert.Equal(t, []string{"fmt=fmt", "rand=crypto/rand", "yaml=gopkg.in/yaml.v3"}, s.ListImportPreferences())
+
+	decls := NewDeclarations()
+	fmtImport := NewImport("fmt", "")
+	decls.Imports[fmtImport.Key] = fmtImport
+	got := s.withImportPreferences(decls)
+	assert.Len(t, decls.Imports, 1, "Original declarations should not be changed.")
+	assert.Equal(t, fmtImport, got.Imports["fmt"])
+	assert.Equal(t, &Import{Key: "rand", Path: "crypto/rand"}, got.Imports["rand"])
+	assert.Equal(t, &Import{Key: "yaml", Path: "gopkg.in/yaml.v3", Alias: "yaml"}, got.Imports["yaml"])
+
+	// Explicit imports take precedence.
+	mathRand := NewImport("math/rand", "")
+	decls.Imports[mathRand.Key] = mathRand
+	got = s.withImportPreferences(decls)
+	assert.Equal(t, mathRand, got.Imports["rand"])
+
+	require.NoError(t, s.SetImportPreference("rand", ""))
+	assert.Equal(t, []string{"fmt=fmt", "yaml=gopkg.in/yaml.v3"}, s.ListImportPreferences())
+}
@@ -0,0 +1,48 @@
+package goexec
+
+import "sort"
+
+// ImportInfo describes one import of the notebook, see State.CurrentImports.
+type ImportInfo struct {
+	// Path of the imported package.
+	Path string
+
+	// Alias given to the package in the import, or empty if none.
+	Alias string
+
+	// Name by which the package is referred to in the code: the alias if one is given, otherwise
+	// the last element of the path. Empty for blank ("_") and dot (".") imports.
+	Name string
+
+	// Blank is set for imports only for their side effects (`import _ "path"`).
+	Blank bool
+
+	// Dot is set for imports into the current namespace (`import . "path"`).
+	Dot bool
+}
+
+// CurrentImports returns the imports declared so far in the executed cells, sorted by path.
+//
+// Notice the imports added automatically by goimports at execution are not included.
+func (s *State) CurrentImports() []ImportInfo {
+	imports := make([]ImportInfo, 0, len(s.Decls.Imports))
+	for _, importDecl := range s.Decls.Imports {
+		info := ImportInfo{Path: importDecl.Path, Alias: importDecl.Alias}
+		switch importDecl.Alias {
+		case "_":
+			info.Blank = true
+		case ".":
+			info.Dot = true
+		default:
+			info.Name = importDecl.Key
+		}
+		imports = append(imports, info)
+	}
+	sort.Slice(imports, func(i, j int) bool {
+		if imports[i].Path != imports[j].Path {
+			return imports[i].Path < imports[j].Path
+		}
+		return imports[i].Alias < imports[j].Alias
+	})
+	return imports
+}
@@ -0,0 +1,37 @@
+package goexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parseCellIntoState parses the cell lines and merges its declarations into s.Decls, as
+// ExecuteCell does, but without compiling.
+func parseCellIntoState(t *testing.T, s *State, lines []string) {
+	s.Decls.MergeFrom(parseCellDecls(t, s, lines))
+}
+
+func parseCellDecls(t *testing.T, s *State, lines []string) *Declarations {
+	_, err := s.createGoFileFromLines(s.MainPath(), lines, nil, NoCursor)
+	require.NoError(t, err)
+	newDecls := NewDeclarations()
+	require.NoError(t, s.ParseImportsFromMainGo(nil, NoCursor, newDecls))
+	return newDecls
+}
+
+func TestCurrentImports(t *testing.T) {
+	s := &State{TempDir: t.TempDir(), Decls: NewDeclarations()}
+	parseCellIntoState(t, s, []string{`import "fmt"`, `import str "strings"`})
+	parseCellIntoState(t, s, []string{`import (`, `	_ "image/png"`, `	_ "image/jpeg"`, `	. "math"`, `)`})
+	parseCellIntoState(t, s, []string{`import "math/rand"`})
+	assert.Equal(t, []ImportInfo{
+		{Path: "fmt", Name: "fmt"},
+		{Path: "image/jpeg", Alias: "_", Blank: true},
+		{Path: "image/png", Alias: "_", Blank: true},
+		{Path: "math", Alias: ".", Dot: true},
+		{Path: "math/rand", Name: "rand"},
+		{Path: "strings", Alias: "str", Name: "str"},
+	}, s.CurrentImports())
+}
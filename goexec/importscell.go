@@ -0,0 +1,134 @@
+package goexec
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+	"golang.org/x/mod/modfile"
+)
+
+// This file implements `%%imports`: a cell with only import declarations, used to set up the
+// dependencies of the notebook up front. The imports are memorized and their modules fetched with
+// `go get`, but nothing is compiled nor executed.
+
+// FetchImports parses the lines as import declarations, fetches the modules that provide them with
+// `go get` -- even if AutoGet is disabled -- and memorizes them for the following cells. It reports
+// the modules added to (or updated in) go.mod.
+//
+// The imports are fetched as blank imports (`_ "path"`), so goimports can't drop them for not being
+// used. If fetching fails, the imports are not memorized.
+func (s *State) FetchImports(msg kernel.Message, lines []string) error {
+	if err := s.GoToolchainError(); err != nil {
+		return err
+	}
+	newImports, err := parseImportsCell(lines)
+	if err != nil {
+		return err
+	}
+	if len(newImports) == 0 {
+		return errors.Errorf("`%%%%imports` requires import declarations in the rest of the cell")
+	}
+
+	// Render main.go with the new imports as blank imports, so they are all used.
+	fetchDecls := s.Decls.Copy()
+	fetchDecls.ClearCursor()
+	for _, imp := range newImports {
+		blank := NewImport(imp.Path, "_")
+		blank.Cursor = NoCursor
+		fetchDecls.Imports[blank.Key] = blank
+	}
+	if _, err = s.createMainFromDecls(s.withImportPreferences(fetchDecls), s.stubMain()); err != nil {
+		return errors.WithMessagef(err, "in goexec.FetchImports() while generating main.go")
+	}
+	before := s.requiredModules()
+	if err = s.goGet(msg); err != nil {
+		return err
+	}
+	fetched := make(map[string]string)
+	for modulePath, version := range s.requiredModules() {
+		if before[modulePath] != version {
+			fetched[modulePath] = version
+		}
+	}
+
+	for _, imp := range newImports {
+		s.Decls.Imports[imp.Key] = imp
+	}
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout, fetchedModulesReport(fetched))
+}
+
+// parseImportsCell parses the lines of a `%%imports` cell, which must only have import declarations.
+func parseImportsCell(lines []string) ([]*Import, error) {
+	src := "package main\n" + strings.Join(lines, "\n")
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, "imports.go", src, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "`%%%%imports` only takes import declarations")
+	}
+	for _, decl := range file.Decls {
+		if genDecl, ok := decl.(*ast.GenDecl); !ok || genDecl.Tok != token.IMPORT {
+			// Line numbers are shifted by the "package" clause added.
+			return nil, errors.Errorf("`%%%%imports` only takes import declarations, line %d is not one",
+				fileSet.Position(decl.Pos()).Line-1)
+		}
+	}
+	var imports []*Import
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid import path %s", spec.Path.Value)
+		}
+		var alias string
+		if spec.Name != nil {
+			alias = spec.Name.Name
+		}
+		imp := NewImport(importPath, alias)
+		imp.Cursor = NoCursor
+		imports = append(imports, imp)
+	}
+	return imports, nil
+}
+
+// requiredModules returns the modules required by the notebook's go.mod, mapped to their versions.
+// It returns an empty map if go.mod can't be read.
+func (s *State) requiredModules() map[string]string {
+	modules := make(map[string]string)
+	content, err := os.ReadFile(s.GoModPath())
+	if err != nil {
+		return modules
+	}
+	f, err := modfile.ParseLax("go.mod", content, nil)
+	if err != nil {
+		return modules
+	}
+	for _, req := range f.Require {
+		modules[req.Mod.Path] = req.Mod.Version
+	}
+	return modules
+}
+
+// fetchedModulesReport returns the report of the modules fetched by FetchImports.
+func fetchedModulesReport(fetched map[string]string) string {
+	if len(fetched) == 0 {
+		return "* Imports memorized, no new modules needed.\n"
+	}
+	paths := make([]string, 0, len(fetched))
+	for modulePath := range fetched {
+		paths = append(paths, modulePath)
+	}
+	sort.Strings(paths)
+	var sb strings.Builder
+	sb.WriteString("* Imports memorized, modules fetched:\n")
+	for _, modulePath := range paths {
+		fmt.Fprintf(&sb, "  - %s %s\n", modulePath, fetched[modulePath])
+	}
+	return sb.String()
+}
@@ -0,0 +1,49 @@
+package goexec
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImportsCell(t *testing.T) {
+	imports, err := parseImportsCell([]string{`import "example.com/x/y"`, `import (`, `	z "example.com/z"`, `	_ "image/png"`, `)`})
+	require.NoError(t, err)
+	var keys []string
+	for _, imp := range imports {
+		keys = append(keys, imp.Key+"="+imp.Path)
+		assert.False(t, imp.HasCursor())
+	}
+	assert.Equal(t, []string{"y=example.com/x/y", "z=example.com/z", "_~image/png=image/png"}, keys)
+
+	_, err = parseImportsCell([]string{`import "fmt"`, "var x = 1"})
+	assert.ErrorContains(t, err, "line 2 is not one")
+	_, err = parseImportsCell([]string{`import fmt`})
+	assert.Error(t, err)
+}
+
+func TestFetchImports(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the go binary")
+	}
+	// A fake go, that only checks the blank import was rendered, and adds a requirement to go.mod.
+	dir := t.TempDir()
+	goPath := filepath.Join(t.TempDir(), "go")
+	require.NoError(t, os.WriteFile(goPath, []byte(
+		"#!/bin/sh\ngrep -q '_ \"example.com/x/y\"' main.go || exit 1\necho 'require example.com/x v1.2.3' >> go.mod\n"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module gonb_test\n"), 0600))
+	s := &State{TempDir: dir, GoBinary: goPath, Decls: NewDeclarations(), StubMainBody: DefaultStubMainBody}
+
+	msg := newTestMessage()
+	require.NoError(t, s.FetchImports(msg, []string{`import "example.com/x/y"`}))
+	assert.Contains(t, strings.Join(msg.Published(), ""), "example.com/x v1.2.3")
+	require.Contains(t, s.Decls.Imports, "y")
+	assert.Equal(t, "example.com/x/y", s.Decls.Imports["y"].Path)
+
+	assert.Error(t, s.FetchImports(newTestMessage(), []string{"// Nothing."}))
+}
@@ -0,0 +1,426 @@
+package goexec
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+	"golang.org/x/mod/modfile"
+)
+
+// This file implements incremental builds (prototype), see `%incremental`: in long sessions most
+// declarations don't change from one cell to the next, but the whole main.go is recompiled every
+// time. With State.Incremental, the stable declarations of main.go are moved to a sub-package of
+// the module (IncrementalPackage), and the main package only keeps the declarations that may change
+// -- plus aliases to the moved ones. The `go` build cache then skips recompiling the sub-package
+// while its contents don't change, and only main is recompiled.
+//
+// Invalidation comes with the build cache, which is keyed by the contents of the files: when a
+// moved declaration changes, so does the sub-package, and it is recompiled.
+//
+// Declarations can only be moved if the main package doesn't need their unexported internals. Kept
+// in main are:
+//
+//   - Variables, since they hold state that the program may modify: an alias would be a copy.
+//   - The main and init functions, and generic functions and types (Go 1.20 has no generic aliases).
+//   - Types whose unexported fields or methods are referenced in the main package.
+//   - Anything that references what is kept in main, since the sub-package can't refer back to it.
+//
+// The split is done on the main.go generated for the cell (after goimports), and only for the
+// build: main.go is restored afterwards. If the split program fails to build for any reason, it
+// falls back to building main.go as usual, so the errors reported are always the same.
+//
+// The program built differs from the regular one where the moved declarations are observed as
+// belonging to another package:
+//
+//   - Moved types are aliases in main: their reflected names (e.g.: `%T` in fmt, or
+//     reflect.Type.String) are "gonb_incremental.Point" instead of "main.Point".
+//   - Panics and stack traces in moved functions point to the file of IncrementalPackage, which is
+//     removed after the build, instead of main.go.
+//
+// Because of the latter, the split is not done while debugging (State.DebugAddress): the debugger
+// needs the positions in main.go.
+
+// IncrementalPackage is the name of the sub-package holding the stable declarations, see
+// State.Incremental.
+const IncrementalPackage = "gonb_incremental"
+
+// incrementalAliasPrefix is the prefix of the exported aliases of the declarations moved to
+// IncrementalPackage.
+const incrementalAliasPrefix = "Gonb_"
+
+// incrementalUnit is a top-level declaration of main.go: a function, a method, or a `import`,
+// `const`, `type` or `var` declaration (with all its specs, e.g.: the constants of a block, which
+// may depend on their order with iota).
+type incrementalUnit struct {
+	decl   ast.Decl
+	source string
+
+	// names defined by the unit, and their kind (token.TYPE, token.CONST or token.FUNC).
+	names []string
+	kind  token.Token
+
+	// receiver is the name of the receiver type of a method.
+	receiver string
+
+	// members are the names of the unexported fields and methods of a type.
+	members []string
+
+	// idents are all the identifiers used in the unit.
+	idents map[string]bool
+
+	active bool
+}
+
+// splitIncremental splits the contents of main.go into the main package and the package
+// IncrementalPackage, to be imported as modulePath/IncrementalPackage. It returns the number of
+// declarations moved, 0 if none can be moved, in which case the program should be built as is.
+func splitIncremental(src []byte, modulePath string) (mainSrc, stableSrc []byte, numMoved int, err error) {
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, "main.go", src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, nil, 0, errors.Wrapf(err, "parsing main.go for incremental build")
+	}
+	for _, spec := range file.Imports {
+		if (spec.Name != nil && spec.Name.Name == ".") || spec.Path.Value == `"C"` {
+			// Dot imports (can't tell what is used) and cgo: not supported.
+			return nil, nil, 0, nil
+		}
+	}
+
+	// Collect units.
+	var units []*incrementalUnit
+	unitsByName := make(map[string]*incrementalUnit)
+	typeMethods := make(map[string][]*incrementalUnit)
+	for _, decl := range file.Decls {
+		unit := &incrementalUnit{decl: decl, idents: make(map[string]bool)}
+		start := decl.Pos()
+		switch typedDecl := decl.(type) {
+		case *ast.FuncDecl:
+			if typedDecl.Doc != nil {
+				start = typedDecl.Doc.Pos()
+			}
+			unit.kind = token.FUNC
+			if typedDecl.Recv != nil && len(typedDecl.Recv.List) > 0 {
+				unit.receiver = receiverTypeName(typedDecl.Recv.List[0].Type)
+				typeMethods[unit.receiver] = append(typeMethods[unit.receiver], unit)
+			} else {
+				unit.names = []string{typedDecl.Name.Name}
+				name := typedDecl.Name.Name
+				unit.active = name == "main" || name == "init" || typedDecl.Type.TypeParams != nil
+			}
+		case *ast.GenDecl:
+			if typedDecl.Doc != nil {
+				start = typedDecl.Doc.Pos()
+			}
+			unit.kind = typedDecl.Tok
+			for _, spec := range typedDecl.Specs {
+				switch typedSpec := spec.(type) {
+				case *ast.ValueSpec:
+					for _, name := range typedSpec.Names {
+						unit.names = append(unit.names, name.Name)
+					}
+				case *ast.TypeSpec:
+					unit.names = append(unit.names, typedSpec.Name.Name)
+					unit.members = append(unit.members, unexportedMembers(typedSpec.Type)...)
+					if typedSpec.TypeParams != nil {
+						unit.active = true
+					}
+				}
+			}
+			unit.active = unit.active || typedDecl.Tok == token.VAR
+			if typedDecl.Tok == token.IMPORT {
+				continue
+			}
+		}
+		unit.source = string(src[fileSet.Position(start).Offset:fileSet.Position(decl.End()).Offset])
+		ast.Inspect(decl, func(node ast.Node) bool {
+			if ident, ok := node.(*ast.Ident); ok {
+				unit.idents[ident.Name] = true
+			}
+			return true
+		})
+		units = append(units, unit)
+		for _, name := range unit.names {
+			unitsByName[name] = unit
+		}
+	}
+	for typeName, methods := range typeMethods {
+		if typeUnit := unitsByName[typeName]; typeUnit != nil && typeUnit.kind == token.TYPE {
+			for _, method := range methods {
+				if funcDecl := method.decl.(*ast.FuncDecl); !token.IsExported(funcDecl.Name.Name) {
+					typeUnit.members = append(typeUnit.members, funcDecl.Name.Name)
+				}
+			}
+		} else {
+			// Methods of a type not declared in main.go: keep them where they are.
+			for _, method := range methods {
+				method.active = true
+			}
+		}
+	}
+
+	// Propagate what has to be kept in main, until it stabilizes.
+	for changed := true; changed; {
+		changed = false
+		activeIdents := make(map[string]bool)
+		for _, unit := range units {
+			if unit.active {
+				for ident := range unit.idents {
+					activeIdents[ident] = true
+				}
+			}
+		}
+		for _, unit := range units {
+			if unit.active {
+				continue
+			}
+			activate := false
+			for ident := range unit.idents {
+				if other := unitsByName[ident]; other != nil && other != unit && other.active {
+					activate = true
+					break
+				}
+			}
+			for _, member := range unit.members {
+				activate = activate || activeIdents[member]
+			}
+			if unit.receiver != "" {
+				// Methods go with their types, both ways.
+				typeUnit := unitsByName[unit.receiver]
+				if typeUnit.active {
+					activate = true
+				} else if activate {
+					typeUnit.active = true
+				}
+			}
+			if activate {
+				unit.active = true
+				changed = true
+			}
+		}
+	}
+
+	// Render both files.
+	var mainBody, stableBody, aliases, exports bytes.Buffer
+	var mainUnits, stableUnits []*incrementalUnit
+	for _, unit := range units {
+		if unit.active {
+			mainUnits = append(mainUnits, unit)
+			mainBody.WriteString(unit.source + "\n\n")
+			continue
+		}
+		stableUnits = append(stableUnits, unit)
+		stableBody.WriteString(unit.source + "\n\n")
+		for _, name := range unit.names {
+			if name == "_" {
+				continue
+			}
+			numMoved++
+			keyword := unit.kind.String()
+			if unit.kind == token.FUNC {
+				keyword = "var"
+			}
+			fmt.Fprintf(&exports, "%s %s%s = %s\n", keyword, incrementalAliasPrefix, name, name)
+			fmt.Fprintf(&aliases, "%s %s = %s.%s%s\n", keyword, name, IncrementalPackage, incrementalAliasPrefix, name)
+		}
+	}
+	if numMoved == 0 {
+		return nil, nil, 0, nil
+	}
+	mainImports, stableImports := splitImports(file.Imports, mainUnits, stableUnits)
+
+	var mainFile, stableFile bytes.Buffer
+	mainFile.WriteString("package main\n\nimport (\n")
+	mainFile.WriteString(mainImports)
+	fmt.Fprintf(&mainFile, "\t%s %q\n)\n\n", IncrementalPackage, path.Join(modulePath, IncrementalPackage))
+	mainFile.Write(aliases.Bytes())
+	mainFile.WriteString("\n")
+	mainFile.Write(mainBody.Bytes())
+
+	fmt.Fprintf(&stableFile, "package %s\n\n", IncrementalPackage)
+	if stableImports != "" {
+		stableFile.WriteString("import (\n" + stableImports + ")\n\n")
+	}
+	stableFile.Write(stableBody.Bytes())
+	stableFile.Write(exports.Bytes())
+	return mainFile.Bytes(), stableFile.Bytes(), numMoved, nil
+}
+
+// receiverTypeName returns the name of the type of a method receiver, e.g.: "T" for `(t *T)`.
+func receiverTypeName(expr ast.Expr) string {
+	for {
+		switch typedExpr := expr.(type) {
+		case *ast.StarExpr:
+			expr = typedExpr.X
+		case *ast.ParenExpr:
+			expr = typedExpr.X
+		case *ast.IndexExpr:
+			expr = typedExpr.X
+		case *ast.IndexListExpr:
+			expr = typedExpr.X
+		case *ast.Ident:
+			return typedExpr.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// unexportedMembers returns the names of the unexported fields of a struct type, or of the
+// unexported methods of an interface type.
+func unexportedMembers(expr ast.Expr) []string {
+	var fields *ast.FieldList
+	switch typedExpr := expr.(type) {
+	case *ast.StructType:
+		fields = typedExpr.Fields
+	case *ast.InterfaceType:
+		fields = typedExpr.Methods
+	default:
+		return nil
+	}
+	var members []string
+	for _, field := range fields.List {
+		for _, name := range field.Names {
+			if !token.IsExported(name.Name) {
+				members = append(members, name.Name)
+			}
+		}
+	}
+	return members
+}
+
+// reImportVersionSuffix matches the suffixes of import paths that are usually not part of the
+// package name: major versions ("/v2") and gopkg.in versions (".v3").
+var reImportVersionSuffix = regexp.MustCompile(`(/v[0-9]+|\.v[0-9]+)$`)
+
+// splitImports returns the import specs (one per line) used by the main and by the stable units.
+//
+// The name of a package imported without an alias is guessed from its path: if the guess is wrong
+// the split program fails to build, and it falls back to the regular build. Imports not used by
+// either are kept in main as blank imports, for their side effects.
+func splitImports(imports []*ast.ImportSpec, mainUnits, stableUnits []*incrementalUnit) (mainImports, stableImports string) {
+	uses := func(units []*incrementalUnit, name string) bool {
+		for _, unit := range units {
+			if unit.idents[name] {
+				return true
+			}
+		}
+		return false
+	}
+	var mainLines, stableLines []string
+	for _, spec := range imports {
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		name := path.Base(reImportVersionSuffix.ReplaceAllString(importPath, ""))
+		name = strings.TrimPrefix(strings.TrimSuffix(name, "-go"), "go-")
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		line := fmt.Sprintf("\t%s %s\n", name, spec.Path.Value)
+		usedByMain, usedByStable := name != "_" && uses(mainUnits, name), name != "_" && uses(stableUnits, name)
+		if usedByMain {
+			mainLines = append(mainLines, line)
+		}
+		if usedByStable {
+			stableLines = append(stableLines, line)
+		}
+		if !usedByMain && !usedByStable {
+			mainLines = append(mainLines, fmt.Sprintf("\t_ %s\n", spec.Path.Value))
+		}
+	}
+	sort.Strings(mainLines)
+	sort.Strings(stableLines)
+	return strings.Join(mainLines, ""), strings.Join(stableLines, "")
+}
+
+// IncrementalPackagePath returns the path of the file of the package IncrementalPackage.
+func (s *State) IncrementalPackagePath() string {
+	return filepath.Join(s.TempDir, IncrementalPackage, IncrementalPackage+".go")
+}
+
+// modulePath returns the path of the notebook's module, as declared in its go.mod.
+func (s *State) modulePath() string {
+	content, err := os.ReadFile(s.GoModPath())
+	if err != nil {
+		return s.Package
+	}
+	if modulePath := modfile.ModulePath(content); modulePath != "" {
+		return modulePath
+	}
+	return s.Package
+}
+
+// compileIncremental tries to build the program with the stable declarations of main.go moved to
+// the package IncrementalPackage, see State.Incremental. args are the arguments to `go build`.
+//
+// main.go is always restored. It returns whether it succeeded -- if not, the program should be
+// built as usual --, and the output of `go build`.
+func (s *State) compileIncremental(msg kernel.Message, args []string) (output string, ok bool) {
+	if len(s.ConstrainedDecls) > 0 {
+		// Constrained declarations are in other files of the main package.
+		return "", false
+	}
+	if s.DebugAddress != "" {
+		// The debugger needs the positions of the declarations in main.go.
+		return "", false
+	}
+	mainContent, err := os.ReadFile(s.MainPath())
+	if err != nil {
+		s.logf("Incremental build: %+v", err)
+		return "", false
+	}
+	mainSrc, stableSrc, numMoved, err := splitIncremental(mainContent, s.modulePath())
+	if err != nil || numMoved == 0 {
+		if err != nil {
+			s.logf("Incremental build: %+v", err)
+		}
+		return "", false
+	}
+
+	stableDir := filepath.Dir(s.IncrementalPackagePath())
+	defer func() {
+		if err := os.WriteFile(s.MainPath(), mainContent, 0600); err != nil {
+			s.logf("Failed to restore %q after incremental build: %+v", s.MainPath(), err)
+		}
+		if err := os.RemoveAll(stableDir); err != nil {
+			s.logf("Failed to remove %q after incremental build: %+v", stableDir, err)
+		}
+	}()
+	if err = os.MkdirAll(stableDir, 0700); err == nil {
+		err = os.WriteFile(s.IncrementalPackagePath(), stableSrc, 0600)
+	}
+	if err == nil {
+		err = os.WriteFile(s.MainPath(), mainSrc, 0600)
+	}
+	if err != nil {
+		s.logf("Incremental build: failed to write split program: %+v", err)
+		return "", false
+	}
+
+	start := time.Now()
+	cmd := s.GoCommand(args...)
+	s.reportCommand(msg, cmd)
+	output, err = runGoCommand(msg, cmd)
+	if err != nil {
+		s.logf("Incremental build failed, building it as usual: %v\n%s", err, output)
+		return "", false
+	}
+	if s.Verbose {
+		_ = kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf(
+			"* Incremental build: %d declarations in package %s, built in %s\n",
+			numMoved, IncrementalPackage, time.Since(start).Round(time.Millisecond)))
+	}
+	return output, true
+}
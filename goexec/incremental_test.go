@@ -0,0 +1,147 @@
+package goexec
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitIncremental(t *testing.T) {
+	src := `package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Point is stable.
+type Point struct{ X, Y int }
+
+func (p Point) String() string { return fmt.Sprintf("(%d, %d)", p.X, p.Y) }
+
+const Scale = 2
+
+func double(p Point) Point { return Point{p.X * Scale, p.Y * Scale} }
+
+var counter int
+
+func increment() { counter++ }
+
+type hidden struct{ value int }
+
+func upper(s string) string { return strings.ToUpper(s) }
+
+func main() {
+	increment()
+	fmt.Println(double(Point{1, 2}), upper("x"), hidden{1}.value)
+}
+`
+	mainSrc, stableSrc, numMoved, err := splitIncremental([]byte(src), "gonb_test")
+	require.NoError(t, err)
+	// Moved: Point, Scale, double, upper.
+	assert.Equal(t, 4, numMoved)
+	mainStr, stableStr := string(mainSrc), string(stableSrc)
+
+	assert.Contains(t, stableStr, "package gonb_incremental")
+	assert.Contains(t, stableStr, "// Point is stable.\ntype Point struct{ X, Y int }")
+	assert.Contains(t, stableStr, "func (p Point) String() string")
+	assert.Contains(t, stableStr, "type Gonb_Point = Point\n")
+	assert.Contains(t, stableStr, "const Gonb_Scale = Scale\n")
+	assert.Contains(t, stableStr, "var Gonb_double = double\n")
+	assert.Contains(t, stableStr, "\tstrings \"strings\"\n")
+	assert.Contains(t, stableStr, "\tfmt \"fmt\"\n")
+
+	assert.Contains(t, mainStr, "\tgonb_incremental \"gonb_test/gonb_incremental\"\n")
+	assert.Contains(t, mainStr, "type Point = gonb_incremental.Gonb_Point\n")
+	assert.Contains(t, mainStr, "var upper = gonb_incremental.Gonb_upper\n")
+	assert.NotContains(t, mainStr, "\tstrings \"strings\"")
+	// Variables, what uses them, and types whose unexported fields are used stay in main.
+	assert.Contains(t, mainStr, "var counter int")
+	assert.Contains(t, mainStr, "func increment() { counter++ }")
+	assert.Contains(t, mainStr, "type hidden struct{ value int }")
+	assert.Contains(t, mainStr, "func main() {")
+
+	// Nothing to move.
+	_, _, numMoved, err = splitIncremental([]byte("package main\n\nvar x int\n\nfunc main() { x++ }\n"), "gonb_test")
+	require.NoError(t, err)
+	assert.Equal(t, 0, numMoved)
+
+	// Dot imports are not supported.
+	_, _, numMoved, err = splitIncremental([]byte("package main\n\nimport . \"fmt\"\n\nfunc f() {}\n\nfunc main() { Println() }\n"), "gonb_test")
+	require.NoError(t, err)
+	assert.Equal(t, 0, numMoved)
+}
+
+func TestIncrementalExecution(t *testing.T) {
+	s := newTestState(t)
+	s.Incremental = true
+	s.Verbose = true
+
+	msg := newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{
+		`import "fmt"`,
+		`func greet(name string) string { return "hello " + name }`,
+		"%%",
+		`fmt.Println(greet("gopher"))`,
+	}, nil))
+	output := strings.Join(msg.Published(), "")
+	assert.Contains(t, output, "hello gopher\n")
+	assert.Contains(t, output, "* Incremental build: 1 declarations in package gonb_incremental")
+
+	// Changing a stable declaration is reflected in the next execution.
+	msg = newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{
+		`func greet(name string) string { return "bye " + name }`,
+		"%%",
+		`fmt.Println(greet("gopher"))`,
+	}, nil))
+	assert.Contains(t, strings.Join(msg.Published(), ""), "bye gopher\n")
+	assert.NoFileExists(t, s.IncrementalPackagePath())
+
+	// Not split while debugging.
+	s.DebugAddress = DefaultDebugAddress
+	_, ok := s.compileIncremental(msg, []string{"build", "-o", s.BinaryPath()})
+	assert.False(t, ok)
+}
+
+// BenchmarkIncrementalBuild measures the time to build a program with many stable declarations
+// after a change in main, with and without State.Incremental. Run with:
+//
+//	go test ./goexec -run=^$ -bench=IncrementalBuild
+func BenchmarkIncrementalBuild(b *testing.B) {
+	const numFuncs = 500
+	var decls strings.Builder
+	decls.WriteString("package main\n\nimport \"strings\"\n\n")
+	for ii := 0; ii < numFuncs; ii++ {
+		fmt.Fprintf(&decls, "func f%d(s string) string {\n", ii)
+		fmt.Fprintf(&decls, "\tswitch {\n\tcase strings.HasPrefix(s, \"a%d\"):\n\t\treturn strings.ToUpper(s)\n", ii)
+		fmt.Fprintf(&decls, "\tcase len(s) > %d:\n\t\treturn s[:%d]\n\t}\n\treturn s + \"%d\"\n}\n\n", ii%7, ii%7, ii)
+	}
+
+	for _, incremental := range []bool{false, true} {
+		name := "full"
+		if incremental {
+			name = "incremental"
+		}
+		b.Run(name, func(b *testing.B) {
+			s := newTestState(b)
+			s.Incremental = incremental
+			msg := newTestMessage()
+			build := func(ii int) {
+				// Only main changes from one build to the next.
+				mainGo := fmt.Sprintf("%sfunc main() {\n\tprintln(f0(\"x\"), %d)\n}\n", decls.String(), ii)
+				require.NoError(b, os.WriteFile(s.MainPath(), []byte(mainGo), 0600))
+				require.NoError(b, s.Compile(msg))
+			}
+			build(-1) // Warm up the build cache.
+			b.ResetTimer()
+			for ii := 0; ii < b.N; ii++ {
+				build(ii)
+			}
+		})
+	}
+}
@@ -7,85 +7,59 @@ import (
 	"github.com/janpfeifer/gonb/gonbui/protocol"
 	"github.com/janpfeifer/gonb/kernel"
 	"github.com/pkg/errors"
-	"log"
+	"os"
 	"os/exec"
-	"path"
+	"path/filepath"
 )
 
-// This file implements saving to a inspect.go file, and then using `gopls` to
-// inspect a requested token.
+// This file implements saving the program with the cell being edited to its own file (see
+// InspectPath), and then using `gopls` to inspect a requested token.
 
-// InspectPath returns the path of the file saved to be used for inspection (`inspect_request
-// message from Jupyter).
+// InspectPath returns the path of the file saved to be used for inspection (`inspect_request`
+// message from Jupyter) and completion. It is in its own directory -- a package of the module in
+// TempDir --, so it doesn't replace main.go, nor conflicts with its declarations.
 func (s *State) InspectPath() string {
-	return path.Join(s.TempDir, "inspect.go")
+	return filepath.Join(s.TempDir, "inspect", "main.go")
 }
 
+// InspectCell returns the description given by `gopls` of the symbol under the cursor, given by line
+// and col in the cell (0-based).
+//
+// It holds the execution lock (see LockExecution), since it reads the declarations of the State.
 func (s *State) InspectCell(lines []string, skipLines map[int]bool, line, col int) (kernel.MIMEMap, error) {
 	if skipLines[line] {
 		// Only Go code can be inspected here.
 		return nil, errors.Errorf("goexec.InspectCell() can only inspect Go code, line %d is a secial command line: %q", line, lines[line])
 	}
 
-	cursorInCell := Cursor{int32(line), int32(col)}
-	cursorInTmpFile, err := s.createGoFileFromLines(s.MainPath(), lines, skipLines, cursorInCell)
+	s.LockExecution()
+	defer s.UnlockExecution()
+	cursorInFile, err := s.renderCellWithCursor(lines, skipLines, line, col)
 	if err != nil {
 		return nil, errors.WithMessagef(err, "in goexec.InspectCell()")
 	}
-	newDecls := NewDeclarations()
-	if err = s.ParseImportsFromMainGo(nil, cursorInTmpFile, newDecls); err != nil {
-		// If cell is in an un-parseable state, just returns empty context. User can try to
-		// run cell to get an error.
-		return make(kernel.MIMEMap), nil
-	}
-
-	// Checks whether there is a "main" function defined in the code.
-	mainDecl, hasMain := newDecls.Functions["main"]
-	if hasMain {
-		// Remove "main" from newDecls: this should not be stored from one cell execution from
-		// another.
-		delete(newDecls.Functions, "main")
-	} else {
-		// Declare a stub main function, just so we can try to compile the final code.
-		mainDecl = &Function{Key: "main", Name: "main", Definition: "func main() { flag.Parse() }"}
-	}
-	_ = mainDecl
-
-	// Merge cell declarations with a copy of the current state: we don't want to commit the new
-	// declarations until they compile successfully.
-	tmpDecls := s.Decls.Copy()
-	tmpDecls.ClearCursor()
-	tmpDecls.MergeFrom(newDecls)
-
-	// Render declarations to main.go.
-	var cursorInFile Cursor
-	cursorInFile, err = s.createMainFromDecls(tmpDecls, mainDecl)
-	if err != nil {
-		return nil, errors.WithMessagef(err, "in goexec.InspectCell() while generating main.go with all declarations")
-	}
 	if !cursorInFile.HasCursor() {
 		// Returns empty data, which returns a "not found".
 		return make(kernel.MIMEMap), nil
 	}
-	log.Printf("CursorInFile: %+v", cursorInFile)
+	s.debugf("CursorInFile: %+v", cursorInFile)
 
 	// Execute `gopls` with the given path.
-	var jsonData map[string]any
-	jsonData, err = goplsQuery(s.TempDir, "definition", s.MainPath(), cursorInFile)
+	jsonData, err := s.goplsQuery(s.TempDir, "definition", s.InspectPath(), cursorInFile)
 	if err != nil {
-		log.Printf("Failed to find definition with `gopls` for symbol under cursor: %v", err)
+		s.logf("Failed to find definition with `gopls` for symbol under cursor: %v", err)
 		// If gopls fails, just returns empty data, which returns a "not found".
 		return make(kernel.MIMEMap), nil
 	}
 	descAny, found := jsonData["description"]
 	if !found {
-		log.Printf("gopls without description, returned %q", jsonData)
+		s.logf("gopls without description, returned %q", jsonData)
 		// Returns empty data, which returns a "not found".
 		return make(kernel.MIMEMap), nil
 	}
 	desc, ok := descAny.(string)
 	if !ok {
-		log.Printf("gopls description not a string: %q", desc)
+		s.logf("gopls description not a string: %q", desc)
 		// Returns empty data, which returns a "not found".
 		return make(kernel.MIMEMap), nil
 	}
@@ -94,9 +68,57 @@ func (s *State) InspectCell(lines []string, skipLines map[int]bool, line, col in
 	return kernel.MIMEMap{protocol.MIMETextMarkdown: desc}, nil
 }
 
+// renderCellWithCursor renders InspectPath with all the declarations of the previous cells merged
+// with the ones of the cell being edited, so tools (e.g.: `gopls`) see the symbols defined in all
+// cells. It returns the position in the file of the cursor, given by line and col in the cell
+// (0-based), or NoCursor if the cell can't be parsed (e.g.: while it is being typed) or the cursor is
+// not in Go code.
+//
+// The execution lock must be held (see LockExecution).
+func (s *State) renderCellWithCursor(lines []string, skipLines map[int]bool, line, col int) (Cursor, error) {
+	if err := os.MkdirAll(filepath.Dir(s.InspectPath()), 0700); err != nil {
+		return NoCursor, errors.Wrapf(err, "failed to create directory for %q", s.InspectPath())
+	}
+	cursorInCell := Cursor{int32(line), int32(col)}
+	cursorInTmpFile, err := s.createGoFileFromLines(s.InspectPath(), lines, skipLines, cursorInCell)
+	if err != nil {
+		return NoCursor, err
+	}
+	newDecls := NewDeclarations()
+	if err = s.parseDeclsFromFile(nil, s.InspectPath(), cursorInTmpFile, newDecls); err != nil {
+		// If cell is in an un-parseable state, just returns no cursor. User can try to
+		// run cell to get an error.
+		return NoCursor, nil
+	}
+
+	// Checks whether there is a "main" function defined in the code.
+	mainDecl, hasMain := newDecls.Functions["main"]
+	if hasMain {
+		// Remove "main" from newDecls: this should not be stored from one cell execution from
+		// another.
+		delete(newDecls.Functions, "main")
+	} else {
+		// Declare a stub main function, just so we can try to compile the final code.
+		mainDecl = s.stubMain()
+	}
+
+	// Merge cell declarations with a copy of the current state: we don't want to commit the new
+	// declarations until they compile successfully.
+	tmpDecls := s.Decls.Copy()
+	tmpDecls.ClearCursor()
+	tmpDecls.MergeFrom(newDecls)
+
+	// Render declarations to InspectPath.
+	cursorInFile, err := s.createMainFileFromDecls(s.InspectPath(), tmpDecls, mainDecl)
+	if err != nil {
+		return NoCursor, errors.WithMessagef(err, "while generating %q with all declarations", s.InspectPath())
+	}
+	return cursorInFile, nil
+}
+
 // goplsQuery invokes gopls to find the definition of a function.
 // TODO: run gopls as a service, as opposed to invoking it every time.
-func goplsQuery(dir, command, filePath string, cursor Cursor) (map[string]any, error) {
+func (s *State) goplsQuery(dir, command, filePath string, cursor Cursor) (map[string]any, error) {
 	goplsPath, err := exec.LookPath("gopls")
 	if err != nil {
 		msg := `
@@ -110,7 +132,7 @@ with:
 ` + "```\n"
 		return map[string]any{"description": any(msg)}, nil
 	}
-	log.Printf("gopls path=%q", goplsPath)
+	s.debugf("gopls path=%q", goplsPath)
 	location := fmt.Sprintf("%s:%d:%d", filePath, cursor.Line+1, cursor.Col+1)
 	cmd := exec.Command(goplsPath, command, "-json", "-markdown", location)
 	cmd.Dir = dir
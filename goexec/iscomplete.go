@@ -0,0 +1,94 @@
+package goexec
+
+import (
+	"github.com/pkg/errors"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"os"
+	"strings"
+)
+
+// Status values returned by IsComplete, as defined by Jupyter's `is_complete_reply`.
+const (
+	CellComplete   = "complete"
+	CellIncomplete = "incomplete"
+	CellInvalid    = "invalid"
+)
+
+// IsComplete checks whether the cell (lines, except those in skipLines) is complete, and can be
+// executed. It is used by console front-ends to decide whether to keep reading input.
+//
+// The cell is wrapped as it would be for execution (see createGoFileFromLines), and only parsed:
+// it is incomplete if the parser reached the end of the code expecting more (an open block, raw
+// string or comment), and invalid for any other parsing error. For incomplete cells it also returns
+// a hint for the indentation of the next line.
+func (s *State) IsComplete(lines []string, skipLines map[int]bool) (status, indent string, err error) {
+	// Special commands continued in the next line.
+	if len(lines) > 0 && skipLines[len(lines)-1] && strings.HasSuffix(lines[len(lines)-1], "\\") {
+		return CellIncomplete, "", nil
+	}
+
+	// Wrap the cell in a temporary file outside State.TempDir, so it is not mixed with the program.
+	f, err := os.CreateTemp("", "gonb_is_complete_*.go")
+	if err != nil {
+		return "", "", errors.Wrapf(err, "creating temporary file to check if cell is complete")
+	}
+	filePath := f.Name()
+	_ = f.Close()
+	defer func() { _ = os.Remove(filePath) }()
+	if _, err = s.createGoFileFromLines(filePath, lines, skipLines, NoCursor); err != nil {
+		return "", "", errors.WithMessagef(err, "in goexec.IsComplete()")
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "reading %q", filePath)
+	}
+
+	_, parseErr := parser.ParseFile(token.NewFileSet(), filePath, content, parser.AllErrors)
+	if parseErr == nil {
+		return CellComplete, "", nil
+	}
+	var errList scanner.ErrorList
+	if !errors.As(parseErr, &errList) {
+		return CellInvalid, "", nil
+	}
+	for _, e := range errList {
+		if strings.Contains(e.Msg, "found 'EOF'") || strings.HasSuffix(e.Msg, "literal not terminated") ||
+			e.Msg == "comment not terminated" {
+			return CellIncomplete, strings.Repeat("\t", openBlocksDepth(lines, skipLines)), nil
+		}
+	}
+	return CellInvalid, "", nil
+}
+
+// openBlocksDepth returns the number of blocks (braces, parenthesis and brackets) left open in the
+// Go lines of the cell.
+func openBlocksDepth(lines []string, skipLines map[int]bool) int {
+	var goLines []string
+	for ii, line := range lines {
+		if !skipLines[ii] {
+			goLines = append(goLines, line)
+		}
+	}
+	src := []byte(strings.Join(goLines, "\n"))
+	fileSet := token.NewFileSet()
+	file := fileSet.AddFile("", fileSet.Base(), len(src))
+	var sc scanner.Scanner
+	sc.Init(file, src, nil, 0)
+	depth := 0
+	for {
+		_, tok, _ := sc.Scan()
+		switch tok {
+		case token.EOF:
+			if depth < 0 {
+				return 0
+			}
+			return depth
+		case token.LBRACE, token.LPAREN, token.LBRACK:
+			depth++
+		case token.RBRACE, token.RPAREN, token.RBRACK:
+			depth--
+		}
+	}
+}
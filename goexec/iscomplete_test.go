@@ -0,0 +1,32 @@
+package goexec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsComplete(t *testing.T) {
+	s := &State{TempDir: t.TempDir()}
+	for _, tc := range []struct {
+		code, status, indent string
+		skipLines            map[int]bool
+	}{
+		{code: "func f() int {\n\treturn 1\n}", status: CellComplete},
+		{code: "%%\nfmt.Println(1)", status: CellComplete},
+		{code: "func f() int {", status: CellIncomplete, indent: "\t"},
+		{code: "%%\nfor {\n\tif true {", status: CellIncomplete, indent: "\t\t"},
+		{code: "var x = `abc", status: CellIncomplete},
+		{code: "/* comment", status: CellIncomplete},
+		{code: "func f() int }", status: CellInvalid},
+		{code: "%env A \\", status: CellIncomplete, skipLines: map[int]bool{0: true}},
+		{code: "%env A b", status: CellComplete, skipLines: map[int]bool{0: true}},
+	} {
+		status, indent, err := s.IsComplete(strings.Split(tc.code, "\n"), tc.skipLines)
+		require.NoError(t, err)
+		assert.Equal(t, tc.status, status, "code: %q", tc.code)
+		assert.Equal(t, tc.indent, indent, "code: %q", tc.code)
+	}
+}
@@ -0,0 +1,130 @@
+package goexec
+
+import (
+	"fmt"
+	"go/token"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements `%ldvar`: package-level string variables whose values are stamped into the
+// program at build time, with the linker flag `-X main.<name>=<value>` -- the idiomatic Go way to
+// embed build information (e.g.: a version), without reading the environment at runtime.
+
+// SetLinkerVar sets the value stamped into the package-level variable name at build time. References
+// to environment variables in the value (`$VAR` or `${VAR}`, e.g.: set with `%env`) are expanded at
+// each build.
+//
+// If the variable is not declared yet, `var <name> string` is declared. Otherwise it must be a string
+// variable that the linker can set: declared without initializer, or initialized with a string
+// literal (overridden by the value).
+func (s *State) SetLinkerVar(name, value string) error {
+	if !token.IsIdentifier(name) || name == "_" {
+		return errors.Errorf("invalid variable name %q", name)
+	}
+	if !isValidLinkerValue(value) {
+		return errors.Errorf("value of %q can't have new lines, or both single and double quotes", name)
+	}
+	if v, found := s.Decls.Variables[name]; found {
+		if err := checkLinkerVar(v); err != nil {
+			return err
+		}
+	} else if kind, found := s.IsDefined(name); found {
+		return errors.Errorf("%q is already declared as a %s, it can't be set by the linker", name, kind)
+	} else {
+		s.Decls.Variables[name] = &Variable{Cursor: NoCursor, Key: name, Name: name, TypeDefinition: "string"}
+	}
+	if s.LinkerVars == nil {
+		s.LinkerVars = make(map[string]string)
+	}
+	s.LinkerVars[name] = value
+	return nil
+}
+
+// isValidLinkerValue returns whether value can be passed in a `-X` flag of `-ldflags`: it can be
+// quoted, but not with new lines, nor with both single and double quotes.
+func isValidLinkerValue(value string) bool {
+	return !strings.ContainsAny(value, "\n\r") && !(strings.ContainsRune(value, '\'') && strings.ContainsRune(value, '"'))
+}
+
+// checkLinkerVar returns an error if the variable v can't be set by the linker (`-X`), which only
+// sets string variables that are not initialized, or initialized with a constant.
+func checkLinkerVar(v *Variable) error {
+	_, err := strconv.Unquote(v.ValueDefinition)
+	isStringLiteral := err == nil && v.ValueDefinition != "" && v.ValueDefinition[0] != '\''
+	switch {
+	case v.TypeDefinition != "" && v.TypeDefinition != "string":
+		return errors.Errorf("variable %q is of type %s, only string variables can be set by the linker",
+			v.Name, v.TypeDefinition)
+	case v.TypeDefinition == "" && !isStringLiteral:
+		return errors.Errorf("variable %q is not a string, only string variables can be set by the linker", v.Name)
+	case v.ValueDefinition != "" && !isStringLiteral:
+		return errors.Errorf("variable %q is initialized with %q, the linker can only set string variables "+
+			"not initialized or initialized with a string literal", v.Name, v.ValueDefinition)
+	}
+	return nil
+}
+
+// ClearLinkerVars removes the values set with SetLinkerVar. The variables remain declared.
+func (s *State) ClearLinkerVars() {
+	s.LinkerVars = nil
+}
+
+// LinkerVarsKeys returns the names of the variables set with SetLinkerVar, sorted.
+func (s *State) LinkerVarsKeys() []string {
+	keys := appendKeys(nil, s.LinkerVars)
+	sort.Strings(keys)
+	return keys
+}
+
+// goBuildFlags returns the flags passed to `go build`: State.BuildFlags, with the `-X` flags of
+// State.LinkerVars added to the `-ldflags` flag -- a repeated `-ldflags` overrides the previous one.
+//
+// It returns an error if a value, once the environment variables are expanded, can't be passed to
+// the linker (see SetLinkerVar).
+func (s *State) goBuildFlags() ([]string, error) {
+	if len(s.LinkerVars) == 0 {
+		return s.BuildFlags, nil
+	}
+	var xFlags []string
+	for _, name := range s.LinkerVarsKeys() {
+		value := os.ExpandEnv(s.LinkerVars[name])
+		if !isValidLinkerValue(value) {
+			return nil, errors.Errorf("`%%ldvar` value of %q is %q once the environment variables in %q are expanded: "+
+				"it can't have new lines, or both single and double quotes", name, value, s.LinkerVars[name])
+		}
+		assignment := fmt.Sprintf("main.%s=%s", name, value)
+		if strings.ContainsAny(assignment, " \t'\"") {
+			// The `-ldflags` value is split in fields, which can be quoted.
+			quote := "'"
+			if strings.Contains(assignment, quote) {
+				quote = `"`
+			}
+			assignment = quote + assignment + quote
+		}
+		xFlag := "-X " + assignment
+		xFlags = append(xFlags, xFlag)
+	}
+	ldFlags := strings.Join(xFlags, " ")
+
+	flags := make([]string, 0, len(s.BuildFlags)+1)
+	for ii := 0; ii < len(s.BuildFlags); ii++ {
+		flag := s.BuildFlags[ii]
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(flag, "-"), "=")
+		if name != "-ldflags" && name != "ldflags" {
+			flags = append(flags, flag)
+			continue
+		}
+		if !hasValue && ii+1 < len(s.BuildFlags) {
+			ii++
+			value = s.BuildFlags[ii]
+		}
+		// Keep the previous linker flags, the last `-ldflags` given is the one used.
+		ldFlags = strings.TrimSpace(value + " " + strings.Join(xFlags, " "))
+	}
+	return append(flags, "-ldflags="+ldFlags), nil
+}
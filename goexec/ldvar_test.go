@@ -0,0 +1,63 @@
+package goexec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetLinkerVar(t *testing.T) {
+	s := &State{TempDir: t.TempDir(), Decls: NewDeclarations()}
+	parseCellIntoState(t, s, []string{
+		`var Version = "dev"`,
+		"var Commit string",
+		"var Count int",
+		"var Computed = strings.ToUpper(\"x\")",
+		"func Build() {}",
+	})
+	require.NoError(t, s.SetLinkerVar("Version", "1.0"))
+	require.NoError(t, s.SetLinkerVar("Commit", "abc"))
+	require.NoError(t, s.SetLinkerVar("Date", "today"))
+	assert.Equal(t, "string", s.Decls.Variables["Date"].TypeDefinition)
+	assert.Error(t, s.SetLinkerVar("Count", "1"))
+	assert.Error(t, s.SetLinkerVar("Computed", "X"))
+	assert.Error(t, s.SetLinkerVar("Build", "x"))
+	assert.Error(t, s.SetLinkerVar("not-a-name", "x"))
+	assert.Error(t, s.SetLinkerVar("Quotes", `'"`))
+	assert.Equal(t, []string{"Commit", "Date", "Version"}, s.LinkerVarsKeys())
+
+	// The -X flags are merged into the -ldflags of the build flags.
+	buildFlags := func() []string {
+		flags, err := s.goBuildFlags()
+		require.NoError(t, err)
+		return flags
+	}
+	assert.Equal(t, []string{"-ldflags=-X main.Commit=abc -X main.Date=today -X main.Version=1.0"}, buildFlags())
+	s.BuildFlags = []string{"-race", "-ldflags", "-s -w"}
+	assert.Equal(t, []string{"-race", "-ldflags=-s -w -X main.Commit=abc -X main.Date=today -X main.Version=1.0"},
+		buildFlags())
+	s.BuildFlags = []string{"--ldflags=-s"}
+	s.ClearLinkerVars()
+	assert.Equal(t, s.BuildFlags, buildFlags())
+	require.NoError(t, s.SetLinkerVar("Version", "1.0 beta"))
+	assert.Equal(t, []string{"-ldflags=-s -X 'main.Version=1.0 beta'"}, buildFlags())
+
+	// Values are validated once the environment variables are expanded.
+	t.Setenv("GONB_TEST_QUOTES", `'"`)
+	require.NoError(t, s.SetLinkerVar("Quotes", "$GONB_TEST_QUOTES"))
+	_, err := s.goBuildFlags()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"Quotes"`)
+	assert.Contains(t, err.Error(), "$GONB_TEST_QUOTES")
+}
+
+func TestLinkerVarCompiled(t *testing.T) {
+	s := newTestState(t)
+	t.Setenv("GONB_TEST_VERSION", "1.0 'beta'")
+	require.NoError(t, s.SetLinkerVar("Version", "${GONB_TEST_VERSION}"))
+	msg := newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{`import "fmt"`, "%%", `fmt.Printf("version=%s\n", Version)`}, nil))
+	assert.Contains(t, strings.Join(msg.Published(), ""), "version=1.0 'beta'\n")
+}
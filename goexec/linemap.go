@@ -0,0 +1,141 @@
+package goexec
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"strings"
+)
+
+// This file maps the lines of main.go to the lines of the cell being executed, to report where in
+// the cell the compilation errors are.
+//
+// The mapping is computed on the final main.go, after goimports (or its replacement) -- which may
+// add or remove import lines, and reformat the code --, by matching the top-level declarations of
+// main.go with the ones of the file generated from the cell (see createGoFileFromLines), whose
+// lines map directly to the cell.
+
+// lineRange is a range of lines (0-based, end inclusive) of a file.
+type lineRange struct {
+	start, end int
+}
+
+// mapCellLinesInMain sets State.cellLinesInMain, from the current main.go and the file last
+// generated from the cell. It must be called after goimports changed main.go.
+func (s *State) mapCellLinesInMain() {
+	s.cellLinesInMain = nil
+	if len(s.cellFileLines) == 0 {
+		return
+	}
+	mainContent, err := os.ReadFile(s.MainPath())
+	if err != nil {
+		s.logf("Failed to map lines of main.go to the cell: %+v", err)
+		return
+	}
+	s.cellLinesInMain = cellLinesInMain(strings.Join(s.cellFileLines, "\n"), string(mainContent), s.cellLinesInFile)
+}
+
+// cellLinesInMain returns the lines of the cell (0-based) of the lines of mainContent, given the
+// contents of the file generated from the cell (cellFileContent) and the mapping of its lines to
+// the cell (cellLinesInFile).
+//
+// Lines of declarations that are not in the cell (e.g.: from previous cells), or that couldn't be
+// matched, are not included.
+func cellLinesInMain(cellFileContent, mainContent string, cellLinesInFile map[int]int) map[int]int {
+	cellFileRanges := declLineRanges(cellFileContent)
+	mainRanges := declLineRanges(mainContent)
+	if len(cellFileRanges) == 0 || len(mainRanges) == 0 {
+		return nil
+	}
+	cellFileLines := strings.Split(cellFileContent, "\n")
+	mainLines := strings.Split(mainContent, "\n")
+	mapping := make(map[int]int)
+	for key, mainRange := range mainRanges {
+		cellFileRange, found := cellFileRanges[key]
+		if !found {
+			continue
+		}
+		for mainLine, cellFileLine := range matchLines(mainLines, mainRange, cellFileLines, cellFileRange) {
+			if cellLine, found := cellLinesInFile[cellFileLine]; found {
+				mapping[mainLine] = cellLine
+			}
+		}
+	}
+	return mapping
+}
+
+// matchLines maps the lines of the range a of linesA to the lines of the range b of linesB,
+// holding the same declaration. If both ranges have the same number of lines, they are mapped
+// one-to-one. Otherwise (e.g.: reformatted), lines are matched in order, ignoring whitespace.
+func matchLines(linesA []string, a lineRange, linesB []string, b lineRange) map[int]int {
+	mapping := make(map[int]int, a.end-a.start+1)
+	if a.end-a.start == b.end-b.start {
+		for ii := 0; ii <= a.end-a.start; ii++ {
+			mapping[a.start+ii] = b.start + ii
+		}
+		return mapping
+	}
+	next := b.start
+	for lineA := a.start; lineA <= a.end && lineA < len(linesA); lineA++ {
+		textA := withoutSpaces(linesA[lineA])
+		for lineB := next; lineB <= b.end && lineB < len(linesB); lineB++ {
+			if withoutSpaces(linesB[lineB]) == textA {
+				mapping[lineA] = lineB
+				next = lineB + 1
+				break
+			}
+		}
+	}
+	return mapping
+}
+
+// withoutSpaces returns line with all its whitespace removed.
+func withoutSpaces(line string) string {
+	return strings.Join(strings.Fields(line), "")
+}
+
+// declLineRanges returns the ranges of lines of the top-level declarations of the Go source
+// content, by a key identifying them. Imports, blank (`_`) declarations and declarations with
+// repeated keys (e.g.: `init` functions) are not included. It returns nil if content can't be parsed.
+func declLineRanges(content string) map[string]lineRange {
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, "main.go", content, parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+	ranges := make(map[string]lineRange)
+	repeated := make(map[string]bool)
+	add := func(key string, node ast.Node) {
+		if strings.HasSuffix(key, " _") {
+			return
+		}
+		if _, found := ranges[key]; found {
+			repeated[key] = true
+		}
+		ranges[key] = lineRange{fileSet.Position(node.Pos()).Line - 1, fileSet.Position(node.End()).Line - 1}
+	}
+	for _, decl := range file.Decls {
+		switch typedDecl := decl.(type) {
+		case *ast.FuncDecl:
+			key := "func " + typedDecl.Name.Name
+			if typedDecl.Recv != nil && len(typedDecl.Recv.List) > 0 {
+				key = "func " + receiverTypeName(typedDecl.Recv.List[0].Type) + "~" + typedDecl.Name.Name
+			}
+			add(key, typedDecl)
+		case *ast.GenDecl:
+			for _, spec := range typedDecl.Specs {
+				switch typedSpec := spec.(type) {
+				case *ast.TypeSpec:
+					add("type "+typedSpec.Name.Name, typedSpec)
+				case *ast.ValueSpec:
+					add(typedDecl.Tok.String()+" "+typedSpec.Names[0].Name, typedSpec)
+				}
+			}
+		}
+	}
+	for key := range repeated {
+		delete(ranges, key)
+	}
+	return ranges
+}
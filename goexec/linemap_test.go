@@ -0,0 +1,84 @@
+package goexec
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCellLinesInMain(t *testing.T) {
+	cellFile := strings.Join([]string{
+		"package main",             // 0
+		"",                         // 1
+		"func add(a, b int) int {", // 2: cell line 0
+		"	return a+b",              // 3: cell line 1
+		"}",                        // 4: cell line 2
+		"",                         // 5
+		"func main() {",            // 6
+		"	flag.Parse()",            // 7
+		"	x := add(1, 2)",          // 8: cell line 4
+		"}",                        // 9
+	}, "\n")
+	cellLinesInFile := map[int]int{2: 0, 3: 1, 4: 2, 8: 4}
+
+	// main.go after goimports: imports added, a declaration from a previous cell, and reformatted.
+	mainGo := strings.Join([]string{
+		"package main",             // 0
+		"",                         // 1
+		"import (",                 // 2
+		`	"flag"`,                  // 3
+		`	"fmt"`,                   // 4
+		")",                        // 5
+		"",                         // 6
+		"func previous() {",        // 7
+		"	fmt.Println()",           // 8
+		"}",                        // 9
+		"",                         // 10
+		"func add(a, b int) int {", // 11
+		"	return a + b",            // 12
+		"}",                        // 13
+		"",                         // 14
+		"func main() {",            // 15
+		"	flag.Parse()",            // 16
+		"	x := add(1, 2)",          // 17
+		"}",                        // 18
+	}, "\n")
+	assert.Equal(t, map[int]int{11: 0, 12: 1, 13: 2, 17: 4}, cellLinesInMain(cellFile, mainGo, cellLinesInFile))
+}
+
+func TestCompileErrorCellLine(t *testing.T) {
+	for _, skipGoImports := range []bool{true, false} {
+		if !skipGoImports {
+			if _, err := exec.LookPath("goimports"); err != nil {
+				continue
+			}
+		}
+		s := newTestState(t)
+		s.SkipGoImports = skipGoImports
+		// The cell has no imports: they are added to main.go by goimports (or by the code that
+		// replaces it), shifting the lines of the declarations.
+		msg := newTestMessage()
+		require.Error(t, s.ExecuteCell(msg, []string{
+			"func twice(x int) int {",
+			"	return 2 * x",
+			"}",
+			"",
+			"%%",
+			"fmt.Println(twice(1))",
+			"undefinedVar++",
+		}, nil))
+		require.NotNil(t, s.LastError())
+		var found bool
+		for _, d := range s.LastError().Diagnostics {
+			if strings.Contains(d.Message, "undefinedVar") {
+				found = true
+				assert.Equal(t, 7, d.CellLine, "diagnostic %+v", d)
+			}
+		}
+		assert.True(t, found, "diagnostics: %+v", s.LastError().Diagnostics)
+		assert.Contains(t, strings.Join(msg.Published(), ""), "(cell line 7)")
+	}
+}
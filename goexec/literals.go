@@ -0,0 +1,53 @@
+package goexec
+
+import "strings"
+
+// LinesInsideLiterals returns the indices of the lines of a cell that start inside a multi-line
+// raw string literal (delimited by backticks) or a block comment (`/* ... */`).
+//
+// These lines are content, not code, so they must not be interpreted as special commands (like
+// `%%` or `%main`), or changed (e.g.: indented) in any way.
+//
+// Lines starting outside a literal with `%` or `!` are special commands, and their contents are
+// not scanned.
+func LinesInsideLiterals(lines []string) map[int]bool {
+	insideLines := make(map[int]bool)
+	var inRawString, inBlockComment bool
+	for lineNum, line := range lines {
+		if inRawString || inBlockComment {
+			insideLines[lineNum] = true
+		} else if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "%") || strings.HasPrefix(trimmed, "!") {
+			continue
+		}
+	scanLine:
+		for pos := 0; pos < len(line); pos++ {
+			switch {
+			case inRawString:
+				if line[pos] == '`' {
+					inRawString = false
+				}
+			case inBlockComment:
+				if strings.HasPrefix(line[pos:], "*/") {
+					inBlockComment = false
+					pos++
+				}
+			case line[pos] == '`':
+				inRawString = true
+			case strings.HasPrefix(line[pos:], "/*"):
+				inBlockComment = true
+				pos++
+			case strings.HasPrefix(line[pos:], "//"):
+				break scanLine
+			case line[pos] == '"' || line[pos] == '\'':
+				// Interpreted strings and runes end in the same line: skip to the closing quote.
+				quote := line[pos]
+				for pos++; pos < len(line) && line[pos] != quote; pos++ {
+					if line[pos] == '\\' {
+						pos++
+					}
+				}
+			}
+		}
+	}
+	return insideLines
+}
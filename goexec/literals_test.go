@@ -0,0 +1,44 @@
+package goexec
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinesInsideLiterals(t *testing.T) {
+	lines := []string{
+		"var a = `first",                    // 0
+		"%%",                                // 1: inside raw string.
+		"%main",                             // 2: inside raw string.
+		"last`",                             // 3: inside raw string.
+		`var b = "\"` + "`" + `" // ` + "`", // 4: backticks in a string and in a comment.
+		"/* comment",                        // 5
+		"%%",                                // 6: inside comment.
+		"*/ var c = `x`",                    // 7: inside comment.
+		"%env X `",                          // 8: special command, not scanned.
+		"%%",                                // 9
+		"fmt.Println(`",                     // 10
+		"  `)",                              // 11: inside raw string.
+		"r := '`'",                          // 12: rune.
+		"!echo",                             // 13
+	}
+	assert.Equal(t, map[int]bool{1: true, 2: true, 3: true, 6: true, 7: true, 11: true}, LinesInsideLiterals(lines))
+}
+
+func TestCreateGoFileFromLinesWithRawStrings(t *testing.T) {
+	s := &State{TempDir: t.TempDir()}
+	lines := []string{
+		"%%",
+		"fmt.Println(`",
+		"%%",
+		"  text  ",
+		"`)",
+	}
+	_, err := s.createGoFileFromLines(s.MainPath(), lines, nil, NoCursor)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(s.MainPath())
+	assert.NoError(t, err)
+	assert.Equal(t, "package main\n\n\nfunc main() {\n\tflag.Parse()\n\tfmt.Println(`\n%%\n  text  \n`)\n}\n", string(content))
+}
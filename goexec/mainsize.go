@@ -0,0 +1,63 @@
+package goexec
+
+import (
+	"fmt"
+
+	"github.com/janpfeifer/gonb/kernel"
+)
+
+// This file implements the size warning: as declarations accumulate over a long session, the
+// generated main.go grows and takes longer to compile. Once it crosses the thresholds of
+// State.SizeWarning, a suggestion to clean up is displayed -- once, until it goes back under them.
+
+// SizeWarning configures the size of the generated program above which a suggestion to `%reset`
+// or split the notebook is displayed. Zero means no threshold.
+type SizeWarning struct {
+	// Lines of the generated main.go.
+	Lines int
+
+	// Decls is the number of declarations (imports, types, constants, variables and functions).
+	Decls int
+}
+
+// DefaultSizeWarning used by the kernel.
+var DefaultSizeWarning = SizeWarning{Lines: 5_000, Decls: 500}
+
+// String implements fmt.Stringer.
+func (w SizeWarning) String() string {
+	if w.Lines <= 0 && w.Decls <= 0 {
+		return "no size warning"
+	}
+	return fmt.Sprintf("lines=%d decls=%d", w.Lines, w.Decls)
+}
+
+// exceeded returns whether the program size crosses any of the thresholds.
+func (w SizeWarning) exceeded(lines, decls int) bool {
+	return (w.Lines > 0 && lines > w.Lines) || (w.Decls > 0 && decls > w.Decls)
+}
+
+// numDecls returns the number of declarations in d.
+func (d *Declarations) numDecls() int {
+	return len(d.Imports) + len(d.Types) + len(d.Constants) + len(d.Variables) + len(d.Functions)
+}
+
+// checkMainSize displays a suggestion to clean up the notebook when the last main.go generated
+// (see createMainFromDecls) crosses the thresholds of State.SizeWarning for the first time.
+func (s *State) checkMainSize(msg kernel.Message) {
+	if !s.SizeWarning.exceeded(s.mainLines, s.mainDecls) {
+		s.sizeWarned = false
+		return
+	}
+	if s.sizeWarned {
+		return
+	}
+	s.sizeWarned = true
+	info := fmt.Sprintf(`<div style="background-color:#ddf4ff;border-left:4px solid #54aeff;padding:4px 8px">`+
+		`&#8505; The generated program has %d lines and %d declarations, which slows down every compilation. `+
+		`Consider <code>%%reset</code> to remove the declarations no longer needed, or splitting the notebook. `+
+		`(Thresholds: %s, change them with <code>%%size_warning</code>.)</div>`,
+		s.mainLines, s.mainDecls, s.SizeWarning)
+	if err := kernel.PublishDisplayDataWithHTML(msg, info); err != nil {
+		s.logf("Failed to display size warning: %+v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package goexec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizeWarning(t *testing.T) {
+	s := newTestState(t)
+	s.SizeWarning = SizeWarning{Decls: 2}
+	const warning = "The generated program has"
+
+	execute := func(lines ...string) string {
+		msg := newTestMessage()
+		require.NoError(t, s.ExecuteCell(msg, lines, nil))
+		return strings.Join(msg.Published(), "")
+	}
+	assert.NotContains(t, execute("const a = 1", "const b = 2"), warning)
+	published := execute("const c = 3")
+	assert.Contains(t, published, warning)
+	assert.Contains(t, published, "3 declarations")
+
+	// Displayed only once, until it goes back under the threshold.
+	assert.NotContains(t, execute("const d = 4"), warning)
+	s.Reset()
+	assert.NotContains(t, execute("const a = 1"), warning)
+	assert.Contains(t, execute("const b, c = 2, 3"), warning)
+
+	// Lines threshold.
+	s.Reset()
+	s.SizeWarning = SizeWarning{Lines: 10}
+	assert.NotContains(t, execute("func f() {", "}"), warning)
+	assert.Contains(t, execute("func g() {", "\t_ = 1", "\t_ = 2", "\t_ = 3", "\t_ = 4", "}"), warning)
+}
@@ -0,0 +1,141 @@
+package goexec
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+)
+
+// This file implements `%%memstats`: the program's main function is wrapped with code that reads
+// the runtime.MemStats before and after it, and saves them to a file, from which a small table with
+// the allocations and garbage collection during the execution is displayed. It is a lightweight
+// alternative to `%%pprof mem`.
+
+// memStatsWrapper is the main function calling the cell's main (renamed gonbMemStatsMain), formatted
+// with the path of the file where the stats are saved: one line with the values of memStatsFields
+// before the execution, and one after. Imports are added by goimports, or see memStatsImports.
+const memStatsWrapper = `
+
+func main() {
+	var gonbMemStats [2]runtime.MemStats
+	runtime.ReadMemStats(&gonbMemStats[0])
+	gonbMemStatsMain()
+	runtime.ReadMemStats(&gonbMemStats[1])
+	var gonbMemStatsReport []byte
+	for _, gonbStats := range gonbMemStats {
+		for _, gonbValue := range []uint64{gonbStats.HeapAlloc, gonbStats.TotalAlloc, gonbStats.Mallocs, gonbStats.Frees, uint64(gonbStats.NumGC), gonbStats.PauseTotalNs} {
+			gonbMemStatsReport = strconv.AppendUint(gonbMemStatsReport, gonbValue, 10)
+			gonbMemStatsReport = append(gonbMemStatsReport, ' ')
+		}
+		gonbMemStatsReport = append(gonbMemStatsReport, '\n')
+	}
+	if err := os.WriteFile(%q, gonbMemStatsReport, 0600); err != nil {
+		panic(err)
+	}
+}`
+
+// memStatsImports are the import paths used by memStatsWrapper.
+var memStatsImports = []string{"os", "runtime", "strconv"}
+
+// memStatsField describes one of the values saved by memStatsWrapper, and how it is displayed.
+type memStatsField struct {
+	Name, Description string
+	Format            func(value int64) string
+}
+
+// memStatsFields are the values saved by memStatsWrapper, in order.
+var memStatsFields = []memStatsField{
+	{"HeapAlloc", "Heap in use", formatMemStatsBytes},
+	{"TotalAlloc", "Allocated", formatMemStatsBytes},
+	{"Mallocs", "Allocations", formatMemStatsCount},
+	{"Frees", "Frees", formatMemStatsCount},
+	{"NumGC", "GC cycles", formatMemStatsCount},
+	{"PauseTotalNs", "GC pauses", func(value int64) string { return time.Duration(value).String() }},
+}
+
+func formatMemStatsBytes(value int64) string {
+	if value < 0 {
+		return "-" + kernel.FormatMemorySize(-value)
+	}
+	return kernel.FormatMemorySize(value)
+}
+
+func formatMemStatsCount(value int64) string {
+	return strconv.FormatInt(value, 10)
+}
+
+// MemStatsPath returns the path of the file where the memory stats collected by `%%memstats` are saved.
+func (s *State) MemStatsPath() string {
+	return filepath.Join(s.TempDir, "memstats.txt")
+}
+
+// memStatsMain returns a new main function that saves the runtime.MemStats before and after calling
+// the given main function (renamed).
+//
+// Notice the stats are not saved if the program exits with os.Exit (or log.Fatal, etc.).
+func (s *State) memStatsMain(mainDecl *Function) (*Function, error) {
+	if !reMainFuncHeader.MatchString(mainDecl.Definition) {
+		return nil, errors.Errorf("can't collect memory stats of main function, unexpected definition: %q", mainDecl.Definition)
+	}
+	_ = os.Remove(s.MemStatsPath())
+	definition := reMainFuncHeader.ReplaceAllString(mainDecl.Definition, "func gonbMemStatsMain()") +
+		fmt.Sprintf(memStatsWrapper, s.MemStatsPath())
+	generatedImports := append(append([]string(nil), memStatsImports...), mainDecl.generatedImports...)
+	return &Function{Key: mainDecl.Key, Name: mainDecl.Name, Definition: definition, generatedImports: generatedImports}, nil
+}
+
+// readMemStats reads the values saved by memStatsWrapper: before and after the execution.
+func readMemStats(content string) (before, after []int64, err error) {
+	lines := strings.Split(strings.TrimSpace(content), "\n")
+	if len(lines) != 2 {
+		return nil, nil, errors.Errorf("invalid memory stats, expected 2 lines, got %q", content)
+	}
+	values := make([][]int64, 2)
+	for ii, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != len(memStatsFields) {
+			return nil, nil, errors.Errorf("invalid memory stats, expected %d values, got %q", len(memStatsFields), line)
+		}
+		for _, field := range fields {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "invalid memory stats value %q", field)
+			}
+			values[ii] = append(values[ii], int64(value))
+		}
+	}
+	return values[0], values[1], nil
+}
+
+// memStatsTable returns the HTML table with the memory stats before, after and the difference.
+func memStatsTable(before, after []int64) string {
+	var sb strings.Builder
+	sb.WriteString("<table>\n<tr><th>Memory stats</th><th>Before</th><th>After</th><th>Delta</th></tr>\n")
+	for ii, field := range memStatsFields {
+		fmt.Fprintf(&sb, `<tr><td title="runtime.MemStats.%s">%s</td><td>%s</td><td>%s</td><td><b>%s</b></td></tr>`+"\n",
+			field.Name, html.EscapeString(field.Description), field.Format(before[ii]), field.Format(after[ii]),
+			field.Format(after[ii]-before[ii]))
+	}
+	sb.WriteString("</table>")
+	return sb.String()
+}
+
+// reportMemStats displays the memory stats saved by the program of the cell.
+func (s *State) reportMemStats(msg kernel.Message) error {
+	content, err := os.ReadFile(s.MemStatsPath())
+	if err != nil {
+		return errors.Wrapf(err, "memory stats not saved, did the program exit with os.Exit()?")
+	}
+	before, after, err := readMemStats(string(content))
+	if err != nil {
+		return err
+	}
+	return kernel.PublishDisplayDataWithHTML(msg, memStatsTable(before, after))
+}
@@ -0,0 +1,36 @@
+package goexec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemStatsTable(t *testing.T) {
+	before, after, err := readMemStats("1024 2048 10 5 1 1000 \n4096 1050624 110 50 3 3500 \n")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1024, 2048, 10, 5, 1, 1000}, before)
+	table := memStatsTable(before, after)
+	assert.Contains(t, table, ">GC cycles</td><td>1</td><td>3</td><td><b>2</b></td>")
+	assert.Contains(t, table, ">Allocations</td><td>10</td><td>110</td><td><b>100</b></td>")
+	assert.Contains(t, table, "<td><b>2.5µs</b></td>")
+
+	_, _, err = readMemStats("1 2 3\n")
+	assert.Error(t, err)
+}
+
+func TestMemStats(t *testing.T) {
+	s := newTestState(t)
+	msg := newTestMessage()
+	s.Cell.MemStats = true
+	require.NoError(t, s.ExecuteCell(msg, []string{
+		"var sink [][]byte",
+		"%%",
+		"for ii := 0; ii < 100; ii++ { sink = append(sink, make([]byte, 1<<10)) }",
+	}, nil))
+	published := strings.Join(msg.Published(), "\n")
+	assert.Contains(t, published, ">Allocations</td>")
+	assert.Contains(t, published, ">GC cycles</td>")
+}
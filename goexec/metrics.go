@@ -0,0 +1,71 @@
+package goexec
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics counts the work done by the State, to monitor deployments of the kernel (e.g.: in a
+// JupyterHub). It is safe for concurrent use, and its zero value is ready to use.
+//
+// It can be exported in the Prometheus text format with WritePrometheus, see the `--metrics` flag.
+type Metrics struct {
+	// CellsExecuted counts the calls to State.ExecuteCell.
+	CellsExecuted atomic.Int64
+
+	// Compilations and CompileFailures count the programs compiled, and the ones that failed.
+	// CompileTime is the total time spent compiling them.
+	Compilations, CompileFailures atomic.Int64
+	CompileTime                   atomic.Int64
+
+	// GoImportsRuns counts the runs of goimports (or of its replacement, if disabled), and
+	// GoImportsTime is the total time spent on them, including `go get`.
+	GoImportsRuns atomic.Int64
+	GoImportsTime atomic.Int64
+
+	// GoGetRuns counts the invocations of `go get`, including retries.
+	GoGetRuns atomic.Int64
+
+	// Executions counts the programs executed, and ExecuteTime is the total time spent executing
+	// them (for programs executed in the background, only the time to start them).
+	Executions  atomic.Int64
+	ExecuteTime atomic.Int64
+}
+
+// observe adds the time elapsed since start to the total time, and increments the counter.
+func observe(counter, total *atomic.Int64, start time.Time) {
+	counter.Add(1)
+	total.Add(int64(time.Since(start)))
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format.
+// Total times are exported as summaries (count and sum, in seconds), from which averages are derived.
+//
+// If kernelID is not empty, the samples are labeled with it (`kernel_id`), to tell apart the
+// metrics of the kernels running in the same host.
+func (m *Metrics) WritePrometheus(w io.Writer, kernelID string) error {
+	var labels string
+	if kernelID != "" {
+		labels = "{kernel_id=" + strconv.Quote(kernelID) + "}"
+	}
+	var buf strings.Builder
+	counter := func(name, help string, value *atomic.Int64) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s counter\n%s%s %d\n", name, help, name, name, labels, value.Load())
+	}
+	summary := func(name, help string, count, total *atomic.Int64) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s summary\n%s_sum%s %g\n%s_count%s %d\n",
+			name, help, name, name, labels, time.Duration(total.Load()).Seconds(), name, labels, count.Load())
+	}
+	counter("gonb_cells_executed_total", "Number of cells executed.", &m.CellsExecuted)
+	summary("gonb_compile_seconds", "Time spent compiling programs.", &m.Compilations, &m.CompileTime)
+	counter("gonb_compile_failures_total", "Number of programs that failed to compile.", &m.CompileFailures)
+	summary("gonb_goimports_seconds", "Time spent running goimports, including go get.", &m.GoImportsRuns, &m.GoImportsTime)
+	counter("gonb_go_get_total", "Number of invocations of go get.", &m.GoGetRuns)
+	summary("gonb_execute_seconds", "Time spent executing programs.", &m.Executions, &m.ExecuteTime)
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
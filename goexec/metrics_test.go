@@ -0,0 +1,37 @@
+package goexec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	s := newTestState(t)
+
+	require.NoError(t, s.ExecuteCell(newTestMessage(), []string{"%%", "println(1)"}, nil))
+	require.Error(t, s.ExecuteCell(newTestMessage(), []string{"%%", "undefinedVar++"}, nil))
+	assert.Equal(t, int64(2), s.Metrics.CellsExecuted.Load())
+	assert.Equal(t, int64(2), s.Metrics.Compilations.Load())
+	assert.Equal(t, int64(1), s.Metrics.CompileFailures.Load())
+	assert.Equal(t, int64(2), s.Metrics.GoImportsRuns.Load())
+	assert.Equal(t, int64(1), s.Metrics.Executions.Load())
+	assert.True(t, s.Metrics.CompileTime.Load() > 0)
+
+	var buf strings.Builder
+	require.NoError(t, s.Metrics.WritePrometheus(&buf, ""))
+	exported := buf.String()
+	assert.Contains(t, exported, "# TYPE gonb_cells_executed_total counter\ngonb_cells_executed_total 2\n")
+	assert.Contains(t, exported, "gonb_compile_failures_total 1\n")
+	assert.Contains(t, exported, "# TYPE gonb_compile_seconds summary\n")
+	assert.Contains(t, exported, "gonb_compile_seconds_count 2\n")
+	assert.Contains(t, exported, "gonb_go_get_total 0\n")
+
+	buf.Reset()
+	require.NoError(t, s.Metrics.WritePrometheus(&buf, "abc123"))
+	exported = buf.String()
+	assert.Contains(t, exported, "gonb_cells_executed_total{kernel_id=\"abc123\"} 2\n")
+	assert.Contains(t, exported, "gonb_compile_seconds_count{kernel_id=\"abc123\"} 2\n")
+}
@@ -0,0 +1,68 @@
+package goexec
+
+import (
+	"github.com/pkg/errors"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// This file implements the imports handling when goimports is disabled (`%goimports off`, see
+// State.SkipGoImports): imports are used as declared, except for the packages used by the code
+// gonb generates in the main function (see Function.generatedImports), which are added if missing.
+
+// addGeneratedCodeImports adds to main.go the imports used by the code gonb generated in the main
+// function (State.mainGeneratedImports) that are not imported. The user's code is never changed:
+// packages it uses without importing are reported by the compiler.
+func (s *State) addGeneratedCodeImports() error {
+	if len(s.mainGeneratedImports) == 0 {
+		return nil
+	}
+	fileSet := token.NewFileSet()
+	f, err := parser.ParseFile(fileSet, s.MainPath(), nil, parser.ImportsOnly)
+	if err != nil {
+		return errors.Wrapf(err, "parsing %q", s.MainPath())
+	}
+	imported := make(map[string]bool)
+	for _, spec := range f.Imports {
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		name := path.Base(importPath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		imported[importPath] = true
+		imported[name] = true
+	}
+
+	missing := make(map[string]bool)
+	for _, importPath := range s.mainGeneratedImports {
+		// Packages already imported, or whose name is taken by another import, are left as is.
+		if !imported[importPath] && !imported[path.Base(importPath)] {
+			missing[importPath] = true
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	importLines := make([]string, 0, len(missing))
+	for importPath := range missing {
+		importLines = append(importLines, "import "+strconv.Quote(importPath))
+	}
+	sort.Strings(importLines)
+	content, err := os.ReadFile(s.MainPath())
+	if err != nil {
+		return errors.Wrapf(err, "reading %q", s.MainPath())
+	}
+	lines := strings.Split(string(content), "\n")
+	packageLine := fileSet.Position(f.Name.End()).Line // 1-based, so it's the index of the next line.
+	lines = append(lines[:packageLine], append(importLines, lines[packageLine:]...)...)
+	if err = os.WriteFile(s.MainPath(), []byte(strings.Join(lines, "\n")), 0600); err != nil {
+		return errors.Wrapf(err, "writing %q", s.MainPath())
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package goexec
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddGeneratedCodeImports(t *testing.T) {
+	s := &State{TempDir: t.TempDir(), SkipGoImports: true}
+	mainGo := strings.Join([]string{
+		`package main`,
+		``,
+		`import pprof "strings"`,
+		`import "os"`,
+		``,
+		`func gonbProfiledMain() {`,
+		`	fmt.Println(pprof.ToUpper("x"))`,
+		`	runtime.GC()`,
+		`}`,
+		``,
+		`func main() {`,
+		`	flag.Parse()`,
+		`	gonbProfiledMain()`,
+		`}`,
+	}, "\n")
+	require.NoError(t, os.WriteFile(s.MainPath(), []byte(mainGo), 0600))
+
+	// Only the imports used by the generated code are added: "runtime" used by the cell is not, and
+	// "os" is already imported and "runtime/pprof" conflicts with the "pprof" import.
+	s.mainGeneratedImports = []string{"flag", "os", "runtime/pprof"}
+	require.NoError(t, s.addGeneratedCodeImports())
+	content, err := os.ReadFile(s.MainPath())
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "package main\nimport \"flag\"\n\nimport pprof \"strings\"\n"),
+		"got:\n%s", content)
+
+	// Without generated code nothing is changed.
+	require.NoError(t, os.WriteFile(s.MainPath(), []byte(mainGo), 0600))
+	s.mainGeneratedImports = nil
+	require.NoError(t, s.addGeneratedCodeImports())
+	content, err = os.ReadFile(s.MainPath())
+	require.NoError(t, err)
+	assert.Equal(t, mainGo, string(content))
+}
+
+func TestMainGeneratedImports(t *testing.T) {
+	s := &State{TempDir: t.TempDir(), Decls: NewDeclarations(), StubMainBody: DefaultStubMainBody}
+	assert.Equal(t, []string{"flag"}, s.stubMain().generatedImports)
+	s.StubMainBody = `runtime.GC()`
+	assert.Empty(t, s.stubMain().generatedImports)
+
+	assert.True(t, hasMainMagic([]string{"var x = 1", "%%", "runtime.GC()"}))
+	assert.False(t, hasMainMagic([]string{"var x = `", "%%", "`"}))
+
+	s.Cell.Profile = "cpu"
+	mainDecl := &Function{Key: "main", Name: "main", Definition: "func main() {\n}", generatedImports: []string{"flag"}}
+	profiled, err := s.profiledMain(mainDecl)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"os", "runtime/pprof", "flag"}, profiled.generatedImports)
+	withStats, err := s.memStatsMain(&Function{Key: "main", Name: "main", Definition: "func main() {\n}"})
+	require.NoError(t, err)
+	assert.Equal(t, memStatsImports, withStats.generatedImports)
+}
@@ -0,0 +1,192 @@
+package goexec
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+	"go/token"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// This file implements NewState and its options, to create a State -- e.g.: to embed the
+// execution engine in other programs, or in tests.
+
+// Option configures the State created by NewState. It returns an error if the configuration is
+// invalid.
+type Option func(s *State) error
+
+// WithUniqueID sets the unique id of the State, used to name its package ("gonb_<uniqueID>") and
+// its temporary directory. If not set, a random one is used.
+func WithUniqueID(uniqueID string) Option {
+	return func(s *State) error {
+		if uniqueID == "" {
+			return errors.New("goexec.WithUniqueID() requires a non-empty id")
+		}
+		s.UniqueID = uniqueID
+		return nil
+	}
+}
+
+// WithPackage sets the name of the module of the generated program, and of the compiled binary.
+// It defaults to "gonb_<uniqueID>".
+func WithPackage(name string) Option {
+	return func(s *State) error {
+		if !token.IsIdentifier(name) {
+			return errors.Errorf("goexec.WithPackage(%q): package name must be a valid identifier", name)
+		}
+		s.Package = name
+		return nil
+	}
+}
+
+// WithTempDir sets the directory where the program is generated and compiled. It is created if it
+// doesn't exist. It defaults to a new directory named after the package in os.TempDir(), which must
+// not yet exist.
+func WithTempDir(dir string) Option {
+	return func(s *State) error {
+		if dir == "" {
+			return errors.New("goexec.WithTempDir() requires a non-empty directory")
+		}
+		s.TempDir = dir
+		return nil
+	}
+}
+
+// WithAutoGet sets whether to run `go get` to fetch missing modules before compiling. Default is true.
+func WithAutoGet(autoGet bool) Option {
+	return func(s *State) error {
+		s.AutoGet = autoGet
+		return nil
+	}
+}
+
+// WithGoBinary sets the `go` binary to use. It defaults to the one found in PATH. Contrary to the
+// default, if the given binary fails, NewState returns an error.
+func WithGoBinary(goBinary string) Option {
+	return func(s *State) error {
+		if goBinary == "" {
+			return errors.New("goexec.WithGoBinary() requires a non-empty path")
+		}
+		s.GoBinary = goBinary
+		return nil
+	}
+}
+
+// WithBuildFlags sets extra flags passed to `go build` (e.g.: "-race" or "-tags=debug").
+func WithBuildFlags(flags ...string) Option {
+	return func(s *State) error {
+		s.BuildFlags = append([]string(nil), flags...)
+		return nil
+	}
+}
+
+// WithSessionsDir sets the directory where sessions are saved (see `%session`). It defaults to
+// "gonb/sessions" in the user's configuration directory (see os.UserConfigDir).
+func WithSessionsDir(dir string) Option {
+	return func(s *State) error {
+		if dir == "" {
+			return errors.New("goexec.WithSessionsDir() requires a non-empty directory")
+		}
+		s.SessionsDir = dir
+		return nil
+	}
+}
+
+// WithLogger sets the logger used by the State. It defaults to the standard logger (log.Default()).
+func WithLogger(logger *log.Logger) Option {
+	return func(s *State) error {
+		if logger == nil {
+			return errors.New("goexec.WithLogger() requires a non-nil logger")
+		}
+		s.logger = logger
+		return nil
+	}
+}
+
+// NewState returns an empty State configured by the given options, with its directory (TempDir)
+// created and its Go module initialized, ready to execute cells.
+//
+// If the go toolchain is not available (and WithGoBinary was not given), the State is still
+// returned, and the problem is reported by GoToolchainError.
+func NewState(opts ...Option) (*State, error) {
+	s := &State{
+		Decls:          NewDeclarations(),
+		AutoGet:        true,
+		GoGetRetries:   DefaultGoGetRetries,
+		OutputLimits:   kernel.DefaultOutputLimits,
+		SizeWarning:    DefaultSizeWarning,
+		StubMainBody:   DefaultStubMainBody,
+		CleanupTimeout: DefaultCleanupTimeout,
+		FreezeTimeout:  DefaultFreezeTimeout,
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	if s.UniqueID == "" {
+		s.UniqueID = fmt.Sprintf("%08x", rand.Uint32())
+	}
+	if s.Package == "" {
+		s.Package = "gonb_" + s.UniqueID
+	}
+
+	var err error
+	if s.Parameters, err = parametersFromEnv(); err != nil {
+		s.logf("Ignoring parameters: %+v", err)
+	}
+
+	// Create directory.
+	if s.TempDir == "" {
+		s.TempDir = filepath.Join(os.TempDir(), s.Package)
+		err = os.Mkdir(s.TempDir, 0700)
+	} else {
+		err = os.MkdirAll(s.TempDir, 0700)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create temporary directory %q", s.TempDir)
+	}
+
+	// Check the go toolchain, and if available initialize the module. If it is not available,
+	// the State is still created (e.g.: the kernel still starts), and reports the problem to the user.
+	explicitGoBinary := s.GoBinary != ""
+	if !explicitGoBinary {
+		s.GoBinary, _ = exec.LookPath("go")
+	}
+	if err = s.initGoToolchain(); err != nil {
+		if explicitGoBinary {
+			return nil, err
+		}
+		s.logf("%v", err)
+	}
+
+	s.logf("Initialized goexec.State in %s", s.TempDir)
+	return s, nil
+}
+
+// logf logs with the State's logger (see WithLogger), at kernel.LogLevelInfo.
+func (s *State) logf(format string, args ...any) {
+	s.logAtLevel(kernel.LogLevelInfo, format, args...)
+}
+
+// debugf logs with the State's logger, only if the kernel's log level is kernel.LogLevelDebug.
+func (s *State) debugf(format string, args ...any) {
+	s.logAtLevel(kernel.LogLevelDebug, format, args...)
+}
+
+// logAtLevel logs with the State's logger, formatted by kernel.LogRecord, if level is enabled (see
+// kernel.SetLogLevel).
+func (s *State) logAtLevel(level kernel.LogLevel, format string, args ...any) {
+	if level > kernel.GetLogLevel() {
+		return
+	}
+	logger := s.logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	_ = logger.Output(3, kernel.LogRecord(level, fmt.Sprintf(format, args...)))
+}
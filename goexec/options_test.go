@@ -0,0 +1,40 @@
+package goexec
+
+import (
+	"bytes"
+	"log"
+	"path/filepath"
+	"testing"
+
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewState(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	dir := filepath.Join(t.TempDir(), "module")
+	s, err := NewState(WithTempDir(dir), WithPackage("mypkg"), WithAutoGet(false),
+		WithBuildFlags("-tags=debug"), WithLogger(log.New(buf, "", 0)))
+	require.NoError(t, err)
+	assert.Equal(t, dir, s.TempDir)
+	assert.DirExists(t, dir)
+	assert.Equal(t, "mypkg", s.Package)
+	assert.NotEmpty(t, s.UniqueID)
+	assert.False(t, s.AutoGet)
+	assert.Equal(t, []string{"-tags=debug"}, s.BuildFlags)
+	assert.Contains(t, buf.String(), "Initialized goexec.State in "+dir)
+
+	// Debug messages also go to the State's logger, if enabled.
+	s.debugf("not logged")
+	assert.NotContains(t, buf.String(), "not logged")
+	defer kernel.SetLogLevel(kernel.GetLogLevel())
+	kernel.SetLogLevel(kernel.LogLevelDebug)
+	s.debugf("logged")
+	assert.Contains(t, buf.String(), `level=debug msg="logged"`)
+
+	_, err = NewState(WithTempDir(t.TempDir()), WithPackage("my-pkg"))
+	assert.Error(t, err)
+	_, err = NewState(WithTempDir(t.TempDir()), WithGoBinary(filepath.Join(t.TempDir(), "missing_go")))
+	assert.Error(t, err)
+}
@@ -0,0 +1,47 @@
+package goexec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+)
+
+// This file implements `%output`: the compiled program is written to a path chosen by the user,
+// instead of State.TempDir, so the artifact can be retrieved -- and it is kept when the kernel
+// exits, since only State.TempDir is removed.
+
+// SetOutputPath sets the path where the compiled program is written, see State.OutputPath.
+// Relative paths are relative to the current directory of the kernel. If path is an existing
+// directory, or ends with a path separator, the program is written in it, named after State.Package.
+// An empty path restores the default, a binary in State.TempDir.
+func (s *State) SetOutputPath(path string) error {
+	if path == "" {
+		s.OutputPath = ""
+		return nil
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return errors.Wrapf(err, "invalid output path %q", path)
+	}
+	if info, err := os.Stat(absPath); (err == nil && info.IsDir()) ||
+		strings.HasSuffix(path, string(filepath.Separator)) || strings.HasSuffix(path, "/") {
+		absPath = filepath.Join(absPath, s.Package+binaryExt())
+	}
+	if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+		return errors.Wrapf(err, "failed to create directory for output path %q", absPath)
+	}
+	s.OutputPath = absPath
+	return nil
+}
+
+// reportOutputPath tells the user where the compiled program was written, if State.OutputPath is set.
+func (s *State) reportOutputPath(msg kernel.Message) {
+	if s.OutputPath == "" {
+		return
+	}
+	_ = kernel.PublishWriteStream(msg, kernel.StreamStderr, fmt.Sprintf("* Program written to %s\n", s.OutputPath))
+}
@@ -0,0 +1,32 @@
+package goexec
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutputPath(t *testing.T) {
+	s := newTestState(t)
+	defaultPath := s.BinaryPath()
+
+	outputDir := t.TempDir()
+	require.NoError(t, s.SetOutputPath(outputDir))
+	assert.Equal(t, filepath.Join(outputDir, s.Package+binaryExt()), s.BinaryPath())
+	outputPath := filepath.Join(outputDir, "sub", "mybin")
+	require.NoError(t, s.SetOutputPath(outputPath))
+	assert.Equal(t, outputPath, s.BinaryPath())
+
+	msg := newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{"func main() {}"}, nil))
+	_, err := os.Stat(outputPath)
+	require.NoError(t, err)
+	assert.Contains(t, strings.Join(msg.Published(), ""), "Program written to "+outputPath)
+
+	require.NoError(t, s.SetOutputPath(""))
+	assert.Equal(t, defaultPath, s.BinaryPath())
+}
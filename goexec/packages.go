@@ -0,0 +1,57 @@
+package goexec
+
+import (
+	"github.com/pkg/errors"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// This file implements support for extra packages, defined with `%%package <name>`, that are
+// written as sub-packages of the notebook's module.
+
+var rePackageClause = regexp.MustCompile(`^\s*package\s+(\w+)`)
+
+// PackagePath returns the path of the file holding the sub-package `name`.
+func (s *State) PackagePath(name string) string {
+	return filepath.Join(s.TempDir, name, name+".go")
+}
+
+// WritePackage writes the given lines as the contents of the sub-package `name` of the
+// notebook module, and returns its import path. If the lines don't have a `package` clause,
+// one is added.
+//
+// The sub-package is written to `<TempDir>/<name>/<name>.go`, and it is compiled by `go build`
+// when imported by the main package. It remains defined across cells, until redefined.
+func (s *State) WritePackage(name string, lines []string) (importPath string, err error) {
+	if !token.IsIdentifier(name) || name == "main" {
+		return "", errors.Errorf("invalid package name %q", name)
+	}
+	hasPackageClause := false
+	for _, line := range lines {
+		matches := rePackageClause.FindStringSubmatch(line)
+		if len(matches) == 0 {
+			continue
+		}
+		if matches[1] != name {
+			return "", errors.Errorf("package clause %q doesn't match package name %q", strings.TrimSpace(line), name)
+		}
+		hasPackageClause = true
+		break
+	}
+	content := strings.Join(lines, "\n") + "\n"
+	if !hasPackageClause {
+		content = "package " + name + "\n\n" + content
+	}
+
+	filePath := s.PackagePath(name)
+	if err = os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+		return "", errors.Wrapf(err, "creating directory for package %q", name)
+	}
+	if err = os.WriteFile(filePath, []byte(content), 0600); err != nil {
+		return "", errors.Wrapf(err, "writing package %q to %q", name, filePath)
+	}
+	return s.Package + "/" + name, nil
+}
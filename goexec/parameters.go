@@ -0,0 +1,83 @@
+package goexec
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// This file implements parameterized notebooks (papermill-style): the cell with the `%parameters`
+// special command declares variables with default values, and those with a matching name in
+// State.Parameters have their values overridden.
+//
+// Parameters are given by the environment variable GONB_PARAMETERS (ParametersEnv), read when the
+// kernel starts, with a JSON object mapping variable names to values, e.g.:
+//
+//	GONB_PARAMETERS='{"name": "report", "limit": 10}' jupyter nbconvert --execute ...
+//
+// Precedence: GONB_PARAMETERS overrides the values in the `%parameters` cell. Any later cell
+// redeclaring the variable overrides both, as usual -- so an injected cell with `var` declarations
+// following the `%parameters` cell works as well.
+//
+// Values are rendered as Go literals: strings are quoted, numbers and booleans are written as
+// is. Since the literal determines the type of untyped declarations (`var x = 1` is an `int`),
+// parameters should be declared with an explicit type (`var x float64 = 1`).
+
+// ParametersEnv is the environment variable with the JSON object of parameters, see `%parameters`.
+const ParametersEnv = "GONB_PARAMETERS"
+
+// ParseParameters parses a JSON object mapping variable names to values (strings, numbers or
+// booleans), and returns them converted to Go literals.
+func ParseParameters(jsonObject string) (map[string]string, error) {
+	dec := json.NewDecoder(strings.NewReader(jsonObject))
+	dec.UseNumber()
+	var values map[string]any
+	if err := dec.Decode(&values); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse parameters, it should be a JSON object")
+	}
+	params := make(map[string]string, len(values))
+	for name, value := range values {
+		switch v := value.(type) {
+		case string:
+			params[name] = strconv.Quote(v)
+		case json.Number:
+			params[name] = v.String()
+		case bool:
+			params[name] = strconv.FormatBool(v)
+		default:
+			return nil, errors.Errorf("parameter %q has a value of type %T, only strings, numbers and booleans are supported", name, value)
+		}
+	}
+	return params, nil
+}
+
+// parametersFromEnv returns the parameters given by ParametersEnv, or nil if it's not set.
+func parametersFromEnv() (map[string]string, error) {
+	jsonObject := os.Getenv(ParametersEnv)
+	if jsonObject == "" {
+		return nil, nil
+	}
+	params, err := ParseParameters(jsonObject)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "in environment variable %s", ParametersEnv)
+	}
+	return params, nil
+}
+
+// applyParameters overrides the values of the variables in decls with a matching State.Parameters.
+// It returns an error if a variable is not declared with a value that can be overridden.
+func (s *State) applyParameters(decls *Declarations) error {
+	for _, v := range decls.Variables {
+		value, found := s.Parameters[v.Name]
+		if !found {
+			continue
+		}
+		if v.ValueDefinition == "" {
+			return errors.Errorf("parameter variable %q must be declared with a default value, e.g.: `var %s = ...`", v.Name, v.Name)
+		}
+		v.ValueDefinition = value
+	}
+	return nil
+}
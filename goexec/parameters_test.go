@@ -0,0 +1,30 @@
+package goexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParameters(t *testing.T) {
+	params, err := ParseParameters(`{"name": "report \"2023\"", "limit": 10, "rate": 0.5, "verbose": true}`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"name": `"report \"2023\""`, "limit": "10", "rate": "0.5", "verbose": "true",
+	}, params)
+	_, err = ParseParameters(`{"list": [1, 2]}`)
+	assert.Error(t, err)
+	_, err = ParseParameters(`not json`)
+	assert.Error(t, err)
+
+	s := &State{TempDir: t.TempDir(), Decls: NewDeclarations(), Parameters: params}
+	decls := parseCellDecls(t, s, []string{`var name = "default"`, `var limit, other int = 5, 7`})
+	require.NoError(t, s.applyParameters(decls))
+	assert.Equal(t, `"report \"2023\""`, decls.Variables["name"].ValueDefinition)
+	assert.Equal(t, "10", decls.Variables["limit"].ValueDefinition)
+	assert.Equal(t, "7", decls.Variables["other"].ValueDefinition)
+
+	decls = parseCellDecls(t, s, []string{`var rate float64`})
+	assert.Error(t, s.applyParameters(decls))
+}
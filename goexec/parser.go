@@ -8,13 +8,12 @@ import (
 	"go/parser"
 	"go/token"
 	"io"
-	"log"
-	"math/rand"
+	"io/fs"
 	"os"
-	"path"
+	"path/filepath"
 	"sort"
-	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 // This file implements functions related to the parsing of the Go code.
@@ -43,18 +42,24 @@ func countLines(definition string) int {
 
 // ParseImportsFromMainGo reads main.go and parses its declarations into decls -- see object Declarations.
 func (s *State) ParseImportsFromMainGo(msg kernel.Message, cursor Cursor, decls *Declarations) error {
+	return s.parseDeclsFromFile(msg, s.MainPath(), cursor, decls)
+}
+
+// parseDeclsFromFile implements ParseImportsFromMainGo for the Go file in filePath.
+func (s *State) parseDeclsFromFile(msg kernel.Message, filePath string, cursor Cursor, decls *Declarations) error {
 	fileSet := token.NewFileSet()
-	packages, err := parser.ParseDir(fileSet, s.TempDir, nil, parser.SkipObjectResolution|parser.AllErrors)
+	// Only the given file is parsed: other files in its directory (e.g.: a fuzz test left behind by an
+	// interrupted `%fuzz`) are not part of the cell.
+	dir := filepath.Dir(filePath)
+	onlyFile := func(info fs.FileInfo) bool { return info.Name() == filepath.Base(filePath) }
+	packages, err := parser.ParseDir(fileSet, dir, onlyFile, parser.SkipObjectResolution|parser.AllErrors|parser.ParseComments)
 	if err != nil {
-		if msg != nil {
-			s.DisplayErrorWithContext(msg, err.Error())
-		}
-		return errors.Wrapf(err, "parsing go files in TempDir %s", s.TempDir)
+		return errors.WithMessagef(s.reportSyntaxError(msg, err, s.cellLinesInFile), "parsing go files in %s", dir)
 	}
 	filesContents := make(map[string]string)
 
 	if cursor.HasCursor() {
-		log.Printf("Cursor=%+v", cursor)
+		s.debugf("Cursor=%+v", cursor)
 	}
 
 	// getCursor returns the cursor position within this declaration, if the original cursor falls in there.
@@ -82,13 +87,11 @@ func (s *State) ParseImportsFromMainGo(msg kernel.Message, cursor Cursor, decls
 
 	for name, pkgAst := range packages {
 		if name != "main" {
-			log.Printf("WARNING: found package %s while parsing imports, but we expected only package main.", name)
+			s.logf("WARNING: found package %s while parsing imports, but we expected only package main.", name)
 			continue
 		}
 		for _, fileObj := range pkgAst.Files {
-			// Currently, there is only `main.go` file.
-			//fmt.Printf("File: %q\n", fileObj.Name.Name)
-			filePath := path.Join(s.TempDir, fileObj.Name.Name) + ".go"
+			// Currently, there is only the one file.
 			content, err := os.ReadFile(filePath)
 			if err != nil {
 				return errors.Wrapf(err, "Failed to read %q", fileObj.Name)
@@ -134,6 +137,11 @@ func (s *State) ParseImportsFromMainGo(msg kernel.Message, cursor Cursor, decls
 						// Loop over variable/const definitions.
 						isVar := typedDecl.Tok == token.VAR
 						var prevConstDecl *Constant
+						var varGroup string
+						varGroupIndex := 0
+						if isVar && len(typedDecl.Specs) > 0 && (len(typedDecl.Specs) > 1 || len(typedDecl.Specs[0].(*ast.ValueSpec).Names) > 1) {
+							varGroup = varGroupKey()
+						}
 
 						for _, spec := range typedDecl.Specs {
 							newCursor := getCursor(spec)
@@ -146,6 +154,13 @@ func (s *State) ParseImportsFromMainGo(msg kernel.Message, cursor Cursor, decls
 								typeDefinition = extractContentOfNode(filesContents, fileSet, vType)
 							}
 							_ = vType
+							var embedDirective string
+							if isVar && len(vSpec.Names) == 1 {
+								embedDirective = extractEmbedDirective(vSpec.Doc)
+								if embedDirective == "" && len(typedDecl.Specs) == 1 {
+									embedDirective = extractEmbedDirective(typedDecl.Doc)
+								}
+							}
 							for nameIdx, name := range vSpec.Names {
 								// Incorporate variable.
 								var valueDefinition string
@@ -153,16 +168,24 @@ func (s *State) ParseImportsFromMainGo(msg kernel.Message, cursor Cursor, decls
 									valueDefinition = extractContentOfNode(filesContents, fileSet, vSpec.Values[nameIdx])
 								}
 								if isVar {
-									v := &Variable{Name: name.Name, TypeDefinition: typeDefinition, ValueDefinition: valueDefinition}
+									v := &Variable{Name: name.Name, TypeDefinition: typeDefinition, ValueDefinition: valueDefinition,
+										EmbedDirective: embedDirective, Group: varGroup, GroupIndex: varGroupIndex}
+									varGroupIndex++
 									v.Key = v.Name
 									if v.Name == "_" {
 										// Each un-named reference has a unique key.
-										v.Key = "_~" + strconv.Itoa(rand.Int()%0xFFFF)
+										v.Key = blankDeclKey()
 									}
 									v.Cursor = newCursor // TODO: Needs to adjust column position, if multiple definitions in the same line.
 									decls.Variables[v.Key] = v
 								} else {
-									c := &Constant{Key: name.Name, TypeDefinition: typeDefinition, ValueDefinition: valueDefinition}
+									c := &Constant{Key: name.Name, Name: name.Name, TypeDefinition: typeDefinition, ValueDefinition: valueDefinition}
+									if c.Key == "_" {
+										// Each un-named constant has a unique key, so blocks starting with `_ = iota` don't
+										// overwrite each other.
+										c.Key = blankDeclKey()
+									}
+									c.SameSpec = nameIdx > 0
 									c.Prev = prevConstDecl
 									if c.Prev != nil {
 										c.Prev.Next = c
@@ -179,7 +202,7 @@ func (s *State) ParseImportsFromMainGo(msg kernel.Message, cursor Cursor, decls
 							tSpec := spec.(*ast.TypeSpec)
 							name := tSpec.Name.Name
 							tDef := extractContentOfNode(filesContents, fileSet, tSpec.Type)
-							tDecl := &TypeDecl{Key: name, TypeDefinition: tDef}
+							tDecl := &TypeDecl{Key: name, TypeDefinition: tDef, Alias: tSpec.Assign.IsValid()}
 							tDecl.Cursor = getCursor(spec)
 							decls.Types[name] = tDecl
 						}
@@ -195,6 +218,39 @@ func (s *State) ParseImportsFromMainGo(msg kernel.Message, cursor Cursor, decls
 	return nil
 }
 
+// blankDeclCounter is used by blankDeclKey to generate unique keys.
+var blankDeclCounter atomic.Int64
+
+// blankDeclKey returns a unique key for an un-named (`_`) variable or constant. Keys are generated
+// in increasing order, so the rendered declarations (sorted by key) keep the order in which they
+// were parsed, and are the same every time they are rendered.
+func blankDeclKey() string {
+	return fmt.Sprintf("_~%08d", blankDeclCounter.Add(1))
+}
+
+var varGroupCounter atomic.Int64
+
+// varGroupKey returns a unique identifier for a group of variables declared together, see
+// Variable.Group.
+func varGroupKey() string {
+	return fmt.Sprintf("group~%08d", varGroupCounter.Add(1))
+}
+
+// extractEmbedDirective returns the `//go:embed` directive lines in the comment group, or empty
+// if there are none.
+func extractEmbedDirective(doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+	var directives []string
+	for _, comment := range doc.List {
+		if strings.HasPrefix(comment.Text, "//go:embed ") {
+			directives = append(directives, comment.Text)
+		}
+	}
+	return strings.Join(directives, "\n")
+}
+
 // RenderImports writes out `import ( ... )` for all imports in Declarations.
 func (d *Declarations) RenderImports(lineNum int, writer io.Writer) (newLineNum int, cursor Cursor, err error) {
 	cursor = NoCursor
@@ -239,6 +295,9 @@ func (d *Declarations) RenderImports(lineNum int, writer io.Writer) (newLineNum
 }
 
 // RenderVariables writes out `var ( ... )` for all variables in Declarations.
+//
+// Variables are sorted by key, except the ones declared together (see Variable.Group): they are
+// rendered in the order they were declared, at the position of the first of them in the sorted order.
 func (d *Declarations) RenderVariables(lineNum int, writer io.Writer) (newLineNum int, cursor Cursor, err error) {
 	cursor = NoCursor
 	newLineNum = lineNum
@@ -263,18 +322,45 @@ func (d *Declarations) RenderVariables(lineNum int, writer io.Writer) (newLineNu
 		_, err = fmt.Fprint(writer, strBuf)
 	}
 
-	w("var (\n")
+	// Members of each group, in declaration order.
+	groups := make(map[string][]*Variable)
+	for _, key := range keys {
+		if varDecl := d.Variables[key]; varDecl.Group != "" {
+			groups[varDecl.Group] = append(groups[varDecl.Group], varDecl)
+		}
+	}
+	for _, members := range groups {
+		sort.SliceStable(members, func(i, j int) bool { return members[i].GroupIndex < members[j].GroupIndex })
+	}
+	ordered := make([]*Variable, 0, len(keys))
 	for _, key := range keys {
 		varDecl := d.Variables[key]
+		if varDecl.Group == "" {
+			ordered = append(ordered, varDecl)
+		} else if members, found := groups[varDecl.Group]; found {
+			ordered = append(ordered, members...)
+			delete(groups, varDecl.Group)
+		}
+	}
+
+	w("var (\n")
+	for _, varDecl := range ordered {
 		var typeStr string
 		if varDecl.TypeDefinition != "" {
 			typeStr = " " + varDecl.TypeDefinition
 		}
+		if varDecl.EmbedDirective != "" {
+			w("\t%s\n", varDecl.EmbedDirective)
+		}
 		if varDecl.HasCursor() {
 			cursor = varDecl.Cursor
 			cursor.Line += int32(lineNum)
 		}
-		w("\t%s%s = %s\n", varDecl.Name, typeStr, varDecl.ValueDefinition)
+		if varDecl.ValueDefinition == "" {
+			w("\t%s%s\n", varDecl.Name, typeStr)
+		} else {
+			w("\t%s%s = %s\n", varDecl.Name, typeStr, varDecl.ValueDefinition)
+		}
 	}
 	w(")\n")
 	newLineNum = lineNum
@@ -357,7 +443,11 @@ func (d *Declarations) RenderTypes(lineNum int, writer io.Writer) (newLineNum in
 			cursor = typeDecl.Cursor
 			cursor.Line += int32(lineNum)
 		}
-		w("type %s %s\n", key, typeDecl.TypeDefinition)
+		if typeDecl.Alias {
+			w("type %s = %s\n", key, typeDecl.TypeDefinition)
+		} else {
+			w("type %s %s\n", key, typeDecl.TypeDefinition)
+		}
 	}
 	newLineNum = lineNum
 	return
@@ -408,15 +498,21 @@ func (d *Declarations) RenderConstants(lineNum int, writer io.Writer) (newLineNu
 			w("const %s\n", constDecl.Render())
 			continue
 		}
-		// Render block of constants.
+		// Render block of constants: constants declared in the same spec (e.g.: `A, B = iota, -iota`)
+		// are rendered in the same line, so they share the same `iota` value.
 		w("const (\n")
 		for constDecl != nil {
-			if constDecl.HasCursor() {
-				cursor = constDecl.Cursor
-				cursor.Line += int32(lineNum)
+			spec := []*Constant{constDecl}
+			for constDecl = constDecl.Next; constDecl != nil && constDecl.SameSpec; constDecl = constDecl.Next {
+				spec = append(spec, constDecl)
+			}
+			for _, c := range spec {
+				if c.HasCursor() {
+					cursor = c.Cursor
+					cursor.Line += int32(lineNum)
+				}
 			}
-			w("\t%s\n", constDecl.Render())
-			constDecl = constDecl.Next
+			w("\t%s\n", renderConstantSpec(spec))
 		}
 		w(")\n")
 	}
@@ -424,9 +520,32 @@ func (d *Declarations) RenderConstants(lineNum int, writer io.Writer) (newLineNu
 	return
 }
 
+// renderConstantSpec renders constants declared in the same spec, e.g.: `A, B int = iota, -iota`.
+func renderConstantSpec(spec []*Constant) string {
+	if len(spec) == 1 {
+		return spec[0].Render()
+	}
+	names := make([]string, len(spec))
+	var values []string
+	for ii, c := range spec {
+		names[ii] = c.Name
+		if c.ValueDefinition != "" {
+			values = append(values, c.ValueDefinition)
+		}
+	}
+	r := strings.Join(names, ", ")
+	if spec[0].TypeDefinition != "" {
+		r += " " + spec[0].TypeDefinition
+	}
+	if len(values) > 0 {
+		r += " = " + strings.Join(values, ", ")
+	}
+	return r
+}
+
 // Render Constant declaration (without the `const` keyword).
 func (c *Constant) Render() string {
-	r := c.Key
+	r := c.Name
 	if c.TypeDefinition != "" {
 		r += " " + c.TypeDefinition
 	}
@@ -7,6 +7,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"os"
 	"path"
+	"strings"
 	"testing"
 )
 
@@ -176,11 +177,11 @@ func init_c() {
 	// Checks variables rendering.
 	wantVariablesRendering := `var (
 	_ = fmt.Printf
+	x float32 = 1
+	y float32 = 2
 	b = math.Sqrt(30.0 +
 		34.0)
 	c = "blah, blah, blah"
-	x float32 = 1
-	y float32 = 2
 )
 `
 	buf = bytes.NewBuffer(make([]byte, 0, 512))
@@ -240,3 +241,67 @@ const (
 	assert.Equal(t, wantConstantsRendering, buf.String())
 	//fmt.Printf("Constants:\n%s\n", buf.String())
 }
+
+func TestTypeAliases(t *testing.T) {
+	s := &State{TempDir: t.TempDir(), Decls: NewDeclarations()}
+	parseCellIntoState(t, s, []string{
+		`type Duration = time.Duration`,
+		`type Celsius float64`,
+		`func (c Celsius) String() string { return "" }`,
+	})
+	require.Contains(t, s.Decls.Types, "Duration")
+	assert.True(t, s.Decls.Types["Duration"].Alias)
+	assert.Equal(t, "time.Duration", s.Decls.Types["Duration"].TypeDefinition)
+	assert.False(t, s.Decls.Types["Celsius"].Alias)
+	assert.Contains(t, s.Decls.Functions, "Celsius~String")
+
+	buf := bytes.NewBuffer(make([]byte, 0, 1024))
+	_, _, err := s.Decls.RenderTypes(0, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "type Celsius float64\ntype Duration = time.Duration\n", buf.String())
+
+	// Redefining an alias as a type definition, and vice versa.
+	parseCellIntoState(t, s, []string{`type Duration int64`, `type Celsius = float64`})
+	assert.False(t, s.Decls.Types["Duration"].Alias)
+	assert.True(t, s.Decls.Types["Celsius"].Alias)
+	buf.Reset()
+	_, _, err = s.Decls.RenderTypes(0, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "type Celsius = float64\ntype Duration int64\n", buf.String())
+
+	// Methods of the type definition are not carried over to the alias.
+	assert.NotContains(t, s.Decls.Functions, "Celsius~String")
+}
+
+// TestDeterministicRendering checks that the same declarations always generate the same main.go.
+func TestDeterministicRendering(t *testing.T) {
+	s := &State{TempDir: t.TempDir(), Decls: NewDeclarations()}
+	parseCellIntoState(t, s, []string{`var _ = a`, `var a, b = 1, 2`, `const (`, `	_ = iota`, `	K1`, `)`})
+	parseCellIntoState(t, s, []string{`var _ = b`, `const _ = "x"`, `type T int`, `func (T) M() {}`, `func f() {}`})
+	mainDecl := &Function{Key: "main", Name: "main", Definition: "func main() {}"}
+
+	render := func(decls *Declarations) string {
+		_, err := s.createMainFromDecls(decls, mainDecl)
+		require.NoError(t, err)
+		content, err := os.ReadFile(s.MainPath())
+		require.NoError(t, err)
+		return string(content)
+	}
+	want := render(s.Decls)
+	for ii := 0; ii < 10; ii++ {
+		require.Equal(t, want, render(s.Decls))
+		require.Equal(t, want, render(s.Decls.Copy()))
+	}
+	// Un-named declarations keep the order in which they were parsed.
+	assert.Less(t, strings.Index(want, "_ = a"), strings.Index(want, "_ = b"))
+}
+
+// TestEmptyGroups checks that empty declaration groups are parsed without declaring anything.
+func TestEmptyGroups(t *testing.T) {
+	s := &State{TempDir: t.TempDir(), Decls: NewDeclarations()}
+	parseCellIntoState(t, s, []string{`var ()`, `const ()`, `type ()`, `var x = 1`})
+	assert.Len(t, s.Decls.Variables, 1)
+	assert.Contains(t, s.Decls.Variables, "x")
+	assert.Empty(t, s.Decls.Constants)
+	assert.Empty(t, s.Decls.Types)
+}
@@ -0,0 +1,81 @@
+package goexec
+
+import (
+	"fmt"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+)
+
+// This file implements `%%plugin`: building the program as a Go plugin (see package "plugin"), a
+// shared object that other programs load with plugin.Open, instead of executing it.
+
+// buildPlugin renders main.go with decls and mainDecl, and builds it with `-buildmode=plugin` to
+// State.Cell.Plugin. The main function of a plugin is never executed.
+//
+// On success it reports the symbols that can be looked up in the plugin: the exported functions and
+// variables of the main package.
+func (s *State) buildPlugin(msg kernel.Message, decls *Declarations, mainDecl *Function) error {
+	pluginPath, err := filepath.Abs(s.Cell.Plugin)
+	if err != nil {
+		return errors.Wrapf(err, "invalid plugin path %q", s.Cell.Plugin)
+	}
+	if err = os.MkdirAll(filepath.Dir(pluginPath), 0755); err != nil {
+		return errors.Wrapf(err, "failed to create directory for plugin %q", pluginPath)
+	}
+	if _, err = s.createMainFromDecls(s.withImportPreferences(decls), mainDecl); err != nil {
+		return errors.WithMessagef(err, "while generating main.go with all declarations")
+	}
+	if err = s.checkSyntax(msg); err != nil {
+		return err
+	}
+	if err = s.GoImports(msg); err != nil {
+		return errors.WithMessagef(err, "goimports failed")
+	}
+
+	buildFlags, err := s.goBuildFlags()
+	if err != nil {
+		return err
+	}
+	args := append(append([]string{"build"}, buildFlags...), "-buildmode=plugin", "-o", pluginPath)
+	cmd := s.GoCommand(args...)
+	s.reportCommand(msg, cmd)
+	output, err := runGoCommand(msg, cmd)
+	if err != nil {
+		s.DisplayErrorWithContext(msg, output)
+		return errors.Wrapf(err, "failed to run %q", cmd.String())
+	}
+	s.displayWarnings(msg, "go build", buildWarnings(output))
+
+	report := fmt.Sprintf("* Plugin written to %s\n", pluginPath)
+	if symbols := pluginSymbols(decls); len(symbols) > 0 {
+		report += fmt.Sprintf("  Symbols: %s\n", strings.Join(symbols, ", "))
+	} else {
+		report += "  It has no exported functions or variables to look up.\n"
+	}
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout, report)
+}
+
+// pluginSymbols returns the names of the symbols of a plugin built from decls, that can be looked
+// up with plugin.Plugin.Lookup: the exported functions (not methods) and variables, sorted.
+func pluginSymbols(decls *Declarations) []string {
+	var symbols []string
+	for _, f := range decls.Functions {
+		// Methods are keyed as "<type>~<method>".
+		if !strings.Contains(f.Key, "~") && token.IsExported(f.Key) {
+			symbols = append(symbols, f.Key+" (func)")
+		}
+	}
+	for _, v := range decls.Variables {
+		if token.IsExported(v.Name) {
+			symbols = append(symbols, v.Name+" (var)")
+		}
+	}
+	sort.Strings(symbols)
+	return symbols
+}
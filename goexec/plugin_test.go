@@ -0,0 +1,50 @@
+package goexec
+
+import (
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildPlugin(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skipf("plugins not supported on %s", runtime.GOOS)
+	}
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skipf("plugins require cgo, and no C compiler is available: %v", err)
+	}
+	s := newTestState(t)
+
+	pluginPath := filepath.Join(t.TempDir(), "out", "greet.so")
+	s.Cell.Plugin = pluginPath
+	msg := newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{
+		"var Greeting = \"hello\"",
+		"type T struct{}",
+		"func (T) Method() {}",
+		"func Greet() string { return Greeting }",
+		"func helper() {}",
+		"%%",
+		"panic(\"main of a plugin is not executed\")",
+	}, nil))
+	assert.FileExists(t, pluginPath)
+	published := strings.Join(msg.Published(), "")
+	assert.Contains(t, published, "Plugin written to "+pluginPath)
+	assert.Contains(t, published, "Symbols: Greet (func), Greeting (var)")
+	assert.Contains(t, s.Decls.Functions, "Greet")
+}
+
+func TestPluginSymbols(t *testing.T) {
+	decls := NewDeclarations()
+	decls.Functions["Exported"] = &Function{Key: "Exported"}
+	decls.Functions["unexported"] = &Function{Key: "unexported"}
+	decls.Functions["T~Method"] = &Function{Key: "T~Method"}
+	decls.Variables["V"] = &Variable{Key: "V", Name: "V"}
+	decls.Variables["v"] = &Variable{Key: "v", Name: "v"}
+	assert.Equal(t, []string{"Exported (func)", "V (var)"}, pluginSymbols(decls))
+}
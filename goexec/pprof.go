@@ -0,0 +1,104 @@
+package goexec
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// This file implements profiling of the executed program, with `%%pprof <kind>`: the program's
+// main function is wrapped with code that collects the profile to a file, which is summarized
+// with `go tool pprof -top` after the execution.
+
+// ProfileKinds lists the kinds of profile supported by `%%pprof`.
+var ProfileKinds = []string{"cpu", "mem", "block"}
+
+// profileWrappers holds the code to start and stop the collection of each kind of profile. Both
+// are formatted with the path of the profile file. Imports are added by goimports, or see
+// profileImports.
+var profileWrappers = map[string][2]string{
+	"cpu": {`
+	gonbProfileFile, err := os.Create(%[1]q)
+	if err != nil {
+		panic(err)
+	}
+	if err := pprof.StartCPUProfile(gonbProfileFile); err != nil {
+		panic(err)
+	}`, `
+	pprof.StopCPUProfile()
+	_ = gonbProfileFile.Close()`},
+	"mem": {`
+	runtime.MemProfileRate = 4096`, `
+	runtime.GC()
+	gonbProfileFile, err := os.Create(%[1]q)
+	if err != nil {
+		panic(err)
+	}
+	if err := pprof.WriteHeapProfile(gonbProfileFile); err != nil {
+		panic(err)
+	}
+	_ = gonbProfileFile.Close()`},
+	"block": {`
+	runtime.SetBlockProfileRate(1)`, `
+	gonbProfileFile, err := os.Create(%[1]q)
+	if err != nil {
+		panic(err)
+	}
+	if err := pprof.Lookup("block").WriteTo(gonbProfileFile, 0); err != nil {
+		panic(err)
+	}
+	_ = gonbProfileFile.Close()`},
+}
+
+// profileImports holds the import paths used by the profileWrappers of each kind.
+var profileImports = map[string][]string{
+	"cpu":   {"os", "runtime/pprof"},
+	"mem":   {"os", "runtime", "runtime/pprof"},
+	"block": {"os", "runtime", "runtime/pprof"},
+}
+
+var reMainFuncHeader = regexp.MustCompile(`^func\s+main\s*\(\s*\)`)
+
+// ProfilePath returns the path of the file where the profile of the given kind is saved.
+func (s *State) ProfilePath(kind string) string {
+	return filepath.Join(s.TempDir, kind+".pprof")
+}
+
+// profiledMain returns a new main function that collects the profile State.Cell.Profile while
+// calling the given main function (renamed).
+//
+// Notice the profile is not saved if the program exits with os.Exit (or log.Fatal, etc.).
+func (s *State) profiledMain(mainDecl *Function) (*Function, error) {
+	wrapper, found := profileWrappers[s.Cell.Profile]
+	if !found {
+		return nil, errors.Errorf("unknown profile kind %q, valid values are %q", s.Cell.Profile, ProfileKinds)
+	}
+	if !reMainFuncHeader.MatchString(mainDecl.Definition) {
+		return nil, errors.Errorf("can't profile main function, unexpected definition: %q", mainDecl.Definition)
+	}
+	profilePath := s.ProfilePath(s.Cell.Profile)
+	_ = os.Remove(profilePath)
+	definition := reMainFuncHeader.ReplaceAllString(mainDecl.Definition, "func gonbProfiledMain()") +
+		"\n\nfunc main() {" + fmt.Sprintf(wrapper[0], profilePath) +
+		"\n\tgonbProfiledMain()" + fmt.Sprintf(wrapper[1], profilePath) + "\n}"
+	generatedImports := append(append([]string(nil), profileImports[s.Cell.Profile]...), mainDecl.generatedImports...)
+	return &Function{Key: mainDecl.Key, Name: mainDecl.Name, Definition: definition, generatedImports: generatedImports}, nil
+}
+
+// reportProfile displays the path to the profile collected, and its summary with `go tool pprof -top`.
+func (s *State) reportProfile(msg kernel.Message) error {
+	profilePath := s.ProfilePath(s.Cell.Profile)
+	if _, err := os.Stat(profilePath); err != nil {
+		return errors.Wrapf(err, "%s profile not saved, did the program exit with os.Exit()?", s.Cell.Profile)
+	}
+	_ = kernel.PublishWriteStream(msg, kernel.StreamStdout,
+		fmt.Sprintf("\n* %s profile saved to %s\n", s.Cell.Profile, profilePath))
+	args := []string{"tool", "pprof", "-top", "-nodecount=20", s.BinaryPath(), profilePath}
+	s.reportExec(msg, s.TempDir, nil, append([]string{s.GoBinary}, args...)...)
+	return kernel.NewPipeExecToJupyterBuilder(msg, s.GoBinary, args...).
+		InDir(s.TempDir).
+		Exec()
+}
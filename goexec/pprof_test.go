@@ -0,0 +1,24 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestProfiledMain(t *testing.T) {
+	s := &State{TempDir: t.TempDir(), Decls: NewDeclarations()}
+	mainDecl := &Function{Key: "main", Name: "main", Definition: "func main() {\n\tfmt.Println(\"hello\")\n}"}
+	s.Cell.Profile = "cpu"
+	wrapped, err := s.profiledMain(mainDecl)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(wrapped.Definition, "func gonbProfiledMain() {"))
+	assert.Contains(t, wrapped.Definition, "\nfunc main() {")
+	assert.Contains(t, wrapped.Definition, "pprof.StartCPUProfile")
+	assert.Contains(t, wrapped.Definition, s.ProfilePath("cpu"))
+
+	s.Cell.Profile = "goroutine"
+	_, err = s.profiledMain(mainDecl)
+	require.Error(t, err)
+}
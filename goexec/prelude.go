@@ -0,0 +1,87 @@
+package goexec
+
+import (
+	"sort"
+
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+)
+
+// This file implements the prelude, see `%%prelude`: declarations (imports, helper functions,
+// configuration) that are part of every compilation, as a base layer under the declarations of the
+// cells. They are kept apart from the other declarations, so `%reset` and redefinitions in cells
+// don't remove them: only ClearPrelude (`%prelude clear`) does.
+
+// AddPrelude parses the lines as Go declarations and adds them to State.Prelude, replacing the
+// prelude declarations with the same names. The program is compiled to validate them, and if it
+// fails they are discarded.
+func (s *State) AddPrelude(msg kernel.Message, lines []string) error {
+	if err := s.GoToolchainError(); err != nil {
+		return err
+	}
+	if _, err := s.createGoFileFromLines(s.MainPath(), lines, nil, NoCursor); err != nil {
+		return errors.WithMessagef(err, "in goexec.AddPrelude()")
+	}
+	newDecls := NewDeclarations()
+	if err := s.ParseImportsFromMainGo(msg, NoCursor, newDecls); err != nil {
+		return errors.WithMessagef(err, "in goexec.AddPrelude() while parsing prelude")
+	}
+	if _, hasMain := newDecls.Functions["main"]; hasMain {
+		return errors.Errorf("the prelude can't define the main function")
+	}
+
+	previous := s.Prelude
+	if previous == nil {
+		s.Prelude = NewDeclarations()
+	} else {
+		s.Prelude = previous.Copy()
+	}
+	s.Prelude.MergeFrom(newDecls)
+	err := s.compileDecls(msg)
+	if err != nil {
+		s.Prelude = previous
+		return errors.WithMessagef(err, "prelude failed to compile, it was not changed")
+	}
+	return nil
+}
+
+// ClearPrelude removes all the declarations of the prelude.
+func (s *State) ClearPrelude() {
+	s.Prelude = nil
+}
+
+// PreludeKeys returns the keys of the declarations of the prelude, sorted.
+func (s *State) PreludeKeys() []string {
+	if s.Prelude == nil {
+		return nil
+	}
+	var keys []string
+	keys = appendKeys(keys, s.Prelude.Imports)
+	keys = appendKeys(keys, s.Prelude.Types)
+	keys = appendKeys(keys, s.Prelude.Constants)
+	keys = appendKeys(keys, s.Prelude.Variables)
+	keys = appendKeys(keys, s.Prelude.Functions)
+	sort.Strings(keys)
+	return keys
+}
+
+// appendKeys appends the keys of m to keys.
+func appendKeys[V any](keys []string, m map[string]V) []string {
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// withPrelude returns decls merged over the declarations of the prelude, or decls itself if there
+// is no prelude. Declarations of the cells take precedence over the ones of the prelude with the
+// same name.
+func (s *State) withPrelude(decls *Declarations) *Declarations {
+	if s.Prelude == nil {
+		return decls
+	}
+	merged := s.Prelude.Copy()
+	merged.ClearCursor()
+	merged.MergeFrom(decls)
+	return merged
+}
@@ -0,0 +1,35 @@
+package goexec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrelude(t *testing.T) {
+	s := newTestState(t)
+
+	require.NoError(t, s.AddPrelude(newTestMessage(), []string{`import "fmt"`, `func greet() { fmt.Println("hello") }`}))
+	assert.Equal(t, []string{"fmt", "greet"}, s.PreludeKeys())
+	assert.Empty(t, s.Decls.Functions)
+	assert.Error(t, s.AddPrelude(newTestMessage(), []string{"func broken() { undefinedFunc() }"}))
+	assert.Equal(t, []string{"fmt", "greet"}, s.PreludeKeys())
+	assert.Error(t, s.AddPrelude(newTestMessage(), []string{"func main() {}"}))
+
+	// The prelude is part of every compilation, also after a reset.
+	s.Reset()
+	msg := newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{"%%", "greet()"}, nil))
+	assert.Contains(t, strings.Join(msg.Published(), ""), "hello\n")
+
+	// Cells take precedence over the prelude.
+	require.NoError(t, s.ExecuteCell(newTestMessage(), []string{`func greet() { fmt.Println("hi") }`}, nil))
+	msg = newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{"%%", "greet()"}, nil))
+	assert.Contains(t, strings.Join(msg.Published(), ""), "hi\n")
+
+	s.ClearPrelude()
+	assert.Empty(t, s.PreludeKeys())
+}
@@ -0,0 +1,107 @@
+package goexec
+
+import (
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+	"os"
+	"os/exec"
+)
+
+// This file implements the tracking of the executed programs, so they (and anything they spawned,
+// like servers) can be terminated when no longer needed.
+
+// setLastProgram is called when a program is started by Execute.
+func (s *State) setLastProgram(cmd *exec.Cmd) {
+	s.muProgram.Lock()
+	defer s.muProgram.Unlock()
+	s.lastProgram = cmd
+}
+
+// addBackgroundProgram is called when a program is started in the background by Execute.
+func (s *State) addBackgroundProgram(cmd *exec.Cmd) {
+	s.muProgram.Lock()
+	defer s.muProgram.Unlock()
+	s.backgroundPrograms = append(s.backgroundPrograms, cmd)
+}
+
+// removeBackgroundProgram is called when a program executed in the background exits: it is no
+// longer tracked, and its copy of the binary (see copyBinaryForBackground) is removed.
+func (s *State) removeBackgroundProgram(cmd *exec.Cmd, binaryPath string) {
+	s.muProgram.Lock()
+	for ii, program := range s.backgroundPrograms {
+		if program == cmd {
+			s.backgroundPrograms = append(s.backgroundPrograms[:ii], s.backgroundPrograms[ii+1:]...)
+			break
+		}
+	}
+	s.muProgram.Unlock()
+	if err := os.Remove(binaryPath); err != nil && !os.IsNotExist(err) {
+		s.logf("Failed to remove copy of binary executed in background: %+v", err)
+	}
+}
+
+// copyBinaryForBackground makes a copy of the compiled binary, to be executed in the background,
+// and returns its path.
+func (s *State) copyBinaryForBackground() (string, error) {
+	data, err := os.ReadFile(s.BinaryPath())
+	if err != nil {
+		return "", errors.Wrapf(err, "reading compiled binary %q", s.BinaryPath())
+	}
+	f, err := os.CreateTemp(s.TempDir, s.Package+"_background_*"+binaryExt())
+	if err != nil {
+		return "", errors.Wrapf(err, "creating copy of binary to execute in background")
+	}
+	binaryPath := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0700)
+	}
+	if newErr := f.Close(); err == nil {
+		err = newErr
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "copying binary to %q", binaryPath)
+	}
+	return binaryPath, nil
+}
+
+// KillProgram kills the last program executed, along with any processes it spawned (e.g.: servers)
+// that may still be running. It's a no-op if there is nothing running.
+//
+// Programs running in the background are not affected, see KillAll.
+func (s *State) KillProgram() error {
+	s.muProgram.Lock()
+	defer s.muProgram.Unlock()
+	if s.lastProgram == nil {
+		return nil
+	}
+	err := kernel.KillProcessGroup(s.lastProgram)
+	s.lastProgram = nil
+	return err
+}
+
+// KillAll kills the last program executed and the programs running in the background, along with
+// any processes they spawned.
+func (s *State) KillAll() error {
+	err := s.KillProgram()
+	s.muProgram.Lock()
+	defer s.muProgram.Unlock()
+	for _, cmd := range s.backgroundPrograms {
+		if newErr := kernel.KillProcessGroup(cmd); newErr != nil && err == nil {
+			err = newErr
+		}
+	}
+	s.backgroundPrograms = nil
+	return err
+}
+
+// Finalize should be called when the kernel is shutting down: it stops any `%watch`, kills any
+// lingering processes started by the executed programs, and then runs the programs registered
+// with `%%cleanup`.
+func (s *State) Finalize() {
+	s.StopWatch()
+	if err := s.KillAll(); err != nil {
+		s.logf("Failed to kill programs during finalization: %+v", err)
+	}
+	s.RunCleanups()
+}
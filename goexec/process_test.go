@@ -0,0 +1,90 @@
+package goexec
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// processState returns the state of the process pid (e.g.: "S" sleeping, "Z" zombie) read from
+// /proc, or "" if there is no such process.
+func processState(pid int) string {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return ""
+	}
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	return fields[0]
+}
+
+// TestKillProgram checks that processes spawned by the last program are killed, even after the
+// program itself exited.
+func TestKillProgram(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skipf("/proc not available: %v", err)
+	}
+	s := newTestState(t)
+	msg := newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{
+		`import (`,
+		`	"fmt"`,
+		`	"os/exec"`,
+		`)`,
+		`%%`,
+		`cmd := exec.Command("sleep", "60")`,
+		`if err := cmd.Start(); err != nil {`,
+		`	panic(err)`,
+		`}`,
+		`fmt.Printf("pid=%d\n", cmd.Process.Pid)`,
+	}, nil))
+	matches := regexp.MustCompile(`pid=(\d+)`).FindStringSubmatch(strings.Join(msg.Published(), ""))
+	require.Len(t, matches, 2, "published: %q", msg.Published())
+	pid, err := strconv.Atoi(matches[1])
+	require.NoError(t, err)
+	require.Contains(t, []string{"S", "R"}, processState(pid), "spawned process should be running")
+
+	require.NoError(t, s.KillProgram())
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if state := processState(pid); state == "" || state == "Z" {
+			break
+		}
+	}
+	assert.Contains(t, []string{"", "Z"}, processState(pid), "spawned process should have been killed")
+
+	// Nothing left to kill.
+	require.NoError(t, s.KillProgram())
+	require.NoError(t, s.KillAll())
+}
+
+// TestBackgroundProgramExit checks that programs executed in the background are no longer tracked
+// once they exit, and that their copy of the binary is removed.
+func TestBackgroundProgramExit(t *testing.T) {
+	s := newTestState(t)
+	s.Cell.Background = true
+	require.NoError(t, s.ExecuteCell(newTestMessage(), []string{`%%`, `println("done")`}, nil))
+
+	backgroundCopies := func() []string {
+		copies, err := filepath.Glob(filepath.Join(s.TempDir, s.Package+"_background_*"))
+		require.NoError(t, err)
+		return copies
+	}
+	numPrograms := func() int {
+		s.muProgram.Lock()
+		defer s.muProgram.Unlock()
+		return len(s.backgroundPrograms)
+	}
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+		if numPrograms() == 0 && len(backgroundCopies()) == 0 {
+			break
+		}
+	}
+	assert.Equal(t, 0, numPrograms())
+	assert.Empty(t, backgroundCopies())
+}
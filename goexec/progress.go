@@ -0,0 +1,125 @@
+package goexec
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/kernel"
+	"html"
+	"io"
+	"os/exec"
+	"regexp"
+	"time"
+)
+
+// reGoProgress matches the lines output by the `go` tool reporting progress, as opposed to errors.
+var reGoProgress = regexp.MustCompile(`^go: (downloading|finding|extracting|added|upgraded|downgraded|found) `)
+
+// runGoCommand runs cmd, a `go` tool command, and returns its combined output.
+//
+// Progress lines (e.g.: "go: downloading ...") are streamed to the notebook as they are output, so
+// the user can follow long downloads of dependencies. Errors are left to the caller to display,
+// with context.
+func runGoCommand(msg kernel.Message, cmd *exec.Cmd) (output string, err error) {
+	return runGoCommandWithProgress(cmd, func(line string) {
+		_ = kernel.PublishWriteStream(msg, kernel.StreamStderr, line)
+	})
+}
+
+// runGoCommandWithProgress runs cmd, a `go` tool command, and returns its combined output. Its
+// progress lines (see reGoProgress) are passed to publish as they are output.
+func runGoCommandWithProgress(cmd *exec.Cmd, publish func(line string)) (output string, err error) {
+	var buf bytes.Buffer
+	progress := &progressWriter{publish: publish}
+	w := io.MultiWriter(&buf, progress)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	err = cmd.Run()
+	return buf.String(), err
+}
+
+// progressWriter publishes the progress lines written to it, see reGoProgress.
+type progressWriter struct {
+	publish func(line string)
+	partial []byte
+}
+
+// Write implements io.Writer.
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		idx := bytes.IndexByte(w.partial, '\n')
+		if idx < 0 {
+			break
+		}
+		line := w.partial[:idx+1]
+		if reGoProgress.Match(line) {
+			w.publish(string(line))
+		}
+		w.partial = w.partial[idx+1:]
+	}
+	return len(p), nil
+}
+
+// reGoDownloading matches the progress line of a module downloaded by the `go` tool, capturing
+// the module path and version.
+var reGoDownloading = regexp.MustCompile(`^go: downloading (\S+) (\S+)\s*$`)
+
+// goGetProgressInterval is the minimum time between updates of the `go get` progress indicator.
+const goGetProgressInterval = 250 * time.Millisecond
+
+// goGetProgress renders the progress of `go get` as a compact indicator -- the number of modules
+// downloaded so far and the last one --, updated in place, instead of one line per module. Progress
+// lines it doesn't recognize are streamed as they are.
+type goGetProgress struct {
+	msg       kernel.Message
+	displayID string
+
+	start, lastUpdate time.Time
+	downloaded        int
+	lastModule        string
+}
+
+// newGoGetProgress returns a goGetProgress that displays the indicator with the given display id.
+func newGoGetProgress(msg kernel.Message, displayID string) *goGetProgress {
+	return &goGetProgress{msg: msg, displayID: displayID, start: time.Now()}
+}
+
+// publish handles one progress line of `go get`.
+func (p *goGetProgress) publish(line string) {
+	matches := reGoDownloading.FindStringSubmatch(line)
+	if matches == nil {
+		_ = kernel.PublishWriteStream(p.msg, kernel.StreamStderr, line)
+		return
+	}
+	p.downloaded++
+	p.lastModule = matches[1] + " " + matches[2]
+	if p.downloaded > 1 && time.Since(p.lastUpdate) < goGetProgressInterval {
+		return
+	}
+	p.lastUpdate = time.Now()
+	p.display(fmt.Sprintf("&#x23F3; <code>go get</code>: downloading modules, %d so far (<code>%s</code>) ...",
+		p.downloaded, html.EscapeString(p.lastModule)))
+}
+
+// done updates the indicator with the final count, if any module was downloaded.
+func (p *goGetProgress) done(err error) {
+	if p.downloaded == 0 {
+		return
+	}
+	status := "downloaded"
+	if err != nil {
+		status = "failed after downloading"
+	}
+	p.display(fmt.Sprintf("<code>go get</code>: %s %d module(s) in %s.", status, p.downloaded,
+		time.Since(p.start).Round(100*time.Millisecond)))
+}
+
+// display publishes the indicator, replacing the previous one.
+func (p *goGetProgress) display(htmlContent string) {
+	_ = kernel.PublishDisplayData(p.msg, kernel.Data{
+		Data:      kernel.MIMEMap{string(protocol.MIMETextHTML): htmlContent},
+		Metadata:  make(kernel.MIMEMap),
+		Transient: kernel.MIMEMap{"display_id": p.displayID},
+	})
+}
@@ -0,0 +1,45 @@
+package goexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressWriter(t *testing.T) {
+	var published []string
+	w := &progressWriter{publish: func(line string) { published = append(published, line) }}
+	_, _ = w.Write([]byte("go: downloading github.com/pkg/errors v0.9.1\ngo: down"))
+	_, _ = w.Write([]byte("loading golang.org/x/text v0.3.7\n./main.go:5:2: undefined: x\n"))
+	assert.Equal(t, []string{
+		"go: downloading github.com/pkg/errors v0.9.1\n",
+		"go: downloading golang.org/x/text v0.3.7\n",
+	}, published)
+}
+
+func TestGoGetProgress(t *testing.T) {
+	msg := newTestMessage()
+	p := newGoGetProgress(msg, "progress_id")
+	p.publish("go: downloading cloud.google.com/go v0.110.0\n")
+	p.publish("go: downloading cloud.google.com/go/storage v1.30.1\n") // Too soon: not displayed.
+	p.publish("go: finding module for package example.com/x\n")
+	p.done(nil)
+	published := msg.Published()
+	require.Len(t, published, 3)
+	assert.Contains(t, published[0], "display_data")
+	assert.Contains(t, published[0], "1 so far (<code>cloud.google.com/go v0.110.0</code>)")
+	assert.Contains(t, published[0], "display_id:progress_id")
+	assert.Contains(t, published[1], "go: finding module for package example.com/x")
+	assert.Contains(t, published[2], "downloaded 2 module(s)")
+	assert.Contains(t, published[2], "display_id:progress_id")
+
+	// Nothing downloaded: no indicator.
+	msg = newTestMessage()
+	p = newGoGetProgress(msg, "progress_id")
+	p.publish("go: added golang.org/x/text v0.3.7\n")
+	p.done(nil)
+	published = msg.Published()
+	require.Len(t, published, 1)
+	assert.Contains(t, published[0], "go: added golang.org/x/text")
+}
@@ -0,0 +1,45 @@
+package goexec
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebuild(t *testing.T) {
+	s := newTestState(t)
+	require.NoError(t, s.ExecuteCell(newTestMessage(), []string{`func kept() int { return 1 }`}, nil))
+
+	// %rebuild compiles the declarations of all cells again.
+	require.NoError(t, os.Remove(s.BinaryPath()))
+	msg := newTestMessage()
+	require.NoError(t, s.Rebuild(msg))
+	assert.FileExists(t, s.BinaryPath())
+	assert.Contains(t, strings.Join(msg.Published(), ""), "Rebuilt successfully")
+
+	// Compilation errors are reported, and the declarations are kept as they were.
+	s.Decls.Functions["broken"] = &Function{Key: "broken", Name: "broken", Definition: `func broken() int { return "x" }`}
+	require.Error(t, s.Rebuild(newTestMessage()))
+	require.NotNil(t, s.LastError())
+	assert.Contains(t, s.Decls.Functions, "kept")
+}
+
+// TestRefresh checks that `%refresh` rebuilds all packages (`go build -a`), instead of using the
+// build cache.
+func TestRefresh(t *testing.T) {
+	s := newTestState(t)
+	require.NoError(t, s.ExecuteCell(newTestMessage(), []string{`func kept() int { return 1 }`}, nil))
+
+	require.NoError(t, os.Remove(s.BinaryPath()))
+	s.Verbose = true
+	msg := newTestMessage()
+	require.NoError(t, s.Refresh(msg))
+	assert.FileExists(t, s.BinaryPath())
+	output := strings.Join(msg.Published(), "")
+	assert.Contains(t, output, "build -a")
+	assert.Contains(t, output, "Refreshed successfully")
+	assert.Contains(t, s.Decls.Functions, "kept")
+}
@@ -0,0 +1,69 @@
+package goexec
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+)
+
+// This file implements `%%go-run-file`: an existing main package (or Go file) is compiled and
+// executed as is, as if it was the program of a cell -- with the arguments set with `%args`, the
+// environment, the resource limits and interruption --, but without merging any declarations.
+
+// RunFile compiles the main package in the directory target, or the Go file target, and executes
+// it with State.Args. It is compiled within its own module, with `go build`, to a binary in
+// State.TempDir. Relative paths are relative to the current directory of the kernel, where the
+// program is executed.
+func (s *State) RunFile(msg kernel.Message, target string) error {
+	if err := s.GoToolchainError(); err != nil {
+		return err
+	}
+	// Terminate anything left running by the previous program, freeing resources (e.g.: ports).
+	if err := s.KillProgram(); err != nil {
+		s.logf("Failed to kill previous program: %+v", err)
+	}
+
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return errors.Wrapf(err, "invalid path %q", target)
+	}
+	info, err := os.Stat(absTarget)
+	if err != nil {
+		return errors.Wrapf(err, "%%%%go-run-file can't find %q", target)
+	}
+	buildDir, pkg := absTarget, "."
+	if !info.IsDir() {
+		if !strings.HasSuffix(absTarget, ".go") {
+			return errors.Errorf("%%%%go-run-file requires a directory with a main package or a \".go\" file, got %q", target)
+		}
+		buildDir, pkg = filepath.Dir(absTarget), filepath.Base(absTarget)
+	}
+
+	binaryPath := filepath.Join(s.TempDir, "gonb_run_file"+binaryExt())
+	args := append(append([]string{"build"}, s.BuildFlags...), "-o", binaryPath, pkg)
+	cmd := s.GoCommand(args...)
+	cmd.Dir = buildDir
+	cmd.Env = nil // The go.work of State.TempDir doesn't apply to the target's module.
+	s.reportCommand(msg, cmd)
+	if output, err := runGoCommand(msg, cmd); err != nil {
+		_ = kernel.PublishWriteStream(msg, kernel.StreamStderr, s.RedactSecrets(output))
+		return errors.Wrapf(err, "failed to build %q", target)
+	}
+
+	env := s.programEnv()
+	s.reportExec(msg, "", env, append([]string{binaryPath}, s.Args...)...)
+	builder := kernel.NewPipeExecToJupyterBuilder(msg, binaryPath, s.Args...).
+		WithEnv(env...).
+		WithOutputLimits(s.OutputLimits).
+		WithANSIMode(s.ANSIMode).
+		WithResourceLimits(s.ResourceLimits).
+		WithDisplaySync().
+		OnStart(s.setLastProgram)
+	if s.GoroutineDump {
+		builder.WithGoroutineDump()
+	}
+	return builder.Exec()
+}
@@ -0,0 +1,41 @@
+package goexec
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunFile(t *testing.T) {
+	s := newTestState(t)
+	toolDir := filepath.Join(t.TempDir(), "tool")
+	require.NoError(t, os.MkdirAll(toolDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(toolDir, "go.mod"), []byte("module example.com/tool\n\ngo 1.20\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(toolDir, "main.go"), []byte(`package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() { fmt.Println("args:", strings.Join(os.Args[1:], ",")) }
+`), 0600))
+	s.Args = []string{"-n", "3"}
+
+	msg := newTestMessage()
+	require.NoError(t, s.RunFile(msg, toolDir))
+	assert.Contains(t, strings.Join(msg.Published(), ""), "args: -n,3")
+	msg = newTestMessage()
+	require.NoError(t, s.RunFile(msg, filepath.Join(toolDir, "main.go")))
+	assert.Contains(t, strings.Join(msg.Published(), ""), "args: -n,3")
+
+	assert.Error(t, s.RunFile(newTestMessage(), filepath.Join(toolDir, "go.mod")))
+	assert.Error(t, s.RunFile(newTestMessage(), filepath.Join(toolDir, "missing")))
+	require.NoError(t, os.WriteFile(filepath.Join(toolDir, "main.go"), []byte("package main\nfunc main() { x }\n"), 0600))
+	assert.Error(t, s.RunFile(newTestMessage(), toolDir))
+}
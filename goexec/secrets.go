@@ -0,0 +1,76 @@
+package goexec
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// This file implements the handling of secrets: values (API tokens, passwords, etc.) that
+// should reach the executed program, but should never be written to disk.
+//
+// Security model:
+//
+//   - Secrets are only kept in memory, in State.Secrets. They are never written to `main.go`,
+//     nor stored in Declarations -- so they don't get carried in the generated source code.
+//   - They are passed to the executed program (and only to it) as environment variables, at
+//     Execute time. Shell commands (`!`) and the Go toolchain (`go build`, `go get`, etc.)
+//     don't see them.
+//   - Any occurrence of a secret value in error reports displayed by the kernel is redacted.
+//   - The latest setting of a variable takes precedence: `%env VAR value` after `%secret VAR`
+//     unsets the secret, and `%secret VAR` after `%env VAR value` overrides it for the program.
+//
+// It doesn't protect against a program that prints its own environment, or code that
+// explicitly writes the value somewhere.
+
+// RedactedSecret is what is displayed in place of a secret value.
+const RedactedSecret = "********"
+
+// SetSecret sets the value of a secret to be passed to the executed program as the
+// environment variable `name`.
+func (s *State) SetSecret(name, value string) {
+	if s.Secrets == nil {
+		s.Secrets = make(map[string]string)
+	}
+	s.Secrets[name] = value
+}
+
+// secretsEnv returns the secrets formatted as environment variables ("NAME=value"), sorted by name.
+func (s *State) secretsEnv() []string {
+	if len(s.Secrets) == 0 {
+		return nil
+	}
+	env := make([]string, 0, len(s.Secrets))
+	for name, value := range s.Secrets {
+		env = append(env, fmt.Sprintf("%s=%s", name, value))
+	}
+	sort.Strings(env)
+	return env
+}
+
+// UnsetSecret removes the secret `name`, if it is set.
+func (s *State) UnsetSecret(name string) {
+	delete(s.Secrets, name)
+}
+
+// RedactSecrets returns text with any occurrences of secret values replaced by RedactedSecret.
+//
+// Longer values are redacted first, so a secret that contains another one is fully redacted.
+func (s *State) RedactSecrets(text string) string {
+	values := make([]string, 0, len(s.Secrets))
+	for _, value := range s.Secrets {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+	for _, value := range values {
+		text = strings.ReplaceAll(text, value, RedactedSecret)
+	}
+	return text
+}
@@ -0,0 +1,25 @@
+package goexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactSecrets(t *testing.T) {
+	s := &State{}
+	s.SetSecret("TOKEN", "abc")
+	s.SetSecret("LONG_TOKEN", "xxabcxx")
+	s.SetSecret("EMPTY", "")
+	// The longer secret contains the shorter one: it must be redacted as a whole, whatever the
+	// order of the map iteration.
+	for ii := 0; ii < 20; ii++ {
+		assert.Equal(t, "token="+RedactedSecret+", other="+RedactedSecret,
+			s.RedactSecrets("token=xxabcxx, other=abc"))
+	}
+	assert.Equal(t, []string{"EMPTY=", "LONG_TOKEN=xxabcxx", "TOKEN=abc"}, s.secretsEnv())
+
+	s.UnsetSecret("LONG_TOKEN")
+	assert.Equal(t, "token=xx"+RedactedSecret+"xx", s.RedactSecrets("token=xxabcxx"))
+	assert.Equal(t, []string{"EMPTY=", "TOKEN=abc"}, s.secretsEnv())
+}
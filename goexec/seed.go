@@ -0,0 +1,55 @@
+package goexec
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// This file implements `%seed`: a deterministic seed for the randomized examples of a notebook,
+// so they produce the same output on every run. Programs read it from the variable SeedVariable
+// (e.g.: `rand.New(rand.NewSource(GonbSeed))`) or from the environment variable SeedEnv.
+
+const (
+	// SeedVariable is the name of the package-level variable (int64) set to the seed.
+	SeedVariable = "GonbSeed"
+
+	// SeedEnv is the environment variable set to the seed for the executed programs.
+	SeedEnv = "GONB_SEED"
+)
+
+// SetSeed sets the seed: it declares (or updates) the variable SeedVariable, and sets SeedEnv for
+// the executed programs. If goMaxProcs > 0, programs are also executed with GOMAXPROCS set to it,
+// which makes scheduling dependent results more reproducible.
+func (s *State) SetSeed(seed int64, goMaxProcs int) {
+	s.Seed = &seed
+	s.SeedGoMaxProcs = goMaxProcs
+	s.Decls.Variables[SeedVariable] = &Variable{
+		Cursor: NoCursor, Key: SeedVariable, Name: SeedVariable,
+		TypeDefinition: "int64", ValueDefinition: strconv.FormatInt(seed, 10),
+	}
+}
+
+// ClearSeed removes the seed set with SetSeed, along with its variable.
+func (s *State) ClearSeed() {
+	s.Seed = nil
+	s.SeedGoMaxProcs = 0
+	delete(s.Decls.Variables, SeedVariable)
+}
+
+// seedEnv returns the environment variables set for the executed programs by SetSeed.
+func (s *State) seedEnv() []string {
+	if s.Seed == nil {
+		return nil
+	}
+	env := []string{fmt.Sprintf("%s=%d", SeedEnv, *s.Seed)}
+	if s.SeedGoMaxProcs > 0 {
+		env = append(env, fmt.Sprintf("GOMAXPROCS=%d", s.SeedGoMaxProcs))
+	}
+	return env
+}
+
+// programEnv returns the environment variables set for the executed programs, on top of the
+// kernel's: secrets, GODEBUG settings and the seed.
+func (s *State) programEnv() []string {
+	return append(append(s.secretsEnv(), s.goDebugEnv()...), s.seedEnv()...)
+}
@@ -0,0 +1,30 @@
+package goexec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeed(t *testing.T) {
+	s := newTestState(t)
+	cell := []string{`import ("fmt"; "os"; "runtime")`, "%%",
+		`fmt.Printf("seed=%d env=%s procs=%d\n", GonbSeed, os.Getenv("GONB_SEED"), runtime.GOMAXPROCS(0))`}
+
+	s.SetSeed(42, 1)
+	msg := newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, cell, nil))
+	assert.Contains(t, strings.Join(msg.Published(), ""), "seed=42 env=42 procs=1\n")
+
+	// Updated when set again.
+	s.SetSeed(7, 0)
+	msg = newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, cell, nil))
+	assert.Contains(t, strings.Join(msg.Published(), ""), "seed=7 env=7")
+
+	s.ClearSeed()
+	assert.NotContains(t, s.Decls.Variables, SeedVariable)
+	assert.Empty(t, s.seedEnv())
+}
@@ -0,0 +1,381 @@
+package goexec
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+)
+
+// This file implements named sessions, see `%session`: the memorized declarations and the
+// configuration of the State are saved to a file, to be loaded later -- also after the kernel is
+// restarted --, so one can keep several independent contexts and switch between them.
+//
+// Values that may be sensitive are never saved: not the secrets, nor the values of the environment
+// variables set with `%env` -- only their names, so that when the session is loaded the user is
+// asked to set them again.
+
+// sessionExt is the extension of the session files, in State.SessionsDir.
+const sessionExt = ".json"
+
+// reSessionName matches valid session names: they are used as file names.
+var reSessionName = regexp.MustCompile(`^[\w.-]+$`)
+
+// Session is the serialized form of the declarations and configuration of a State.
+type Session struct {
+	sessionDecls
+
+	Prelude          *sessionDecls            `json:"prelude,omitempty"`
+	ConstrainedDecls map[string]*sessionDecls `json:"constrained_decls,omitempty"`
+	// Files maps the files written with `%%file` (see State.Files) to their contents.
+	Files map[string]string `json:"files,omitempty"`
+
+	Args       []string          `json:"args,omitempty"`
+	BuildFlags []string          `json:"build_flags,omitempty"`
+	LinkerVars map[string]string `json:"linker_vars,omitempty"`
+	// EnvNames are the names of the environment variables set with `%env`, sorted. Their values are
+	// not saved.
+	EnvNames          []string          `json:"env_names,omitempty"`
+	GoDebug           []string          `json:"godebug,omitempty"`
+	ImportPreferences map[string]string `json:"import_preferences,omitempty"`
+	AutoGet           bool              `json:"autoget"`
+	AutoPrint         bool              `json:"autoprint"`
+	SkipGoImports     bool              `json:"skip_goimports"`
+	StubMainBody      string            `json:"stub_main_body"`
+	Seed              *int64            `json:"seed,omitempty"`
+	SeedGoMaxProcs    int               `json:"seed_gomaxprocs,omitempty"`
+}
+
+// sessionDecls is the serialized form of Declarations.
+type sessionDecls struct {
+	Functions map[string]*Function `json:"functions,omitempty"`
+	Variables map[string]*Variable `json:"variables,omitempty"`
+	Types     map[string]*TypeDecl `json:"types,omitempty"`
+	Imports   map[string]*Import   `json:"imports,omitempty"`
+	Constants []sessionConstant    `json:"constants,omitempty"`
+}
+
+// sessionConstant is the serialized form of a Constant: the links to the previous and next
+// constants of its block are given by their keys.
+type sessionConstant struct {
+	Key, Name                       string
+	TypeDefinition, ValueDefinition string
+	Prev, Next                      string
+	SameSpec                        bool
+}
+
+// SessionInfo describes a saved session, see ListSessions.
+type SessionInfo struct {
+	Name    string
+	ModTime time.Time
+}
+
+// sessionsDir returns State.SessionsDir, defaulting to "gonb/sessions" in the user's configuration
+// directory.
+func (s *State) sessionsDir() (string, error) {
+	if s.SessionsDir != "" {
+		return s.SessionsDir, nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", errors.Wrapf(err, "no directory to save sessions, set one with goexec.WithSessionsDir")
+	}
+	return filepath.Join(configDir, "gonb", "sessions"), nil
+}
+
+// sessionPath returns the path of the file of the session with the given name.
+func (s *State) sessionPath(name string) (string, error) {
+	if !reSessionName.MatchString(name) || strings.Trim(name, ".") == "" {
+		return "", errors.Errorf("invalid session name %q: use letters, digits, \"_\", \".\" and \"-\"", name)
+	}
+	dir, err := s.sessionsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+sessionExt), nil
+}
+
+// newSessionDecls returns the serialized form of a copy of decls.
+func newSessionDecls(decls *Declarations) sessionDecls {
+	decls = decls.Copy()
+	sd := sessionDecls{
+		Functions: decls.Functions,
+		Variables: decls.Variables,
+		Types:     decls.Types,
+		Imports:   decls.Imports,
+	}
+	keys := make([]string, 0, len(decls.Constants))
+	for key := range decls.Constants {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		c := decls.Constants[key]
+		sc := sessionConstant{
+			Key: c.Key, Name: c.Name, TypeDefinition: c.TypeDefinition, ValueDefinition: c.ValueDefinition,
+			SameSpec: c.SameSpec,
+		}
+		if c.Prev != nil {
+			sc.Prev = c.Prev.Key
+		}
+		if c.Next != nil {
+			sc.Next = c.Next.Key
+		}
+		sd.Constants = append(sd.Constants, sc)
+	}
+	return sd
+}
+
+// declarations returns the Declarations serialized in sd.
+func (sd *sessionDecls) declarations() *Declarations {
+	decls := NewDeclarations()
+	copyMap(decls.Functions, sd.Functions)
+	copyMap(decls.Variables, sd.Variables)
+	copyMap(decls.Types, sd.Types)
+	copyMap(decls.Imports, sd.Imports)
+	for _, sc := range sd.Constants {
+		decls.Constants[sc.Key] = &Constant{
+			Cursor: NoCursor, Key: sc.Key, Name: sc.Name,
+			TypeDefinition: sc.TypeDefinition, ValueDefinition: sc.ValueDefinition, SameSpec: sc.SameSpec,
+		}
+	}
+	for _, sc := range sd.Constants {
+		c := decls.Constants[sc.Key]
+		c.Prev = decls.Constants[sc.Prev]
+		c.Next = decls.Constants[sc.Next]
+	}
+	return decls
+}
+
+// newSession returns the Session with the current declarations and configuration of the State. It
+// reads the contents of the files written with `%%file`.
+func (s *State) newSession() (*Session, error) {
+	session := &Session{
+		sessionDecls:      newSessionDecls(s.Decls),
+		Args:              s.Args,
+		BuildFlags:        s.BuildFlags,
+		LinkerVars:        s.LinkerVars,
+		GoDebug:           s.GoDebug,
+		ImportPreferences: s.ImportPreferences,
+		AutoGet:           s.AutoGet,
+		AutoPrint:         s.AutoPrint,
+		SkipGoImports:     s.SkipGoImports,
+		StubMainBody:      s.StubMainBody,
+		Seed:              s.Seed,
+		SeedGoMaxProcs:    s.SeedGoMaxProcs,
+	}
+	if s.Prelude != nil {
+		prelude := newSessionDecls(s.Prelude)
+		session.Prelude = &prelude
+	}
+	for constraint, decls := range s.ConstrainedDecls {
+		if session.ConstrainedDecls == nil {
+			session.ConstrainedDecls = make(map[string]*sessionDecls, len(s.ConstrainedDecls))
+		}
+		sd := newSessionDecls(decls)
+		session.ConstrainedDecls[constraint] = &sd
+	}
+	for _, relPath := range s.ListFiles() {
+		content, err := os.ReadFile(filepath.Join(s.TempDir, filepath.FromSlash(relPath)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read file %q", relPath)
+		}
+		if session.Files == nil {
+			session.Files = make(map[string]string, len(s.Files))
+		}
+		session.Files[relPath] = string(content)
+	}
+	for name := range s.Env {
+		session.EnvNames = append(session.EnvNames, name)
+	}
+	sort.Strings(session.EnvNames)
+	return session, nil
+}
+
+// apply the session to the State: it replaces the declarations, the prelude, the files and the
+// configuration saved.
+//
+// The environment variables are not changed, see applySessionEnv.
+func (s *State) applySession(session *Session) error {
+	s.Decls = session.sessionDecls.declarations()
+	s.lastMainDecl = nil
+	s.Prelude = nil
+	if session.Prelude != nil {
+		s.Prelude = session.Prelude.declarations()
+	}
+	s.ConstrainedDecls = nil
+	for constraint, sd := range session.ConstrainedDecls {
+		if s.ConstrainedDecls == nil {
+			s.ConstrainedDecls = make(map[string]*Declarations, len(session.ConstrainedDecls))
+		}
+		s.ConstrainedDecls[constraint] = sd.declarations()
+	}
+
+	s.Args = session.Args
+	s.BuildFlags = session.BuildFlags
+	s.LinkerVars = session.LinkerVars
+	s.GoDebug = session.GoDebug
+	s.ImportPreferences = session.ImportPreferences
+	s.AutoGet = session.AutoGet
+	s.AutoPrint = session.AutoPrint
+	s.SkipGoImports = session.SkipGoImports
+	s.StubMainBody = session.StubMainBody
+	if s.StubMainBody == "" {
+		s.StubMainBody = DefaultStubMainBody
+	}
+	s.Seed = session.Seed
+	s.SeedGoMaxProcs = session.SeedGoMaxProcs
+
+	if err := s.ClearFiles(); err != nil {
+		return err
+	}
+	for relPath, content := range session.Files {
+		if err := s.WriteFile(relPath, strings.Split(strings.TrimSuffix(content, "\n"), "\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applySessionEnv keeps the environment variables set with `%env` that are named in names, and
+// unsets the others, so programs don't see variables of the previous session. It returns the names
+// not set, whose values the user has to set again.
+func (s *State) applySessionEnv(names []string) (unset []string) {
+	inSession := make(map[string]bool, len(names))
+	for _, name := range names {
+		inSession[name] = true
+		if _, found := s.Env[name]; !found {
+			unset = append(unset, name)
+		}
+	}
+	for name := range s.Env {
+		if !inSession[name] {
+			_ = os.Unsetenv(name)
+			delete(s.Env, name)
+		}
+	}
+	return unset
+}
+
+// restoreEnv sets back the environment variables set with `%env`, after applySessionEnv.
+func (s *State) restoreEnv(env map[string]string) {
+	s.Env = env
+	for name, value := range env {
+		_ = os.Setenv(name, value)
+	}
+}
+
+// SaveSession saves the current declarations and configuration (program arguments, build flags,
+// prelude, files written with `%%file`, etc.) as the session with the given name, replacing any
+// previous one. Secrets and the values of the environment variables set with `%env` are not saved,
+// only the names of the latter.
+func (s *State) SaveSession(name string) error {
+	sessionPath, err := s.sessionPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(sessionPath), 0700); err != nil {
+		return errors.Wrapf(err, "failed to create sessions directory %q", filepath.Dir(sessionPath))
+	}
+	session, err := s.newSession()
+	if err != nil {
+		return errors.WithMessagef(err, "failed to save session %q", name)
+	}
+	content, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize session %q", name)
+	}
+	if err := os.WriteFile(sessionPath, content, 0600); err != nil {
+		return errors.Wrapf(err, "failed to save session %q", name)
+	}
+	return nil
+}
+
+// ListSessions returns the saved sessions, sorted by name.
+func (s *State) ListSessions() ([]SessionInfo, error) {
+	dir, err := s.sessionsDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to list sessions in %q", dir)
+	}
+	var sessions []SessionInfo
+	for _, entry := range entries {
+		name, found := strings.CutSuffix(entry.Name(), sessionExt)
+		if !found || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed in the meantime.
+		}
+		sessions = append(sessions, SessionInfo{Name: name, ModTime: info.ModTime()})
+	}
+	return sessions, nil
+}
+
+// LoadSession replaces the current declarations and configuration by the ones of the saved session
+// with the given name, and compiles them to validate them. If they fail to compile, the previous
+// declarations and configuration are restored.
+//
+// The environment variables set with `%env` that the session doesn't name are unset. It returns the
+// names of the variables of the session that are not set: their values are not saved, so they must
+// be set again.
+func (s *State) LoadSession(msg kernel.Message, name string) (unsetEnv []string, err error) {
+	if err := s.GoToolchainError(); err != nil {
+		return nil, err
+	}
+	sessionPath, err := s.sessionPath(name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(sessionPath)
+	if os.IsNotExist(err) {
+		return nil, errors.Errorf("session %q not found, see `%%session list`", name)
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to read session %q", name)
+	}
+	session := &Session{}
+	if err := json.Unmarshal(content, session); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse session %q in %q", name, sessionPath)
+	}
+
+	previous, err := s.newSession()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "in goexec.LoadSession() while saving the current session")
+	}
+	previousEnv := make(map[string]string, len(s.Env))
+	copyMap(previousEnv, s.Env)
+	restore := func(err error) error {
+		if restoreErr := s.applySession(previous); restoreErr != nil {
+			err = errors.WithMessagef(err, "and failed to restore the previous session: %v", restoreErr)
+		}
+		s.restoreEnv(previousEnv)
+		return err
+	}
+	if err := s.applySession(session); err != nil {
+		return nil, restore(errors.WithMessagef(err, "in goexec.LoadSession() while loading session %q", name))
+	}
+	unsetEnv = s.applySessionEnv(session.EnvNames)
+	if _, err := s.createMainFromDecls(s.withImportPreferences(s.Decls), s.stubMain()); err != nil {
+		return nil, restore(errors.WithMessagef(err, "in goexec.LoadSession() while generating main.go"))
+	}
+	err = s.GoImports(msg)
+	if err == nil {
+		err = s.Compile(msg)
+	}
+	if err != nil {
+		return nil, restore(errors.WithMessagef(err, "session %q failed to compile, the previous declarations are kept", name))
+	}
+	return unsetEnv, nil
+}
@@ -0,0 +1,98 @@
+package goexec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessions(t *testing.T) {
+	s := newTestState(t, WithSessionsDir(t.TempDir()))
+	msg := newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{
+		"const (", "\tA = iota", "\tB", ")",
+		"type Point struct{ X, Y int }",
+		"var origin = Point{A, B}",
+		"func norm(p Point) int { return p.X*p.X + p.Y*p.Y }",
+	}, nil))
+	s.Args = []string{"-v"}
+	require.NoError(t, s.AddPrelude(msg, []string{"func double(x int) int { return 2 * x }"}))
+	require.NoError(t, s.ExecuteCell(msg, []string{"//go:build linux || !linux", "func always() int { return 1 }"}, nil))
+	require.NoError(t, s.WriteFile("data/points.txt", []string{"1 2", "3 4"}))
+	const envA, envB = "GONB_TEST_SESSION_A", "GONB_TEST_SESSION_B"
+	t.Setenv(envA, "secret value")
+	t.Setenv(envB, "")
+	s.Env = map[string]string{envA: "secret value"}
+
+	sessions, err := s.ListSessions()
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+	require.NoError(t, s.SaveSession("geometry"))
+	for _, invalid := range []string{"", "..", "a/b"} {
+		assert.Error(t, s.SaveSession(invalid), "session name %q", invalid)
+	}
+	sessions, err = s.ListSessions()
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "geometry", sessions[0].Name)
+	content, err := os.ReadFile(filepath.Join(s.SessionsDir, "geometry.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), envA)
+	assert.NotContains(t, string(content), "secret value")
+
+	// Switch to a new context, and back.
+	s.Reset()
+	s.ClearPrelude()
+	require.NoError(t, s.ClearFiles())
+	s.Args = nil
+	s.Env = map[string]string{envB: "b"}
+	require.NoError(t, os.Setenv(envB, "b"))
+	require.NoError(t, s.ExecuteCell(msg, []string{"var other = 1"}, nil))
+	require.NoError(t, s.SaveSession("other"))
+	unsetEnv, err := s.LoadSession(msg, "geometry")
+	require.NoError(t, err)
+	assert.Equal(t, []string{envA}, unsetEnv)
+	assert.Empty(t, s.Env)
+	_, isSet := os.LookupEnv(envB)
+	assert.False(t, isSet, "variables of the previous session are unset")
+	assert.Equal(t, []string{"-v"}, s.Args)
+	assert.NotContains(t, s.Decls.Variables, "other")
+	require.Contains(t, s.Decls.Constants, "B")
+	require.NotNil(t, s.Decls.Constants["B"].Prev)
+	assert.Equal(t, "A", s.Decls.Constants["B"].Prev.Key)
+	assert.Equal(t, []string{"double"}, s.PreludeKeys())
+	assert.Len(t, s.ConstrainedDecls, 1)
+	assert.Equal(t, []string{"data/points.txt"}, s.ListFiles())
+	content, err = os.ReadFile(filepath.Join(s.TempDir, "data", "points.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "1 2\n3 4\n", string(content))
+	require.NoError(t, s.ExecuteCell(msg, []string{"%%", "_ = norm(origin) + double(always())"}, nil))
+
+	unsetEnv, err = s.LoadSession(msg, "other")
+	require.NoError(t, err)
+	assert.Equal(t, []string{envB}, unsetEnv)
+	assert.Contains(t, s.Decls.Variables, "other")
+	assert.NotContains(t, s.Decls.Functions, "norm")
+	assert.Empty(t, s.PreludeKeys())
+	assert.Empty(t, s.ConstrainedDecls)
+	assert.Empty(t, s.ListFiles())
+	_, err = os.Stat(filepath.Join(s.TempDir, "data", "points.txt"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = s.LoadSession(msg, "missing")
+	assert.Error(t, err)
+
+	// A session that fails to compile doesn't change the environment of the kernel.
+	s.Decls.Variables["broken"] = &Variable{Key: "broken", Name: "broken", ValueDefinition: "undefinedName"}
+	require.NoError(t, s.SaveSession("broken"))
+	delete(s.Decls.Variables, "broken")
+	s.Env = map[string]string{envB: "kept"}
+	require.NoError(t, os.Setenv(envB, "kept"))
+	_, err = s.LoadSession(msg, "broken")
+	require.Error(t, err)
+	assert.Equal(t, "kept", os.Getenv(envB))
+	assert.Equal(t, map[string]string{envB: "kept"}, s.Env)
+	assert.Contains(t, s.Decls.Variables, "other")
+}
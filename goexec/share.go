@@ -0,0 +1,76 @@
+package goexec
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// PlaygroundShareURL is the Go Playground endpoint used by `%share` to share programs.
+	PlaygroundShareURL = "https://go.dev/_/share"
+
+	// PlaygroundURL is the prefix of the links to shared programs, completed with their id.
+	PlaygroundURL = "https://go.dev/play/p/"
+
+	// PlaygroundMaxSize is the maximum size of programs accepted by the Go Playground.
+	PlaygroundMaxSize = 64 * 1024
+
+	// PlaygroundTimeout is the timeout to share a program to the Go Playground.
+	PlaygroundTimeout = 30 * time.Second
+)
+
+// Share submits the last generated `main.go` (the one of the last executed cell) to the
+// Go Playground, and displays the link to it.
+//
+// Notice the Go Playground doesn't have access to the notebook's sub-packages (see `%%package`),
+// and may not support all external modules.
+func (s *State) Share(msg kernel.Message) error {
+	content, err := os.ReadFile(s.MainPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("no program to share yet, execute a cell first")
+		}
+		return errors.Wrapf(err, "reading %q to share", s.MainPath())
+	}
+	if len(content) > PlaygroundMaxSize {
+		return errors.Errorf("program too large to share: %d bytes, the Go Playground accepts at most %d bytes",
+			len(content), PlaygroundMaxSize)
+	}
+	link, err := shareToPlayground(content)
+	if err != nil {
+		return err
+	}
+	return kernel.PublishDisplayDataWithHTML(msg,
+		fmt.Sprintf(`Shared at <a href="%s" target="_blank">%s</a>`, html.EscapeString(link), html.EscapeString(link)))
+}
+
+// shareToPlayground posts the content to the Go Playground and returns the link to it.
+func shareToPlayground(content []byte) (string, error) {
+	client := &http.Client{Timeout: PlaygroundTimeout}
+	resp, err := client.Post(PlaygroundShareURL, "text/plain; charset=utf-8", bytes.NewReader(content))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to share program to the Go Playground (%s)", PlaygroundShareURL)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read response from the Go Playground (%s)", PlaygroundShareURL)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("the Go Playground (%s) failed to share the program: %s: %s",
+			PlaygroundShareURL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	id := strings.TrimSpace(string(body))
+	if id == "" {
+		return "", errors.Errorf("the Go Playground (%s) returned an empty id", PlaygroundShareURL)
+	}
+	return PlaygroundURL + id, nil
+}
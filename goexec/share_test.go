@@ -0,0 +1,35 @@
+package goexec
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShareToPlayground(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		if received == "" {
+			http.Error(w, "empty program", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte("abc123\n"))
+	}))
+	defer server.Close()
+	defer func(url string) { PlaygroundShareURL = url }(PlaygroundShareURL)
+	PlaygroundShareURL = server.URL
+
+	link, err := shareToPlayground([]byte("package main\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "package main\n", received)
+	assert.Equal(t, PlaygroundURL+"abc123", link)
+
+	_, err = shareToPlayground(nil)
+	assert.ErrorContains(t, err, "empty program")
+}
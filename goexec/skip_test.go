@@ -0,0 +1,40 @@
+package goexec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkipCell(t *testing.T) {
+	s := newTestState(t)
+
+	// Declarations and main are kept, but nothing is executed.
+	s.Cell.Skip = true
+	msg := newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{
+		"func greeting() string { return \"hello\" }",
+		"%%",
+		"println(\"should not run\")",
+	}, nil))
+	s.ResetCell()
+	output := strings.Join(msg.Published(), "")
+	assert.NotContains(t, output, "should not run")
+	assert.Contains(t, output, "not executed (%%skip)")
+	require.NotNil(t, s.lastMainDecl)
+	assert.Contains(t, s.lastMainDecl.Definition, "should not run")
+	assert.Contains(t, s.Decls.Functions, "greeting")
+
+	// Declarations are available to the following cells.
+	msg = newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{"%%", "println(greeting())"}, nil))
+	assert.Contains(t, strings.Join(msg.Published(), ""), "hello")
+
+	// Cells that fail to compile are not kept.
+	s.Cell.Skip = true
+	require.Error(t, s.ExecuteCell(newTestMessage(), []string{"func broken() int { return \"x\" }"}, nil))
+	s.ResetCell()
+	assert.NotContains(t, s.Decls.Functions, "broken")
+}
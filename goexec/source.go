@@ -0,0 +1,156 @@
+package goexec
+
+import (
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"html"
+	"os"
+	"strings"
+
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+)
+
+// This file implements `%source`: it displays the generated main.go as it was last rendered --
+// whether it compiled or not --, with line numbers, syntax highlighting and the errors of the last
+// compilation inline.
+
+// sourceTokenStyles maps the kinds of tokens highlighted to their CSS style.
+var sourceTokenStyles = map[string]string{
+	"keyword": "color:#0000ff",
+	"string":  "color:#a31515",
+	"number":  "color:#098658",
+	"comment": "color:#008000;font-style:italic",
+}
+
+// sourceTokenKind returns the kind of token highlighted (see sourceTokenStyles), or "" if the token
+// is not highlighted.
+func sourceTokenKind(tok token.Token) string {
+	switch {
+	case tok.IsKeyword():
+		return "keyword"
+	case tok == token.STRING || tok == token.CHAR:
+		return "string"
+	case tok == token.INT || tok == token.FLOAT || tok == token.IMAG:
+		return "number"
+	case tok == token.COMMENT:
+		return "comment"
+	}
+	return ""
+}
+
+// highlightGoSource returns the lines of the Go source, HTML escaped, with the tokens highlighted
+// with spans. Tokens spanning multiple lines (e.g.: raw strings) are highlighted in each line, so
+// each line can be displayed separately.
+func highlightGoSource(src []byte) []string {
+	var (
+		sb     strings.Builder
+		copied int // Position in src up to which the source was already copied.
+		s      scanner.Scanner
+	)
+	fileSet := token.NewFileSet()
+	file := fileSet.AddFile("main.go", -1, len(src))
+	s.Init(file, src, nil /* errors are ignored */, scanner.ScanComments)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		kind := sourceTokenKind(tok)
+		if kind == "" {
+			continue // Copied verbatim along with the next highlighted token.
+		}
+		offset := file.Offset(pos)
+		length := len(tok.String())
+		if lit != "" {
+			length = len(lit)
+		}
+		if offset < copied || offset+length > len(src) {
+			continue
+		}
+		sb.WriteString(html.EscapeString(string(src[copied:offset])))
+		for ii, part := range strings.Split(string(src[offset:offset+length]), "\n") {
+			if ii > 0 {
+				sb.WriteString("\n")
+			}
+			if part != "" {
+				fmt.Fprintf(&sb, `<span style="%s">%s</span>`, sourceTokenStyles[kind], html.EscapeString(part))
+			}
+		}
+		copied = offset + length
+	}
+	sb.WriteString(html.EscapeString(string(src[copied:])))
+	return strings.Split(sb.String(), "\n")
+}
+
+// DisplaySource displays the generated main.go, as it was last rendered (the last successful or
+// attempted compilation, or `%dryrun`), with line numbers and syntax highlighting. The errors of the
+// last compilation, if any, are displayed inline, below the lines they refer to.
+func (s *State) DisplaySource(msg kernel.Message) error {
+	content, err := os.ReadFile(s.MainPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("no main.go generated yet, execute a cell first")
+		}
+		return errors.Wrapf(err, "reading %q", s.MainPath())
+	}
+	source := s.RedactSecrets(string(content))
+	rawLines := strings.Split(source, "\n")
+	lines := highlightGoSource([]byte(source))
+
+	// Diagnostics of main.go per line (1-based).
+	diagnostics := make(map[int][]Diagnostic)
+	if buildErr := s.LastError(); buildErr != nil {
+		for _, d := range buildErr.Diagnostics {
+			if d.File == "main.go" {
+				diagnostics[d.Line] = append(diagnostics[d.Line], d)
+			}
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`<pre style="line-height:1.3">`)
+	for ii, line := range lines {
+		if ii == len(lines)-1 && line == "" {
+			break // File ends with a newline.
+		}
+		lineNum := ii + 1
+		lineDiagnostics := diagnostics[lineNum]
+		if len(lineDiagnostics) > 0 {
+			sb.WriteString(`<span style="background-color:#fdd">`)
+		}
+		fmt.Fprintf(&sb, `<span style="color:#888;user-select:none">%4d  </span>%s`, lineNum, line)
+		if len(lineDiagnostics) > 0 {
+			sb.WriteString(`</span>`)
+		}
+		sb.WriteString("\n")
+		for _, d := range lineDiagnostics {
+			fmt.Fprintf(&sb, `<span style="color:#c00;font-weight:bold">      %s^ %s</span>`+"\n",
+				caretIndent(rawLines[ii], d.Column), html.EscapeString(d.Message))
+		}
+	}
+	sb.WriteString("</pre>")
+	return kernel.PublishDisplayDataWithHTML(msg, sb.String())
+}
+
+// caretIndent returns the indentation to place a caret under the given column (1-based, in bytes)
+// of line: tabs are kept, so it aligns with the line displayed above.
+func caretIndent(line string, column int) string {
+	if column <= 1 {
+		return ""
+	}
+	column--
+	if column > len(line) {
+		column = len(line)
+	}
+	var sb strings.Builder
+	for _, c := range line[:column] {
+		if c == '\t' {
+			sb.WriteByte('\t')
+		} else {
+			sb.WriteByte(' ')
+		}
+	}
+	return sb.String()
+}
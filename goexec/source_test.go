@@ -0,0 +1,24 @@
+package goexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHighlightGoSource(t *testing.T) {
+	lines := highlightGoSource([]byte("func f() string {\n\treturn `a<\nb` // x\n}\n"))
+	assert.Equal(t, []string{
+		`<span style="color:#0000ff">func</span> f() string {`,
+		"\t" + `<span style="color:#0000ff">return</span> <span style="color:#a31515">` + "`a&lt;</span>",
+		`<span style="color:#a31515">b` + "`</span> " + `<span style="color:#008000;font-style:italic">// x</span>`,
+		"}",
+		"",
+	}, lines)
+}
+
+func TestCaretIndent(t *testing.T) {
+	assert.Equal(t, "", caretIndent("\tx := 1", 0))
+	assert.Equal(t, "\t   ", caretIndent("\tx := 1", 5))
+	assert.Equal(t, "\t   ", caretIndent("\tx :", 100))
+}
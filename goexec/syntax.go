@@ -0,0 +1,56 @@
+package goexec
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+)
+
+// This file implements focused reporting of syntax errors: only the first one is reported, since
+// the following ones are often a cascade of the first. Syntax errors are detected with `go/parser`,
+// before the heavier `goimports` and `go build` steps.
+
+// focusSyntaxError returns the message of the first error of a `go/parser` error, and the number of
+// errors omitted. If err is not from `go/parser`, its message is returned as is.
+//
+// If cellLines is given, it maps line numbers (0-based) in the parsed file to the lines in the cell,
+// and the line in the cell is appended to the message.
+func focusSyntaxError(err error, cellLines map[int]int) (message string, omitted int) {
+	errList, ok := err.(scanner.ErrorList)
+	if !ok || len(errList) == 0 {
+		return err.Error(), 0
+	}
+	errList.Sort()
+	first := errList[0]
+	message = first.Error()
+	if cellLine, found := cellLines[first.Pos.Line-1]; found {
+		message = fmt.Sprintf("%s (cell line %d)", message, cellLine+1)
+	}
+	return message, len(errList) - 1
+}
+
+// reportSyntaxError displays the focused syntax error (see focusSyntaxError) if msg is not nil, and
+// returns it as an error.
+func (s *State) reportSyntaxError(msg kernel.Message, err error, cellLines map[int]int) error {
+	message, omitted := focusSyntaxError(err, cellLines)
+	if msg != nil {
+		report := message
+		if omitted > 0 {
+			report = fmt.Sprintf("%s\n(%d more syntax errors omitted, they are often caused by the first one)", message, omitted)
+		}
+		s.DisplayErrorWithContext(msg, report)
+	}
+	return errors.Errorf("syntax error: %s", message)
+}
+
+// checkSyntax parses the generated main.go, and reports any syntax errors.
+func (s *State) checkSyntax(msg kernel.Message) error {
+	_, err := parser.ParseFile(token.NewFileSet(), s.MainPath(), nil, parser.SkipObjectResolution|parser.AllErrors)
+	if err != nil {
+		return s.reportSyntaxError(msg, err, nil)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package goexec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyntaxErrors(t *testing.T) {
+	s := &State{TempDir: t.TempDir(), Decls: NewDeclarations()}
+	lines := []string{
+		`var x = 1`,
+		`func f() int {`,
+		`	return x +`,
+		`}`,
+		`func g( {`,
+	}
+	_, err := s.createGoFileFromLines(s.MainPath(), lines, nil, NoCursor)
+	require.NoError(t, err)
+	err = s.ParseImportsFromMainGo(nil, NoCursor, NewDeclarations())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "main.go:6:1: expected operand")
+	assert.Contains(t, err.Error(), "(cell line 4)")
+	assert.Equal(t, 1, strings.Count(err.Error(), "main.go:"), "only the first syntax error should be reported: %s", err)
+
+	// The generated main.go is checked as well.
+	require.NoError(t, s.writeLinesToFile(s.MainPath(), linesChanForTest("package main", "func main() {}")))
+	require.NoError(t, s.checkSyntax(nil))
+	require.NoError(t, s.writeLinesToFile(s.MainPath(), linesChanForTest("package main", "func main() {")))
+	err = s.checkSyntax(nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "syntax error:")
+}
+
+func linesChanForTest(lines ...string) <-chan string {
+	ch := make(chan string, len(lines))
+	for _, line := range lines {
+		ch <- line
+	}
+	close(ch)
+	return ch
+}
@@ -0,0 +1,71 @@
+package goexec
+
+import (
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements `%%template`: the body of the cell is a Go text/template that expands into Go
+// code (in the format of a cell: declarations, `%%`, etc.), which is then executed as usual. It is a
+// way to experiment with code generation interactively.
+
+// TemplateData is the data the `%%template` cells are executed with.
+type TemplateData struct {
+	// Args holds the "key=value" arguments given in the `%%template` line.
+	Args map[string]string
+
+	// Env holds the environment variables, including those set with `%env`.
+	Env map[string]string
+}
+
+// templateFuncs are the functions available to the `%%template` cells, besides the text/template
+// builtins.
+var templateFuncs = template.FuncMap{
+	// seq returns the integers from 0 to n-1, to range over: `{{range seq 3}}...{{end}}`.
+	"seq": func(n int) []int {
+		values := make([]int, n)
+		for ii := range values {
+			values[ii] = ii
+		}
+		return values
+	},
+	"upper":     strings.ToUpper,
+	"lower":     strings.ToLower,
+	"join":      strings.Join,
+	"repeat":    strings.Repeat,
+	"split":     strings.Split,
+	"trimSpace": strings.TrimSpace,
+}
+
+// templateCellTransformer implements `%%template [key=value ...]`: it executes the body of the cell
+// as a text/template, with TemplateData, and returns the resulting lines.
+//
+// Errors parsing or executing the template are reported as such, before any compilation.
+func templateCellTransformer(args []string, body []string) ([]string, error) {
+	data := &TemplateData{Args: make(map[string]string, len(args)), Env: make(map[string]string)}
+	for _, arg := range args {
+		key, value, found := strings.Cut(arg, "=")
+		if !found || key == "" {
+			return nil, errors.Errorf("%%%%template arguments must be in the form key=value, got %q", arg)
+		}
+		data.Args[key] = value
+	}
+	for _, entry := range os.Environ() {
+		if key, value, found := strings.Cut(entry, "="); found {
+			data.Env[key] = value
+		}
+	}
+
+	tmpl, err := template.New("cell").Funcs(templateFuncs).Option("missingkey=error").Parse(strings.Join(body, "\n"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "template expansion failed (the cell was not compiled)")
+	}
+	var expanded strings.Builder
+	if err := tmpl.Execute(&expanded, data); err != nil {
+		return nil, errors.Wrapf(err, "template expansion failed (the cell was not compiled)")
+	}
+	return strings.Split(expanded.String(), "\n"), nil
+}
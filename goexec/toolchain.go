@@ -0,0 +1,77 @@
+package goexec
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// GoToolchainError returns an error, with instructions on how to fix it, if the go toolchain is not
+// available. It is checked at start-up, and when a new go binary is set (see SetGoBinary).
+func (s *State) GoToolchainError() error {
+	return s.goToolchainError
+}
+
+// SetGoBinary sets the `go` binary to use. goBinary is resolved using PATH if it doesn't have a
+// path separator.
+//
+// If `go version` fails with it, the error is returned and the current go binary is kept.
+func (s *State) SetGoBinary(goBinary string) error {
+	goPath, err := exec.LookPath(goBinary)
+	if err != nil {
+		return errors.Wrapf(err, "invalid go binary %q", goBinary)
+	}
+	previous := s.GoBinary
+	s.GoBinary = goPath
+	if _, err := s.goVersion(); err != nil {
+		s.GoBinary = previous
+		return errors.WithMessagef(err, "invalid go binary %q, keeping %q", goBinary, previous)
+	}
+	return s.initGoToolchain()
+}
+
+// initGoToolchain checks that `go version` runs with State.GoBinary, caching the version in
+// State.GoVersion, and initializes the Go module in State.TempDir if not yet done.
+//
+// If it fails, the error is also kept in GoToolchainError.
+func (s *State) initGoToolchain() error {
+	s.GoVersion, s.goToolchainError = s.goVersion()
+	if s.goToolchainError != nil {
+		return s.goToolchainError
+	}
+	if _, err := os.Stat(filepath.Join(s.TempDir, "go.mod")); err == nil {
+		return nil
+	}
+	cmd := s.GoCommand("mod", "init", s.Package)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		s.logf("Failed to run `go mod init %s`:\n%s", s.Package, output)
+		s.goToolchainError = errors.Wrapf(err, "failed to run %q: %s", cmd.String(), output)
+	}
+	return s.goToolchainError
+}
+
+// goToolchainHelp is appended to errors of the go toolchain.
+const goToolchainHelp = `
+The Go toolchain is required to compile the cells. Install it following the instructions
+in https://go.dev/doc/install, and make sure "go" is in the PATH of the Jupyter server (and
+restart the kernel), or set its location with "%gobin /path/to/go".`
+
+// goVersion runs `go version` with State.GoBinary, and returns the version (e.g.: "go1.20.3").
+func (s *State) goVersion() (string, error) {
+	if s.GoBinary == "" {
+		return "", errors.New("`go` not found in PATH." + goToolchainHelp)
+	}
+	output, err := s.GoCommand("version").CombinedOutput()
+	if err != nil {
+		return "", errors.Errorf("failed to run `%s version`: %v %s%s", s.GoBinary, err, output, goToolchainHelp)
+	}
+	// Output is in the form "go version go1.20.3 linux/amd64".
+	fields := strings.Fields(string(output))
+	if len(fields) < 3 || fields[0] != "go" || fields[1] != "version" {
+		return "", errors.Errorf("unexpected output from `%s version`: %q", s.GoBinary, output)
+	}
+	return fields[2], nil
+}
@@ -0,0 +1,33 @@
+package goexec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitGoToolchain(t *testing.T) {
+	s := &State{Package: "gonb_test", TempDir: t.TempDir()}
+	err := s.initGoToolchain()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "https://go.dev/doc/install")
+	assert.Equal(t, err, s.GoToolchainError())
+
+	// Invalid go binary: not executable.
+	fakeGo := filepath.Join(t.TempDir(), "go")
+	require.NoError(t, os.WriteFile(fakeGo, []byte("not a binary"), 0600))
+	assert.Error(t, s.SetGoBinary(fakeGo))
+
+	// A working toolchain is kept if the new binary is not a go toolchain.
+	s = newTestState(t)
+	goBinary, goVersion := s.GoBinary, s.GoVersion
+	notGo := filepath.Join(t.TempDir(), "go")
+	require.NoError(t, os.WriteFile(notGo, []byte("#!/bin/sh\necho this is not go\n"), 0700))
+	assert.Error(t, s.SetGoBinary(notGo))
+	assert.Equal(t, goBinary, s.GoBinary)
+	assert.Equal(t, goVersion, s.GoVersion)
+	assert.NoError(t, s.GoToolchainError())
+}
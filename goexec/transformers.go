@@ -0,0 +1,125 @@
+package goexec
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// This file implements cell transformers: cells starting with a `%%<name> {...args...}` line,
+// for a registered name, have their contents transformed into Go code, which is then executed
+// as a normal cell. This allows domain-specific extensions (e.g.: a `%%sql` cell that is
+// converted to Go code that runs a query).
+
+// CellTransformer converts the body of a cell (the lines following the `%%<name> {...args...}`
+// line) into Go code, in the same format of a cell: including `%%` to start the main function,
+// special commands, etc.
+type CellTransformer interface {
+	Transform(args []string, body []string) (goLines []string, err error)
+}
+
+// CellTransformerFunc is an adapter to allow the use of ordinary functions as CellTransformer.
+type CellTransformerFunc func(args []string, body []string) ([]string, error)
+
+// Transform implements CellTransformer.
+func (fn CellTransformerFunc) Transform(args []string, body []string) ([]string, error) {
+	return fn(args, body)
+}
+
+var (
+	muCellTransformers sync.RWMutex
+	cellTransformers   = map[string]CellTransformer{
+		"html":     CellTransformerFunc(htmlCellTransformer),
+		"template": CellTransformerFunc(templateCellTransformer),
+	}
+)
+
+// RegisterCellTransformer registers the transformer for cells starting with `%%<name>`.
+// Registering a transformer with the name of an existing one replaces it.
+func RegisterCellTransformer(name string, transformer CellTransformer) {
+	muCellTransformers.Lock()
+	defer muCellTransformers.Unlock()
+	cellTransformers[name] = transformer
+}
+
+// GetCellTransformer returns the transformer registered for `%%<name>`, or nil if there is none.
+func GetCellTransformer(name string) CellTransformer {
+	muCellTransformers.RLock()
+	defer muCellTransformers.RUnlock()
+	return cellTransformers[name]
+}
+
+// CellTransformerLine returns the line of the `%%<name>` magic of a registered transformer in the
+// cell, or -1 if there is none. Lines in skipLines (special commands) and empty lines are ignored.
+//
+// The rest of the cell after the magic is the body of the transformer, so the magic must come before
+// any Go code -- otherwise an error is returned. The same rule is used by specialcmd.Parse and by
+// ExecuteCell.
+func CellTransformerLine(lines []string, skipLines map[int]bool) (int, error) {
+	insideLiterals := LinesInsideLiterals(lines)
+	codeLine := -1
+	for ii, line := range lines {
+		if skipLines[ii] {
+			continue
+		}
+		line = strings.TrimSpace(line)
+		if line == "" && !insideLiterals[ii] {
+			continue
+		}
+		if name := cellTransformerName(line); !insideLiterals[ii] && name != "" {
+			if codeLine >= 0 {
+				return -1, errors.Errorf("cell transformer %%%%%s in line %d must come before any Go code (found in line %d)",
+					name, ii+1, codeLine+1)
+			}
+			return ii, nil
+		}
+		if codeLine < 0 {
+			codeLine = ii
+		}
+	}
+	return -1, nil
+}
+
+// cellTransformerName returns the name of the transformer if line is a `%%<name> ...` line for a
+// registered transformer, or "" otherwise.
+func cellTransformerName(line string) string {
+	if !strings.HasPrefix(line, "%%") {
+		return ""
+	}
+	parts := strings.Fields(line[2:])
+	if len(parts) == 0 || GetCellTransformer(parts[0]) == nil {
+		return ""
+	}
+	return parts[0]
+}
+
+// transformCell checks whether the cell has the `%%<name>` line of a registered transformer (see
+// CellTransformerLine), in which case it returns the transformed lines, and an empty skipLines.
+// Otherwise, it returns lines and skipLines unchanged.
+func (s *State) transformCell(lines []string, skipLines map[int]bool) ([]string, map[int]bool, error) {
+	lineNum, err := CellTransformerLine(lines, skipLines)
+	if err != nil || lineNum < 0 {
+		return lines, skipLines, err
+	}
+	parts := strings.Fields(strings.TrimSpace(lines[lineNum])[2:])
+	goLines, err := GetCellTransformer(parts[0]).Transform(parts[1:], lines[lineNum+1:])
+	if err != nil {
+		return nil, nil, errors.WithMessagef(err, "in cell transformer %%%%%s", parts[0])
+	}
+	return goLines, make(map[int]bool), nil
+}
+
+// htmlCellTransformer implements `%%html`: it displays the body of the cell as HTML. It is a simple
+// example of a CellTransformer.
+func htmlCellTransformer(args []string, body []string) ([]string, error) {
+	if len(args) != 0 {
+		return nil, errors.Errorf("%%%%html takes no arguments, %d were given", len(args))
+	}
+	return []string{
+		`import "github.com/janpfeifer/gonb/gonbui"`,
+		"%%",
+		fmt.Sprintf("gonbui.DisplayHTML(%s)", strconv.Quote(strings.Join(body, "\n"))),
+	}, nil
+}
@@ -0,0 +1,74 @@
+package goexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformCell(t *testing.T) {
+	s := &State{}
+	registerTestTransformer(t, "upper", func(args []string, body []string) ([]string, error) {
+		return append(args, body...), nil
+	})
+
+	lines := []string{"%env X 1", "", "%%upper a b", "c"}
+	got, skipLines, err := s.transformCell(lines, map[int]bool{0: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, got)
+	assert.Empty(t, skipLines)
+
+	// Not registered: unchanged.
+	lines = []string{"%%unknown", "c"}
+	got, _, err = s.transformCell(lines, nil)
+	require.NoError(t, err)
+	assert.Equal(t, lines, got)
+
+	// Magic after Go code: error, instead of silently ignoring it.
+	_, _, err = s.transformCell([]string{"var x = 1", "%%upper"}, nil)
+	assert.Error(t, err)
+
+	// Magic inside a string literal: unchanged.
+	lines = []string{"var x = `", "%%upper", "`"}
+	got, _, err = s.transformCell(lines, nil)
+	require.NoError(t, err)
+	assert.Equal(t, lines, got)
+}
+
+// registerTestTransformer registers a cell transformer, which is unregistered at the end of the test.
+func registerTestTransformer(t *testing.T, name string, fn CellTransformerFunc) {
+	RegisterCellTransformer(name, fn)
+	t.Cleanup(func() {
+		muCellTransformers.Lock()
+		defer muCellTransformers.Unlock()
+		delete(cellTransformers, name)
+	})
+}
+
+func TestTemplateCellTransformer(t *testing.T) {
+	t.Setenv("GONB_TEST_TYPE", "float64")
+	s := &State{}
+	lines := []string{
+		"%%template n=3",
+		`{{range seq (len (repeat "x" 3))}}func F{{.}}() {{$.Env.GONB_TEST_TYPE}} { return {{.}} }`,
+		"{{end}}// n={{.Args.n}}",
+	}
+	got, _, err := s.transformCell(lines, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"func F0() float64 { return 0 }",
+		"func F1() float64 { return 1 }",
+		"func F2() float64 { return 2 }",
+		"// n=3",
+	}, got)
+
+	// Template errors are reported as such.
+	_, _, err = s.transformCell([]string{"%%template", "{{.Args.missing}}"}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "template expansion failed")
+	_, _, err = s.transformCell([]string{"%%template", "{{range}}"}, nil)
+	assert.Error(t, err)
+	_, _, err = s.transformCell([]string{"%%template n", "x"}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,29 @@
+package goexec
+
+import "sort"
+
+// VariableInfo describes a package-level variable declared in the cells, see State.ListVariables.
+type VariableInfo struct {
+	// Name of the variable.
+	Name string
+
+	// Type as declared, empty if inferred from the value.
+	Type string
+
+	// Value is the expression used to initialize the variable, empty if none.
+	Value string
+}
+
+// ListVariables returns the package-level variables declared so far in the executed cells,
+// sorted by name. Blank (`_`) variables are not included.
+func (s *State) ListVariables() []VariableInfo {
+	vars := make([]VariableInfo, 0, len(s.Decls.Variables))
+	for _, v := range s.Decls.Variables {
+		if v.Name == "_" {
+			continue
+		}
+		vars = append(vars, VariableInfo{Name: v.Name, Type: v.TypeDefinition, Value: v.ValueDefinition})
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars
+}
@@ -0,0 +1,18 @@
+package goexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListVariables(t *testing.T) {
+	s := &State{TempDir: t.TempDir(), Decls: NewDeclarations()}
+	parseCellIntoState(t, s, []string{`var b, a int`, `var _ = 1`})
+	parseCellIntoState(t, s, []string{`var c = map[string]int{}`})
+	assert.Equal(t, []VariableInfo{
+		{Name: "a", Type: "int"},
+		{Name: "b", Type: "int"},
+		{Name: "c", Value: "map[string]int{}"},
+	}, s.ListVariables())
+}
@@ -0,0 +1,79 @@
+package goexec
+
+import (
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/janpfeifer/gonb/kernel"
+)
+
+// This file implements the verbose mode (`%verbose on`), where the external commands run by GoNB
+// (`go build`, `goimports -w`, `go get`, the program itself, etc.) are displayed before being run,
+// so users can understand and reproduce what is done under the hood.
+
+// reShellSafe matches arguments that don't need quoting in a shell command line.
+var reShellSafe = regexp.MustCompile(`^[a-zA-Z0-9_@%+=:,./^$-]+$`)
+
+// shellQuote quotes arg (if needed) to be used in a shell command line.
+func shellQuote(arg string) string {
+	if reShellSafe.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// commandLine formats a command as a shell command line: run in dir (if not empty), with the env
+// overrides ("NAME=value"). The values of secrets are redacted.
+func (s *State) commandLine(dir string, env []string, args []string) string {
+	var parts []string
+	if dir != "" {
+		parts = append(parts, "cd", shellQuote(dir), "&&")
+	}
+	for _, entry := range env {
+		name, value, _ := strings.Cut(entry, "=")
+		value = shellQuote(value)
+		if _, isSecret := s.Secrets[name]; isSecret {
+			value = RedactedSecret
+		}
+		parts = append(parts, name+"="+value)
+	}
+	for _, arg := range args {
+		parts = append(parts, shellQuote(arg))
+	}
+	return s.RedactSecrets(strings.Join(parts, " "))
+}
+
+// envOverrides returns the entries of env that are not in the current environment (os.Environ) --
+// the ones set for a command.
+func envOverrides(env []string) []string {
+	if env == nil {
+		return nil
+	}
+	current := make(map[string]bool)
+	for _, entry := range os.Environ() {
+		current[entry] = true
+	}
+	var overrides []string
+	for _, entry := range env {
+		if !current[entry] {
+			overrides = append(overrides, entry)
+		}
+	}
+	return overrides
+}
+
+// reportExec displays the command about to be run (args, including the command itself), if
+// State.Verbose is set.
+func (s *State) reportExec(msg kernel.Message, dir string, env []string, args ...string) {
+	if !s.Verbose {
+		return
+	}
+	_ = kernel.PublishWriteStream(msg, kernel.StreamStderr, "$ "+s.commandLine(dir, env, args)+"\n")
+}
+
+// reportCommand displays cmd before it is run, if State.Verbose is set. See reportExec.
+func (s *State) reportCommand(msg kernel.Message, cmd *exec.Cmd) {
+	s.reportExec(msg, cmd.Dir, envOverrides(cmd.Env), cmd.Args...)
+}
@@ -0,0 +1,24 @@
+package goexec
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandLine(t *testing.T) {
+	s, err := NewState(WithTempDir(t.TempDir()), WithAutoGet(false))
+	require.NoError(t, err)
+	s.SetSecret("TOKEN", "s3cr3t")
+	assert.Equal(t, "cd /tmp/x && GOWORK=/tmp/x/go.work go build -o /tmp/x/bin",
+		s.commandLine("/tmp/x", []string{"GOWORK=/tmp/x/go.work"}, []string{"go", "build", "-o", "/tmp/x/bin"}))
+	assert.Equal(t, "TOKEN=******** ./prog 'hello world' 'it'\\''s' ********",
+		s.commandLine("", []string{"TOKEN=s3cr3t"}, []string{"./prog", "hello world", "it's", "s3cr3t"}))
+
+	cmd := exec.Command("go", "get")
+	cmd.Env = append(cmd.Environ(), "GOWORK=off")
+	assert.Equal(t, []string{"GOWORK=off"}, envOverrides(cmd.Env))
+	assert.Nil(t, envOverrides(nil))
+}
@@ -0,0 +1,71 @@
+package goexec
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/janpfeifer/gonb/kernel"
+)
+
+// This file implements build warnings: diagnostics that don't fail the compilation -- e.g.: warnings
+// of cgo's C compiler or of the linker, or the findings of `go vet` with `%vet on` -- are displayed
+// informationally, with their own styling, and the program is executed anyway.
+
+// reWarning matches the lines of the `go` tool output that are warnings, as opposed to errors.
+var reWarning = regexp.MustCompile(`(?i)(^|[\s:])warning:`)
+
+// buildWarnings returns the warnings in the output of a successful `go build`: lines marked as
+// warnings and diagnostics (`file.go:line:col: message`). Progress lines (see reGoProgress) and
+// package headers ("# <package>") are not warnings.
+func buildWarnings(output string) []string {
+	var warnings []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || reGoProgress.MatchString(trimmed) {
+			continue
+		}
+		if reWarning.MatchString(line) || reDiagnostic.MatchString(trimmed) {
+			warnings = append(warnings, line)
+		}
+	}
+	return warnings
+}
+
+// vet runs `go vet` on the program, if State.Vet is set, and displays its findings as warnings.
+func (s *State) vet(msg kernel.Message) {
+	if !s.Vet {
+		return
+	}
+	cmd := s.GoCommand("vet", ".")
+	s.reportCommand(msg, cmd)
+	output, err := runGoCommandWithProgress(cmd, func(string) {})
+	warnings := buildWarnings(output)
+	if err != nil && len(warnings) == 0 {
+		// Not findings, but vet itself failed.
+		s.logf("`go vet` failed: %v\n%s", err, output)
+		warnings = []string{"`go vet` failed: " + strings.TrimSpace(output)}
+	}
+	s.displayWarnings(msg, "go vet", warnings)
+}
+
+// displayWarnings displays the warnings reported by the given tool, if any.
+func (s *State) displayWarnings(msg kernel.Message, tool string, warnings []string) {
+	if len(warnings) == 0 {
+		return
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<div style="background-color:#fff8c5;border-left:4px solid #d4a72c;padding:4px 8px">`+
+		`<b>&#9888; Warnings from <code>%s</code></b> (the program is executed anyway):<pre style="margin:4px 0 0 0">`,
+		html.EscapeString(tool))
+	for _, warning := range warnings {
+		sb.WriteString(html.EscapeString(s.RedactSecrets(warning)))
+		sb.WriteString("\n")
+	}
+	sb.WriteString("</pre></div>")
+	if err := kernel.PublishDisplayDataWithHTML(msg, sb.String()); err != nil {
+		s.logf("Failed to display warnings: %+v", err)
+	}
+}
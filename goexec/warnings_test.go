@@ -0,0 +1,40 @@
+package goexec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildWarnings(t *testing.T) {
+	output := `go: downloading example.com/x v1.0.0
+# gonb_1234
+cgo-gcc-prolog: In function '_cgo_1234':
+cgo-gcc-prolog:58:11: warning: unused variable 'x' [-Wunused-variable]
+./main.go:7:2: fmt.Printf format %d has arg "x" of wrong type string
+/usr/bin/ld: warning: libfoo.so, needed by bar, not found
+`
+	assert.Equal(t, []string{
+		"cgo-gcc-prolog:58:11: warning: unused variable 'x' [-Wunused-variable]",
+		`./main.go:7:2: fmt.Printf format %d has arg "x" of wrong type string`,
+		"/usr/bin/ld: warning: libfoo.so, needed by bar, not found",
+	}, buildWarnings(output))
+	assert.Empty(t, buildWarnings("go: downloading example.com/x v1.0.0\n"))
+}
+
+func TestVetWarnings(t *testing.T) {
+	s := newTestState(t)
+	s.Vet = true
+	msg := newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{`import "fmt"`, "%%", `fmt.Printf("%d\n", "x")`}, nil))
+	published := strings.Join(msg.Published(), "")
+	assert.Contains(t, published, "Warnings from <code>go vet</code>")
+	assert.Contains(t, published, "wrong type string")
+	assert.Contains(t, published, "%!d(string=x)") // Executed anyway.
+
+	msg = newTestMessage()
+	require.NoError(t, s.ExecuteCell(msg, []string{"%%", `fmt.Println("ok")`}, nil))
+	assert.NotContains(t, strings.Join(msg.Published(), ""), "Warnings from")
+}
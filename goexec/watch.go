@@ -0,0 +1,193 @@
+package goexec
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// This file implements `%watch <dir>`: the cell is re-executed (rebuilt and re-run) whenever the
+// files in the directory change. It's meant for iterating on a local package (usually included
+// with `go mod edit -replace`) from a notebook.
+//
+// Changes are detected by polling the modification time and size of the files, to avoid
+// depending on platform specific notification APIs.
+
+// WatchPollInterval is the interval between checks for changes in the watched directory.
+var WatchPollInterval = time.Second
+
+// watcher holds a watched directory, and the cell to re-execute when it changes.
+type watcher struct {
+	dir       string
+	msg       kernel.Message
+	lines     []string
+	skipLines map[int]bool
+	cell      CellOptions
+	done      chan struct{}
+}
+
+// dirSnapshot maps the files in a directory to their modification time and size, to detect changes.
+type dirSnapshot map[string]string
+
+// takeDirSnapshot walks dir and records the state of all its files. Hidden directories (e.g.: .git)
+// are skipped.
+func takeDirSnapshot(dir string) (dirSnapshot, error) {
+	snapshot := make(dirSnapshot)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != dir && len(entry.Name()) > 1 && entry.Name()[0] == '.' {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		snapshot[path] = fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size())
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list files in %q", dir)
+	}
+	return snapshot, nil
+}
+
+// Equal returns whether the two snapshots are the same.
+func (snapshot dirSnapshot) Equal(other dirSnapshot) bool {
+	if len(snapshot) != len(other) {
+		return false
+	}
+	for path, state := range snapshot {
+		if other[path] != state {
+			return false
+		}
+	}
+	return true
+}
+
+// startWatch starts watching State.Cell.Watch, and re-executing the cell given by lines and
+// skipLines whenever it changes. It replaces any previous watch.
+func (s *State) startWatch(msg kernel.Message, lines []string, skipLines map[int]bool) error {
+	dir, err := filepath.Abs(s.Cell.Watch)
+	if err != nil {
+		return errors.Wrapf(err, "invalid directory %q for %%watch", s.Cell.Watch)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return errors.Errorf("%%watch %q: not a directory", s.Cell.Watch)
+	}
+	snapshot, err := takeDirSnapshot(dir)
+	if err != nil {
+		return err
+	}
+	s.StopWatch()
+	w := &watcher{
+		dir:       dir,
+		msg:       msg,
+		lines:     append([]string(nil), lines...),
+		skipLines: make(map[int]bool, len(skipLines)),
+		cell:      s.Cell,
+		done:      make(chan struct{}),
+	}
+	copyMap(w.skipLines, skipLines)
+	w.cell.Watch = ""
+
+	s.muWatch.Lock()
+	s.watcher = w
+	s.muWatch.Unlock()
+	go s.watchLoop(w, snapshot)
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout,
+		fmt.Sprintf("* Watching %s: cell is re-executed on changes. Use \"%%watch stop\" to stop.\n", dir))
+}
+
+// watchLoop polls the watched directory, and re-executes the cell when it changes, until the watch
+// is stopped.
+func (s *State) watchLoop(w *watcher, snapshot dirSnapshot) {
+	ticker := time.NewTicker(WatchPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		newSnapshot, err := takeDirSnapshot(w.dir)
+		if err != nil {
+			s.logf("%%watch: %+v", err)
+			continue
+		}
+		if newSnapshot.Equal(snapshot) {
+			continue
+		}
+		snapshot = newSnapshot
+		s.reExecuteWatchedCell(w)
+	}
+}
+
+// reExecuteWatchedCell re-executes the watched cell, and reports the outcome.
+func (s *State) reExecuteWatchedCell(w *watcher) {
+	s.LockExecution()
+	defer s.UnlockExecution()
+	select {
+	case <-w.done:
+		// Watch stopped while waiting for the lock.
+		return
+	default:
+	}
+	_ = kernel.PublishWriteStream(w.msg, kernel.StreamStdout,
+		fmt.Sprintf("* %s changed, re-executing cell ...\n", w.dir))
+	s.Cell = w.cell
+	defer s.ResetCell()
+	if err := s.ExecuteCell(w.msg, w.lines, w.skipLines); err != nil {
+		_ = kernel.PublishWriteStream(w.msg, kernel.StreamStderr,
+			fmt.Sprintf("* Re-execution after changes in %s failed: %v\n", w.dir, err))
+		return
+	}
+	_ = kernel.PublishWriteStream(w.msg, kernel.StreamStdout,
+		fmt.Sprintf("* Re-execution after changes in %s succeeded.\n", w.dir))
+}
+
+// WatchedDir returns the directory being watched with `%watch`, or empty if none.
+func (s *State) WatchedDir() string {
+	s.muWatch.Lock()
+	defer s.muWatch.Unlock()
+	if s.watcher == nil {
+		return ""
+	}
+	return s.watcher.dir
+}
+
+// StopWatch stops watching the directory set with `%watch`, if any.
+func (s *State) StopWatch() {
+	s.muWatch.Lock()
+	defer s.muWatch.Unlock()
+	if s.watcher == nil {
+		return
+	}
+	close(s.watcher.done)
+	s.watcher = nil
+}
+
+// LockExecution must be held while executing a cell: executions may be requested concurrently
+// (e.g.: cells run in quick succession from the front-end or the HTTP control endpoint, or the
+// watched cell, see `%watch`, re-executed at any time), and they share the declarations, the
+// generated files and the binary. The lock is granted in the order it is requested, so cells are
+// executed one at a time, in order.
+//
+// It is also held while rendering a cell being edited, for inspection and completion (see
+// InspectCell and CompleteCell), since that reads the declarations.
+func (s *State) LockExecution() {
+	s.execQueue.Lock()
+}
+
+// UnlockExecution releases the lock acquired with LockExecution.
+func (s *State) UnlockExecution() {
+	s.execQueue.Unlock()
+}
@@ -0,0 +1,36 @@
+package goexec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "a.go")
+	require.NoError(t, os.WriteFile(filePath, []byte("package a\n"), 0600))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, ".git"), 0700))
+	snapshot, err := takeDirSnapshot(dir)
+	require.NoError(t, err)
+
+	// Files in hidden directories are ignored.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".git", "index"), []byte("x"), 0600))
+	newSnapshot, err := takeDirSnapshot(dir)
+	require.NoError(t, err)
+	assert.True(t, snapshot.Equal(newSnapshot))
+
+	// Changed and new files are detected.
+	require.NoError(t, os.WriteFile(filePath, []byte("package a\n\nconst A = 1\n"), 0600))
+	newSnapshot, err = takeDirSnapshot(dir)
+	require.NoError(t, err)
+	assert.False(t, snapshot.Equal(newSnapshot))
+	snapshot = newSnapshot
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.go"), []byte("package a\n"), 0600))
+	newSnapshot, err = takeDirSnapshot(dir)
+	require.NoError(t, err)
+	assert.False(t, snapshot.Equal(newSnapshot))
+}
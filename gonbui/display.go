@@ -0,0 +1,66 @@
+package gonbui
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// GonbMarshaler is implemented by types that control how they are displayed in the notebook by
+// Display, the Go analog of Jupyter's `_repr_html_` in Python.
+//
+// MarshalGonb returns the MIME type of the content (e.g.: "text/html" or "image/png") and the
+// content itself.
+type GonbMarshaler interface {
+	MarshalGonb() (mimeType string, data []byte, err error)
+}
+
+// Display displays v in the notebook, as the output of the cell being executed. If v implements
+// GonbMarshaler, it is displayed with the MIME type and content it returns, along with its text
+// representation (`fmt.Sprint(v)`) for front-ends that don't support the MIME type. Otherwise,
+// only its text representation is displayed.
+//
+// It returns the error returned by MarshalGonb, if any.
+func Display(v any) error {
+	if !IsNotebook {
+		return nil
+	}
+	data, err := displayData(v)
+	if err != nil {
+		return err
+	}
+	sendData(data)
+	return nil
+}
+
+// displayData returns the DisplayData that Display sends for v.
+func displayData(v any) (*protocol.DisplayData, error) {
+	data := &protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{protocol.MIMETextPlain: fmt.Sprint(v)},
+	}
+	marshaler, ok := v.(GonbMarshaler)
+	if !ok {
+		return data, nil
+	}
+	mimeType, content, err := marshaler.MarshalGonb()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "%T.MarshalGonb() failed", v)
+	}
+	if mimeType == "" {
+		return nil, errors.Errorf("%T.MarshalGonb() returned an empty MIME type", v)
+	}
+	if isTextMIMEType(mimeType) {
+		data.Data[protocol.MIMEType(mimeType)] = string(content)
+	} else {
+		// Binary content is base64 encoded by the kernel, as Jupyter expects.
+		data.Data[protocol.MIMEType(mimeType)] = content
+	}
+	return data, nil
+}
+
+// isTextMIMEType returns whether the content of mimeType is text, as opposed to binary.
+func isTextMIMEType(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "text/") || strings.HasSuffix(mimeType, "+xml") ||
+		strings.HasSuffix(mimeType, "json") || mimeType == "application/javascript"
+}
@@ -0,0 +1,44 @@
+package gonbui
+
+import (
+	"testing"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type htmlColor string
+
+func (c htmlColor) MarshalGonb() (string, []byte, error) {
+	if c == "" {
+		return "", nil, errors.New("no color")
+	}
+	return "text/html", []byte(`<span style="color:` + string(c) + `">&#9632;</span>`), nil
+}
+
+type pngImage []byte
+
+func (p pngImage) MarshalGonb() (string, []byte, error) { return "image/png", p, nil }
+
+func TestDisplayData(t *testing.T) {
+	data, err := displayData(htmlColor("red"))
+	require.NoError(t, err)
+	assert.Equal(t, map[protocol.MIMEType]any{
+		protocol.MIMETextHTML:  `<span style="color:red">&#9632;</span>`,
+		protocol.MIMETextPlain: "red",
+	}, data.Data)
+
+	data, err = displayData(pngImage{1, 2, 3})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, data.Data[protocol.MIMEImagePNG])
+
+	// Types without MarshalGonb are displayed as text.
+	data, err = displayData(42)
+	require.NoError(t, err)
+	assert.Equal(t, map[protocol.MIMEType]any{protocol.MIMETextPlain: "42"}, data.Data)
+
+	_, err = displayData(htmlColor(""))
+	assert.ErrorContains(t, err, "no color")
+}
@@ -0,0 +1,67 @@
+package gonbui
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+)
+
+// DisplayError displays err formatted by the kernel: each error it wraps is shown in its own
+// line, along with the stack trace recorded by the errors created with `github.com/pkg/errors`.
+//
+// If not running in a notebook, err is printed to stderr, with its stack traces.
+func DisplayError(err error) {
+	if err == nil {
+		return
+	}
+	if !IsNotebook {
+		_, _ = fmt.Fprintf(os.Stderr, "%+v\n", err)
+		return
+	}
+	encoded, jsonErr := json.Marshal(ErrorReport(err))
+	if jsonErr != nil {
+		// Not expected, the report only holds strings.
+		_, _ = fmt.Fprintf(os.Stderr, "%+v\n", err)
+		return
+	}
+	sendData(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{protocol.MIMEGonbError: string(encoded)},
+	})
+}
+
+// stackTracer is implemented by the errors of `github.com/pkg/errors` that record a stack trace.
+type stackTracer interface {
+	StackTrace() errors.StackTrace
+}
+
+// ErrorReport returns the chain of errors wrapped by err, with the message each one adds and the
+// stack traces recorded, as displayed by DisplayError.
+func ErrorReport(err error) *protocol.ErrorReport {
+	report := &protocol.ErrorReport{}
+	var stack []string
+	for err != nil {
+		next := errors.Unwrap(err)
+		if tracer, ok := err.(stackTracer); ok && stack == nil {
+			for _, frame := range tracer.StackTrace() {
+				stack = append(stack, fmt.Sprintf("%+v", frame))
+			}
+		}
+		message := err.Error()
+		if next != nil {
+			if message == next.Error() {
+				// This layer only adds a stack trace (e.g.: errors.WithStack): it goes to the next
+				// layer with a message.
+				err = next
+				continue
+			}
+			message = strings.TrimSuffix(message, ": "+next.Error())
+		}
+		report.Chain = append(report.Chain, protocol.ErrorLayer{Message: message, Stack: stack})
+		stack = nil
+		err = next
+	}
+	return report
+}
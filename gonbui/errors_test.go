@@ -0,0 +1,25 @@
+package gonbui
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorReport(t *testing.T) {
+	err := errors.New("file not found")
+	err = errors.Wrapf(err, "loading config %q", "x.json")
+	err = fmt.Errorf("starting server: %w", err)
+	report := ErrorReport(err)
+	require.Len(t, report.Chain, 3)
+	assert.Equal(t, "starting server", report.Chain[0].Message)
+	assert.Empty(t, report.Chain[0].Stack)
+	assert.Equal(t, `loading config "x.json"`, report.Chain[1].Message)
+	require.NotEmpty(t, report.Chain[1].Stack)
+	assert.Contains(t, report.Chain[1].Stack[0], "TestErrorReport")
+	assert.Equal(t, "file not found", report.Chain[2].Message)
+	assert.NotEmpty(t, report.Chain[2].Stack)
+}
@@ -0,0 +1,79 @@
+package gonbui
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+)
+
+// ServeFile makes the file at filePath (e.g.: an image generated by the program) available to the
+// browser, and returns a URL for it, to be used in the HTML displayed (e.g.: with DisplayHTML).
+//
+// The file is copied to the directory of the kernel's static file
+// server, so later changes to the file are not reflected -- call ServeFile again to serve a new
+// version, under a new URL. The files served are removed when the kernel is stopped.
+//
+// The server is bound to localhost, so the URLs are only reachable by a browser in the same machine
+// as the kernel.
+//
+// It returns an empty string, and prints the error to stderr, if the file can't be served, or if not
+// running in a notebook.
+func ServeFile(filePath string) string {
+	fileURL, err := serveFile(filePath)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "gonbui.ServeFile: %+v\n", err)
+		return ""
+	}
+	return fileURL
+}
+
+// serveFile implements ServeFile.
+func serveFile(filePath string) (string, error) {
+	dir, baseURL := os.Getenv(protocol.GONB_FILES_DIR_ENV), os.Getenv(protocol.GONB_FILES_URL_ENV)
+	if dir == "" || baseURL == "" {
+		return "", errors.Errorf("file server not available for %q, not running in a notebook", filePath)
+	}
+
+	// Each file is served in a new randomly named subdirectory, so URLs can't be guessed and files
+	// with the same name don't conflict.
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", errors.Wrapf(err, "failed to generate a name to serve %q", filePath)
+	}
+	id := hex.EncodeToString(idBytes)
+	name := filepath.Base(filePath)
+	if err := os.Mkdir(filepath.Join(dir, id), 0700); err != nil {
+		return "", errors.Wrapf(err, "failed to serve %q", filePath)
+	}
+	target := filepath.Join(dir, id, name)
+	if err := copyFile(filePath, target); err != nil {
+		return "", errors.WithMessagef(err, "failed to serve %q", filePath)
+	}
+	return baseURL + path.Join(id, url.PathEscape(name)), nil
+}
+
+// copyFile copies the contents of the file src to a new file dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %q", src)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %q", dst)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return errors.Wrapf(err, "failed to copy %q to %q", src, dst)
+	}
+	return errors.Wrapf(out.Close(), "failed to copy %q to %q", src, dst)
+}
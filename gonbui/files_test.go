@@ -0,0 +1,35 @@
+package gonbui
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeFile(t *testing.T) {
+	t.Setenv(protocol.GONB_FILES_DIR_ENV, "")
+	source := path.Join(t.TempDir(), "my plot.svg")
+	require.NoError(t, os.WriteFile(source, []byte("<svg/>"), 0600))
+	_, err := serveFile(source)
+	assert.Error(t, err)
+
+	dir := t.TempDir()
+	t.Setenv(protocol.GONB_FILES_DIR_ENV, dir)
+	t.Setenv(protocol.GONB_FILES_URL_ENV, "http://127.0.0.1:1234/token/")
+	url := ServeFile(source)
+	require.True(t, strings.HasPrefix(url, "http://127.0.0.1:1234/token/"), "url=%q", url)
+	require.True(t, strings.HasSuffix(url, "/my%20plot.svg"), "url=%q", url)
+	id := strings.Split(strings.TrimPrefix(url, "http://127.0.0.1:1234/token/"), "/")[0]
+	content, err := os.ReadFile(path.Join(dir, id, "my plot.svg"))
+	require.NoError(t, err)
+	assert.Equal(t, "<svg/>", string(content))
+
+	// Each call serves a new copy, under a new URL.
+	assert.NotEqual(t, url, ServeFile(source))
+	assert.Equal(t, "", ServeFile(path.Join(t.TempDir(), "missing.png")))
+}
@@ -3,6 +3,7 @@ package gonbui
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"github.com/janpfeifer/gonb/gonbui/protocol"
 	"github.com/pkg/errors"
@@ -14,6 +15,7 @@ import (
 
 func init() {
 	IsNotebook = os.Getenv(protocol.GONB_PIPE_ENV) != ""
+	syncDisplay = displaySyncEnabled()
 }
 
 var (
@@ -27,8 +29,20 @@ var (
 	gonbPipe      *os.File
 	gonbPipeError error
 	gonbEncoder   *gob.Encoder
+
+	// syncDisplay indicates the kernel keeps the order of the displayed content and stdout, using
+	// the number of the last content sent, displaySequence. See protocol.DisplayData.Sequence.
+	syncDisplay     bool
+	displaySequence int
 )
 
+// displaySyncEnabled returns whether the kernel keeps the order of the displayed content and the
+// stdout, and the stdout is the one read by the kernel. See protocol.GONB_DISPLAY_SYNC_ENV.
+func displaySyncEnabled() bool {
+	id := os.Getenv(protocol.GONB_DISPLAY_SYNC_ENV)
+	return id != "" && id == protocol.DisplaySyncID(os.Stdout)
+}
+
 // Error returns the first error that may have happened in communication to the kernel. Nil if there has been
 // no errors.
 func Error() error {
@@ -65,12 +79,19 @@ func openLocked() error {
 	return nil
 }
 
+// sendData sends data to the kernel. If syncDisplay is set and the data is displayed, it is numbered
+// and its marker is written to stdout first, see protocol.DisplayData.Sequence.
 func sendData(data *protocol.DisplayData) {
 	mu.Lock()
 	defer mu.Unlock()
 	if err := openLocked(); err != nil {
 		return
 	}
+	if syncDisplay && isDisplayed(data) {
+		displaySequence++
+		data.Sequence = displaySequence
+		_, _ = os.Stdout.WriteString(protocol.DisplaySyncMarker(displaySequence))
+	}
 	err := gonbEncoder.Encode(data)
 	if err != nil {
 		gonbPipeError = errors.Wrapf(err, "failed to write to pipe %q", os.Getenv(protocol.GONB_PIPE_ENV))
@@ -78,6 +99,14 @@ func sendData(data *protocol.DisplayData) {
 	}
 }
 
+// isDisplayed returns whether data is published to the notebook -- as opposed to being only
+// processed by the kernel, like the values of the store.
+func isDisplayed(data *protocol.DisplayData) bool {
+	_, isStore := data.Data[protocol.MIMEGonbStore]
+	_, isResultMetadata := data.Data[protocol.MIMEGonbResultMetadata]
+	return !isStore && !isResultMetadata
+}
+
 // DisplayHTML will display the given HTML in the notebook, as the output of the cell being executed.
 func DisplayHTML(html string) {
 	if !IsNotebook {
@@ -88,6 +117,69 @@ func DisplayHTML(html string) {
 	})
 }
 
+// DisplayMarkdown will display the given Markdown in the notebook, as the output of the cell being
+// executed. Jupyter renders formulas delimited by `$` (inline) or `$$` in Markdown as well.
+func DisplayMarkdown(markdown string) {
+	if !IsNotebook {
+		return
+	}
+	sendData(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{
+			protocol.MIMETextMarkdown: markdown,
+			protocol.MIMETextPlain:    markdown,
+		},
+	})
+}
+
+// DisplayLatex will display the given LaTeX in the notebook, as the output of the cell being
+// executed. Formulas should be delimited by `$` (inline) or `$$`, e.g.: `$$e^{i\pi} + 1 = 0$$`.
+func DisplayLatex(latex string) {
+	if !IsNotebook {
+		return
+	}
+	sendData(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{
+			protocol.MIMETextLatex: latex,
+			protocol.MIMETextPlain: latex,
+		},
+	})
+}
+
+// SetResultMetadata sets metadata to be included in the `execute_reply` of the cell being
+// executed, for automation pipelines (e.g.: nbclient) inspecting the results of the cells.
+// It is merged to any previously set metadata, with the new keys taking precedence.
+//
+// It returns an error if the metadata can't be encoded to JSON.
+func SetResultMetadata(metadata map[string]any) error {
+	if !IsNotebook {
+		return nil
+	}
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode result metadata to JSON")
+	}
+	sendData(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{protocol.MIMEGonbResultMetadata: string(encoded)},
+	})
+	return nil
+}
+
+// ClearOutput clears the output of the cell being executed. If wait is true, the output is only
+// cleared when new output is displayed, which avoids flickering: e.g.: to animate, call
+// ClearOutput(true) before displaying each frame.
+//
+// Notice text written to stdout and stderr is forwarded to the notebook separately from the
+// displayed content, so it may be cleared out of order: prefer displaying text (e.g.: with
+// DisplayHTML) when clearing the output.
+func ClearOutput(wait bool) {
+	if !IsNotebook {
+		return
+	}
+	sendData(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{protocol.MIMEGonbClearOutput: wait},
+	})
+}
+
 // DisplayPNG displays the given PNG, given as raw bytes.
 func DisplayPNG(png []byte) {
 	if !IsNotebook {
@@ -0,0 +1,87 @@
+package gonbui
+
+import (
+	"encoding/gob"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setStdout replaces os.Stdout by a new file, for the duration of the test.
+func setStdout(t *testing.T) *os.File {
+	f, err := os.CreateTemp(t.TempDir(), "stdout")
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = f
+	t.Cleanup(func() {
+		os.Stdout = stdout
+		f.Close()
+	})
+	return f
+}
+
+func TestDisplaySyncEnabled(t *testing.T) {
+	stdout := setStdout(t)
+	id := protocol.DisplaySyncID(stdout)
+	if id == "" {
+		t.Skip("display sync not supported")
+	}
+	t.Setenv(protocol.GONB_DISPLAY_SYNC_ENV, id)
+	assert.True(t, displaySyncEnabled())
+
+	// Stdout is not the one read by the kernel, e.g.: redirected by a command executed by the program.
+	other, err := os.Create(path.Join(t.TempDir(), "other"))
+	require.NoError(t, err)
+	defer other.Close()
+	t.Setenv(protocol.GONB_DISPLAY_SYNC_ENV, protocol.DisplaySyncID(other))
+	assert.False(t, displaySyncEnabled())
+
+	t.Setenv(protocol.GONB_DISPLAY_SYNC_ENV, "")
+	assert.False(t, displaySyncEnabled())
+}
+
+func TestSendData(t *testing.T) {
+	pipe, err := os.Create(path.Join(t.TempDir(), "pipe"))
+	require.NoError(t, err)
+	defer pipe.Close()
+	stdout := setStdout(t)
+	prevPipe, prevEncoder, prevSync, prevSequence := gonbPipe, gonbEncoder, syncDisplay, displaySequence
+	gonbPipe, gonbEncoder, syncDisplay, displaySequence = pipe, gob.NewEncoder(pipe), true, 0
+	defer func() {
+		gonbPipe, gonbEncoder, syncDisplay, displaySequence = prevPipe, prevEncoder, prevSync, prevSequence
+	}()
+
+	html := &protocol.DisplayData{Data: map[protocol.MIMEType]any{protocol.MIMETextHTML: "a"}}
+	store := &protocol.DisplayData{Data: map[protocol.MIMEType]any{protocol.MIMEGonbStore: []byte{1}}}
+	metadata := &protocol.DisplayData{Data: map[protocol.MIMEType]any{protocol.MIMEGonbResultMetadata: "{}"}}
+	assert.True(t, isDisplayed(html))
+	assert.False(t, isDisplayed(store))
+	assert.False(t, isDisplayed(metadata))
+	sendData(html)
+	sendData(store)
+	sendData(&protocol.DisplayData{Data: map[protocol.MIMEType]any{protocol.MIMETextHTML: "b"}})
+	require.NoError(t, Error())
+
+	// Only the displayed content is numbered, and its marker written to stdout.
+	content, err := os.ReadFile(stdout.Name())
+	require.NoError(t, err)
+	assert.Equal(t, protocol.DisplaySyncMarker(1)+protocol.DisplaySyncMarker(2), string(content))
+	_, err = pipe.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	decoder := gob.NewDecoder(pipe)
+	var sequences []int
+	for {
+		var data protocol.DisplayData
+		if err := decoder.Decode(&data); err != nil {
+			require.Equal(t, io.EOF, err)
+			break
+		}
+		sequences = append(sequences, data.Sequence)
+	}
+	assert.Equal(t, []int{1, 0, 2}, sequences)
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package protocol
+
+import "os"
+
+// DisplaySyncID returns the value of GONB_DISPLAY_SYNC_ENV for a program whose stdout is f. Only
+// supported on Unix systems: elsewhere it returns "", and the order is not kept.
+func DisplaySyncID(f *os.File) string {
+	return ""
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package protocol
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// DisplaySyncID returns the value of GONB_DISPLAY_SYNC_ENV for a program whose stdout is f: the
+// device and inode of the file, so the program can check that its stdout is the one read by the
+// kernel. It returns "" if they can't be read.
+func DisplaySyncID(f *os.File) string {
+	info, err := f.Stat()
+	if err != nil {
+		return ""
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+}
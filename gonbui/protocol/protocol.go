@@ -2,19 +2,113 @@
 // kernel, using the standard Go `encoding/gob` package.
 package protocol
 
+import (
+	"encoding/hex"
+	"strconv"
+)
+
 const GONB_PIPE_ENV = "GONB_PIPE"
 
+// GONB_STORE_DIR_ENV is the environment variable with the directory where the kernel keeps the values
+// of the shared store, see MIMEGonbStore.
+const GONB_STORE_DIR_ENV = "GONB_STORE_DIR"
+
+// GONB_FILES_DIR_ENV is the environment variable with the directory of the files served by the kernel's
+// static file server, and GONB_FILES_URL_ENV the URL (ending in "/") under which they are served. A
+// file copied to a path relative to the directory is served at the same path relative to the URL.
+const (
+	GONB_FILES_DIR_ENV = "GONB_FILES_DIR"
+	GONB_FILES_URL_ENV = "GONB_FILES_URL"
+)
+
+// GONB_DISPLAY_SYNC_ENV is the environment variable set by kernels that keep the order of the
+// displayed content and the program's stdout, see DisplayData.Sequence. It is set to the
+// DisplaySyncID of the program's stdout: programs only write the markers if their stdout is that
+// file -- and not, e.g., a program they execute with its stdout redirected, which inherits the
+// environment.
+const GONB_DISPLAY_SYNC_ENV = "GONB_DISPLAY_SYNC"
+
+// DisplaySyncMarkerPrefix and DisplaySyncMarkerSuffix delimit the sequence number in the markers
+// written to stdout, see DisplaySyncMarker. The marker is an "application program command" escape
+// sequence, ignored by terminals, should it be displayed outside the kernel.
+const (
+	DisplaySyncMarkerPrefix = "\x1b_gonb-display:"
+	DisplaySyncMarkerSuffix = "\x1b\\"
+)
+
+// DisplaySyncMarker returns the marker written to stdout just before sending the DisplayData with
+// the given Sequence number. The kernel removes it from the output.
+func DisplaySyncMarker(sequence int) string {
+	return DisplaySyncMarkerPrefix + strconv.Itoa(sequence) + DisplaySyncMarkerSuffix
+}
+
 type MIMEType string
 
 const (
 	MIMETextHTML       MIMEType = "text/html"
 	MIMETextJavascript          = "text/javascript"
 	MIMETextMarkdown            = "text/markdown"
+	MIMETextLatex               = "text/latex"
 	MIMETextPlain               = "text/plain"
 	MIMEImagePNG                = "image/png"
 	MIMEImageSVG                = "image/svg+xml"
+
+	// MIMEGonbResultMetadata is not displayed: its content is a JSON object (as a string) merged
+	// by the kernel into the metadata of the cell's `execute_reply`.
+	MIMEGonbResultMetadata = "application/vnd.gonb.result-metadata+json"
+
+	// MIMEGonbError content is an ErrorReport encoded in JSON (as a string), rendered by the kernel
+	// as a formatted error.
+	MIMEGonbError = "application/vnd.gonb.error+json"
+
+	// MIMEGonbStore is not displayed: it sets the value of a key in the store shared by the programs of
+	// all cells. The key is given in the DisplayData.Metadata under StoreKeyMetadata, and the content is
+	// the value encoded with `encoding/gob` ([]byte). An empty content deletes the key.
+	//
+	// The kernel saves each value in the directory given by GONB_STORE_DIR_ENV, in a file named
+	// StoreFileName(key), from where programs read them.
+	MIMEGonbStore = "application/vnd.gonb.store"
+
+	// MIMEGonbClearOutput is not displayed: it clears the output of the cell. The content is a bool,
+	// the `wait` parameter of Jupyter's `clear_output` message: if true, the output is only cleared
+	// when new output is available, which avoids flickering in animations.
+	MIMEGonbClearOutput = "application/vnd.gonb.clear-output"
+)
+
+// StoreKeyMetadata is the DisplayData.Metadata key holding the key of a MIMEGonbStore request.
+const StoreKeyMetadata = "key"
+
+// Limits of the shared store, see MIMEGonbStore.
+const (
+	// StoreMaxKeyLength is the maximum length in bytes of a key.
+	StoreMaxKeyLength = 100
+
+	// StoreMaxValueSize is the maximum size in bytes of an encoded value.
+	StoreMaxValueSize = 64 << 20
+
+	// StoreMaxTotalSize is the maximum size in bytes of all the values stored.
+	StoreMaxTotalSize = 512 << 20
 )
 
+// StoreFileName returns the name of the file, in the store directory, holding the value of key.
+// The key is hex encoded, so any key is a valid file name.
+func StoreFileName(key string) string {
+	return hex.EncodeToString([]byte(key))
+}
+
+// ErrorReport describes an error reported by the program, with the chain of wrapped errors,
+// starting from the outermost.
+type ErrorReport struct {
+	Chain []ErrorLayer `json:"chain"`
+}
+
+// ErrorLayer is one of the wrapped errors of an ErrorReport: Message is the message added by this
+// layer, and Stack the stack trace recorded when it was created, if any, one frame per entry.
+type ErrorLayer struct {
+	Message string   `json:"message"`
+	Stack   []string `json:"stack,omitempty"`
+}
+
 // DisplayData mimics the contents of the "display_data" message used by Jupyter, see
 // https://jupyter-client.readthedocs.io/en/latest/messaging.html
 type DisplayData struct {
@@ -29,4 +123,10 @@ type DisplayData struct {
 	// overwrite some previous content. So far tested only with HTML. A program should always generate
 	// unique IDs to start with, and then re-use them to update them.
 	DisplayID string
+
+	// Sequence, if > 0, is the number of the content sent by the program, which wrote the
+	// DisplaySyncMarker with it to its stdout just before: the kernel publishes the content at that
+	// point of the stdout, so they are displayed in the order they were produced. Set only if
+	// GONB_DISPLAY_SYNC_ENV is set to the DisplaySyncID of the program's stdout.
+	Sequence int
 }
@@ -0,0 +1,108 @@
+package gonbui
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+)
+
+// This file implements a store shared by the programs of all cells: since each cell is compiled and
+// executed as a separate program, there is no state carried in memory from one execution to the next.
+// Instead, a program can store values with Store, and a later program (e.g.: of another cell) can
+// load them with Load.
+//
+// Values are serialized with the standard `encoding/gob` package, so they must be gob-encodable
+// (exported fields, interface values registered with gob.Register, etc.) and loaded into a variable
+// of a compatible type. See protocol.StoreMaxValueSize and protocol.StoreMaxTotalSize for the limits.
+//
+// The store is kept by the kernel, and it is lost when the kernel is restarted.
+
+// ErrNotStored is returned by Load when the key is not in the store.
+var ErrNotStored = errors.New("key not in store")
+
+var (
+	muStore sync.Mutex
+
+	// storedValues holds the values stored (or deleted, if empty) by this program: they are sent
+	// asynchronously to the kernel, so they are kept locally for Load.
+	storedValues = make(map[string][]byte)
+)
+
+// Store value under key in the store shared by the programs of all cells, so it can be read with Load
+// by programs executed later. The value is encoded with `encoding/gob`.
+//
+// It returns an error if the value can't be encoded, or if the key or the encoded value exceed the
+// limits of the store. If the kernel later fails to store the value (e.g.: the store is full), the
+// error is displayed in the cell.
+//
+// If not running in a notebook, the value is only available to Load in this same program.
+func Store(key string, value any) error {
+	if key == "" || len(key) > protocol.StoreMaxKeyLength {
+		return errors.Errorf("invalid store key %q, it must have between 1 and %d bytes", key, protocol.StoreMaxKeyLength)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return errors.Wrapf(err, "failed to encode value of type %T for key %q", value, key)
+	}
+	if buf.Len() > protocol.StoreMaxValueSize {
+		return errors.Errorf("encoded value for key %q has %d bytes, more than the limit of %d",
+			key, buf.Len(), protocol.StoreMaxValueSize)
+	}
+	return setStored(key, buf.Bytes())
+}
+
+// Delete key from the store shared by the programs of all cells. It is not an error if the key
+// is not in the store.
+func Delete(key string) error {
+	return setStored(key, []byte{})
+}
+
+// setStored records the encoded value of key locally and sends it to the kernel.
+func setStored(key string, encoded []byte) error {
+	muStore.Lock()
+	storedValues[key] = encoded
+	muStore.Unlock()
+	if !IsNotebook {
+		return nil
+	}
+	sendData(&protocol.DisplayData{
+		Data:     map[protocol.MIMEType]any{protocol.MIMEGonbStore: encoded},
+		Metadata: map[string]any{protocol.StoreKeyMetadata: key},
+	})
+	return Error()
+}
+
+// Load the value of key from the store shared by the programs of all cells into valuePtr, which
+// must be a pointer to a variable of a type compatible with the one stored (see `encoding/gob`).
+//
+// It returns ErrNotStored if the key is not in the store.
+func Load(key string, valuePtr any) error {
+	muStore.Lock()
+	encoded, found := storedValues[key]
+	muStore.Unlock()
+	if !found {
+		dir := os.Getenv(protocol.GONB_STORE_DIR_ENV)
+		if dir == "" {
+			return ErrNotStored
+		}
+		var err error
+		encoded, err = os.ReadFile(path.Join(dir, protocol.StoreFileName(key)))
+		if os.IsNotExist(err) {
+			return ErrNotStored
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to read key %q from store", key)
+		}
+	}
+	if len(encoded) == 0 {
+		return ErrNotStored // Deleted by this program.
+	}
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(valuePtr); err != nil {
+		return errors.Wrapf(err, "failed to decode value of key %q into %T", key, valuePtr)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package gonbui
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	type point struct{ X, Y int }
+
+	// Values stored by a previous program, saved by the kernel.
+	dir := t.TempDir()
+	t.Setenv(protocol.GONB_STORE_DIR_ENV, dir)
+	var buf bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&buf).Encode(point{1, 2}))
+	require.NoError(t, os.WriteFile(path.Join(dir, protocol.StoreFileName("p")), buf.Bytes(), 0600))
+	var p point
+	require.NoError(t, Load("p", &p))
+	assert.Equal(t, point{1, 2}, p)
+
+	// Values stored by this program.
+	require.NoError(t, Store("p", point{3, 4}))
+	require.NoError(t, Load("p", &p))
+	assert.Equal(t, point{3, 4}, p)
+	require.NoError(t, Store("names", []string{"a", "b"}))
+	var names []string
+	require.NoError(t, Load("names", &names))
+	assert.Equal(t, []string{"a", "b"}, names)
+
+	// Errors.
+	assert.True(t, errors.Is(Load("missing", &p), ErrNotStored))
+	require.NoError(t, Delete("p"))
+	assert.True(t, errors.Is(Load("p", &p), ErrNotStored))
+	assert.Error(t, Load("names", &p), "decoding into an incompatible type")
+	assert.Error(t, Store("", 1))
+	assert.Error(t, Store("f", func() {}), "functions can't be encoded")
+}
@@ -0,0 +1,93 @@
+package gonbui
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"html"
+	"reflect"
+	"strings"
+)
+
+// TableMaxRows is the maximum number of rows displayed by DisplayTable. Set to 0 for no limit.
+var TableMaxRows = 100
+
+// DisplayTable displays a slice (or array) of structs (or pointers to structs) as an HTML table,
+// similar to a DataFrame display. Each exported field of the struct becomes a column, with the
+// field name as its header. Slices of other types are displayed with one "value" column.
+//
+// At most TableMaxRows are displayed, see TableHTML to set a different limit.
+func DisplayTable(v any) error {
+	tableHTML, err := TableHTML(v, TableMaxRows)
+	if err != nil {
+		return err
+	}
+	DisplayHTML(tableHTML)
+	return nil
+}
+
+// TableHTML returns the HTML table that DisplayTable displays, showing at most maxRows rows
+// (0 for no limit).
+func TableHTML(v any, maxRows int) (string, error) {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return "", errors.Errorf("gonbui.TableHTML() requires a slice or array, got %T", v)
+	}
+	elemType := value.Type().Elem()
+	isPointer := elemType.Kind() == reflect.Pointer
+	if isPointer {
+		elemType = elemType.Elem()
+	}
+
+	// Find columns.
+	var fields []int
+	var headers []string
+	if elemType.Kind() == reflect.Struct {
+		for ii := 0; ii < elemType.NumField(); ii++ {
+			field := elemType.Field(ii)
+			if !field.IsExported() {
+				continue
+			}
+			fields = append(fields, ii)
+			headers = append(headers, field.Name)
+		}
+	} else {
+		headers = []string{"value"}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("<table>\n<tr><th></th>")
+	for _, header := range headers {
+		fmt.Fprintf(&sb, "<th>%s</th>", html.EscapeString(header))
+	}
+	sb.WriteString("</tr>\n")
+	numRows := value.Len()
+	if maxRows > 0 && numRows > maxRows {
+		numRows = maxRows
+	}
+	for row := 0; row < numRows; row++ {
+		fmt.Fprintf(&sb, "<tr><th>%d</th>", row)
+		elem := value.Index(row)
+		if isPointer {
+			if elem.IsNil() {
+				for range headers {
+					sb.WriteString("<td>&lt;nil&gt;</td>")
+				}
+				sb.WriteString("</tr>\n")
+				continue
+			}
+			elem = elem.Elem()
+		}
+		if fields == nil {
+			fmt.Fprintf(&sb, "<td>%s</td>", html.EscapeString(fmt.Sprint(elem.Interface())))
+		}
+		for _, fieldIdx := range fields {
+			fmt.Fprintf(&sb, "<td>%s</td>", html.EscapeString(fmt.Sprint(elem.Field(fieldIdx).Interface())))
+		}
+		sb.WriteString("</tr>\n")
+	}
+	sb.WriteString("</table>\n")
+	if numRows < value.Len() {
+		fmt.Fprintf(&sb, "<p>... (%d more rows)</p>\n", value.Len()-numRows)
+	}
+	return sb.String(), nil
+}
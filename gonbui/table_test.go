@@ -0,0 +1,36 @@
+package gonbui
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableHTML(t *testing.T) {
+	type point struct {
+		X, Y   int
+		hidden string
+	}
+	points := []*point{{1, 2, "a"}, nil, {5, 6, "c"}}
+	got, err := TableHTML(points, 2)
+	require.NoError(t, err)
+	assert.Equal(t, `<table>
+<tr><th></th><th>X</th><th>Y</th></tr>
+<tr><th>0</th><td>1</td><td>2</td></tr>
+<tr><th>1</th><td>&lt;nil&gt;</td><td>&lt;nil&gt;</td></tr>
+</table>
+<p>... (1 more rows)</p>
+`, got)
+
+	got, err = TableHTML([]string{"<a>"}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, `<table>
+<tr><th></th><th>value</th></tr>
+<tr><th>0</th><td>&lt;a&gt;</td></tr>
+</table>
+`, got)
+
+	_, err = TableHTML(points[0], 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,363 @@
+package kernel
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements the handling of ANSI escape sequences (e.g.: colors) in the output of executed
+// programs, see ANSIMode.
+
+// ANSIMode configures how ANSI escape sequences in the output of executed programs are forwarded to
+// Jupyter.
+type ANSIMode int
+
+const (
+	// ANSIRaw forwards the output untouched: the front-end may or may not render the sequences.
+	ANSIRaw ANSIMode = iota
+
+	// ANSIStrip removes the escape sequences from the output.
+	ANSIStrip
+
+	// ANSIHTML translates the SGR sequences (colors, bold, etc.) to HTML spans: output with styles is
+	// displayed as HTML, and other escape sequences (e.g.: cursor movement) are removed.
+	ANSIHTML
+)
+
+// ansiModeNames are the names of the ANSIMode values, used by String and ParseANSIMode.
+var ansiModeNames = []string{"raw", "strip", "html"}
+
+// String implements fmt.Stringer.
+func (m ANSIMode) String() string {
+	if m < 0 || int(m) >= len(ansiModeNames) {
+		return fmt.Sprintf("ANSIMode(%d)", int(m))
+	}
+	return ansiModeNames[m]
+}
+
+// ParseANSIMode parses the name of an ANSIMode: "raw", "strip" or "html".
+func ParseANSIMode(name string) (ANSIMode, error) {
+	for ii, modeName := range ansiModeNames {
+		if name == modeName {
+			return ANSIMode(ii), nil
+		}
+	}
+	return ANSIRaw, errors.Errorf("invalid ANSI mode %q, valid values are %q", name, ansiModeNames)
+}
+
+const (
+	ansiEscape = 0x1b
+
+	// ansiMaxPending is the maximum length of an escape sequence held while waiting for the
+	// rest of it (split across writes): longer sequences are considered malformed and dropped.
+	ansiMaxPending = 256
+
+	// ansiFlushInterval is how long output without a new line is held in ANSIHTML mode, before it
+	// is published anyway (e.g.: a prompt), see ansiWriter.
+	ansiFlushInterval = 100 * time.Millisecond
+)
+
+// ansiPalette holds the 16 basic colors: normal (codes 30-37/40-47) followed by bright (codes
+// 90-97/100-107).
+var ansiPalette = [16]string{
+	"#000000", "#cd3131", "#0dbc79", "#e5e510", "#2472c8", "#bc3fbc", "#11a8cd", "#e5e5e5",
+	"#666666", "#f14c4c", "#23d18b", "#f5f543", "#3b8eea", "#d670d6", "#29b8db", "#ffffff",
+}
+
+// ansiColor256 returns the CSS color of the given 256-colors palette index.
+func ansiColor256(index int) string {
+	switch {
+	case index < 16:
+		return ansiPalette[index]
+	case index < 232:
+		levels := [6]int{0, 95, 135, 175, 215, 255}
+		index -= 16
+		return fmt.Sprintf("#%02x%02x%02x", levels[index/36], levels[(index/6)%6], levels[index%6])
+	default:
+		gray := 8 + 10*(index-232)
+		return fmt.Sprintf("#%02x%02x%02x", gray, gray, gray)
+	}
+}
+
+// ansiStyle is the text style set by SGR sequences.
+type ansiStyle struct {
+	bold, dim, italic, underline bool
+	fg, bg                       string // CSS colors, empty for the default.
+}
+
+// css returns the CSS of the style, or "" for the default style.
+func (st ansiStyle) css() string {
+	var parts []string
+	if st.bold {
+		parts = append(parts, "font-weight:bold")
+	}
+	if st.dim {
+		parts = append(parts, "opacity:0.7")
+	}
+	if st.italic {
+		parts = append(parts, "font-style:italic")
+	}
+	if st.underline {
+		parts = append(parts, "text-decoration:underline")
+	}
+	if st.fg != "" {
+		parts = append(parts, "color:"+st.fg)
+	}
+	if st.bg != "" {
+		parts = append(parts, "background-color:"+st.bg)
+	}
+	return strings.Join(parts, ";")
+}
+
+// apply the parameters of an SGR sequence (e.g.: "1;31" for "\x1b[1;31m") to the style.
+func (st *ansiStyle) apply(params string) {
+	var codes []int
+	for _, param := range strings.Split(params, ";") {
+		code, err := strconv.Atoi(param)
+		if err != nil {
+			code = 0 // Empty parameters mean 0.
+		}
+		codes = append(codes, code)
+	}
+	for ii := 0; ii < len(codes); ii++ {
+		code := codes[ii]
+		switch {
+		case code == 0:
+			*st = ansiStyle{}
+		case code == 1:
+			st.bold = true
+		case code == 2:
+			st.dim = true
+		case code == 3:
+			st.italic = true
+		case code == 4:
+			st.underline = true
+		case code == 22:
+			st.bold, st.dim = false, false
+		case code == 23:
+			st.italic = false
+		case code == 24:
+			st.underline = false
+		case code >= 30 && code <= 37:
+			st.fg = ansiPalette[code-30]
+		case code >= 90 && code <= 97:
+			st.fg = ansiPalette[code-90+8]
+		case code == 39:
+			st.fg = ""
+		case code >= 40 && code <= 47:
+			st.bg = ansiPalette[code-40]
+		case code >= 100 && code <= 107:
+			st.bg = ansiPalette[code-100+8]
+		case code == 49:
+			st.bg = ""
+		case code == 38 || code == 48:
+			// Extended colors: "38;5;<index>" or "38;2;<r>;<g>;<b>".
+			var color string
+			if ii+2 < len(codes) && codes[ii+1] == 5 {
+				color = ansiColor256(codes[ii+2] & 0xff)
+				ii += 2
+			} else if ii+4 < len(codes) && codes[ii+1] == 2 {
+				color = fmt.Sprintf("#%02x%02x%02x", codes[ii+2]&0xff, codes[ii+3]&0xff, codes[ii+4]&0xff)
+				ii += 4
+			} else {
+				return // Malformed, ignore the rest.
+			}
+			if code == 38 {
+				st.fg = color
+			} else {
+				st.bg = color
+			}
+		}
+	}
+}
+
+// ansiSequenceLen returns the length of the escape sequence at the start of data (data[0] is ESC),
+// or complete=false if data ends before the sequence does.
+func ansiSequenceLen(data []byte) (length int, complete bool) {
+	if len(data) < 2 {
+		return 0, false
+	}
+	switch data[1] {
+	case '[':
+		// CSI: parameter bytes (0x30-0x3F), intermediate bytes (0x20-0x2F) and a final byte (0x40-0x7E).
+		for ii := 2; ii < len(data); ii++ {
+			c := data[ii]
+			if c >= 0x40 && c <= 0x7e {
+				return ii + 1, true
+			}
+			if c < 0x20 || c > 0x3f {
+				return ii, true // Malformed: drop what was read so far.
+			}
+		}
+		return 0, false
+	case ']':
+		// OSC: terminated by BEL or ST (ESC \).
+		for ii := 2; ii < len(data); ii++ {
+			if data[ii] == 0x07 {
+				return ii + 1, true
+			}
+			if data[ii] == ansiEscape && ii+1 < len(data) && data[ii+1] == '\\' {
+				return ii + 2, true
+			}
+		}
+		return 0, false
+	default:
+		return 2, true
+	}
+}
+
+// ansiWriter is an io.Writer that handles the ANSI escape sequences of the output written to it,
+// according to its mode (ANSIStrip or ANSIHTML), before forwarding it to the text writer -- or, for
+// styled output in ANSIHTML mode, to publishHTML.
+//
+// In ANSIHTML mode the output is processed by whole lines, so each published HTML block holds
+// complete lines (and as many as were written together), instead of arbitrary fragments. Output
+// without a new line is held for at most ansiFlushInterval, or until Flush or Close.
+//
+// Escape sequences split across writes are held until complete. Close must be called once the
+// output ends: it forwards what is still held, including an incomplete escape sequence, as text.
+type ansiWriter struct {
+	mode        ANSIMode
+	text        io.Writer
+	publishHTML func(html string) error
+
+	mu       sync.Mutex
+	buffered []byte      // ANSIHTML mode: output after the last new line, not processed yet.
+	timer    *time.Timer // Flushes buffered after ansiFlushInterval.
+	pending  []byte      // Incomplete escape sequence from the previous write.
+	style    ansiStyle
+}
+
+// newANSIWriter returns a writer that handles the ANSI escape sequences according to mode. For
+// ANSIRaw it returns text itself.
+func newANSIWriter(mode ANSIMode, text io.Writer, publishHTML func(html string) error) io.Writer {
+	if mode == ANSIRaw {
+		return text
+	}
+	return &ansiWriter{mode: mode, text: text, publishHTML: publishHTML}
+}
+
+// Write implements io.Writer.
+func (w *ansiWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.mode != ANSIHTML {
+		if err := w.process(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	w.buffered = append(w.buffered, p...)
+	if idx := bytes.LastIndexByte(w.buffered, '\n'); idx >= 0 {
+		lines := w.buffered[:idx+1]
+		w.buffered = append([]byte(nil), w.buffered[idx+1:]...)
+		if err := w.process(lines); err != nil {
+			return 0, err
+		}
+	}
+	if len(w.buffered) > 0 && w.timer == nil {
+		w.timer = time.AfterFunc(ansiFlushInterval, func() { _ = w.Flush() })
+	}
+	return len(p), nil
+}
+
+// Flush processes the output held waiting for a new line.
+func (w *ansiWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flushLocked()
+}
+
+func (w *ansiWriter) flushLocked() error {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	data := w.buffered
+	w.buffered = nil
+	return w.process(data)
+}
+
+// Close implements io.Closer: it forwards all the output held, including an incomplete escape
+// sequence at the end, which is written as text.
+func (w *ansiWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.flushLocked(); err != nil {
+		return err
+	}
+	if len(w.pending) == 0 {
+		return nil
+	}
+	_, err := w.text.Write(w.pending)
+	w.pending = nil
+	return err
+}
+
+// process handles the escape sequences of data, and forwards the result.
+func (w *ansiWriter) process(p []byte) error {
+	data := append(w.pending, p...)
+	w.pending = nil
+	var (
+		plain, htmlBuf bytes.Buffer
+		styled         bool // Whether any SGR sequence was found, or a style is active.
+	)
+	emit := func(segment []byte) {
+		if len(segment) == 0 {
+			return
+		}
+		plain.Write(segment)
+		if css := w.style.css(); css != "" {
+			styled = true
+			fmt.Fprintf(&htmlBuf, `<span style="%s">%s</span>`, css, html.EscapeString(string(segment)))
+		} else {
+			htmlBuf.WriteString(html.EscapeString(string(segment)))
+		}
+	}
+	for len(data) > 0 {
+		idx := bytes.IndexByte(data, ansiEscape)
+		if idx < 0 {
+			emit(data)
+			break
+		}
+		emit(data[:idx])
+		data = data[idx:]
+		seqLen, complete := ansiSequenceLen(data)
+		if !complete {
+			if len(data) < ansiMaxPending {
+				w.pending = append([]byte(nil), data...)
+			}
+			break
+		}
+		if seq := data[:seqLen]; seqLen > 2 && seq[1] == '[' && seq[seqLen-1] == 'm' {
+			w.style.apply(string(seq[2 : seqLen-1]))
+			styled = true
+		}
+		data = data[seqLen:]
+	}
+
+	if w.mode == ANSIHTML && styled && htmlBuf.Len() > 0 {
+		return w.publishHTML(`<pre style="margin:0">` + htmlBuf.String() + `</pre>`)
+	}
+	if plain.Len() > 0 {
+		if _, err := w.text.Write(plain.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeANSIWriter closes w if it was created by newANSIWriter with a mode other than ANSIRaw.
+func closeANSIWriter(w io.Writer) {
+	if aw, ok := w.(*ansiWriter); ok {
+		_ = aw.Close()
+	}
+}
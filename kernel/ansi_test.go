@@ -0,0 +1,77 @@
+package kernel
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestANSIWriter(t *testing.T) {
+	var text bytes.Buffer
+	var htmls []string
+	publishHTML := func(html string) error {
+		htmls = append(htmls, html)
+		return nil
+	}
+
+	// Raw mode: untouched.
+	assert.Equal(t, &text, newANSIWriter(ANSIRaw, &text, publishHTML))
+
+	// Strip mode, with sequences split across writes.
+	w := newANSIWriter(ANSIStrip, &text, publishHTML)
+	for _, chunk := range []string{"\x1b[1;3", "1mred\x1b", "[0m plain\x1b[2K\n", "\x1b]0;title\x07done\n"} {
+		_, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "red plain\ndone\n", text.String())
+	assert.Empty(t, htmls)
+
+	// Strip mode: an incomplete sequence at the end is written as text when closed.
+	text.Reset()
+	w = newANSIWriter(ANSIStrip, &text, publishHTML)
+	_, err := w.Write([]byte("done\x1b[3"))
+	require.NoError(t, err)
+	assert.Equal(t, "done", text.String())
+	require.NoError(t, w.(io.Closer).Close())
+	assert.Equal(t, "done\x1b[3", text.String())
+
+	// HTML mode: plain output remains text, styled output is published as HTML, by whole lines.
+	text.Reset()
+	w = newANSIWriter(ANSIHTML, &text, publishHTML)
+	for _, chunk := range []string{"plain <a>\n", "\x1b[31mred\x1b[0m ok\n", "\x1b[1;38;5;21mbold", " blue\n", "\x1b[0m"} {
+		_, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.(io.Closer).Close())
+	assert.Equal(t, "plain <a>\n", text.String())
+	assert.Equal(t, []string{
+		`<pre style="margin:0"><span style="color:#cd3131">red</span> ok` + "\n</pre>",
+		`<pre style="margin:0"><span style="font-weight:bold;color:#0000ff">bold blue` + "\n</span></pre>",
+	}, htmls)
+
+	// HTML mode: output without a new line is published after ansiFlushInterval.
+	text.Reset()
+	w = newANSIWriter(ANSIHTML, &text, publishHTML)
+	_, err = w.Write([]byte("prompt: "))
+	require.NoError(t, err)
+	aw := w.(*ansiWriter)
+	aw.mu.Lock()
+	assert.Equal(t, "", text.String())
+	aw.mu.Unlock()
+	time.Sleep(2 * ansiFlushInterval)
+	aw.mu.Lock()
+	assert.Equal(t, "prompt: ", text.String())
+	aw.mu.Unlock()
+	require.NoError(t, aw.Close())
+
+	mode, err := ParseANSIMode("html")
+	require.NoError(t, err)
+	assert.Equal(t, ANSIHTML, mode)
+	assert.Equal(t, "strip", ANSIStrip.String())
+	_, err = ParseANSIMode("colors")
+	assert.Error(t, err)
+}
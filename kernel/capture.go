@@ -0,0 +1,85 @@
+package kernel
+
+import "sync"
+
+// This file implements capturing the rich content displayed by programs (with the gonbui display
+// protocol, see processDisplayData) instead of publishing it, so it can be displayed later.
+
+var (
+	muDisplayCaptures sync.Mutex
+	displayCaptures   = make(map[Message]*DisplayCapture)
+)
+
+// DisplayCapture holds the display data captured for a Message, see CaptureDisplayData.
+type DisplayCapture struct {
+	msg  Message
+	mu   sync.Mutex
+	data []Data
+}
+
+// CaptureDisplayData starts capturing the rich content displayed by the programs executed for msg,
+// instead of publishing it. The capture ends with DisplayCapture.Stop.
+//
+// Content displayed with a display id (e.g.: updated HTML) is captured only in its last version.
+func CaptureDisplayData(msg Message) *DisplayCapture {
+	c := &DisplayCapture{msg: msg}
+	muDisplayCaptures.Lock()
+	defer muDisplayCaptures.Unlock()
+	displayCaptures[msg] = c
+	return c
+}
+
+// Stop capturing and return the display data captured, in order. The display ids are removed, so
+// the data can be displayed again as new content.
+func (c *DisplayCapture) Stop() []Data {
+	muDisplayCaptures.Lock()
+	if displayCaptures[c.msg] == c {
+		delete(displayCaptures, c.msg)
+	}
+	muDisplayCaptures.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ii := range c.data {
+		c.data[ii].Transient = make(MIMEMap)
+	}
+	return c.data
+}
+
+// captureDisplayData stores data if there is a capture for msg, and returns whether it was captured.
+func captureDisplayData(msg Message, data Data) bool {
+	muDisplayCaptures.Lock()
+	c, found := displayCaptures[msg]
+	muDisplayCaptures.Unlock()
+	if !found {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if displayID, ok := data.Transient["display_id"]; ok {
+		for ii, previous := range c.data {
+			if previous.Transient["display_id"] == displayID {
+				c.data[ii] = data
+				return true
+			}
+		}
+	}
+	c.data = append(c.data, data)
+	return true
+}
+
+// clearCapturedDisplayData discards the display data captured so far, if there is a capture for
+// msg, and returns whether there was one: the output being cleared is the one captured.
+func clearCapturedDisplayData(msg Message) bool {
+	muDisplayCaptures.Lock()
+	c, found := displayCaptures[msg]
+	muDisplayCaptures.Unlock()
+	if !found {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data = nil
+	return true
+}
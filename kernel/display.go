@@ -5,6 +5,7 @@ package kernel
 
 import (
 	"encoding/gob"
+	"encoding/json"
 	"github.com/janpfeifer/gonb/gonbui/protocol"
 	"github.com/pkg/errors"
 	"io"
@@ -15,17 +16,23 @@ import (
 // PollDisplayRequests will continuously read for incoming requests for displaying content on the notebook.
 // It expects pipeIn to be closed when the polling is to stop.
 func PollDisplayRequests(msg Message, pipeReader *os.File) {
+	pollDisplayRequests(pipeReader, func(data *protocol.DisplayData) { processDisplayData(msg, data) })
+}
+
+// pollDisplayRequests implements PollDisplayRequests, calling process with each content received.
+func pollDisplayRequests(pipeReader *os.File, process func(data *protocol.DisplayData)) {
 	decoder := gob.NewDecoder(pipeReader)
 	for {
 		data := &protocol.DisplayData{}
 		err := decoder.Decode(data)
-		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, os.ErrClosed) {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, os.ErrClosed) ||
+			errors.Is(err, os.ErrDeadlineExceeded) {
 			return
 		} else if err != nil {
 			log.Printf("Failed to read from named pipe, stopped polling for new data content: %+v", err)
 			return
 		}
-		processDisplayData(msg, data)
+		process(data)
 	}
 }
 
@@ -48,6 +55,39 @@ func logDisplayData(data MIMEMap) {
 
 // processDisplayData process an incoming `protocol.DisplayData` object.
 func processDisplayData(msg Message, data *protocol.DisplayData) {
+	if encoded, found := data.Data[protocol.MIMEGonbResultMetadata]; found {
+		processResultMetadata(msg, encoded)
+		return
+	}
+
+	if _, found := data.Data[protocol.MIMEGonbStore]; found {
+		processStore(msg, data)
+		return
+	}
+
+	if content, found := data.Data[protocol.MIMEGonbClearOutput]; found {
+		if clearCapturedDisplayData(msg) {
+			return
+		}
+		wait, _ := content.(bool)
+		if err := PublishClearOutput(msg, wait); err != nil {
+			log.Printf("Failed to clear output (ignoring): %v", err)
+		}
+		return
+	}
+
+	if encoded, found := data.Data[protocol.MIMEGonbError]; found {
+		rendered, err := renderErrorReport(encoded)
+		if err != nil {
+			log.Printf("Failed to display error report (ignoring): %+v", err)
+			return
+		}
+		data.Data = make(map[protocol.MIMEType]any, len(rendered))
+		for mimeType, content := range rendered {
+			data.Data[protocol.MIMEType(mimeType)] = content
+		}
+	}
+
 	// Log info about what is being displayed.
 	msgData := Data{
 		Data:      make(MIMEMap, len(data.Data)),
@@ -64,8 +104,27 @@ func processDisplayData(msg Message, data *protocol.DisplayData) {
 	if data.DisplayID != "" {
 		msgData.Transient["display_id"] = data.DisplayID
 	}
+	if captureDisplayData(msg, msgData) {
+		return
+	}
 	err := PublishDisplayData(msg, msgData)
 	if err != nil {
 		log.Printf("Failed to display data (ignoring): %v", err)
 	}
 }
+
+// processResultMetadata decodes the JSON object of a protocol.MIMEGonbResultMetadata, and merges it
+// into the metadata of the reply to msg.
+func processResultMetadata(msg Message, encoded any) {
+	encodedStr, ok := encoded.(string)
+	if !ok {
+		log.Printf("Invalid result metadata of type %T (ignoring), expected a JSON string", encoded)
+		return
+	}
+	metadata := make(map[string]any)
+	if err := json.Unmarshal([]byte(encodedStr), &metadata); err != nil {
+		log.Printf("Failed to decode result metadata (ignoring): %v", err)
+		return
+	}
+	msg.MergeResultMetadata(metadata)
+}
@@ -0,0 +1,71 @@
+package kernel
+
+import (
+	"testing"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessResultMetadata(t *testing.T) {
+	msg := &MessageImpl{}
+	for _, encoded := range []string{`{"a": 1, "b": "x"}`, `{"b": "y", "c": [1, 2]}`, `not json`} {
+		processDisplayData(msg, &protocol.DisplayData{
+			Data: map[protocol.MIMEType]any{protocol.MIMEGonbResultMetadata: encoded},
+		})
+	}
+	assert.Equal(t, map[string]any{"a": 1.0, "b": "y", "c": []any{1.0, 2.0}}, msg.resultMetadata)
+}
+
+func TestCaptureDisplayData(t *testing.T) {
+	msg := &MessageImpl{}
+	capture := CaptureDisplayData(msg)
+	for _, data := range []*protocol.DisplayData{
+		{Data: map[protocol.MIMEType]any{protocol.MIMETextHTML: "progress 0%"}, DisplayID: "progress"},
+		{Data: map[protocol.MIMEType]any{protocol.MIMEImagePNG: []byte{1, 2, 3}}},
+		{Data: map[protocol.MIMEType]any{protocol.MIMETextHTML: "progress 100%"}, DisplayID: "progress"},
+	} {
+		processDisplayData(msg, data)
+	}
+	captured := capture.Stop()
+	assert.Len(t, captured, 2)
+	assert.Equal(t, MIMEMap{string(protocol.MIMETextHTML): "progress 100%"}, captured[0].Data)
+	assert.Empty(t, captured[0].Transient)
+	assert.Equal(t, MIMEMap{string(protocol.MIMEImagePNG): []byte{1, 2, 3}}, captured[1].Data)
+	assert.False(t, captureDisplayData(msg, Data{}), "capture should have stopped")
+}
+
+func TestRenderErrorReport(t *testing.T) {
+	msg := &MessageImpl{}
+	capture := CaptureDisplayData(msg)
+	processDisplayData(msg, &protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{protocol.MIMEGonbError: `{"chain": [` +
+			`{"message": "loading <config>"}, ` +
+			`{"message": "file not found", "stack": ["main.load\n\t/tmp/main.go:10"]}]}`},
+	})
+	captured := capture.Stop()
+	assert.Len(t, captured, 1)
+	assert.Equal(t, "Error: loading <config>\nCaused by: file not found\n\tmain.load\n\t\t/tmp/main.go:10\n",
+		captured[0].Data[protocol.MIMETextPlain])
+	htmlContent := captured[0].Data[string(protocol.MIMETextHTML)].(string)
+	assert.Contains(t, htmlContent, "loading &lt;config&gt;")
+	assert.Contains(t, htmlContent, "<pre>main.load\n\t/tmp/main.go:10</pre>")
+}
+
+func TestClearOutput(t *testing.T) {
+	msg := newStreamsMessage(t)
+	processDisplayData(msg, &protocol.DisplayData{Data: map[protocol.MIMEType]any{protocol.MIMEGonbClearOutput: true}})
+	processDisplayData(msg, &protocol.DisplayData{Data: map[protocol.MIMEType]any{protocol.MIMEGonbClearOutput: false}})
+	assert.Equal(t, []string{`clear_output: {"wait":true}`, `clear_output: {"wait":false}`}, msg.published)
+
+	// While capturing, what was captured is cleared instead.
+	capture := CaptureDisplayData(msg)
+	processDisplayData(msg, &protocol.DisplayData{Data: map[protocol.MIMEType]any{protocol.MIMETextHTML: "frame 1"}})
+	processDisplayData(msg, &protocol.DisplayData{Data: map[protocol.MIMEType]any{protocol.MIMEGonbClearOutput: true}})
+	processDisplayData(msg, &protocol.DisplayData{Data: map[protocol.MIMEType]any{protocol.MIMETextHTML: "frame 2"}})
+	captured := capture.Stop()
+	require.Len(t, captured, 1)
+	assert.Equal(t, "frame 2", captured[0].Data[string(protocol.MIMETextHTML)])
+	assert.Len(t, msg.published, 2)
+}
@@ -0,0 +1,218 @@
+package kernel
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+)
+
+// This file keeps the order between the content displayed by a program (received from the named
+// pipe) and its stdout (received from its own pipe): since they are read concurrently, the content
+// could otherwise be published before the output that preceded it, or after the output that followed.
+//
+// Programs using gonbui write a marker (protocol.DisplaySyncMarker) to their stdout just before
+// sending each content, with its sequence number (protocol.DisplayData.Sequence). The stdout is
+// scanned for the markers (see displaySyncWriter), and each content is published when its marker
+// is reached: after the output that precedes it, and before the output that follows.
+
+// displaySyncTimeout is how long the publishing of a content waits for its marker in the stdout, or
+// how long the stdout waits for the content of a marker -- in case the program's stdout is not
+// connected to the kernel (e.g.: redirected), or the content never arrives.
+const displaySyncTimeout = time.Second
+
+// displaySync matches the contents received with their markers in the stdout, see displaySyncWriter.
+type displaySync struct {
+	msg Message
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending map[int]*protocol.DisplayData
+
+	// done holds the sequence numbers of the contents already published (or given up on), and
+	// closed is set once the stdout ended: further contents are published as they arrive.
+	done   map[int]bool
+	closed bool
+}
+
+func newDisplaySync(msg Message) *displaySync {
+	s := &displaySync{msg: msg, pending: make(map[int]*protocol.DisplayData), done: make(map[int]bool)}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// deliver is called with the contents received from the named pipe. Contents with a sequence number
+// are held until their marker is reached in the stdout, or for displaySyncTimeout.
+func (s *displaySync) deliver(data *protocol.DisplayData) {
+	seq := data.Sequence
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seq <= 0 || s.closed || s.done[seq] {
+		processDisplayData(s.msg, data)
+		return
+	}
+	s.pending[seq] = data
+	s.cond.Broadcast()
+	time.AfterFunc(displaySyncTimeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if data, found := s.pending[seq]; found {
+			log.Printf("Marker of displayed content #%d not found in stdout, displaying it anyway", seq)
+			s.publishLocked(seq, data)
+		}
+	})
+}
+
+// reached is called when the marker of the content with the given sequence number is reached in
+// the stdout, after the preceding output was published. It publishes the content, waiting for it
+// for at most displaySyncTimeout.
+func (s *displaySync) reached(seq int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done[seq] {
+		return
+	}
+	deadline := time.Now().Add(displaySyncTimeout)
+	timer := time.AfterFunc(displaySyncTimeout, func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer timer.Stop()
+	for s.pending[seq] == nil && time.Now().Before(deadline) {
+		s.cond.Wait()
+	}
+	if data, found := s.pending[seq]; found {
+		s.publishLocked(seq, data)
+	} else {
+		// Given up on: if the content arrives later, it is published as it comes.
+		s.done[seq] = true
+	}
+}
+
+// close is called when the stdout ends: the contents still pending are published, in order.
+func (s *displaySync) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	sequences := make([]int, 0, len(s.pending))
+	for seq := range s.pending {
+		sequences = append(sequences, seq)
+	}
+	sort.Ints(sequences)
+	for _, seq := range sequences {
+		s.publishLocked(seq, s.pending[seq])
+	}
+}
+
+func (s *displaySync) publishLocked(seq int, data *protocol.DisplayData) {
+	delete(s.pending, seq)
+	s.done[seq] = true
+	processDisplayData(s.msg, data)
+}
+
+// displaySyncWriter is an io.Writer that forwards the stdout of a program to w, removing the
+// markers of the displayed contents (see protocol.DisplaySyncMarker): the output before each
+// marker is written to w, and then its content is published.
+type displaySyncWriter struct {
+	w  io.Writer
+	ds *displaySync
+
+	// flushOutput, if set, is called before publishing a content, to flush the output held by w.
+	flushOutput func() error
+
+	// buf holds the end of the output that may be the start of a marker.
+	buf []byte
+}
+
+var (
+	displaySyncMarkerPrefix = []byte(protocol.DisplaySyncMarkerPrefix)
+	displaySyncMarkerSuffix = []byte(protocol.DisplaySyncMarkerSuffix)
+)
+
+// maxDisplaySyncMarkerLen is the maximum length of a valid marker: longer "markers" are not, and
+// are forwarded as is.
+var maxDisplaySyncMarkerLen = len(protocol.DisplaySyncMarker(1 << 62))
+
+func newDisplaySyncWriter(w io.Writer, ds *displaySync) *displaySyncWriter {
+	return &displaySyncWriter{w: w, ds: ds}
+}
+
+// Write implements io.Writer.
+func (w *displaySyncWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for len(w.buf) > 0 {
+		start := bytes.Index(w.buf, displaySyncMarkerPrefix)
+		if start < 0 {
+			// Keep the end of the output, if it could be the start of a marker.
+			keep := partialPrefixLen(w.buf, displaySyncMarkerPrefix)
+			if err := w.flush(len(w.buf) - keep); err != nil {
+				return 0, err
+			}
+			break
+		}
+		if err := w.flush(start); err != nil {
+			return 0, err
+		}
+		rest := w.buf[len(displaySyncMarkerPrefix):]
+		end := bytes.Index(rest, displaySyncMarkerSuffix)
+		if end < 0 && len(w.buf) < maxDisplaySyncMarkerLen {
+			break // Wait for the rest of the marker.
+		}
+		var seq int
+		var err error
+		if end >= 0 {
+			seq, err = strconv.Atoi(string(rest[:end]))
+		}
+		if end < 0 || err != nil || seq <= 0 {
+			// Not a marker: forward its first byte as output, and keep looking.
+			if err := w.flush(1); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		w.buf = rest[end+len(displaySyncMarkerSuffix):]
+		if w.flushOutput != nil {
+			if err := w.flushOutput(); err != nil {
+				return 0, err
+			}
+		}
+		w.ds.reached(seq)
+	}
+	return len(p), nil
+}
+
+// Close writes the output held, waiting for the rest of a marker, and publishes the pending contents.
+func (w *displaySyncWriter) Close() error {
+	err := w.flush(len(w.buf))
+	if w.flushOutput != nil && err == nil {
+		err = w.flushOutput()
+	}
+	w.ds.close()
+	return err
+}
+
+// flush writes the first n bytes held to w.
+func (w *displaySyncWriter) flush(n int) error {
+	if n == 0 {
+		return nil
+	}
+	_, err := w.w.Write(w.buf[:n])
+	w.buf = w.buf[n:]
+	return err
+}
+
+// partialPrefixLen returns the length of the longest suffix of data that is a (proper) prefix of prefix.
+func partialPrefixLen(data, prefix []byte) int {
+	for n := len(prefix) - 1; n > 0; n-- {
+		if len(data) >= n && bytes.Equal(data[len(data)-n:], prefix[:n]) {
+			return n
+		}
+	}
+	return 0
+}
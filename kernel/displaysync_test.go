@@ -0,0 +1,90 @@
+package kernel
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisplaySyncWriter(t *testing.T) {
+	msg := newStreamsMessage(t)
+	ds := newDisplaySync(msg)
+	w := newDisplaySyncWriter(NewJupyterStreamWriter(msg, StreamStdout), ds)
+	html := func(seq int) *protocol.DisplayData {
+		return &protocol.DisplayData{Data: map[protocol.MIMEType]any{protocol.MIMETextHTML: fmt.Sprintf("display %d", seq)}, Sequence: seq}
+	}
+
+	// Content 1 arrives before its marker is reached, which is split across writes.
+	ds.deliver(html(1))
+	marker := protocol.DisplaySyncMarker(1)
+	_, _ = w.Write([]byte("a\n" + marker[:5]))
+	_, _ = w.Write([]byte(marker[5:] + "b\n"))
+	// Content 2 arrives after its marker is reached.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ds.deliver(html(2))
+	}()
+	_, _ = w.Write([]byte(protocol.DisplaySyncMarker(2) + "c\x1b_not a marker\n"))
+	// Content 3 has no marker.
+	ds.deliver(html(3))
+	require.NoError(t, w.Close())
+
+	msg.mu.Lock()
+	defer msg.mu.Unlock()
+	require.Len(t, msg.published, 6)
+	assert.Contains(t, msg.published[0], `"text":"a\n"`)
+	assert.Contains(t, msg.published[1], "display 1")
+	assert.Contains(t, msg.published[2], `"text":"b\n"`)
+	assert.Contains(t, msg.published[3], "display 2")
+	assert.Contains(t, msg.published[4], `"text":"c\u001b_not a marker\n"`)
+	assert.Contains(t, msg.published[5], "display 3")
+}
+
+// TestDisplayOrder checks that content displayed with gonbui is published in order with the
+// stdout of the program.
+func TestDisplayOrder(t *testing.T) {
+	binPath := buildModuleTestProgram(t, `package main
+
+import (
+	"fmt"
+
+	"github.com/janpfeifer/gonb/gonbui"
+)
+
+func main() {
+	for ii := 1; ii <= 20; ii++ {
+		fmt.Printf("line %d\n", ii)
+		gonbui.DisplayHTML(fmt.Sprintf("display %d", ii))
+	}
+}
+`)
+	msg := newStreamsMessage(t)
+	require.NoError(t, NewPipeExecToJupyterBuilder(msg, binPath).WithDisplaySync().Exec())
+	msg.mu.Lock()
+	defer msg.mu.Unlock()
+	var outputs []string
+	for _, published := range msg.published {
+		switch {
+		case strings.HasPrefix(published, "stream: "):
+			// Lines may be published together.
+			for _, line := range strings.Split(published, `\n`) {
+				if idx := strings.Index(line, "line "); idx >= 0 {
+					outputs = append(outputs, line[idx:])
+				}
+			}
+		case strings.HasPrefix(published, "display_data: "):
+			idx := strings.Index(published, "display ")
+			outputs = append(outputs, published[idx:idx+len("display ")+strings.IndexByte(published[idx+len("display "):], '"')])
+		}
+	}
+	var want []string
+	for ii := 1; ii <= 20; ii++ {
+		want = append(want, fmt.Sprintf("line %d", ii), fmt.Sprintf("display %d", ii))
+	}
+	assert.Equal(t, want, outputs)
+}
@@ -0,0 +1,49 @@
+package kernel
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+	"html"
+	"strings"
+)
+
+// This file implements the rendering of errors reported by programs with gonbui.DisplayError, see
+// protocol.MIMEGonbError.
+
+// renderErrorReport decodes the JSON protocol.ErrorReport in encoded, and returns it rendered as HTML
+// and plain text.
+func renderErrorReport(encoded any) (MIMEMap, error) {
+	encodedStr, ok := encoded.(string)
+	if !ok {
+		return nil, errors.Errorf("invalid error report of type %T, expected a JSON string", encoded)
+	}
+	var report protocol.ErrorReport
+	if err := json.Unmarshal([]byte(encodedStr), &report); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode error report")
+	}
+
+	var htmlBuf, textBuf strings.Builder
+	htmlBuf.WriteString(`<div style="border-left: 4px solid #d9534f; padding-left: 8px">` + "\n")
+	for ii, layer := range report.Chain {
+		if ii == 0 {
+			fmt.Fprintf(&htmlBuf, "<b>Error:</b> %s\n", html.EscapeString(layer.Message))
+			fmt.Fprintf(&textBuf, "Error: %s\n", layer.Message)
+		} else {
+			fmt.Fprintf(&htmlBuf, "<br/><b>Caused by:</b> %s\n", html.EscapeString(layer.Message))
+			fmt.Fprintf(&textBuf, "Caused by: %s\n", layer.Message)
+		}
+		if len(layer.Stack) == 0 {
+			continue
+		}
+		stack := strings.Join(layer.Stack, "\n")
+		fmt.Fprintf(&htmlBuf, "<details><summary>Stack trace</summary><pre>%s</pre></details>\n", html.EscapeString(stack))
+		textBuf.WriteString("\t" + strings.ReplaceAll(stack, "\n", "\n\t") + "\n")
+	}
+	htmlBuf.WriteString("</div>\n")
+	return MIMEMap{
+		string(protocol.MIMETextHTML): htmlBuf.String(),
+		protocol.MIMETextPlain:        textBuf.String(),
+	}, nil
+}
@@ -0,0 +1,159 @@
+package kernel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements the static file server of the kernel (see gonbui.ServeFile): files generated
+// by programs (e.g.: images or other assets) that are referenced by the HTML they display need to be
+// accessible to the browser.
+//
+// Programs copy the files to the directory of the server, passed in the environment variable
+// protocol.GONB_FILES_DIR_ENV, and reference them under the URL passed in protocol.GONB_FILES_URL_ENV.
+// The server is bound to localhost, and the URL includes a random token, so only the files in its
+// directory, by their exact paths, are reachable. The directory is removed when the kernel is stopped.
+
+// FileServer serves the files in a directory over HTTP, see Kernel.FileServer.
+type FileServer struct {
+	dir, url string
+	server   *http.Server
+}
+
+// FileServer returns the static file server of the kernel, starting it on first use, in a new
+// temporary directory. It is stopped, and the directory removed, when the kernel is stopped.
+func (k *Kernel) FileServer() (*FileServer, error) {
+	k.muFileServer.Lock()
+	defer k.muFileServer.Unlock()
+	if k.fileServer == nil {
+		fs, err := startFileServer()
+		if err != nil {
+			return nil, err
+		}
+		k.fileServer = fs
+	}
+	return k.fileServer, nil
+}
+
+// startFileServer creates the directory and starts serving it on a random port of localhost.
+func startFileServer() (*FileServer, error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, errors.Wrapf(err, "failed to generate token for the file server")
+	}
+	token := hex.EncodeToString(tokenBytes)
+	dir, err := os.MkdirTemp("", "gonb_files_")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create directory for the file server")
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, errors.Wrapf(err, "failed to listen for the file server")
+	}
+	prefix := "/" + token + "/"
+	mux := http.NewServeMux()
+	mux.Handle(prefix, http.StripPrefix(prefix, noDirListing(http.FileServer(http.Dir(dir)))))
+	fs := &FileServer{
+		dir:    dir,
+		url:    fmt.Sprintf("http://%s%s", listener.Addr().String(), prefix),
+		server: &http.Server{Handler: mux},
+	}
+	go func() {
+		if err := fs.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("File server %q failed: %+v", fs.url, err)
+		}
+	}()
+	return fs, nil
+}
+
+// noDirListing wraps handler to refuse listing directories.
+func noDirListing(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "" || strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Dir returns the directory of the files served.
+func (fs *FileServer) Dir() string {
+	return fs.dir
+}
+
+// URL returns the URL, ending in "/", under which the files of Dir are served.
+func (fs *FileServer) URL() string {
+	return fs.url
+}
+
+// MoveFile moves the file in srcPath to a new randomly named subdirectory of Dir, under the given
+// name, and returns the URL under which it is served.
+func (fs *FileServer) MoveFile(srcPath, name string) (string, error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", errors.Wrapf(err, "failed to generate id for file %q", srcPath)
+	}
+	id := hex.EncodeToString(idBytes)
+	dir := path.Join(fs.dir, id)
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return "", errors.Wrapf(err, "failed to create directory for file %q", srcPath)
+	}
+	if err := moveFile(srcPath, path.Join(dir, name)); err != nil {
+		return "", err
+	}
+	return fs.url + id + "/" + name, nil
+}
+
+// moveFile renames srcPath to dstPath, or, if that fails (e.g.: across file systems), copies it
+// without loading it all in memory, and removes srcPath.
+func moveFile(srcPath, dstPath string) error {
+	if err := os.Rename(srcPath, dstPath); err == nil {
+		return nil
+	}
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %q", srcPath)
+	}
+	defer func() { _ = src.Close() }()
+	dst, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %q", dstPath)
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return errors.Wrapf(err, "failed to copy %q to %q", srcPath, dstPath)
+	}
+	if err = dst.Close(); err != nil {
+		return errors.Wrapf(err, "failed to close %q", dstPath)
+	}
+	_ = os.Remove(srcPath)
+	return nil
+}
+
+// stopFileServer stops the file server and removes its directory, if one was started.
+func (k *Kernel) stopFileServer() {
+	k.muFileServer.Lock()
+	defer k.muFileServer.Unlock()
+	if k.fileServer == nil {
+		return
+	}
+	if err := k.fileServer.server.Close(); err != nil {
+		log.Printf("Failed to stop file server %q: %+v", k.fileServer.url, err)
+	}
+	if err := os.RemoveAll(k.fileServer.dir); err != nil {
+		log.Printf("Failed to remove file server directory %q: %+v", k.fileServer.dir, err)
+	}
+	k.fileServer = nil
+}
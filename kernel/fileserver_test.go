@@ -0,0 +1,54 @@
+package kernel
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileServer(t *testing.T) {
+	k := &Kernel{}
+	defer k.stopFileServer()
+	fs, err := k.FileServer()
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(fs.URL(), "http://127.0.0.1:"))
+	require.NoError(t, os.Mkdir(path.Join(fs.Dir(), "abc"), 0700))
+	require.NoError(t, os.WriteFile(path.Join(fs.Dir(), "abc", "plot.svg"), []byte("<svg/>"), 0600))
+
+	get := func(url string) (int, string) {
+		resp, err := http.Get(url)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+	status, body := get(fs.URL() + "abc/plot.svg")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "<svg/>", body)
+
+	// Directories are not listed, and the token is required.
+	status, _ = get(fs.URL() + "abc/")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = get(fs.URL())
+	assert.Equal(t, http.StatusNotFound, status)
+	host := strings.Join(strings.SplitN(fs.URL(), "/", 4)[:3], "/") // "http://127.0.0.1:<port>"
+	status, _ = get(host + "/abc/plot.svg")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = get(host + "/wrongtoken/abc/plot.svg")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// Same server on later calls, removed when stopped.
+	fs2, err := k.FileServer()
+	require.NoError(t, err)
+	assert.Equal(t, fs.URL(), fs2.URL())
+	k.stopFileServer()
+	_, err = os.Stat(fs.Dir())
+	assert.True(t, os.IsNotExist(err))
+}
@@ -98,9 +98,22 @@ type Kernel struct {
 	// Interrupted indicates whether shell currently being executed was Interrupted.
 	Interrupted atomic.Bool
 
+	// interruptCallbacks are called whenever an interruption is received. See OnInterrupt.
+	muInterrupt             sync.Mutex
+	interruptCallbacks      map[int]func()
+	nextInterruptCallbackID int
+
 	// stdinMsg holds the MessageImpl that last asked from input from stdin (MessageImpl.PromptInput).
 	stdinMsg *MessageImpl
 	stdinFn  OnInputFn // Callback when stdin input is received.
+
+	// store shared by the programs executed, created on first use. See Kernel.Store.
+	muStore sync.Mutex
+	store   *Store
+
+	// fileServer of static files generated by the programs, started on first use. See Kernel.FileServer.
+	muFileServer sync.Mutex
+	fileServer   *FileServer
 }
 
 // IsStopped returns whether the Kernel has been stopped.
@@ -123,6 +136,8 @@ func (k *Kernel) StoppedChan() <-chan struct{} {
 // Stop the Kernel, indicating to all polling processes to quit.
 func (k *Kernel) Stop() {
 	close(k.stop)
+	k.removeStore()
+	k.stopFileServer()
 }
 
 // HandleInterrupt will configure the kernel to listen to the system SIGINT,
@@ -146,6 +161,7 @@ func (k *Kernel) HandleInterrupt() {
 				case <-k.sigintC:
 					k.Interrupted.Store(true)
 					log.Printf("INTERRUPT received")
+					k.callInterruptCallbacks()
 				case <-k.stop:
 					return // kernel stopped.
 				}
@@ -154,6 +170,39 @@ func (k *Kernel) HandleInterrupt() {
 	}
 }
 
+// OnInterrupt registers fn to be called whenever an interruption is received (see HandleInterrupt).
+// It returns a function that unregisters fn.
+//
+// fn is called synchronously from the goroutine handling the signals, so it shouldn't block.
+func (k *Kernel) OnInterrupt(fn func()) (unregister func()) {
+	k.muInterrupt.Lock()
+	defer k.muInterrupt.Unlock()
+	if k.interruptCallbacks == nil {
+		k.interruptCallbacks = make(map[int]func())
+	}
+	id := k.nextInterruptCallbackID
+	k.nextInterruptCallbackID++
+	k.interruptCallbacks[id] = fn
+	return func() {
+		k.muInterrupt.Lock()
+		defer k.muInterrupt.Unlock()
+		delete(k.interruptCallbacks, id)
+	}
+}
+
+// callInterruptCallbacks calls all functions registered with OnInterrupt.
+func (k *Kernel) callInterruptCallbacks() {
+	k.muInterrupt.Lock()
+	callbacks := make([]func(), 0, len(k.interruptCallbacks))
+	for _, fn := range k.interruptCallbacks {
+		callbacks = append(callbacks, fn)
+	}
+	k.muInterrupt.Unlock()
+	for _, fn := range callbacks {
+		fn()
+	}
+}
+
 // ExitWait will wait for the kernel to be stopped and all polling
 // goroutines to finish.
 func (k *Kernel) ExitWait() {
@@ -174,7 +223,7 @@ type MsgOrError struct {
 // connection to kernel was closed.
 //
 // These are messages received from a console like input, while executing
-// a command. For MessageImpl.PipeExecToJupyterWithInput to work, you need
+// a command. For PipeExecToJupyterBuilder.WithInputs to work, you need
 // to call MessageImpl.DeliverInput() on these messages.
 func (k *Kernel) Stdin() <-chan Message {
 	return k.stdin
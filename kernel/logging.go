@@ -0,0 +1,84 @@
+package kernel
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// LogLevel of the kernel logs: messages with a level above the current one (see SetLogLevel)
+// are discarded.
+type LogLevel int32
+
+const (
+	// LogLevelError only logs errors.
+	LogLevelError LogLevel = iota
+
+	// LogLevelInfo logs general information about the kernel execution, the default.
+	LogLevelInfo
+
+	// LogLevelDebug also logs verbose diagnostic information, like the tracking of the cursor
+	// position and line mappings, useful when reporting issues.
+	LogLevelDebug
+)
+
+var logLevelNames = []string{"error", "info", "debug"}
+
+// String implements fmt.Stringer.
+func (l LogLevel) String() string {
+	if l < 0 || int(l) >= len(logLevelNames) {
+		return fmt.Sprintf("LogLevel(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+// ParseLogLevel parses the name of a log level ("error", "info" or "debug").
+func ParseLogLevel(name string) (LogLevel, error) {
+	for ii, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return LogLevel(ii), nil
+		}
+	}
+	return LogLevelInfo, errors.Errorf("invalid log level %q, valid values are %q", name, logLevelNames)
+}
+
+var currentLogLevel atomic.Int32
+
+func init() {
+	currentLogLevel.Store(int32(LogLevelInfo))
+}
+
+// SetLogLevel sets the level of the messages logged with Logf.
+func SetLogLevel(level LogLevel) {
+	currentLogLevel.Store(int32(level))
+}
+
+// GetLogLevel returns the current log level.
+func GetLogLevel() LogLevel {
+	return LogLevel(currentLogLevel.Load())
+}
+
+// LogRecord formats a log message as a key=value record, with its level: e.g. `level=info msg="..."`.
+func LogRecord(level LogLevel, msg string) string {
+	return fmt.Sprintf("level=%s msg=%s", level, strconv.Quote(msg))
+}
+
+// Logf logs the message with the standard `log` package, formatted by LogRecord, if level is
+// enabled (see SetLogLevel).
+func Logf(level LogLevel, format string, args ...any) {
+	if level > GetLogLevel() {
+		return
+	}
+	_ = log.Output(2, LogRecord(level, fmt.Sprintf(format, args...)))
+}
+
+// Debugf logs with LogLevelDebug, see Logf.
+func Debugf(format string, args ...any) {
+	if LogLevelDebug > GetLogLevel() {
+		return
+	}
+	_ = log.Output(2, LogRecord(LogLevelDebug, fmt.Sprintf(format, args...)))
+}
@@ -0,0 +1,41 @@
+package kernel
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogf(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	flags := log.Flags()
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+		SetLogLevel(LogLevelInfo)
+	}()
+
+	level, err := ParseLogLevel("Debug")
+	require.NoError(t, err)
+	assert.Equal(t, LogLevelDebug, level)
+	_, err = ParseLogLevel("verbose")
+	assert.Error(t, err)
+
+	Debugf("hidden %d", 1)
+	Logf(LogLevelInfo, "shown %d", 2)
+	SetLogLevel(LogLevelDebug)
+	Debugf("shown %d", 3)
+	SetLogLevel(LogLevelError)
+	Logf(LogLevelInfo, "hidden %d", 4)
+	Logf(LogLevelError, "failed: %q", "x")
+	assert.Equal(t, `level=info msg="shown 2"
+level=debug msg="shown 3"
+level=error msg="failed: \"x\""
+`, buf.String())
+}
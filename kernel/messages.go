@@ -7,6 +7,7 @@ import (
 	"io"
 	"log"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/go-zeromq/zmq4"
@@ -81,6 +82,16 @@ type CompleteReply struct {
 	Metadata    MIMEMap  `json:"metadata"`
 }
 
+// IsCompleteReply message sent in reply to an "is_complete_request": used by console front-ends
+// to decide whether to execute the code entered so far, or to keep reading input.
+type IsCompleteReply struct {
+	// Status is one of "complete", "incomplete", "invalid" or "unknown".
+	Status string `json:"status"`
+
+	// Indent is a hint of the indentation of the next line, only if Status is "incomplete".
+	Indent string `json:"indent,omitempty"`
+}
+
 // InspectReply message sent in reply to an "inspect_request": used for introspection on the
 // code under a certain position of the cursor.
 type InspectReply struct {
@@ -133,8 +144,14 @@ type Message interface {
 	DeliverInput() error
 
 	// Reply creates a new ComposedMsg and sends it back to the return identities over the
-	// Shell channel.
+	// Shell channel. Any result metadata (see MergeResultMetadata) is included in the
+	// reply's metadata.
 	Reply(msgType string, content interface{}) error
+
+	// MergeResultMetadata merges the given metadata into the metadata to be sent with the
+	// reply to this message. Used by programs to report results (see
+	// gonbui.SetResultMetadata).
+	MergeResultMetadata(metadata map[string]any)
 }
 
 // MessageImpl represents a received message or an Error, with its return identities, and
@@ -144,6 +161,9 @@ type MessageImpl struct {
 	Composed   ComposedMsg
 	Identities [][]byte
 	kernel     *Kernel
+
+	muResultMetadata sync.Mutex
+	resultMetadata   map[string]any
 }
 
 // Error returns the error receiving the message, or nil if no error.
@@ -279,12 +299,33 @@ func (m *MessageImpl) Reply(msgType string, content interface{}) error {
 	}
 
 	msg.Content = content
+	m.muResultMetadata.Lock()
+	if len(m.resultMetadata) > 0 {
+		msg.Metadata = make(map[string]any, len(m.resultMetadata))
+		for key, value := range m.resultMetadata {
+			msg.Metadata[key] = value
+		}
+	}
+	m.muResultMetadata.Unlock()
 	log.Printf("Reply(%s):", msgType)
 	return m.kernel.sockets.ShellSocket.RunLocked(func(shell zmq4.Socket) error {
 		return m.sendMessage(shell, msg)
 	})
 }
 
+// MergeResultMetadata merges the given metadata into the metadata to be sent with the reply to
+// this message.
+func (m *MessageImpl) MergeResultMetadata(metadata map[string]any) {
+	m.muResultMetadata.Lock()
+	defer m.muResultMetadata.Unlock()
+	if m.resultMetadata == nil {
+		m.resultMetadata = make(map[string]any, len(metadata))
+	}
+	for key, value := range metadata {
+		m.resultMetadata[key] = value
+	}
+}
+
 func EnsureMIMEMap(bundle MIMEMap) MIMEMap {
 	if bundle == nil {
 		bundle = make(MIMEMap)
@@ -347,6 +388,16 @@ func PublishDisplayData(msg Message, data Data) error {
 	})
 }
 
+// PublishClearOutput publishes a `clear_output` message, which clears the output of the cell. If
+// wait is true, the output is only cleared when new output is available.
+func PublishClearOutput(msg Message, wait bool) error {
+	return msg.Publish("clear_output", struct {
+		Wait bool `json:"wait"`
+	}{
+		Wait: wait,
+	})
+}
+
 // PublishDisplayDataWithHTML is a shortcut to PublishDisplayData for HTML content.
 func PublishDisplayDataWithHTML(msg Message, html string) error {
 	msgData := Data{
@@ -359,6 +410,18 @@ func PublishDisplayDataWithHTML(msg Message, html string) error {
 	return PublishDisplayData(msg, msgData)
 }
 
+// PublishDisplayDataWithMarkdown is a shortcut to PublishDisplayData for Markdown content.
+func PublishDisplayDataWithMarkdown(msg Message, markdown string) error {
+	msgData := Data{
+		Data:      make(MIMEMap, 1),
+		Metadata:  make(MIMEMap),
+		Transient: make(MIMEMap),
+	}
+	msgData.Data[string(protocol.MIMETextMarkdown)] = markdown
+	logDisplayData(msgData.Data)
+	return PublishDisplayData(msg, msgData)
+}
+
 const (
 	// StreamStdout defines the stream name for standard out on the front-end. It
 	// is used in `PublishWriteStream` to specify the stream to write to.
@@ -419,16 +482,26 @@ func PublishKernelStatus(msg Message, status string) error {
 }
 
 // SendKernelInfo sends a kernel_info_reply message.
-func SendKernelInfo(msg Message, version string) error {
+//
+// goVersion is the version of the go toolchain used, if empty the version of Go used to build the
+// kernel is reported. If goToolchainErr is not nil, it is included in the banner.
+func SendKernelInfo(msg Message, version, goVersion string, goToolchainErr error) error {
+	if goVersion == "" {
+		goVersion = runtime.Version()
+	}
+	banner := fmt.Sprintf("Go kernel: gonb - v%s (%s)", version, goVersion)
+	if goToolchainErr != nil {
+		banner += fmt.Sprintf("\n\nWARNING: %v", goToolchainErr)
+	}
 	return msg.Reply("kernel_info_reply",
 		KernelInfo{
 			ProtocolVersion:       ProtocolVersion,
 			Implementation:        "gonb",
 			ImplementationVersion: version,
-			Banner:                fmt.Sprintf("Go kernel: gonb - v%s", version),
+			Banner:                banner,
 			LanguageInfo: KernelLanguageInfo{
 				Name:          "go",
-				Version:       runtime.Version(),
+				Version:       goVersion,
 				FileExtension: ".go",
 			},
 			HelpLinks: []HelpLink{
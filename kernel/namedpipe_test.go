@@ -0,0 +1,48 @@
+package kernel
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNamedPipeDrained checks that content written to the named pipe right before the program
+// finishes is still processed.
+func TestNamedPipeDrained(t *testing.T) {
+	msg := newStreamsMessage(t)
+	doneChan := make(chan struct{})
+	pipePath, err := StartNamedPipe(msg, t.TempDir(), doneChan)
+	require.NoError(t, err)
+	w, err := os.OpenFile(pipePath, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	encoder := gob.NewEncoder(w)
+	// Values larger than the pipe's buffer, so the last one is still being read when the program
+	// finishes.
+	const numValues = 10
+	value := make([]byte, 1<<20)
+	for ii := 0; ii < numValues; ii++ {
+		require.NoError(t, encoder.Encode(&protocol.DisplayData{
+			Data:     map[protocol.MIMEType]any{protocol.MIMEGonbStore: value},
+			Metadata: map[string]any{protocol.StoreKeyMetadata: fmt.Sprintf("key%03d", ii)},
+		}))
+	}
+	require.NoError(t, w.Close())
+	close(doneChan) // The program finished.
+
+	store, err := msg.kernel.Store()
+	require.NoError(t, err)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		keys, _ := store.Sizes()
+		if len(keys) == numValues {
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "only %d of %d values stored", len(keys), numValues)
+		time.Sleep(10 * time.Millisecond)
+	}
+}
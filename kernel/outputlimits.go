@@ -0,0 +1,214 @@
+package kernel
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements limits on the output of executed programs, to protect the front-end
+// from accidentally being flooded.
+
+// OutputLimits configures how much output of an executed program is forwarded to Jupyter.
+// After the limits are reached, further output is discarded and a notice is displayed
+// at the end of the execution.
+type OutputLimits struct {
+	// MaxLines and MaxBytes of output forwarded to Jupyter, summed over stdout and stderr.
+	// Zero means no limit.
+	MaxLines, MaxBytes int
+
+	// SpillToFile indicates that the full output should also be saved to a temporary file,
+	// whose path is displayed if the output is truncated.
+	SpillToFile bool
+
+	// ServeSpilled indicates that, if the output is truncated, the file with the full output
+	// (see SpillToFile) is moved to the kernel's file server, and a link to download it is
+	// displayed instead of its path. It only has effect if SpillToFile is also set.
+	ServeSpilled bool
+}
+
+// DefaultOutputLimits used by the kernel.
+var DefaultOutputLimits = OutputLimits{MaxLines: 10_000, MaxBytes: 1 << 20}
+
+// IsLimited returns whether any limit is set.
+func (l OutputLimits) IsLimited() bool {
+	return l.MaxLines > 0 || l.MaxBytes > 0
+}
+
+// String implements fmt.Stringer.
+func (l OutputLimits) String() string {
+	if !l.IsLimited() {
+		return "no output limits"
+	}
+	spill := fmt.Sprint(l.SpillToFile)
+	if l.SpillToFile && l.ServeSpilled {
+		spill = "link"
+	}
+	return fmt.Sprintf("lines=%d bytes=%d spill=%s", l.MaxLines, l.MaxBytes, spill)
+}
+
+// outputLimiter is shared by the writers (stdout and stderr) of one execution, and keeps track
+// of the output forwarded so far.
+type outputLimiter struct {
+	OutputLimits
+
+	mu                         sync.Mutex
+	lines, bytes               int
+	truncated                  bool
+	skippedLines, skippedBytes int
+	lastSkippedByte            byte
+	spill                      *os.File
+}
+
+// newOutputLimiter creates an outputLimiter. If limits.SpillToFile is set, it also creates
+// the file where the full output is saved.
+func newOutputLimiter(limits OutputLimits) *outputLimiter {
+	l := &outputLimiter{OutputLimits: limits}
+	if limits.IsLimited() && limits.SpillToFile {
+		var err error
+		l.spill, err = os.CreateTemp("", "gonb_output_*.txt")
+		if err != nil {
+			log.Printf("Failed to create file to save full output, ignoring: %+v", err)
+			l.spill = nil
+		}
+	}
+	return l
+}
+
+// Wrap returns an io.Writer that writes to w, but subject to the limits.
+func (l *outputLimiter) Wrap(w io.Writer) io.Writer {
+	if !l.IsLimited() {
+		return w
+	}
+	return &limitedWriter{limiter: l, w: w}
+}
+
+type limitedWriter struct {
+	limiter *outputLimiter
+	w       io.Writer
+}
+
+// Write implements io.Writer. It always reports everything as written, even when the
+// output is being discarded.
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	l := lw.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.spill != nil {
+		if _, err := l.spill.Write(p); err != nil {
+			log.Printf("Failed to save output to %q, ignoring: %+v", l.spill.Name(), err)
+		}
+	}
+	if l.truncated {
+		l.skip(p)
+		return len(p), nil
+	}
+
+	allowed := p
+	if l.MaxBytes > 0 && l.bytes+len(allowed) > l.MaxBytes {
+		allowed = allowed[:l.MaxBytes-l.bytes]
+	}
+	if l.MaxLines > 0 {
+		remaining := l.MaxLines - l.lines
+		for pos, count := 0, 0; pos < len(allowed); pos++ {
+			if count == remaining {
+				allowed = allowed[:pos]
+				break
+			}
+			if allowed[pos] == '\n' {
+				count++
+			}
+		}
+		if remaining == 0 {
+			allowed = allowed[:0]
+		}
+	}
+	l.bytes += len(allowed)
+	l.lines += bytes.Count(allowed, []byte{'\n'})
+	if len(allowed) < len(p) {
+		l.truncated = true
+		l.skip(p[len(allowed):])
+	}
+	if len(allowed) > 0 {
+		if _, err := lw.w.Write(allowed); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// skip accounts for discarded output.
+func (l *outputLimiter) skip(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	l.skippedBytes += len(p)
+	l.skippedLines += bytes.Count(p, []byte{'\n'})
+	l.lastSkippedByte = p[len(p)-1]
+}
+
+// Finish closes the spill file (removing it if output was not truncated), and if output was
+// truncated, displays a notice about it.
+func (l *outputLimiter) Finish(msg Message) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var spillPath string
+	if l.spill != nil {
+		spillPath = l.spill.Name()
+		if err := l.spill.Close(); err != nil {
+			log.Printf("Failed to close %q: %+v", spillPath, err)
+		}
+		l.spill = nil
+		if !l.truncated {
+			_ = os.Remove(spillPath)
+			spillPath = ""
+		}
+	}
+	if !l.truncated {
+		return
+	}
+	skippedLines := l.skippedLines
+	if l.lastSkippedByte != '\n' {
+		skippedLines++ // Count last partial line.
+	}
+	notice := fmt.Sprintf("\n...output truncated (%d more lines, %d bytes)\n", skippedLines, l.skippedBytes)
+	if spillPath != "" && l.ServeSpilled {
+		if err := publishSpillLink(msg, spillPath, l.bytes+l.skippedBytes); err != nil {
+			log.Printf("Failed to serve full output, displaying its path instead: %+v", err)
+		} else {
+			spillPath = ""
+		}
+	}
+	if spillPath != "" {
+		notice += fmt.Sprintf("Full output saved in %q\n", spillPath)
+	}
+	if err := PublishWriteStream(msg, StreamStderr, notice); err != nil {
+		log.Printf("Failed to publish output truncation notice: %+v", err)
+	}
+}
+
+// publishSpillLink moves the file with the full output to the kernel's file server, and displays
+// a link to download it.
+func publishSpillLink(msg Message, spillPath string, size int) error {
+	k := msg.Kernel()
+	if k == nil {
+		return errors.New("no kernel available to serve the full output")
+	}
+	fs, err := k.FileServer()
+	if err != nil {
+		return err
+	}
+	url, err := fs.MoveFile(spillPath, "output.txt")
+	if err != nil {
+		return err
+	}
+	return PublishDisplayDataWithHTML(msg, fmt.Sprintf(
+		`<a href="%s" download="output.txt" target="_blank">Download full output</a> (%d bytes)`,
+		html.EscapeString(url), size))
+}
@@ -0,0 +1,72 @@
+package kernel
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutputLimiter(t *testing.T) {
+	// Limit on lines.
+	limiter := newOutputLimiter(OutputLimits{MaxLines: 3})
+	buf := &bytes.Buffer{}
+	w := limiter.Wrap(buf)
+	for ii := 0; ii < 10; ii++ {
+		n, err := fmt.Fprintf(w, "line %d\n", ii)
+		assert.NoError(t, err)
+		assert.Equal(t, 7, n)
+	}
+	assert.Equal(t, "line 0\nline 1\nline 2\n", buf.String())
+	assert.True(t, limiter.truncated)
+	assert.Equal(t, 7, limiter.skippedLines)
+
+	// Limit on bytes, shared across writers.
+	limiter = newOutputLimiter(OutputLimits{MaxBytes: 10})
+	buf1, buf2 := &bytes.Buffer{}, &bytes.Buffer{}
+	w1, w2 := limiter.Wrap(buf1), limiter.Wrap(buf2)
+	_, _ = w1.Write([]byte("012345"))
+	_, _ = w2.Write([]byte("6789abcdef"))
+	assert.Equal(t, "012345", buf1.String())
+	assert.Equal(t, "6789", buf2.String())
+	assert.Equal(t, 6, limiter.skippedBytes)
+
+	// No limits.
+	limiter = newOutputLimiter(OutputLimits{})
+	buf = &bytes.Buffer{}
+	assert.Equal(t, buf, limiter.Wrap(buf))
+}
+
+func TestOutputLimiterServeSpilled(t *testing.T) {
+	msg := newStreamsMessage(t)
+	limiter := newOutputLimiter(OutputLimits{MaxLines: 2, SpillToFile: true, ServeSpilled: true})
+	require.NotNil(t, limiter.spill)
+	spillPath := limiter.spill.Name()
+	w := limiter.Wrap(io.Discard)
+	for ii := 0; ii < 5; ii++ {
+		_, _ = fmt.Fprintf(w, "line %d\n", ii)
+	}
+	limiter.Finish(msg)
+	assert.NoFileExists(t, spillPath)
+
+	require.Len(t, msg.published, 2)
+	assert.True(t, strings.HasPrefix(msg.published[0], "display_data: "))
+	assert.Contains(t, msg.published[0], "(35 bytes)")
+	assert.Contains(t, msg.published[1], "output truncated (3 more lines, 21 bytes)")
+	assert.NotContains(t, msg.published[1], "Full output saved")
+
+	url := regexp.MustCompile(`href=\\"([^\\]+)\\"`).FindStringSubmatch(msg.published[0])
+	require.Len(t, url, 2)
+	resp, err := http.Get(url[1])
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	contents, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "line 0\nline 1\nline 2\nline 3\nline 4\n", string(contents))
+}
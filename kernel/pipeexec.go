@@ -1,12 +1,14 @@
 package kernel
 
 import (
+	"fmt"
 	"github.com/janpfeifer/gonb/gonbui/protocol"
 	"io"
 	"log"
 	"os"
 	"os/exec"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -20,8 +22,10 @@ import (
 //
 // It returns an error if it failed to execute or created the pipes -- but not if the executed
 // program returns an error for any reason.
+//
+// For more options, see NewPipeExecToJupyterBuilder.
 func PipeExecToJupyter(msg Message, dir, name string, args ...string) error {
-	return pipeExecToJupyter(msg, dir, name, args, -1, false)
+	return NewPipeExecToJupyterBuilder(msg, name, args...).InDir(dir).Exec()
 }
 
 // PipeExecToJupyterWithInput executes the given command (command plus arguments) and
@@ -34,7 +38,7 @@ func PipeExecToJupyter(msg Message, dir, name string, args ...string) error {
 // It returns an error if it failed to execute or created the pipes -- but not if the executed
 // program returns an error for any reason.
 func PipeExecToJupyterWithInput(msg Message, dir, name string, args ...string) error {
-	return pipeExecToJupyter(msg, dir, name, args, 500, false)
+	return NewPipeExecToJupyterBuilder(msg, name, args...).InDir(dir).WithInputs(500).Exec()
 }
 
 // PipeExecToJupyterWithPassword executes the given command (command plus arguments) and
@@ -43,49 +47,268 @@ func PipeExecToJupyterWithInput(msg Message, dir, name string, args ...string) e
 //
 // If dir is not empty, before running the command the current directory is changed to dir.
 func PipeExecToJupyterWithPassword(msg Message, dir, name string, args ...string) error {
-	return pipeExecToJupyter(msg, dir, name, args, 1, true)
+	return NewPipeExecToJupyterBuilder(msg, name, args...).InDir(dir).WithPassword().Exec()
+}
+
+// PipeExecToJupyterBuilder holds the configuration for executing a command whose output is piped to
+// Jupyter. Create it with NewPipeExecToJupyterBuilder, configure it with the various `With*`
+// methods, and finally call Exec.
+type PipeExecToJupyterBuilder struct {
+	msg                 Message
+	dir, command        string
+	args, env           []string
+	millisecondsToInput int
+	inputPassword       bool
+	outputLimits        OutputLimits
+	onStart, onExit     func(cmd *exec.Cmd)
+	background          bool
+	goroutineDump       bool
+	ansiMode            ANSIMode
+	resourceLimits      ResourceLimits
+	terminalSize        *TerminalSize
+	displaySync         bool
+}
+
+// NewPipeExecToJupyterBuilder creates a builder that executes the given command (command plus
+// arguments) and pipes the output to Jupyter stdout and stderr streams connected to msg.
+//
+// Configure it with the various methods of the returned builder, and then call Exec.
+func NewPipeExecToJupyterBuilder(msg Message, name string, args ...string) *PipeExecToJupyterBuilder {
+	return &PipeExecToJupyterBuilder{
+		msg:                 msg,
+		command:             name,
+		args:                args,
+		millisecondsToInput: -1,
+	}
+}
+
+// InDir configures the command to be executed in the given directory. If dir is empty (the
+// default), the current directory is used.
+func (b *PipeExecToJupyterBuilder) InDir(dir string) *PipeExecToJupyterBuilder {
+	b.dir = dir
+	return b
+}
+
+// WithInputs configures the command to plumb the input from Jupyter input, starting
+// millisecondsWait after the program started (so if programs don't execute quick, an
+// optional input will be made available).
+func (b *PipeExecToJupyterBuilder) WithInputs(millisecondsWait int) *PipeExecToJupyterBuilder {
+	b.millisecondsToInput = millisecondsWait
+	b.inputPassword = false
+	return b
+}
+
+// WithPassword configures the command to plumb one input from Jupyter input, set as
+// a password (input hidden).
+func (b *PipeExecToJupyterBuilder) WithPassword() *PipeExecToJupyterBuilder {
+	b.millisecondsToInput = 1
+	b.inputPassword = true
+	return b
+}
+
+// WithEnv adds the given environment variables (in the form "KEY=value") to the ones
+// inherited from the kernel, only for the execution of this command.
+func (b *PipeExecToJupyterBuilder) WithEnv(env ...string) *PipeExecToJupyterBuilder {
+	b.env = append(b.env, env...)
+	return b
+}
+
+// WithOutputLimits configures limits to the output forwarded to Jupyter. See OutputLimits.
+func (b *PipeExecToJupyterBuilder) WithOutputLimits(limits OutputLimits) *PipeExecToJupyterBuilder {
+	b.outputLimits = limits
+	return b
+}
+
+// OnStart configures fn to be called with the command, just after it is started. It can be used to
+// keep a handle to the running process, for instance to kill it later.
+//
+// Commands are started in their own process group, so KillProcessGroup can be used to kill the
+// program and any processes it may have spawned.
+func (b *PipeExecToJupyterBuilder) OnStart(fn func(cmd *exec.Cmd)) *PipeExecToJupyterBuilder {
+	b.onStart = fn
+	return b
+}
+
+// OnExit configures fn to be called with the command, after it exited and its output was published.
+func (b *PipeExecToJupyterBuilder) OnExit(fn func(cmd *exec.Cmd)) *PipeExecToJupyterBuilder {
+	b.onExit = fn
+	return b
+}
+
+// InBackground configures the command to be executed in the background: Exec returns as soon as
+// the command is started, and its output continues to be streamed to Jupyter, with each line
+// prefixed with the process id. No input is plumbed to background commands.
+func (b *PipeExecToJupyterBuilder) InBackground() *PipeExecToJupyterBuilder {
+	b.background = true
+	b.millisecondsToInput = -1
+	return b
+}
+
+// WithGoroutineDump configures the first interruption to send a SIGQUIT to the command, instead of
+// SIGINT: Go programs handle it by printing the stack of all goroutines (to stderr, so it is displayed)
+// and exiting -- useful to find where a program is stuck. If the program is still running, the next
+// interruption kills it (and the processes it spawned). Only supported on Unix systems: elsewhere
+// the first interruption kills the program.
+func (b *PipeExecToJupyterBuilder) WithGoroutineDump() *PipeExecToJupyterBuilder {
+	b.goroutineDump = true
+	return b
+}
+
+// WithANSIMode configures how ANSI escape sequences (e.g.: colors) in the output of the command are
+// handled, see ANSIMode. The default is ANSIRaw, which forwards the output untouched.
+func (b *PipeExecToJupyterBuilder) WithANSIMode(mode ANSIMode) *PipeExecToJupyterBuilder {
+	b.ansiMode = mode
+	return b
+}
+
+// WithResourceLimits configures limits on the memory and CPU time the command can use, see
+// ResourceLimits. They are only supported on Linux: elsewhere a warning is displayed and the
+// command is executed without limits.
+func (b *PipeExecToJupyterBuilder) WithResourceLimits(limits ResourceLimits) *PipeExecToJupyterBuilder {
+	b.resourceLimits = limits
+	return b
+}
+
+// WithPTY configures the command to be attached to a new pseudo-terminal of the given size,
+// instead of pipes, for programs that require (or behave differently with) a terminal. Its stdout
+// and stderr are then merged, and published to the Jupyter stdout stream.
+//
+// Pseudo-terminals are only supported on Linux.
+func (b *PipeExecToJupyterBuilder) WithPTY(size TerminalSize) *PipeExecToJupyterBuilder {
+	b.terminalSize = &size
+	return b
+}
+
+// WithDisplaySync configures the command as a program using gonbui, whose displayed content is
+// published in order with its stdout: it is told (in protocol.GONB_DISPLAY_SYNC_ENV) to write a
+// marker to its stdout before each content displayed. Only supported on Unix systems.
+func (b *PipeExecToJupyterBuilder) WithDisplaySync() *PipeExecToJupyterBuilder {
+	b.displaySync = true
+	return b
+}
+
+// Exec executes the configured command and pipes the output and error to Jupyter stdout
+// and stderr streams.
+//
+// It returns an error if it failed to execute or created the pipes -- but not if the executed
+// program returns an error for any reason.
+func (b *PipeExecToJupyterBuilder) Exec() error {
+	return pipeExecToJupyter(b)
 }
 
-func pipeExecToJupyter(msg Message, dir, name string, args []string, millisecondsToInput int, inputPassword bool) error {
+func pipeExecToJupyter(b *PipeExecToJupyterBuilder) error {
+	msg, dir, name, args := b.msg, b.dir, b.command, b.args
+	millisecondsToInput, inputPassword := b.millisecondsToInput, b.inputPassword
 	log.Printf("Executing: %s %v", name, args)
 
 	cmd := exec.Command(name, args...)
 	cmd.Dir = dir
+	// Start command in its own process group, so it can be killed along with anything it
+	// spawns. Interruptions are explicitly forwarded below.
+	SetProcessGroup(cmd)
 
-	cmdStdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return errors.WithMessagef(err, "failed to create pipe for stdout")
-	}
-	cmdStderr, err := cmd.StderrPipe()
-	if err != nil {
-		return errors.WithMessagef(err, "failed to create pipe for stderr")
+	var (
+		cmdStdout, cmdStderr io.ReadCloser
+		cmdStdin             io.WriteCloser
+		tty, stdoutWriter    *os.File
+		err                  error
+	)
+	if b.terminalSize != nil {
+		// The command is attached to the terminal in its own session, which also makes it the
+		// leader of its own process group. Its output is read from, and its input written to,
+		// the controlling side of the terminal.
+		var master *os.File
+		master, tty, err = openPTY(*b.terminalSize)
+		if err != nil {
+			return err
+		}
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+		cmdStdout, cmdStdin = ptyReader{master}, master
+	} else {
+		// The stdout pipe is created here (instead of with cmd.StdoutPipe), to identify it for
+		// WithDisplaySync. It is closed once read.
+		var stdoutReader *os.File
+		stdoutReader, stdoutWriter, err = os.Pipe()
+		if err != nil {
+			return errors.Wrapf(err, "failed to create pipe for stdout")
+		}
+		cmd.Stdout, cmdStdout = stdoutWriter, stdoutReader
+		cmdStderr, err = cmd.StderrPipe()
+		if err != nil {
+			return errors.WithMessagef(err, "failed to create pipe for stderr")
+		}
+		cmdStdin, err = cmd.StdinPipe()
+		if err != nil {
+			return errors.WithMessagef(err, "failed to create pipe for stdin")
+		}
 	}
 
-	// Pipe all stdout and stderr to Jupyter.
-	jupyterStdout := NewJupyterStreamWriter(msg, StreamStdout)
-	jupyterStderr := NewJupyterStreamWriter(msg, StreamStderr)
+	// Pipe all stdout and stderr to Jupyter, subject to the output limits: streamers are started
+	// once the command is started.
+	limiter := newOutputLimiter(b.outputLimits)
+	resourcesWatcher := &resourceLimitsWatcher{limits: b.resourceLimits}
+	// Displayed content is published in order with the stdout, see displaySync.
+	dispSync := newDisplaySync(msg)
 	var streamersWG sync.WaitGroup
-	streamersWG.Add(2)
-	go func() {
-		defer streamersWG.Done()
-		io.Copy(jupyterStdout, cmdStdout)
-	}()
-	go func() {
-		defer streamersWG.Done()
-		io.Copy(jupyterStderr, cmdStderr)
-	}()
+	startStreamers := func(prefix string) {
+		// stdout and stderr are forwarded separately, in their own streams, so front-ends can display
+		// them distinctly. Output translated to HTML (see ANSIHTML) from stderr is highlighted like
+		// Jupyter does for the stderr stream.
+		publishHTML := func(html string) error { return PublishDisplayDataWithHTML(msg, html) }
+		publishStderrHTML := func(html string) error {
+			return PublishDisplayDataWithHTML(msg, `<div style="background-color:#fdd">`+html+`</div>`)
+		}
+		stdoutANSI := newANSIWriter(b.ansiMode, NewJupyterStreamWriter(msg, StreamStdout), publishHTML)
+		stderrANSI := newANSIWriter(b.ansiMode, NewJupyterStreamWriter(msg, StreamStderr), publishStderrHTML)
+		jupyterStdout := limiter.Wrap(stdoutANSI)
+		jupyterStderr := resourcesWatcher.Wrap(limiter.Wrap(stderrANSI))
+		if prefix != "" {
+			jupyterStdout = newLinePrefixWriter(jupyterStdout, prefix)
+			jupyterStderr = newLinePrefixWriter(jupyterStderr, prefix)
+		}
+		newSyncWriter := func(w io.Writer) *displaySyncWriter {
+			syncWriter := newDisplaySyncWriter(w, dispSync)
+			if aw, ok := stdoutANSI.(*ansiWriter); ok {
+				// Output held by the ANSI handling must be published before the content displayed after it.
+				syncWriter.flushOutput = aw.Flush
+			}
+			return syncWriter
+		}
+		if cmdStderr == nil {
+			// Attached to a terminal: stdout and stderr are merged.
+			streamersWG.Add(1)
+			go func() {
+				defer streamersWG.Done()
+				syncWriter := newSyncWriter(resourcesWatcher.Wrap(jupyterStdout))
+				io.Copy(syncWriter, cmdStdout)
+				syncWriter.Close()
+				closeANSIWriter(stdoutANSI)
+			}()
+			return
+		}
+		streamersWG.Add(2)
+		go func() {
+			defer streamersWG.Done()
+			syncWriter := newSyncWriter(jupyterStdout)
+			io.Copy(syncWriter, cmdStdout)
+			cmdStdout.Close()
+			syncWriter.Close()
+			closeANSIWriter(stdoutANSI)
+		}()
+		go func() {
+			defer streamersWG.Done()
+			io.Copy(jupyterStderr, cmdStderr)
+			closeANSIWriter(stderrANSI)
+		}()
+	}
 
 	// Optionally prepare stdin to start after millisecondsToInput.
 	var (
 		done     bool
 		doneChan = make(chan struct{})
 		muDone   sync.Mutex
-		cmdStdin io.WriteCloser
 	)
-	cmdStdin, err = cmd.StdinPipe()
-	if err != nil {
-		return errors.WithMessagef(err, "failed to create pipe for stdin")
-	}
 	if millisecondsToInput > 0 {
 		// Set function to handle incoming content.
 		var writeStdinFn OnInputFn
@@ -127,7 +350,24 @@ func pipeExecToJupyter(msg Message, dir, name string, args []string, millisecond
 	}
 
 	// Prepare named-pipe to use for rich-data display.
-	if err = StartNamedPipe(msg, dir, doneChan); err != nil {
+	// closeOutputs closes the output pipes (or the terminal) if the command is not executed.
+	closeOutputs := func() {
+		if tty != nil {
+			tty.Close()
+		}
+		if stdoutWriter != nil {
+			stdoutWriter.Close()
+		}
+		if cmdStderr != nil {
+			cmdStderr.Close()
+		}
+		cmdStdout.Close()
+	}
+
+	pipePath, pipeDrained, err := startNamedPipe(dir, doneChan, dispSync.deliver)
+	if err != nil {
+		closeOutputs()
+		cmdStdin.Close()
 		return errors.WithMessagef(err, "failed to create named pipe for display content")
 	}
 
@@ -143,55 +383,203 @@ func pipeExecToJupyter(msg Message, dir, name string, args []string, millisecond
 		muDone.Unlock()
 	}
 
-	// Start command.
+	// Start command: the named pipe is passed only in the command's environment, since more than
+	// one program (or kernel) may be running at the same time.
+	cmd.Env = os.Environ()
+	if tty != nil {
+		cmd.Env = append(cmd.Env, "TERM=xterm-256color")
+	}
+	cmd.Env = append(append(cmd.Env, b.env...), protocol.GONB_PIPE_ENV+"="+pipePath)
+	if b.displaySync {
+		stdoutFile := stdoutWriter
+		if tty != nil {
+			stdoutFile = tty
+		}
+		if id := protocol.DisplaySyncID(stdoutFile); id != "" {
+			cmd.Env = append(cmd.Env, protocol.GONB_DISPLAY_SYNC_ENV+"="+id)
+		}
+	}
+	if k := msg.Kernel(); k != nil {
+		if store, err := k.Store(); err != nil {
+			log.Printf("Shared store not available for %q: %+v", name, err)
+		} else {
+			cmd.Env = append(cmd.Env, protocol.GONB_STORE_DIR_ENV+"="+store.Dir())
+		}
+		if fs, err := k.FileServer(); err != nil {
+			log.Printf("File server not available for %q: %+v", name, err)
+		} else {
+			cmd.Env = append(cmd.Env,
+				protocol.GONB_FILES_DIR_ENV+"="+fs.Dir(),
+				protocol.GONB_FILES_URL_ENV+"="+fs.URL())
+		}
+	}
+	if b.resourceLimits.IsLimited() {
+		if err := setResourceLimits(cmd, b.resourceLimits); err != nil {
+			if resourceLimitsSupported {
+				// Don't run the program unconstrained if the limits were requested.
+				closeOutputs()
+				limiter.Finish(msg)
+				doneFn()
+				return errors.WithMessagef(err, "failed to set resource limits of command %q", name)
+			}
+			_ = PublishWriteStream(msg, StreamStderr, fmt.Sprintf("* Warning: %v, executing without limits.\n", err))
+		}
+	}
 	if err := cmd.Start(); err != nil {
-		cmdStderr.Close()
-		cmdStdout.Close()
+		closeOutputs()
+		limiter.Finish(msg)
 		doneFn()
 		return errors.WithMessagef(err, "failed to start to execute command %q", name)
 	}
+	if tty != nil {
+		// The command has its own copy of the terminal: once it (and anything it spawned) exits,
+		// reading from the controlling side ends.
+		tty.Close()
+		tty = nil
+	}
+	if stdoutWriter != nil {
+		// Likewise, the command has its own copy of the stdout pipe.
+		stdoutWriter.Close()
+		stdoutWriter = nil
+	}
+	if b.onStart != nil {
+		b.onStart(cmd)
+	}
 
-	// Wait for output pipes to finish.
-	streamersWG.Wait()
-	if err := cmd.Wait(); err != nil {
-		errMsg := err.Error() + "\n"
-		if msg.Kernel().Interrupted.Load() {
-			errMsg = "^C\n" + errMsg
+	// waitFn waits for the command and output pipes to finish.
+	var prefix string
+	waitFn := func() {
+		streamersWG.Wait()
+		limiter.Finish(msg)
+		if err := cmd.Wait(); err != nil {
+			errMsg := prefix + err.Error() + "\n"
+			if exceeded := resourcesWatcher.Exceeded(cmd); exceeded != "" {
+				errMsg += prefix + exceeded
+			}
+			if !b.background && msg.Kernel().Interrupted.Load() {
+				errMsg = "^C\n" + errMsg
+			}
+			PublishWriteStream(msg, StreamStderr, errMsg)
+		} else if b.background {
+			PublishWriteStream(msg, StreamStdout, prefix+"finished.\n")
 		}
-		PublishWriteStream(msg, StreamStderr, errMsg)
+		doneFn()
+		<-pipeDrained
+		if b.onExit != nil {
+			b.onExit(cmd)
+		}
+		log.Printf("Execution of %q finished", name)
 	}
-	doneFn()
 
-	log.Printf("Execution finished successfully")
+	if b.background {
+		// Output of background commands may arrive after the cell finished, so it is attributed
+		// to the process.
+		prefix = fmt.Sprintf("[background %d] ", cmd.Process.Pid)
+		startStreamers(prefix)
+		if err := PublishWriteStream(msg, StreamStdout, fmt.Sprintf(
+			"* Started in background with pid %d, use %%kill to stop it.\n", cmd.Process.Pid)); err != nil {
+			log.Printf("Failed to publish start of background execution: %+v", err)
+		}
+		go waitFn()
+		return nil
+	}
+
+	// Foreground execution: forward interruptions to the command, and wait for it.
+	startStreamers("")
+	if k := msg.Kernel(); k != nil {
+		var interruptions atomic.Int32
+		unregister := k.OnInterrupt(func() {
+			if !b.goroutineDump {
+				_ = interruptProcessGroup(cmd)
+				return
+			}
+			if interruptions.Add(1) == 1 {
+				if err := quitProcess(cmd); err != nil {
+					_ = PublishWriteStream(msg, StreamStderr, fmt.Sprintf("* Interrupted: %v, killing the program.\n", err))
+					_ = KillProcessGroup(cmd)
+					return
+				}
+				_ = PublishWriteStream(msg, StreamStderr, "* Interrupted: dumping goroutines, interrupt again to kill the program.\n")
+				return
+			}
+			_ = KillProcessGroup(cmd)
+		})
+		defer unregister()
+	}
+	waitFn()
 	return nil
 }
 
+// linePrefixWriter is an io.Writer that adds a prefix to each line written.
+type linePrefixWriter struct {
+	w           io.Writer
+	prefix      []byte
+	atLineStart bool
+}
+
+func newLinePrefixWriter(w io.Writer, prefix string) io.Writer {
+	return &linePrefixWriter{w: w, prefix: []byte(prefix), atLineStart: true}
+}
+
+// Write implements io.Writer.
+func (w *linePrefixWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, 0, len(p)+len(w.prefix))
+	for _, c := range p {
+		if w.atLineStart {
+			buf = append(buf, w.prefix...)
+			w.atLineStart = false
+		}
+		buf = append(buf, c)
+		if c == '\n' {
+			w.atLineStart = true
+		}
+	}
+	if _, err := w.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
 // StartNamedPipe creates a named pipe in `dir` and starts a listener (on a separate goroutine) that reads
-// the pipe and displays rich content. It also exports environment variable GONB_FIFO announcing the name of the
-// named pipe.
+// the pipe and displays rich content. It returns the path of the named pipe, which should be passed to
+// the program in the environment variable GONB_PIPE.
 //
 // The doneChan is listened to: when it is closed, it will trigger the listener goroutine to close the pipe,
 // remove it and quit.
 //
 // TODO: make this more secure, maybe with a secret key also passed by the environment.
-func StartNamedPipe(msg Message, dir string, doneChan <-chan struct{}) error {
+func StartNamedPipe(msg Message, dir string, doneChan <-chan struct{}) (string, error) {
+	pipePath, _, err := startNamedPipe(dir, doneChan, func(data *protocol.DisplayData) { processDisplayData(msg, data) })
+	return pipePath, err
+}
+
+// pipeDrainTimeout is how long, after the program finishes, the named pipe is still read for content
+// already written to it, see startNamedPipe.
+const pipeDrainTimeout = time.Second
+
+// startNamedPipe implements StartNamedPipe, calling process with each content received. It also returns a channel that is closed once the pipe
+// is closed, after doneChan is closed: the pipe is still read until all content written by the
+// program is processed (or for at most pipeDrainTimeout, if the pipe is kept open by some
+// other process), so content sent just before the program exits is not lost.
+func startNamedPipe(dir string, doneChan <-chan struct{}, process func(data *protocol.DisplayData)) (pipePath string, drained <-chan struct{}, err error) {
 	// Create a temporary file name.
 	f, err := os.CreateTemp(dir, "gonb_pipe_")
 	if err != nil {
-		return err
+		return "", nil, err
 	}
-	pipePath := f.Name()
+	pipePath = f.Name()
 	if err = f.Close(); err != nil {
-		return err
+		return "", nil, err
 	}
 	if err = os.Remove(pipePath); err != nil {
-		return err
+		return "", nil, err
 	}
 
 	// Create pipe.
 	if err = syscall.Mkfifo(pipePath, 0600); err != nil {
-		return errors.Wrapf(err, "failed to create pipe (Mkfifo) for %q", pipePath)
+		return "", nil, errors.Wrapf(err, "failed to create pipe (Mkfifo) for %q", pipePath)
 	}
+	drainedChan := make(chan struct{})
 
 	// Synchronize pipe: if it's not opened by the program being executed,
 	// we have to open it ourselves for writing, to avoid blocking
@@ -218,12 +606,11 @@ func StartNamedPipe(msg Message, dir string, doneChan <-chan struct{}) error {
 		os.Remove(pipePath)
 	}()
 
-	os.Setenv(protocol.GONB_PIPE_ENV, pipePath)
 	go func() {
+		defer close(drainedChan)
 		// Notice that opening pipeReader below blocks, until the other end
 		// (the go program being executed) opens it as well.
-		var pipeReader *os.File
-		pipeReader, err = os.Open(pipePath)
+		pipeReader, err := os.Open(pipePath)
 		if err != nil {
 			log.Printf("Failed to open pipe (Mkfifo) %q for reading: %+v", pipePath, err)
 			return
@@ -231,11 +618,18 @@ func StartNamedPipe(msg Message, dir string, doneChan <-chan struct{}) error {
 		muFifo.Lock()
 		fifoOpenedForReading = true
 		muFifo.Unlock()
-		go PollDisplayRequests(msg, pipeReader)
+		polled := make(chan struct{})
+		go func() {
+			pollDisplayRequests(pipeReader, process)
+			close(polled)
+		}()
 
-		// Wait till channel is closed and then close reader.
+		// Wait till channel is closed, drain what is left in the pipe, and then close reader.
 		<-doneChan
+		if err := pipeReader.SetReadDeadline(time.Now().Add(pipeDrainTimeout)); err == nil {
+			<-polled
+		}
 		pipeReader.Close()
 	}()
-	return nil
+	return pipePath, drainedChan, nil
 }
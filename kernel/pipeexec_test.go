@@ -0,0 +1,147 @@
+package kernel
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinePrefixWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := newLinePrefixWriter(buf, "> ")
+	_, _ = w.Write([]byte("a\nb"))
+	_, _ = w.Write([]byte("c\n\nd"))
+	assert.Equal(t, "> a\n> bc\n> \n> d", buf.String())
+}
+
+// streamsMessage is a Message that records the messages published: the streams' text by stream,
+// and all the messages (streams included) encoded as "<msgType>: <JSON content>", in order.
+type streamsMessage struct {
+	Message
+	kernel *Kernel
+
+	mu             sync.Mutex
+	stdout, stderr strings.Builder
+	published      []string
+}
+
+func newStreamsMessage(t *testing.T) *streamsMessage {
+	k := &Kernel{}
+	t.Cleanup(k.removeStore)
+	t.Cleanup(k.stopFileServer)
+	return &streamsMessage{kernel: k}
+}
+
+func (m *streamsMessage) Kernel() *Kernel { return m.kernel }
+
+func (m *streamsMessage) Publish(msgType string, content interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, _ := json.Marshal(content)
+	m.published = append(m.published, msgType+": "+string(data))
+	if msgType != "stream" {
+		return nil
+	}
+	var stream struct {
+		Name string `json:"name"`
+		Text string `json:"text"`
+	}
+	_ = json.Unmarshal(data, &stream)
+	switch stream.Name {
+	case StreamStdout:
+		m.stdout.WriteString(stream.Text)
+	case StreamStderr:
+		m.stderr.WriteString(stream.Text)
+	}
+	return nil
+}
+
+func (m *streamsMessage) CancelInput() error { return nil }
+
+// buildTestProgram compiles the given Go source code, and returns the path to the binary.
+func buildTestProgram(t *testing.T, source string) string {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skipf("go not found: %v", err)
+	}
+	dir := t.TempDir()
+	mainPath := path.Join(dir, "main.go")
+	require.NoError(t, os.WriteFile(mainPath, []byte(source), 0600))
+	binPath := path.Join(dir, "program")
+	build := exec.Command(goBin, "build", "-o", binPath, mainPath)
+	build.Dir = dir
+	out, err := build.CombinedOutput()
+	require.NoErrorf(t, err, "failed to build: %s", out)
+	return binPath
+}
+
+// buildModuleTestProgram builds a program that can import the packages of this module (e.g.:
+// gonbui) and returns the path to its binary.
+func buildModuleTestProgram(t *testing.T, source string) string {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skipf("go not found: %v", err)
+	}
+	// Directories starting with "_" are ignored by "./..." patterns.
+	dir, err := os.MkdirTemp(".", "_testprogram")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	require.NoError(t, os.WriteFile(path.Join(dir, "main.go"), []byte(source), 0600))
+	binPath := path.Join(t.TempDir(), "program")
+	out, err := exec.Command(goBin, "build", "-o", binPath, "./"+dir).CombinedOutput()
+	require.NoErrorf(t, err, "failed to build: %s", out)
+	return binPath
+}
+
+func TestGoroutineDump(t *testing.T) {
+	binPath := buildTestProgram(t, "package main\n\nimport \"time\"\n\nfunc main() {\n\ttime.Sleep(time.Hour)\n}\n")
+	msg := newStreamsMessage(t)
+	started := make(chan struct{})
+	go func() {
+		<-started
+		time.Sleep(100 * time.Millisecond)
+		msg.kernel.callInterruptCallbacks()
+	}()
+	require.NoError(t, NewPipeExecToJupyterBuilder(msg, binPath).
+		WithGoroutineDump().
+		OnStart(func(*exec.Cmd) { close(started) }).
+		Exec())
+	msg.mu.Lock()
+	defer msg.mu.Unlock()
+	assert.Contains(t, msg.stderr.String(), "dumping goroutines")
+	assert.Contains(t, msg.stderr.String(), "main.main()")
+}
+
+// TestStdoutStderrSeparated checks that the stdout and stderr of the program are published in their
+// own streams, not interleaved into one.
+func TestStdoutStderrSeparated(t *testing.T) {
+	binPath := buildTestProgram(t, `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	for ii := 0; ii < 3; ii++ {
+		fmt.Fprintf(os.Stdout, "out %d\n", ii)
+		fmt.Fprintf(os.Stderr, "err %d\n", ii)
+	}
+}
+`)
+	msg := newStreamsMessage(t)
+	require.NoError(t, NewPipeExecToJupyterBuilder(msg, binPath).Exec())
+	msg.mu.Lock()
+	defer msg.mu.Unlock()
+	assert.Equal(t, "out 0\nout 1\nout 2\n", msg.stdout.String())
+	assert.Equal(t, "err 0\nerr 1\nerr 2\n", msg.stderr.String())
+}
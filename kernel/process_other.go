@@ -0,0 +1,44 @@
+//go:build !unix
+
+package kernel
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// SetProcessGroup is a no-op: process groups are only supported on Unix systems.
+func SetProcessGroup(cmd *exec.Cmd) {}
+
+// interruptProcessGroup interrupts the command. Processes it may have spawned are not affected.
+func interruptProcessGroup(cmd *exec.Cmd) error {
+	if cmd == nil || cmd.Process == nil {
+		return nil
+	}
+	err := cmd.Process.Signal(os.Interrupt)
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return errors.Wrapf(err, "failed to interrupt process %d", cmd.Process.Pid)
+	}
+	return nil
+}
+
+// quitProcess is not supported: there is no SIGQUIT to make Go programs dump their goroutines.
+func quitProcess(cmd *exec.Cmd) error {
+	return errors.New("dumping the goroutines of a program is only supported on Unix systems")
+}
+
+// KillProcessGroup kills the given command, started with PipeExecToJupyterBuilder or configured
+// with SetProcessGroup. Process groups are only supported on Unix systems: processes it may
+// have spawned are not affected.
+func KillProcessGroup(cmd *exec.Cmd) error {
+	if cmd == nil || cmd.Process == nil {
+		return nil
+	}
+	err := cmd.Process.Kill()
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return errors.Wrapf(err, "failed to kill process %d", cmd.Process.Pid)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+//go:build unix
+
+package kernel
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// SetProcessGroup configures the command to start in its own process group, so it can be signaled
+// (interrupted or killed) along with anything it spawns. Since it no longer receives the signals
+// sent to the kernel's group, interruptions must be explicitly forwarded, see signalProcessGroup.
+func SetProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalProcessGroup sends sig to the process group led by cmd, started with SetProcessGroup.
+//
+// Processes are only identified by their ids, which are reused once they exit. So the group is only
+// signaled if the command is still running -- checked with its process handle, which won't signal a
+// process already waited for --, or if no process holds its id: an id is not reused while there
+// are processes in its group, so the group is then the one of the command (or is empty).
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	if cmd == nil || cmd.Process == nil {
+		return nil
+	}
+	pgid := cmd.Process.Pid
+	if err := cmd.Process.Signal(syscall.Signal(0)); errors.Is(err, os.ErrProcessDone) {
+		if err := syscall.Kill(pgid, 0); !errors.Is(err, syscall.ESRCH) {
+			// The id was reused by some other process: the command's group is gone.
+			return nil
+		}
+	}
+	err := syscall.Kill(-pgid, sig)
+	if err != nil && !errors.Is(err, syscall.ESRCH) {
+		return errors.Wrapf(err, "failed to send %s to process group %d", sig, pgid)
+	}
+	return nil
+}
+
+// interruptProcessGroup interrupts (SIGINT) the process group led by cmd.
+func interruptProcessGroup(cmd *exec.Cmd) error {
+	return signalProcessGroup(cmd, syscall.SIGINT)
+}
+
+// quitProcess sends a SIGQUIT to the command only (not its group): Go programs handle it by
+// printing the stack of all goroutines and exiting.
+func quitProcess(cmd *exec.Cmd) error {
+	if cmd == nil || cmd.Process == nil {
+		return nil
+	}
+	err := cmd.Process.Signal(syscall.SIGQUIT)
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return errors.Wrapf(err, "failed to send SIGQUIT to process %d", cmd.Process.Pid)
+	}
+	return nil
+}
+
+// KillProcessGroup kills (SIGKILL) the process group led by the given command, started with
+// PipeExecToJupyterBuilder or configured with SetProcessGroup. That includes any processes it may
+// have spawned that are still running, even if the command itself already exited.
+func KillProcessGroup(cmd *exec.Cmd) error {
+	return signalProcessGroup(cmd, syscall.SIGKILL)
+}
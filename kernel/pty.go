@@ -0,0 +1,55 @@
+package kernel
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements the execution of commands attached to a pseudo-terminal (PTY), see
+// PipeExecToJupyterBuilder.WithPTY, for programs that behave differently (e.g.: disable colors or
+// interactivity) when their output is not a terminal.
+
+// TerminalSize is the size of the pseudo-terminal commands are attached to, in characters.
+type TerminalSize struct {
+	Cols, Rows int
+}
+
+// DefaultTerminalSize used when none is given.
+var DefaultTerminalSize = TerminalSize{Cols: 80, Rows: 24}
+
+// String implements fmt.Stringer.
+func (size TerminalSize) String() string {
+	return fmt.Sprintf("%dx%d", size.Cols, size.Rows)
+}
+
+// ParseTerminalSize parses a size in the form "<cols>x<rows>", e.g.: "120x40".
+func ParseTerminalSize(value string) (TerminalSize, error) {
+	var size TerminalSize
+	var extra string
+	if n, _ := fmt.Sscanf(value+" ", "%dx%d%s", &size.Cols, &size.Rows, &extra); n != 2 {
+		return size, errors.Errorf("invalid terminal size %q, it should be given as <cols>x<rows>, e.g. \"120x40\"", value)
+	}
+	if size.Cols <= 0 || size.Rows <= 0 || size.Cols > 0xFFFF || size.Rows > 0xFFFF {
+		return size, errors.Errorf("invalid terminal size %q, columns and rows must be positive", value)
+	}
+	return size, nil
+}
+
+// ptyReader reads the controlling side of a pseudo-terminal. Once all the processes attached to the
+// terminal closed it, reading it fails (EIO on Linux) instead of returning io.EOF: ptyReader turns
+// any error into an io.EOF, so the output is fully copied.
+type ptyReader struct {
+	*os.File
+}
+
+// Read implements io.Reader.
+func (r ptyReader) Read(p []byte) (int, error) {
+	n, err := r.File.Read(p)
+	if err != nil {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,62 @@
+package kernel
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// openPTY opens a new pseudo-terminal of the given size, and returns its controlling side (master)
+// and the terminal (tty) to attach the command to.
+//
+// Output post-processing is disabled, so "\n" is not translated to "\r\n", as a terminal does.
+func openPTY(size TerminalSize) (master, tty *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to open pseudo-terminal")
+	}
+	defer func() {
+		if err != nil {
+			_ = master.Close()
+			master = nil
+		}
+	}()
+	var unlock int32
+	if err = ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to unlock pseudo-terminal")
+	}
+	var ptyNumber uint32
+	if err = ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&ptyNumber)); err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to get pseudo-terminal number")
+	}
+	ttyPath := fmt.Sprintf("/dev/pts/%d", ptyNumber)
+	tty, err = os.OpenFile(ttyPath, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to open terminal %q", ttyPath)
+	}
+	var termios syscall.Termios
+	if err = ioctl(tty, syscall.TCGETS, unsafe.Pointer(&termios)); err == nil {
+		termios.Oflag &^= syscall.OPOST
+		err = ioctl(tty, syscall.TCSETS, unsafe.Pointer(&termios))
+	}
+	if err == nil {
+		winSize := [4]uint16{uint16(size.Rows), uint16(size.Cols), 0, 0}
+		err = ioctl(tty, syscall.TIOCSWINSZ, unsafe.Pointer(&winSize))
+	}
+	if err != nil {
+		_ = tty.Close()
+		return nil, nil, errors.Wrapf(err, "failed to configure terminal %q", ttyPath)
+	}
+	return master, tty, nil
+}
+
+func ioctl(f *os.File, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
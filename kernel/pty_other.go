@@ -0,0 +1,15 @@
+//go:build !linux
+
+package kernel
+
+import (
+	"os"
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+// openPTY is only supported on Linux.
+func openPTY(size TerminalSize) (master, tty *os.File, err error) {
+	return nil, nil, errors.Errorf("pseudo-terminals are not supported on %s", runtime.GOOS)
+}
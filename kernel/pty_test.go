@@ -0,0 +1,56 @@
+package kernel
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTerminalSize(t *testing.T) {
+	size, err := ParseTerminalSize("120x40")
+	require.NoError(t, err)
+	assert.Equal(t, TerminalSize{Cols: 120, Rows: 40}, size)
+	assert.Equal(t, "120x40", size.String())
+	for _, value := range []string{"", "120", "120x", "x40", "0x40", "120x40x3", "-1x40", "axb"} {
+		_, err = ParseTerminalSize(value)
+		assert.Errorf(t, err, "value %q", value)
+	}
+}
+
+func TestPipeExecWithPTY(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skipf("pseudo-terminals not supported on %s", runtime.GOOS)
+	}
+	binPath := buildTestProgram(t, `package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func main() {
+	var winSize [4]uint16
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdout.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&winSize)))
+	fmt.Printf("tty=%v size=%dx%d TERM=%s\n", errno == 0, winSize[1], winSize[0], os.Getenv("TERM"))
+	fmt.Fprintln(os.Stderr, "from stderr")
+}
+`)
+	msg := newStreamsMessage(t)
+	require.NoError(t, NewPipeExecToJupyterBuilder(msg, binPath).WithPTY(TerminalSize{Cols: 100, Rows: 30}).Exec())
+	msg.mu.Lock()
+	assert.Equal(t, "tty=true size=100x30 TERM=xterm-256color\nfrom stderr\n", msg.stdout.String())
+	assert.Empty(t, msg.stderr.String())
+	msg.mu.Unlock()
+
+	// Without a terminal.
+	msg = newStreamsMessage(t)
+	require.NoError(t, NewPipeExecToJupyterBuilder(msg, binPath).Exec())
+	msg.mu.Lock()
+	defer msg.mu.Unlock()
+	assert.Contains(t, msg.stdout.String(), "tty=false size=0x0")
+	assert.Equal(t, "from stderr\n", msg.stderr.String())
+}
@@ -0,0 +1,169 @@
+package kernel
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements limits on the resources (memory and CPU time) used by executed programs,
+// to protect the host when running untrusted code.
+//
+// Limits are applied as rlimits of the child process, set before the program is executed (see
+// setResourceLimits). This is only supported on Linux: in other platforms the programs are
+// executed without limits and a warning is displayed.
+
+// ResourceLimits configures the resources executed programs are allowed to use. Zero values
+// mean no limit.
+type ResourceLimits struct {
+	// Memory is the maximum size in bytes of the data segment (heap and other private writable
+	// memory) of the program, RLIMIT_DATA in Linux. Allocations beyond it fail, which Go programs
+	// report as "runtime: out of memory".
+	Memory int64
+
+	// CPU is the maximum CPU time used by the program, RLIMIT_CPU in Linux, rounded up to seconds.
+	// The program is killed when it is reached.
+	CPU time.Duration
+}
+
+// IsLimited returns whether any limit is set.
+func (l ResourceLimits) IsLimited() bool {
+	return l.Memory > 0 || l.CPU > 0
+}
+
+// String implements fmt.Stringer.
+func (l ResourceLimits) String() string {
+	if !l.IsLimited() {
+		return "no resource limits"
+	}
+	var parts []string
+	if l.Memory > 0 {
+		parts = append(parts, "mem="+FormatMemorySize(l.Memory))
+	}
+	if l.CPU > 0 {
+		parts = append(parts, "cpu="+l.CPU.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// memoryUnits used by FormatMemorySize and ParseMemorySize, from the largest.
+var memoryUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// FormatMemorySize returns a human-readable representation of a number of bytes, using the largest
+// unit (powers of 1024) that represents it exactly. E.g.: 512MB.
+func FormatMemorySize(n int64) string {
+	for _, unit := range memoryUnits {
+		if n >= unit.size && n%unit.size == 0 {
+			return fmt.Sprintf("%d%s", n/unit.size, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// ParseMemorySize parses sizes like "512MB", "2GB", "100kb" or "4096" (bytes). Units are powers
+// of 1024, and the "B" can be omitted (e.g.: "512M").
+func ParseMemorySize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range memoryUnits {
+		if trimmed, found := strings.CutSuffix(s, unit.suffix); found {
+			s, multiplier = trimmed, unit.size
+			break
+		}
+		if unit.size > 1 {
+			if trimmed, found := strings.CutSuffix(s, unit.suffix[:1]); found {
+				s, multiplier = trimmed, unit.size
+				break
+			}
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("invalid memory size %q, use for instance \"512MB\" or \"2GB\"", value)
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, errors.Errorf("memory size %q is too large", value)
+	}
+	return n * multiplier, nil
+}
+
+// outOfMemoryMarkers are the messages (in lower case) written to stderr by programs failing to
+// allocate memory.
+var outOfMemoryMarkers = []string{"runtime: out of memory", "cannot allocate memory"}
+
+// resourceLimitsWatcher wraps the stderr of a program with resource limits, to detect whether it
+// ran out of memory, and reports the limits exceeded when the program fails.
+type resourceLimitsWatcher struct {
+	limits ResourceLimits
+
+	mu          sync.Mutex
+	tail        []byte // Last bytes of stderr, for markers split across writes.
+	outOfMemory bool
+}
+
+// Wrap w to watch the output for out-of-memory errors.
+func (rw *resourceLimitsWatcher) Wrap(w io.Writer) io.Writer {
+	if rw.limits.Memory <= 0 {
+		return w
+	}
+	return io.MultiWriter(w, rw)
+}
+
+// Write implements io.Writer.
+func (rw *resourceLimitsWatcher) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.outOfMemory {
+		return len(p), nil
+	}
+	buf := bytes.ToLower(append(rw.tail, p...))
+	for _, marker := range outOfMemoryMarkers {
+		if bytes.Contains(buf, []byte(marker)) {
+			rw.outOfMemory = true
+			return len(p), nil
+		}
+	}
+	const tailSize = 64
+	if len(buf) > tailSize {
+		buf = buf[len(buf)-tailSize:]
+	}
+	rw.tail = append(rw.tail[:0], buf...)
+	return len(p), nil
+}
+
+// Exceeded returns a message describing the limits exceeded by the finished cmd, or "" if none
+// was exceeded.
+func (rw *resourceLimitsWatcher) Exceeded(cmd *exec.Cmd) string {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.outOfMemory {
+		return fmt.Sprintf("* Program exceeded the memory limit of %s (see %%limit).\n",
+			FormatMemorySize(rw.limits.Memory))
+	}
+	if rw.limits.CPU > 0 && cmd.ProcessState != nil && exceededCPULimit(cmd.ProcessState, rw.limits) {
+		return fmt.Sprintf("* Program exceeded the CPU time limit of %s (see %%limit).\n", rw.limits.CPU)
+	}
+	return ""
+}
+
+// cpuLimitSeconds returns the CPU limit rounded up to seconds, the resolution of RLIMIT_CPU.
+func (l ResourceLimits) cpuLimitSeconds() uint64 {
+	return uint64((l.CPU + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,104 @@
+package kernel
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// resourceLimitsSupported indicates whether setResourceLimits is implemented in this platform.
+const resourceLimitsSupported = true
+
+// resourceLimitsWrapperEnv is set when the kernel's binary is executed as a wrapper that applies
+// resource limits to itself and then executes the command, see setResourceLimits. Its value is
+// "<memory>:<cpu seconds>:<command path>".
+const resourceLimitsWrapperEnv = "GONB_RESOURCE_LIMITS_WRAPPER"
+
+// RunResourceLimitsWrapperIfRequested executes, with the resource limits applied, the command the
+// kernel's binary was started to wrap (see setResourceLimits), and never returns. Otherwise, it
+// returns immediately.
+//
+// It must be called at the start of the kernel's main(), before parsing flags: the arguments are the
+// ones of the wrapped command.
+func RunResourceLimitsWrapperIfRequested() {
+	if value, found := os.LookupEnv(resourceLimitsWrapperEnv); found {
+		runResourceLimitsWrapper(value)
+	}
+}
+
+// setResourceLimits configures cmd (not yet started) to run with the given limits: it is executed
+// through a wrapper -- the kernel's own binary, see RunResourceLimitsWrapperIfRequested -- that sets
+// the rlimits of its process and then replaces itself with the command. So the limits are in place before the command's first
+// instruction, and are inherited by anything it spawns.
+func setResourceLimits(cmd *exec.Cmd, limits ResourceLimits) error {
+	if cmd.Err != nil {
+		// The command can't be started, let cmd.Start() report it.
+		return nil
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return errors.Wrapf(err, "failed to find the kernel's binary, to apply resource limits")
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d:%d:%s", resourceLimitsWrapperEnv,
+		limits.Memory, limits.cpuLimitSeconds(), cmd.Path))
+	cmd.Path = self
+	return nil
+}
+
+// runResourceLimitsWrapper applies the limits encoded in value (see resourceLimitsWrapperEnv) to
+// the current process, and executes the command with the current arguments. It never returns.
+func runResourceLimitsWrapper(value string) {
+	fail := func(err error) {
+		_, _ = fmt.Fprintf(os.Stderr, "gonb: %v\n", err)
+		os.Exit(126) // Same as shells, for commands that can't be executed.
+	}
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 {
+		fail(errors.Errorf("invalid $%s=%q", resourceLimitsWrapperEnv, value))
+	}
+	memory, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		fail(errors.Wrapf(err, "invalid memory limit in $%s=%q", resourceLimitsWrapperEnv, value))
+	}
+	cpuSeconds, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		fail(errors.Wrapf(err, "invalid CPU limit in $%s=%q", resourceLimitsWrapperEnv, value))
+	}
+	if memory > 0 {
+		// RLIMIT_DATA (since Linux 4.7) accounts for the writable private mappings, which is where the
+		// heap lives. RLIMIT_AS can't be used, since the Go runtime reserves large (unused) ranges of
+		// address space at start up.
+		if err = syscall.Setrlimit(syscall.RLIMIT_DATA, &syscall.Rlimit{Cur: memory, Max: memory}); err != nil {
+			fail(errors.Wrapf(err, "failed to limit memory"))
+		}
+	}
+	if cpuSeconds > 0 {
+		// The soft limit sends a SIGXCPU, and the hard limit a second later kills the process, in
+		// case SIGXCPU is handled.
+		if err = syscall.Setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{Cur: cpuSeconds, Max: cpuSeconds + 1}); err != nil {
+			fail(errors.Wrapf(err, "failed to limit CPU time"))
+		}
+	}
+	if err = os.Unsetenv(resourceLimitsWrapperEnv); err != nil {
+		fail(err)
+	}
+	err = syscall.Exec(parts[2], os.Args, os.Environ())
+	fail(errors.Wrapf(err, "failed to execute %q", parts[2]))
+}
+
+// exceededCPULimit returns whether the program that finished with state was killed for exceeding
+// the CPU time limit: by the SIGXCPU of the soft limit, or the SIGKILL of the hard one.
+func exceededCPULimit(state *os.ProcessState, limit ResourceLimits) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	cpuTime := state.UserTime() + state.SystemTime()
+	return ok && status.Signaled() && (status.Signal() == syscall.SIGXCPU ||
+		(status.Signal() == syscall.SIGKILL && cpuTime >= limit.CPU))
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package kernel
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+// resourceLimitsSupported indicates whether setResourceLimits is implemented in this platform.
+const resourceLimitsSupported = false
+
+// RunResourceLimitsWrapperIfRequested is a no-op: resource limits are only supported on Linux.
+func RunResourceLimitsWrapperIfRequested() {}
+
+// setResourceLimits is only supported on Linux.
+func setResourceLimits(cmd *exec.Cmd, limits ResourceLimits) error {
+	return errors.Errorf("resource limits are not supported on %s", runtime.GOOS)
+}
+
+// exceededCPULimit is always false, since CPU limits are only supported on Linux.
+func exceededCPULimit(state *os.ProcessState, limit ResourceLimits) bool {
+	return false
+}
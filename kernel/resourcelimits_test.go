@@ -0,0 +1,88 @@
+package kernel
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain lets the test binary be the wrapper that applies resource limits, like the kernel's.
+func TestMain(m *testing.M) {
+	RunResourceLimitsWrapperIfRequested()
+	os.Exit(m.Run())
+}
+
+func TestParseMemorySize(t *testing.T) {
+	for value, want := range map[string]int64{
+		"4096": 4096, "512MB": 512 << 20, "2gb": 2 << 30, "100K": 100 << 10, "1TB": 1 << 40, "10B": 10,
+	} {
+		got, err := ParseMemorySize(value)
+		require.NoError(t, err, "parsing %q", value)
+		assert.Equal(t, want, got, "parsing %q", value)
+	}
+	for _, value := range []string{"", "MB", "-1MB", "1.5GB", "12XB", "9223372036854775807KB", "8388608TB"} {
+		_, err := ParseMemorySize(value)
+		assert.Error(t, err, "parsing %q", value)
+	}
+	assert.Equal(t, "512MB", FormatMemorySize(512<<20))
+	assert.Equal(t, "1536KB", FormatMemorySize(1536<<10))
+	assert.Equal(t, "1000B", FormatMemorySize(1000))
+	assert.Equal(t, "mem=2GB cpu=30s", ResourceLimits{Memory: 2 << 30, CPU: 30e9}.String())
+}
+
+func TestMemoryLimit(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skipf("resource limits not supported on %s", runtime.GOOS)
+	}
+	binPath := buildTestProgram(t, `package main
+
+import "fmt"
+
+func main() {
+	buf := make([]byte, 2<<30)
+	buf[len(buf)-1] = 1
+	fmt.Println("allocated", len(buf))
+}
+`)
+	msg := newStreamsMessage(t)
+	require.NoError(t, NewPipeExecToJupyterBuilder(msg, binPath).
+		WithResourceLimits(ResourceLimits{Memory: 256 << 20}).
+		Exec())
+	msg.mu.Lock()
+	defer msg.mu.Unlock()
+	assert.Contains(t, msg.stderr.String(), "exceeded the memory limit of 256MB")
+}
+
+// TestResourceLimitsAtStart checks that the limits are in place from the start of the program.
+func TestResourceLimitsAtStart(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skipf("resource limits not supported on %s", runtime.GOOS)
+	}
+	binPath := buildTestProgram(t, `package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func main() {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_CPU, &limit); err != nil {
+		panic(err)
+	}
+	fmt.Printf("cpu=%d args=%q wrapper=%q\n", limit.Cur, os.Args[1:], os.Getenv("GONB_RESOURCE_LIMITS_WRAPPER"))
+}
+`)
+	msg := newStreamsMessage(t)
+	require.NoError(t, NewPipeExecToJupyterBuilder(msg, binPath, "a", "b c").
+		WithResourceLimits(ResourceLimits{CPU: 1500 * time.Millisecond}).
+		Exec())
+	msg.mu.Lock()
+	defer msg.mu.Unlock()
+	assert.Contains(t, msg.stdout.String(), `cpu=2 args=["a" "b c"] wrapper=""`)
+}
@@ -0,0 +1,150 @@
+package kernel
+
+import (
+	"log"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+)
+
+// This file implements the store shared by the programs of all cells (gonbui.Store and gonbui.Load),
+// to approximate a persistent session, despite each cell being compiled and executed separately.
+//
+// Programs send the values to be stored through the display pipe (see protocol.MIMEGonbStore), and
+// the kernel saves them in a directory, one file per key, passed to the programs in the environment
+// variable protocol.GONB_STORE_DIR_ENV, from where they read the values.
+
+// Store holds the values shared by the programs executed by the kernel, see Kernel.Store.
+type Store struct {
+	dir string
+
+	mu    sync.Mutex
+	sizes map[string]int // Size of the value of each key.
+	total int
+}
+
+// Store returns the store shared by the programs executed by the kernel, creating it in a new
+// temporary directory on first use. It is removed when the kernel is stopped.
+func (k *Kernel) Store() (*Store, error) {
+	k.muStore.Lock()
+	defer k.muStore.Unlock()
+	if k.store == nil {
+		dir, err := os.MkdirTemp("", "gonb_store_")
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create directory for the shared store")
+		}
+		k.store = &Store{dir: dir, sizes: make(map[string]int)}
+	}
+	return k.store, nil
+}
+
+// Dir returns the directory where the values are saved.
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Set the value (encoded) of key, or deletes it if value is empty. It returns an error if the key or
+// value exceed the limits of the store (see protocol.StoreMaxKeyLength and related constants).
+func (s *Store) Set(key string, value []byte) error {
+	if key == "" || len(key) > protocol.StoreMaxKeyLength {
+		return errors.Errorf("invalid store key %q, it must have between 1 and %d bytes", key, protocol.StoreMaxKeyLength)
+	}
+	if len(value) > protocol.StoreMaxValueSize {
+		return errors.Errorf("value for key %q has %s, more than the limit of %s", key,
+			FormatMemorySize(int64(len(value))), FormatMemorySize(protocol.StoreMaxValueSize))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	filePath := path.Join(s.dir, protocol.StoreFileName(key))
+	if len(value) == 0 {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to delete key %q from store", key)
+		}
+		s.total -= s.sizes[key]
+		delete(s.sizes, key)
+		return nil
+	}
+	if newTotal := s.total - s.sizes[key] + len(value); newTotal > protocol.StoreMaxTotalSize {
+		return errors.Errorf("storing key %q would take the store to %s, more than the limit of %s", key,
+			FormatMemorySize(int64(newTotal)), FormatMemorySize(protocol.StoreMaxTotalSize))
+	}
+
+	// Write to a temporary file first, so programs never read a partially written value.
+	tmpPath := filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, value, 0600); err != nil {
+		return errors.Wrapf(err, "failed to save key %q in store", key)
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return errors.Wrapf(err, "failed to save key %q in store", key)
+	}
+	s.total += len(value) - s.sizes[key]
+	s.sizes[key] = len(value)
+	return nil
+}
+
+// Sizes returns the keys in the store, sorted, and the sizes of their values.
+func (s *Store) Sizes() (keys []string, sizes []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys = make([]string, 0, len(s.sizes))
+	for key := range s.sizes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sizes = make([]int, len(keys))
+	for ii, key := range keys {
+		sizes[ii] = s.sizes[key]
+	}
+	return
+}
+
+// Reset deletes all keys from the store.
+func (s *Store) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.sizes {
+		if err := os.Remove(path.Join(s.dir, protocol.StoreFileName(key))); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to delete key %q from store", key)
+		}
+		delete(s.sizes, key)
+	}
+	s.total = 0
+	return nil
+}
+
+// removeStore removes the directory of the store, if one was created.
+func (k *Kernel) removeStore() {
+	k.muStore.Lock()
+	defer k.muStore.Unlock()
+	if k.store == nil {
+		return
+	}
+	if err := os.RemoveAll(k.store.dir); err != nil {
+		log.Printf("Failed to remove shared store directory %q: %+v", k.store.dir, err)
+	}
+	k.store = nil
+}
+
+// processStore handles a protocol.MIMEGonbStore request sent by a program. Errors are reported
+// to the program's cell, since the program is not waiting for an answer.
+func processStore(msg Message, data *protocol.DisplayData) {
+	key, _ := data.Metadata[protocol.StoreKeyMetadata].(string)
+	value, _ := data.Data[protocol.MIMEGonbStore].([]byte)
+	err := errors.New("kernel not available")
+	if k := msg.Kernel(); k != nil {
+		var store *Store
+		store, err = k.Store()
+		if err == nil {
+			err = store.Set(key, value)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to store key %q: %+v", key, err)
+		_ = PublishWriteStream(msg, StreamStderr, "gonbui.Store: "+err.Error()+"\n")
+	}
+}
@@ -0,0 +1,52 @@
+package kernel
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	k := &Kernel{}
+	defer k.removeStore()
+	store, err := k.Store()
+	require.NoError(t, err)
+	dir := store.Dir()
+
+	require.NoError(t, store.Set("a", []byte("123")))
+	require.NoError(t, store.Set("b", []byte("45")))
+	content, err := os.ReadFile(path.Join(dir, protocol.StoreFileName("a")))
+	require.NoError(t, err)
+	assert.Equal(t, "123", string(content))
+	keys, sizes := store.Sizes()
+	assert.Equal(t, []string{"a", "b"}, keys)
+	assert.Equal(t, []int{3, 2}, sizes)
+
+	// Through the display protocol, as sent by gonbui.Store.
+	processDisplayData(&MessageImpl{kernel: k}, &protocol.DisplayData{
+		Data:     map[protocol.MIMEType]any{protocol.MIMEGonbStore: []byte{}},
+		Metadata: map[string]any{protocol.StoreKeyMetadata: "a"},
+	})
+	assert.NoFileExists(t, path.Join(dir, protocol.StoreFileName("a")))
+	keys, _ = store.Sizes()
+	assert.Equal(t, []string{"b"}, keys)
+
+	// Limits.
+	assert.Error(t, store.Set(strings.Repeat("k", protocol.StoreMaxKeyLength+1), []byte("1")))
+	assert.Error(t, store.Set("big", make([]byte, protocol.StoreMaxValueSize+1)))
+	store.total = protocol.StoreMaxTotalSize - 1
+	assert.Error(t, store.Set("c", []byte("12")))
+
+	require.NoError(t, store.Reset())
+	keys, _ = store.Sizes()
+	assert.Empty(t, keys)
+	assert.NoFileExists(t, path.Join(dir, protocol.StoreFileName("b")))
+
+	k.removeStore()
+	assert.NoFileExists(t, dir)
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/janpfeifer/gonb/kernel"
 	"io"
 	"log"
+	"net"
 	"os"
 )
 
@@ -17,6 +18,18 @@ var (
 	flagKernel   = flag.String("kernel", "", "Run kernel using given path for the `connection_file` provided by Jupyter client")
 	flagExtraLog = flag.String("extra_log", "", "Extra file to include in the log.")
 	flagForce    = flag.Bool("force", false, "Force install even if goimports and/or gopls are missing.")
+	flagLogLevel = flag.String("log_level", "info", "Log level: one of \"error\", \"info\" or \"debug\". "+
+		"Use \"debug\" to capture diagnostic logs for bug reports.")
+	flagLogFile     = flag.String("log_file", "", "Write logs to the given file only, instead of to the terminal (stderr).")
+	flagHTTPControl = flag.String("http_control", "", "If set, serve an HTTP endpoint on the given address "+
+		"(e.g.: \":8889\", bound to localhost if no host is given, or \":0\" for a free port) that executes code "+
+		"POSTed to it and returns the outputs. Requests require the token logged at start in the header "+
+		"\"X-Gonb-Token\". With --install, the port is replaced by 0. Meant for testing and tooling, don't "+
+		"expose it to other hosts.")
+	flagMetrics = flag.String("metrics", "", "If set, serve the metrics of the kernel (cells executed, compilations, "+
+		"etc.) in the Prometheus format on the given address (e.g.: \":9090\", bound to localhost if no host is "+
+		"given, or \":0\" for a free port), under the path \"/metrics\", labeled with the kernel id logged at "+
+		"start. With --install, the port is replaced by 0. If the address can't be bound, the kernel runs without it.")
 )
 
 // UniqueID uniquely identifies a kernel execution. Used for logging and creating temporary directories.
@@ -24,6 +37,7 @@ var (
 var UniqueID string
 
 func main() {
+	kernel.RunResourceLimitsWrapperIfRequested()
 	flag.Parse()
 	SetUpLogging() // Also sets UniqueID
 
@@ -33,6 +47,18 @@ func main() {
 		if *flagExtraLog != "" {
 			extraArgs = []string{"--extra_log", *flagExtraLog}
 		}
+		if *flagLogLevel != "info" {
+			extraArgs = append(extraArgs, "--log_level", *flagLogLevel)
+		}
+		if *flagLogFile != "" {
+			extraArgs = append(extraArgs, "--log_file", *flagLogFile)
+		}
+		if *flagHTTPControl != "" {
+			extraArgs = append(extraArgs, "--http_control", installAddress("http_control", *flagHTTPControl))
+		}
+		if *flagMetrics != "" {
+			extraArgs = append(extraArgs, "--metrics", installAddress("metrics", *flagMetrics))
+		}
 		err := kernel.Install(extraArgs, *flagForce)
 		if err != nil {
 			log.Fatalf("Installation failed: %+v\n", err)
@@ -60,34 +86,87 @@ func main() {
 		log.Fatalf("Failed to create go executor: %+v", err)
 	}
 
+	// Optional HTTP control endpoint.
+	if *flagHTTPControl != "" {
+		httpControl, err := dispatcher.ServeHTTPControl(k, goExec, *flagHTTPControl)
+		if err != nil {
+			log.Fatalf("Failed to start HTTP control endpoint: %+v", err)
+		}
+		defer func() { _ = httpControl.Close() }()
+	}
+
+	// Optional metrics endpoint.
+	if *flagMetrics != "" {
+		// The metrics are not essential to the notebook: the kernel runs without them.
+		metrics, err := dispatcher.ServeMetrics(goExec, *flagMetrics)
+		if err != nil {
+			log.Printf("Failed to start metrics endpoint, running without it: %+v", err)
+		} else {
+			defer func() { _ = metrics.Close() }()
+		}
+	}
+
 	// Orchestrate dispatching of messages.
 	dispatcher.RunKernel(k, goExec)
 
 	// Wait for all polling goroutines.
 	k.ExitWait()
+	goExec.Finalize()
 	log.Printf("Exiting...")
 }
 
+// installAddress returns the address to install in the kernel configuration for the endpoint of
+// the given flag: Jupyter starts every kernel with the same arguments, so a fixed port would be
+// shared by all the kernels running at the same time. The port is replaced by "0" instead: each
+// kernel listens to a free port, and logs it.
+func installAddress(flagName, address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		log.Fatalf("Invalid --%s=%q: %+v", flagName, address, err)
+	}
+	if port != "0" {
+		log.Printf("--%s port %s is not installed, each kernel will listen to a free port and log it.", flagName, port)
+	}
+	return net.JoinHostPort(host, "0")
+}
+
 var (
 	ColorReset    = "\033[0m"
 	ColorYellow   = "\033[33m"
 	ColorBgYellow = "\033[7;39;32m"
 )
 
-// SetUpLogging creates a UniqueID, uses it as a prefix for logging, and sets up --extra_log if
-// requested.
+// SetUpLogging creates a UniqueID, uses it as a prefix for logging, sets the log level and sets up
+// --log_file and --extra_log if requested.
 func SetUpLogging() {
 	uuid, _ := uuid.NewV7()
 	uuidStr := uuid.String()
 	UniqueID = uuidStr[len(uuidStr)-8:]
 	log.SetPrefix(fmt.Sprintf("%s[%s]%s ", ColorBgYellow, UniqueID, ColorReset))
+	level, err := kernel.ParseLogLevel(*flagLogLevel)
+	if err != nil {
+		log.Fatalf("Invalid --log_level: %+v", err)
+	}
+	kernel.SetLogLevel(level)
+
+	var w io.Writer = os.Stderr
+	if *flagLogFile != "" {
+		// Logs go only to the file, no colors.
+		w = openLogFile(*flagLogFile)
+		log.SetPrefix(fmt.Sprintf("[%s] ", UniqueID))
+	}
 	if *flagExtraLog != "" {
-		f, err := os.OpenFile(*flagExtraLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
-		if err != nil {
-			log.Fatalf("Failed to open log file %q for writting: %+v", *flagExtraLog, err)
-		}
-		f.Write([]byte("\n\n"))
-		w := io.MultiWriter(f, os.Stderr) // Write to STDERR and the newly open f.
-		log.SetOutput(w)
+		w = io.MultiWriter(openLogFile(*flagExtraLog), w) // Write to the newly open file and w.
+	}
+	log.SetOutput(w)
+}
+
+// openLogFile opens the file for appending logs.
+func openLogFile(filePath string) io.Writer {
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Fatalf("Failed to open log file %q for writting: %+v", filePath, err)
 	}
+	f.Write([]byte("\n\n"))
+	return f
 }
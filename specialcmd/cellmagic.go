@@ -0,0 +1,172 @@
+package specialcmd
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/goexec"
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// This file implements cell magics: special commands in the form `%%<name> {...args...}`,
+// that apply to the whole cell. Some of them take the rest of the cell (the lines following
+// the command) as their body, as opposed to Go code.
+
+// cellMagicTakesBody lists the cell magics whose body is the rest of the cell.
+var cellMagicTakesBody = map[string]bool{
+	"asm":           true,
+	"cleanup":       true,
+	"defer-cleanup": true,
+	"file":          true,
+	"go-run-file":   true,
+	"go.mod":        true,
+	"imports":       true,
+	"package":       true,
+	"prelude":       true,
+}
+
+// isCellMagic returns whether the line is a cell magic, that is, a line starting with `%%` followed
+// by a command name. A line with only `%%` is the shortcut for `%main`.
+func isCellMagic(line string) bool {
+	line = strings.TrimSpace(line)
+	return len(line) > 2 && strings.HasPrefix(line, "%%") && line[2] != ' ' && line[2] != '\t'
+}
+
+// execCellMagic executes the cell magic given by parts (command name and arguments). body holds the
+// rest of the cell for the cell magics listed in cellMagicTakesBody.
+//
+// Like execInternal, only errors in the execution are returned. Unknown commands are reported
+// back to Jupyter.
+func execCellMagic(msg kernel.Message, goExec *goexec.State, parts []string, body []string) error {
+	switch parts[0] {
+	case "background":
+		goExec.Cell.Background = true
+	case "pprof":
+		if len(parts) != 2 {
+			return errors.Errorf("`%%%%pprof <kind>` takes 1 argument, one of %q. %d were given", goexec.ProfileKinds, len(parts)-1)
+		}
+		goExec.Cell.Profile = parts[1]
+	case "memstats":
+		if len(parts) != 1 {
+			return errors.Errorf("`%%%%memstats` takes no arguments")
+		}
+		goExec.Cell.MemStats = true
+	case "capture-display":
+		if len(parts) != 2 {
+			return errors.Errorf("`%%%%capture-display <name>` takes 1 argument, the name under which to capture. %d were given", len(parts)-1)
+		}
+		goExec.Cell.CaptureDisplay = parts[1]
+	case "autoget":
+		if len(parts) != 2 || (parts[1] != "on" && parts[1] != "off") {
+			return errors.Errorf("`%%%%autoget on|off` takes 1 argument, \"on\" or \"off\"")
+		}
+		autoGet := parts[1] == "on"
+		goExec.Cell.AutoGet = &autoGet
+	case "dryrun":
+		goExec.Cell.DryRun = true
+	case "skip":
+		if len(parts) != 1 {
+			return errors.Errorf("`%%%%skip` takes no arguments")
+		}
+		goExec.Cell.Skip = true
+	case "pty":
+		if len(parts) > 2 {
+			return errors.Errorf("`%%%%pty [<cols>x<rows>]` takes at most 1 argument, the size of the terminal. %d were given", len(parts)-1)
+		}
+		size := kernel.DefaultTerminalSize
+		if len(parts) == 2 {
+			var err error
+			if size, err = kernel.ParseTerminalSize(parts[1]); err != nil {
+				return err
+			}
+		}
+		goExec.Cell.Terminal = &size
+	case "plugin":
+		if len(parts) != 2 {
+			return errors.Errorf("`%%%%plugin <path.so>` takes 1 argument, the path of the plugin. %d were given", len(parts)-1)
+		}
+		goExec.Cell.Plugin = parts[1]
+	case "file":
+		if len(parts) != 2 {
+			return errors.Errorf("`%%%%file <path>` takes 1 argument, the path of the file. %d were given", len(parts)-1)
+		}
+		if err := goExec.WriteFile(parts[1], body); err != nil {
+			return err
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf("* File %s written.\n", parts[1]))
+	case "asm":
+		if len(parts) != 2 {
+			return errors.Errorf("`%%%%asm <file.s>` takes 1 argument, the name of the assembly file. %d were given", len(parts)-1)
+		}
+		if err := goExec.WriteAssembly(parts[1], body); err != nil {
+			return err
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf("* Assembly file %s written.\n", parts[1]))
+	case "go.mod":
+		if len(parts) != 1 {
+			return errors.Errorf("`%%%%go.mod` takes no arguments, the contents of go.mod are the rest of the cell")
+		}
+		if err := goExec.WriteGoMod(body); err != nil {
+			return err
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, "* go.mod written.\n")
+	case "go-run-file":
+		if len(parts) != 2 {
+			return errors.Errorf("`%%%%go-run-file <path>` takes 1 argument, the main package directory or Go file. %d were given", len(parts)-1)
+		}
+		for _, line := range body {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "//") {
+				return errors.Errorf("`%%%%go-run-file` doesn't take any Go code, the rest of the cell must be empty")
+			}
+		}
+		return goExec.RunFile(msg, parts[1])
+	case "imports":
+		if len(parts) != 1 {
+			return errors.Errorf("`%%%%imports` takes no arguments, the import declarations are the rest of the cell")
+		}
+		return goExec.FetchImports(msg, body)
+	case "prelude":
+		if len(parts) != 1 {
+			return errors.Errorf("`%%%%prelude` takes no arguments, the declarations are the rest of the cell")
+		}
+		if err := goExec.AddPrelude(msg, body); err != nil {
+			return err
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, "* Prelude updated.\n")
+	case "cleanup", "defer-cleanup":
+		if len(parts) != 1 {
+			return errors.Errorf("`%%%%%s` takes no arguments, the code to execute at shutdown is the rest of the cell", parts[0])
+		}
+		if err := goExec.AddCleanup(msg, body); err != nil {
+			return err
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout,
+			fmt.Sprintf("* Cleanup registered, %d will be executed at shutdown.\n", len(goExec.Cleanups)))
+	case "package":
+		if len(parts) != 2 {
+			return errors.Errorf("`%%%%package <name>` takes 1 argument, the package name. %d were given", len(parts)-1)
+		}
+		importPath, err := goExec.WritePackage(parts[1], body)
+		if err != nil {
+			return err
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout,
+			fmt.Sprintf("* Package %s written, import it with %q.\n", parts[1], importPath))
+	default:
+		err := kernel.PublishWriteStream(msg, kernel.StreamStderr, fmt.Sprintf("\"%%%%%s\" unknown or not implemented yet.", parts[0]))
+		if err != nil {
+			kernel.Logf(kernel.LogLevelError, "Error while reporting back on unimplmented cell magic \"%%%%%s\": %+v", parts[0], err)
+		}
+	}
+	return nil
+}
+
+// firstCodeLine returns the first line of lines that is not empty, trimmed, to identify a cell.
+func firstCodeLine(lines []string) string {
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
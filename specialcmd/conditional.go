@@ -0,0 +1,257 @@
+package specialcmd
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/kernel"
+	"github.com/pkg/errors"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// This file implements conditional regions of a cell: lines between `%%if <condition>` and
+// `%%endif` (with an optional `%%else`) are included or excluded depending on the condition. It also
+// implements `%%skipif <condition>`, that skips the whole cell if the condition is true.
+//
+// Conditions are evaluated when the `%%if` line is reached, so they see the environment variables
+// set by `%env` in previous lines. Excluded lines are marked as used, so they are neither executed
+// as special commands nor included in the Go code -- and the line numbers of errors in the
+// included lines still refer to the cell.
+
+// isConditionalMagic returns whether the cell magic is one of `%%if`, `%%else` or `%%endif`.
+func isConditionalMagic(name string) bool {
+	return name == "if" || name == "else" || name == "endif"
+}
+
+// execConditional handles the conditional cell magic in codeLines[lineNum], already split into
+// parts. It returns the line number of the last line used (excluded lines are marked in usedLines).
+//
+// If the cell is not being executed (e.g.: for auto-complete or inspection, of a cell still being
+// edited), it is tolerant: unbalanced magics are ignored, and invalid conditions are taken as true.
+func execConditional(codeLines []string, lineNum int, parts []string, usedLines, insideLiterals map[int]bool, status *cellStatus) (int, error) {
+	lineNum, err := execConditionalStrict(codeLines, lineNum, parts, usedLines, insideLiterals, status)
+	if err != nil && !status.execute {
+		err = nil
+	}
+	return lineNum, err
+}
+
+// execConditionalStrict implements execConditional, returning any errors.
+func execConditionalStrict(codeLines []string, lineNum int, parts []string, usedLines, insideLiterals map[int]bool, status *cellStatus) (int, error) {
+	usedLines[lineNum] = true
+	switch parts[0] {
+	case "if":
+		if len(parts) < 2 {
+			return lineNum, errors.Errorf("`%%%%if <condition>` requires a condition")
+		}
+		status.openIfs++
+		included, err := evalCondition(parts[1:], status)
+		if err != nil {
+			// If tolerated (see execConditional), the region is included.
+			return lineNum, err
+		}
+		if included {
+			return lineNum, nil
+		}
+		lineNum = skipConditionalRegion(codeLines, lineNum, usedLines, insideLiterals)
+		if lineNum >= len(codeLines) {
+			return lineNum, errors.Errorf("`%%%%if` without matching `%%%%endif`")
+		}
+		if isConditionalLine(codeLines[lineNum], "endif") {
+			status.openIfs--
+		}
+		return lineNum, nil
+
+	case "else":
+		// Reached the `%%else` of an included `%%if` region: skip the else region.
+		if status.openIfs == 0 {
+			return lineNum, errors.Errorf("`%%%%else` without `%%%%if`")
+		}
+		lineNum = skipConditionalRegion(codeLines, lineNum, usedLines, insideLiterals)
+		if lineNum >= len(codeLines) || !isConditionalLine(codeLines[lineNum], "endif") {
+			return lineNum, errors.Errorf("`%%%%else` without matching `%%%%endif`")
+		}
+		status.openIfs--
+		return lineNum, nil
+
+	default: // "endif"
+		if status.openIfs == 0 {
+			return lineNum, errors.Errorf("`%%%%endif` without `%%%%if`")
+		}
+		status.openIfs--
+		return lineNum, nil
+	}
+}
+
+// skipConditionalRegion marks as used the lines following fromLine, up to (and including) the
+// matching `%%else` or `%%endif`. It returns the line number of the matching line, or
+// len(codeLines) if not found.
+func skipConditionalRegion(codeLines []string, fromLine int, usedLines, insideLiterals map[int]bool) int {
+	depth := 0
+	for lineNum := fromLine + 1; lineNum < len(codeLines); lineNum++ {
+		usedLines[lineNum] = true
+		if insideLiterals[lineNum] {
+			continue
+		}
+		line := codeLines[lineNum]
+		switch {
+		case isConditionalLine(line, "if"):
+			depth++
+		case isConditionalLine(line, "endif"):
+			if depth == 0 {
+				return lineNum
+			}
+			depth--
+		case isConditionalLine(line, "else"):
+			if depth == 0 {
+				return lineNum
+			}
+		}
+	}
+	return len(codeLines)
+}
+
+// isConditionalLine returns whether line is the cell magic `%%<name>`.
+func isConditionalLine(line, name string) bool {
+	if !isCellMagic(line) {
+		return false
+	}
+	parts := splitCmd(strings.TrimSpace(line)[2:])
+	return len(parts) > 0 && parts[0] == name
+}
+
+// evalCondition evaluates the condition of a `%%if` (or `%%skipif`), given as a list of parts.
+// Conditions take the form `<value>` or `!<value>` (true if the value is non-empty), or
+// `<value> <op> <value>`, where op is one of `==`, `!=`, `<`, `<=`, `>` or `>=`. The ordering
+// operators compare versions (e.g.: `goversion < 1.21`). Spaces around the operator are optional.
+//
+// Values are either literals or the variables: `goos` and `goarch` (the platform the kernel is
+// running on), `goversion` (the version of the Go toolchain, e.g. "1.21.3"), or `env.NAME` for the
+// environment variable NAME.
+func evalCondition(parts []string, status *cellStatus) (bool, error) {
+	condition := strings.Join(parts, " ")
+	if matches := reComparison.FindStringSubmatch(condition); matches != nil {
+		lhs, op, rhs := conditionValue(matches[1], status), matches[2], conditionValue(matches[3], status)
+		switch op {
+		case "==":
+			return lhs == rhs, nil
+		case "!=":
+			return lhs != rhs, nil
+		}
+		cmp, err := compareVersions(lhs, rhs)
+		if err != nil {
+			return false, errors.WithMessagef(err, "invalid condition %q", condition)
+		}
+		switch op {
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		default: // ">="
+			return cmp >= 0, nil
+		}
+	}
+	if len(parts) == 1 {
+		operand := parts[0]
+		negate := strings.HasPrefix(operand, "!")
+		if negate {
+			operand = operand[1:]
+		}
+		return (conditionValue(operand, status) != "") != negate, nil
+	}
+	return false, errors.Errorf("invalid condition %q: it should be `<value>`, `!<value>` or `<value> <op> <value>`, "+
+		"with op one of ==, !=, <, <=, > or >=", condition)
+}
+
+// reComparison matches conditions in the form `<value> <op> <value>`.
+var reComparison = regexp.MustCompile(`^\s*([^\s=!<>]+)\s*(==|!=|<=|>=|<|>)\s*([^\s=!<>]+)\s*$`)
+
+// conditionValue returns the value of the variable named by operand, or operand itself if it is
+// a literal.
+func conditionValue(operand string, status *cellStatus) string {
+	switch {
+	case operand == "goos":
+		return runtime.GOOS
+	case operand == "goarch":
+		return runtime.GOARCH
+	case operand == "goversion":
+		return strings.TrimPrefix(status.goVersion, "go")
+	case strings.HasPrefix(operand, "env."):
+		return os.Getenv(operand[len("env."):])
+	}
+	return operand
+}
+
+// compareVersions compares versions in the form "1.21.3" (an optional "go" prefix and suffixes
+// like "rc1" are ignored), returning -1, 0 or 1. Missing components are taken as 0.
+func compareVersions(a, b string) (int, error) {
+	aParts, err := versionParts(a)
+	if err != nil {
+		return 0, err
+	}
+	bParts, err := versionParts(b)
+	if err != nil {
+		return 0, err
+	}
+	for ii := 0; ii < len(aParts) || ii < len(bParts); ii++ {
+		var aPart, bPart int
+		if ii < len(aParts) {
+			aPart = aParts[ii]
+		}
+		if ii < len(bParts) {
+			bPart = bParts[ii]
+		}
+		if aPart != bPart {
+			if aPart < bPart {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// reVersion matches the numeric components of a version.
+var reVersion = regexp.MustCompile(`^(?:go)?(\d+(?:\.\d+)*)`)
+
+// versionParts returns the numeric components of the version.
+func versionParts(version string) ([]int, error) {
+	matches := reVersion.FindStringSubmatch(version)
+	if matches == nil {
+		return nil, errors.Errorf("%q is not a version (is the go toolchain available?)", version)
+	}
+	var parts []int
+	for _, part := range strings.Split(matches[1], ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid version %q", version)
+		}
+		parts = append(parts, n)
+	}
+	return parts, nil
+}
+
+// execSkipIf evaluates the condition of a `%%skipif <condition>` in codeLines[lineNum], already split
+// into parts. If the condition is true, all lines of the cell are marked as used -- so nothing else is
+// executed or compiled -- a note is displayed, and it returns true.
+func execSkipIf(msg kernel.Message, codeLines []string, lineNum int, parts []string, usedLines map[int]bool, status *cellStatus) (bool, error) {
+	usedLines[lineNum] = true
+	if len(parts) < 2 {
+		return false, errors.Errorf("`%%%%skipif <condition>` requires a condition")
+	}
+	skip, err := evalCondition(parts[1:], status)
+	if err != nil || !skip {
+		return false, err
+	}
+	for ii := range codeLines {
+		usedLines[ii] = true
+	}
+	if msg != nil {
+		err = kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf("Cell skipped: %s\n", strings.Join(parts[1:], " ")))
+	}
+	return true, err
+}
@@ -11,8 +11,11 @@ import (
 	"github.com/janpfeifer/gonb/goexec"
 	"github.com/janpfeifer/gonb/kernel"
 	"github.com/pkg/errors"
-	"log"
 	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 )
 
 const HelpMessage = `GoNB is a Go kernel that compiles and executed on-the-fly Go code. 
@@ -52,8 +55,249 @@ Special non-Go commands:
   use flags as a normal program.
 - "%autoget" and "%noautoget": Default is "%autoget", which automatically does "go get" for
   packages not yet available.
+- "%autoprint on|off": Default is "off". With "on", if the last statement of the main function
+  (e.g.: of a "%%" cell) is an expression whose value would otherwise be discarded, it is printed
+  with fmt.Println, REPL style. E.g.: "x", "a+b" or "m[key]". Function calls (like "math.Sqrt(2)")
+  are executed as usual and not printed, since they may not return a value, and neither are
+  assignments or channel receives. With "%goimports off", "fmt" must be imported.
+- "%%autoget on|off": overrides "%autoget"/"%noautoget" only for the execution of the current
+  cell. E.g.: a single cell to install dependencies in an otherwise offline notebook.
+- "%verbose on|off": Default is "off". With "on", the external commands run by GoNB ("go build",
+  "goimports -w", "go get", the program itself, etc.) are displayed before being run, with their
+  arguments, directory and environment overrides -- the values of secrets are redacted.
+- "%debug on [<address>]|off": Default is "off". With "on", programs are compiled without
+  optimizations and inlining ("-gcflags=all=-N -l"), and executed under Delve ("dlv exec
+  --headless"), which waits for a debugger (e.g.: "dlv connect <address>" or an IDE) to attach on
+  <address> -- by default "127.0.0.1:2345". Requires Delve to be installed:
+  "!go install github.com/go-delve/delve/cmd/dlv@latest".
+- "%output <path>|reset": writes the compiled program to <path> (relative to the current
+  directory; if it is a directory, or ends with "/", the program is written in it), instead of the
+  temporary directory, so it can be retrieved -- it is not removed when the kernel exits. The final
+  path is reported after each compilation. Combined with "%env GOOS <os>" and "%env GOARCH <arch>"
+  it builds programs for other platforms: use "%rebuild" to compile them without executing them.
+  "%output" shows the current setting and "%output reset" restores the default.
+- "%seed <n> [gomaxprocs=<k>]": sets a deterministic seed, to make randomized examples reproducible:
+  the variable "GonbSeed" (int64) is declared with the value <n>, and the programs are executed with
+  the environment variable GONB_SEED=<n>. E.g.: "rng := rand.New(rand.NewSource(GonbSeed))". With
+  "gomaxprocs=<k>", programs are also executed with GOMAXPROCS=<k>. "%seed" shows the current seed
+  and "%seed off" removes it.
+- "%ldvar <name>=<value> ...": stamps the value into the package-level string variable <name> at
+  build time, with the linker flag "-X main.<name>=<value>" (added to "-ldflags"). The variable is
+  declared as "var <name> string" if needed, otherwise it must be a string variable not initialized
+  or initialized with a string literal. Environment variables in <value> ("$VAR" or "${VAR}", e.g.:
+  set with "%env") are expanded at each build. "%ldvar" lists the values and "%ldvar reset" removes
+  them.
+- "%vet on|off": Default is "off". With "on", "go vet" is run after each successful compilation,
+  and its findings are displayed as warnings, without failing the execution. Warnings of the build
+  itself (e.g.: from cgo's C compiler) are always displayed that way.
+- "%incremental on|off": Default is "off". With "on" (a prototype), the declarations that are
+  not affected by the last changes are compiled as a separate package, which "go build" reuses
+  from its cache, so only the rest is recompiled -- it speeds up the execution of cells in
+  notebooks with lots of declarations. Variables, generic declarations, and whatever depends on
+  them are always recompiled. If the split program fails to build, it is built as usual. With
+  "%verbose on", the number of declarations compiled separately is displayed.
+  Notice the declarations compiled separately belong to another package: the names of their types
+  printed with "%T" or by "reflect" are "gonb_incremental.<name>" instead of "main.<name>", and
+  stack traces show their lines in a (removed) file of that package. It is disabled while "%debug"
+  is on.
+- "%goroutinedump on|off": Default is "off". With "on", interrupting a running program (e.g.: one
+  that hangs) makes it print the stack of all its goroutines and exit (it sends a SIGQUIT, instead
+  of SIGINT). Interrupting it again kills it. Only on Unix systems, elsewhere the program is killed.
+- "%goimports on|off|warn": Default is "on", which runs goimports to add missing imports and remove
+  unused ones. With "off", imports are used exactly as declared (they are carried over across
+  cells), and missing or unused ones are reported by the compiler. The exceptions are "flag",
+  "os", "runtime", "runtime/pprof" and "strconv" when used in the main function: gonb generates code using
+  them there, so they are added if missing. "%autoget" still applies. "warn" is like "on", but
+  also reports any change goimports makes to the declarations other than formatting. Declarations
+  removed or added by goimports are always reported.
+- "%gowork <path/to/go.work>|reset": makes the notebook's module part of the go.work workspace,
+  so the notebook can import its local modules, and lists the modules it exposes. "reset" leaves
+  the workspace, and with no arguments it shows the current one.
+- "%dot-import <path>": adds a dot import (import . "<path>"), so the names exported by the package
+  can be used unqualified in the following cells. Beware of collisions with names declared in the
+  cells or in other dot-imported packages. Dot imports not used by a cell are dropped when compiling
+  it. "%dot-import remove <path>" removes it, and "%dot-import" lists the dot imports.
+- "%importpref name=path ...": sets the package to import when "name" is used in the code but
+  not imported, instead of letting goimports guess (e.g. "%importpref rand=crypto/rand").
+  Imports declared in the cells take precedence. Use "name=" to remove a preference, or no
+  arguments to list them.
+- "%source_on_error <off|context|full>": on errors, also displays the generated source code
+  (main.go), with line numbers: only the lines around the errors ("context") or all of it
+  ("full"). Default is "off".
+- "%fuzz FuzzName [fuzztime]": instead of executing the program, runs the fuzz target
+  "func FuzzName(f *testing.F)", defined in the cell or in previous ones, with "go test -fuzz"
+  for the given duration (default 10s). Failing inputs are saved in the "testdata/fuzz/FuzzName"
+  directory under the notebook's temporary directory.
+- "%append <funcName>": appends the Go code of the cell to the end of the body of the function
+  funcName, defined in previous cells, instead of declaring it as usual. Use "Type.Method" for
+  methods.
+- "%rebuild": compiles the declarations of the previous cells again, without executing it.
+  Useful after changes that don't alter the code but affect the build, like "%gobin" or "%env".
+- "%refresh": like "%rebuild", but also refreshes the dependencies: runs "go get" (even with
+  "%noautoget") and rebuilds all packages ("go build -a") instead of using the build cache.
+  Useful after changing on disk a local module used with a "replace" directive or "%gowork".
+- "%stubmain <go code>": sets the body of the main function used for cells that don't define one
+  (cells with only declarations). With the default ("flag.Parse()") these cells are only compiled,
+  to validate them; with any other body they are also executed, running it. "%stubmain reset"
+  restores the default, and without arguments it displays the current one.
+- "%watch <dir>": re-executes (rebuilds and re-runs) the cell whenever files in the directory
+  change -- e.g.: a local package included with "!go mod edit -replace". Only one directory is
+  watched at a time. "%watch stop" stops it, and without arguments it displays the watched one.
+- "%parameters": marks the cell as the one declaring the parameters of the notebook
+  (papermill-style): the values of its variables are overridden by the ones given in the
+  environment variable GONB_PARAMETERS, a JSON object (e.g.: '{"name": "report", "limit": 10}')
+  read when the kernel starts. Declare parameters with explicit types (e.g.: "var limit int = 5").
+- "%who": lists the variables declared in the previous cells, with their types (or the
+  value they are initialized with, if the type is inferred).
+- "%export <dir>": writes the program with the current declarations, and the main function of
+  the last executed cell, as a standalone Go project in <dir> (which must be empty or not exist):
+  "main.go", "go.mod", "go.sum", the packages from "%%package" and the files from "%%file" and
+  "%%asm". Relative paths in "replace" directives of "go.mod" may need to be updated.
+- "%share": shares the program generated by the last executed cell to the Go Playground, and
+  displays the link to it.
+- "%gobin /path/to/go": sets the "go" binary used to build the cells and to fetch modules.
+  It defaults to the one found in PATH. Without arguments it displays the current one.
+- "%goget_retries <n>": number of times "go get" is retried (with exponential backoff) when
+  it fails with a transient network error. Errors like unknown modules are not retried, and
+  there are no retries if offline ("GOPROXY=off"). Default is 3, at most 10. The wait between
+  retries is at most 30 seconds, 2 minutes in total, and it is interrupted by an interrupt.
 - "%env VAR value": Sets the environment variable VAR to the given value. These variables
   will be available both for Go code as well as for shell scripts.
+- "%secret VAR": prompts for the value of a secret (e.g. an API token), which is passed to the
+  executed Go program as the environment variable VAR. The value is never written to the
+  generated "main.go", is not visible to shell commands, and is redacted from error reports.
+  Prefer it over "%env" for sensitive values, since "%env" values are written in the cell.
+- "%kill": kills the last executed program and the programs running in the background, along
+  with any processes they spawned (e.g. servers) that may still be running. The last program
+  (but not the ones in background) is also killed automatically when a new cell is executed,
+  and all of them when the kernel shuts down.
+- "%%background": executes the program of the cell in the background: the cell returns as soon
+  as the program starts, and its output (prefixed with its process id) continues to be
+  displayed as it comes. Use "%kill" to stop it.
+- "%output_limit [lines=<n>] [bytes=<n>] [spill=<true|false|link>]": limits the output of
+  executed programs and shell commands (stdout and stderr combined) -- further output is
+  discarded and a notice is displayed. A value of 0 means no limit. If "spill=true", the
+  full output is also saved to a temporary file, whose path is displayed if the output is
+  truncated. With "spill=link" the file is served by the kernel instead, and a link to
+  download it is displayed. Use "%output_limit off" to disable all limits, or without arguments to display
+  the current limits. Default is "lines=10000 bytes=1048576".
+- "%size_warning [lines=<n>] [decls=<n>]": once the generated program has more than <n> lines, or
+  more than <n> declarations, a suggestion to "%reset" or split the notebook is displayed, since
+  large programs slow down every compilation. A value of 0 means no threshold. Use
+  "%size_warning off" to disable it, or without arguments to display the current thresholds.
+  Default is "lines=5000 decls=500".
+- "%ansi raw|strip|html": configures how ANSI escape sequences (e.g.: colors of CLI tools) in the
+  output of executed programs and shell commands are handled: "raw" (the default) forwards them
+  untouched, "strip" removes them, and "html" translates colors and styles to HTML.
+- "%limit [mem=<size>] [cpu=<duration>]": limits the resources used by executed programs, to
+  protect the host when running untrusted code: "mem" limits the memory (data segment) of the
+  program, e.g.: "512MB" or "2GB"; "cpu" limits the CPU time used, e.g.: "30s" or "5m". A value
+  of 0 means no limit. Programs exceeding the limits fail, and the limit exceeded is reported.
+  Use "%limit off" to disable all limits, or without arguments to display the current limits.
+  Only supported on Linux: elsewhere programs are executed without limits and a warning is shown.
+- "%%if <condition>", "%%else" and "%%endif": the lines between them are included or not,
+  depending on the condition. Conditions are "<value>" or "!<value>" (checks whether the value
+  is non-empty), or "<value> <op> <value>" with op one of "==", "!=", or "<", "<=", ">", ">="
+  to compare versions. Values can be literals or the variables "goos", "goarch", "goversion"
+  (of the Go toolchain, e.g. "1.21.3"), or "env.NAME" for environment variable NAME. Example:
+  "%%if goos == linux". Conditional regions can be nested.
+- "%%skipif <condition>": skips the rest of the cell (nothing is compiled or executed, and its
+  declarations are not kept) if the condition (see "%%if") is true. E.g.: "%%skipif goversion<1.21".
+  Put it at the start of the cell, since special commands before it are still executed.
+- "%%dryrun": generates the program of the cell (main.go, after goimports) and displays it,
+  without compiling or executing it. The declarations of the cell are not kept.
+- "%%skip": compiles the cell and keeps its declarations for the following cells, but doesn't
+  execute it, even if it has a main function ("%%" or "%main"). Its main function is kept as
+  the last one defined (e.g.: used by "%export").
+- "%fmt": formats the Go code of the cell (with "go/format", like "gofmt") and replaces the cell
+  with it, without compiling or executing it. Special commands are kept as they are -- and not
+  executed --, and "%fmt" is removed. If the code has syntax errors, they are reported and the cell is left unchanged.
+- "%%pty [<cols>x<rows>]": executes the program attached to a pseudo-terminal of the given size
+  (default 80x24), instead of pipes, for programs that require a terminal or disable colors and
+  interactivity without one. Its stdout and stderr are merged. Only supported on Linux.
+- "%%plugin <path.so>": builds the program as a Go plugin ("go build -buildmode=plugin") written
+  to <path.so> (relative to the kernel's working directory), instead of executing it, and lists
+  the symbols that can be looked up in it (exported functions and variables). The declarations of
+  the cell are kept. Plugins are only supported on Linux, macOS and FreeBSD, and require cgo.
+- "%%file <path>": the rest of the cell is written to the file <path>, relative to the
+  notebook's module directory, so it can be used by the program, e.g. with "//go:embed <path>".
+  Files remain defined across cells: use "%files" to list them and "%files clear" to remove them.
+- "%%asm <file.s>": the rest of the cell is written as an assembly file (Go's Plan 9 assembly,
+  e.g.: "add_amd64.s") of the main package, built along with the program. The functions it
+  implements must be declared without a body in Go (e.g.: "func add(a, b int64) int64").
+  It is listed and removed with "%files". C files (cgo) are not supported in the main package,
+  since the preamble of 'import "C"' is not preserved: use them within a "%%package" instead,
+  writing the ".c" files to its directory with "%%file <package>/<file.c>".
+- "%%go.mod": the rest of the cell is written as the notebook's "go.mod" file, replacing the
+  current one -- e.g.: to add "replace" or "exclude" directives. It's validated first, and the
+  previous one is kept if invalid. The module directive, if present, must be the notebook's.
+- "%%go-run-file <path>": compiles the existing main package in the directory <path> (or the Go
+  file <path>), within its own module, and executes it like the program of a cell: with the
+  arguments set with "%args", the environment and "%limit", and it can be interrupted. The
+  declarations of the notebook are not used. E.g.: "%%go-run-file ./cmd/tool".
+- "//go:build <expr>" as the first line of a cell: the declarations of the cell are kept for the
+  platforms satisfying the constraint only, rendered to their own file. E.g.: define a function in
+  a cell with "//go:build linux" and again in one with "//go:build !linux". Declarations for other
+  platforms are only compiled when building for them (see "%env GOOS <os>").
+- "%%prelude": the rest of the cell are declarations (imports, helper functions, configuration)
+  added to the prelude, which is part of every compilation, under the declarations of the cells
+  (these take precedence if defined with the same name). The prelude is not affected by "%reset":
+  "%prelude" lists its declarations and "%prelude clear" removes them.
+- "%%cleanup" (or "%%defer-cleanup"): the rest of the cell is compiled, with the declarations of
+  the previous cells, into a program executed when the kernel shuts down -- e.g.: to remove
+  temporary files or stop external processes. If it has no "%%" or main function, it is all
+  wrapped in a main function. The latest registered runs first, and their output goes to the
+  kernel log. "%cleanup" lists them and "%cleanup clear" drops them without running them.
+- "%%imports": the rest of the cell has only import declarations, which are memorized for the
+  following cells, and the modules providing them are fetched ("go get", even with "%noautoget")
+  and reported. Nothing is compiled nor executed: use it to set up the dependencies up front.
+- "%%pprof <cpu|mem|block>": collects a profile of the given kind while executing the program
+  of the cell, and displays its summary ("go tool pprof -top"). The profile file path is
+  printed, for further analysis. The profile is not saved if the program calls os.Exit. Use
+  "%env GOMAXPROCS <n>" to control the number of threads.
+- "%%memstats": displays a table with the memory stats (heap in use, bytes allocated, number of
+  allocations, GC cycles and pauses) before and after executing the program of the cell, and the
+  difference. A lightweight alternative to "%%pprof mem". The stats are not displayed if the
+  program calls os.Exit.
+- "%%capture-display <name>": captures the rich content (HTML, images, etc.) displayed by the
+  program of the cell, instead of displaying it. Use "%display <name> ..." to display it later
+  (e.g.: to assemble a report), or "%display" to list the names captured. Text output (stdout and
+  stderr) is not captured.
+- "%freeze <var> ...": package-level variables are initialized at the start of every program
+  compiled afterwards (in the order of their dependencies, so they can call functions declared in
+  other cells). "%freeze" evaluates the given variables once, and replaces their declarations by the
+  values obtained, as Go literals -- so expensive initializers are not executed again. Only values
+  that can be written as Go literals can be frozen (e.g.: not functions or channels). The evaluation
+  is killed if interrupted, or after 5 minutes.
+- "%godebug <name>=<value> ...": adds runtime settings (e.g.: "gctrace=1" or "schedtrace=1000")
+  to the GODEBUG environment variable of the executed programs, to observe the garbage collector,
+  the scheduler and other runtime diagnostics. Settings accumulate, and setting a name again
+  replaces its value. "%godebug" shows the current settings and "%godebug reset" clears them.
+- "%session save <name>", "%session load <name>" and "%session list": saves the memorized
+  declarations, the "%%prelude", the "%%file" files and the configuration (program arguments,
+  build flags, "%godebug", "%autoget", "%autoprint", "%goimports", "%stubmain" and "%importpref")
+  as a named session, kept across kernel restarts (in "gonb/sessions" in the user's configuration
+  directory); loads a session, replacing the current declarations and configuration, and compiles
+  it to validate it; or lists the saved sessions. Secrets and the values set with "%env" are never
+  saved: only the names of the "%env" variables, which "%session load" asks to set again. Variables
+  set with "%env" that the loaded session doesn't name are unset.
+- "%source": displays the generated main.go as last rendered -- whether it compiled or not --,
+  with line numbers, syntax highlighting and the errors of the last compilation inline. Unlike
+  "%dryrun", it doesn't render the cell again.
+- "%store" and "%store reset": lists the keys (and sizes of the values) in the store shared by
+  the programs of all cells, or deletes all of them. Programs use gonbui.Store(key, value) and
+  gonbui.Load(key, &value) to share data across cells -- values are encoded with "encoding/gob".
+- "%%template [key=value ...]": the rest of the cell is a Go text/template, expanded into Go code
+  (in the format of a cell) that is then executed as usual. The template data has ".Args" (the
+  key=value arguments) and ".Env" (the environment variables, e.g.: set with "%env"), and the
+  functions "seq n" (0 to n-1), "upper", "lower", "join", "repeat", "split" and "trimSpace".
+  Use it with "%%dryrun" to see the generated code.
+- "%%html": the rest of the cell is displayed as HTML. It is an example of a cell transformer,
+  see goexec.RegisterCellTransformer.
+- "%%package <name>": the rest of the cell is written as the contents of the sub-package
+  <name> of the notebook module (the "package <name>" clause is optional). It can then be
+  imported by the following cells, with the import path printed. The package remains
+  defined until redefined.
 - "%reset": clears all memorized declarations (imports, functions, variables, types and 
   constants).
 - "%with_inputs": will prompt for inputs for the next shell command. Use this if
@@ -80,6 +324,15 @@ Executing shell commands:
 // cellStatus holds temporary status for the execution of the current cell.
 type cellStatus struct {
 	withInputs, withPassword bool
+
+	// openIfs is the number of `%%if` regions currently open.
+	openIfs int
+
+	// goVersion is the version of the Go toolchain (goexec.State.GoVersion), for conditions.
+	goVersion string
+
+	// execute is set if the cell is being executed, see Parse.
+	execute bool
 }
 
 // Parse will check whether the given code to be executed has any special commands.
@@ -89,12 +342,59 @@ type cellStatus struct {
 //
 // If any errors happen, it is returned in err.
 func Parse(msg kernel.Message, goExec *goexec.State, execute bool, codeLines []string, usedLines map[int]bool) (err error) {
-	status := &cellStatus{}
+	status := &cellStatus{execute: execute}
+	if goExec != nil {
+		status.goVersion = goExec.GoVersion
+	}
+	insideLiterals := goexec.LinesInsideLiterals(codeLines)
 	for lineNum := 0; lineNum < len(codeLines); lineNum++ {
-		if usedLines[lineNum] {
+		if usedLines[lineNum] || insideLiterals[lineNum] {
 			continue
 		}
 		line := codeLines[lineNum]
+		if isCellMagic(line) {
+			// Cell magics ("%%<name> ...") may take the rest of the cell as its body.
+			parts := splitCmd(strings.TrimSpace(line)[2:])
+			if len(parts) > 0 && isConditionalMagic(parts[0]) {
+				lineNum, err = execConditional(codeLines, lineNum, parts, usedLines, insideLiterals, status)
+				if err != nil {
+					return
+				}
+				continue
+			}
+			if len(parts) > 0 && parts[0] == "skipif" {
+				usedLines[lineNum] = true
+				if execute {
+					var skipped bool
+					if skipped, err = execSkipIf(msg, codeLines, lineNum, parts, usedLines, status); err != nil || skipped {
+						return
+					}
+				}
+				continue
+			}
+			if len(parts) > 0 && goexec.GetCellTransformer(parts[0]) != nil {
+				// The rest of the cell is transformed to Go code by goexec, if the transformer comes
+				// before any Go code.
+				_, err = goexec.CellTransformerLine(codeLines, usedLines)
+				return
+			}
+			usedLines[lineNum] = true
+			var body []string
+			if cellMagicTakesBody[parts[0]] {
+				body = codeLines[lineNum+1:]
+				for ii := lineNum + 1; ii < len(codeLines); ii++ {
+					usedLines[ii] = true
+				}
+				lineNum = len(codeLines)
+			}
+			if execute {
+				err = execCellMagic(msg, goExec, parts, body)
+				if err != nil {
+					return
+				}
+			}
+			continue
+		}
 		if len(line) > 1 && (line[0] == '%' || line[0] == '!') {
 			var cmdStr string
 			cmdStr = joinLine(codeLines, lineNum, usedLines)
@@ -123,9 +423,25 @@ func Parse(msg kernel.Message, goExec *goexec.State, execute bool, codeLines []s
 			}
 		}
 	}
+	if status.openIfs > 0 && execute {
+		err = errors.Errorf("`%%%%if` without matching `%%%%endif`")
+	}
 	return
 }
 
+// IsFormatCell returns whether one of the special commands of the cell is `%fmt`, given the lines
+// used by special commands (usedLines, as filled by Parse): the cell is then formatted (see
+// goexec.FormatCell) instead of executed, and Parse should be called with execute=false, so none
+// of its commands are executed either.
+func IsFormatCell(codeLines []string, usedLines map[int]bool) bool {
+	for lineNum := range usedLines {
+		if strings.TrimSpace(codeLines[lineNum]) == "%fmt" {
+			return true
+		}
+	}
+	return false
+}
+
 // joinLine starts from fromLine and joins consecutive lines if the current line terminates with a `\n`,
 // allowing multi-line commands to be issued.
 //
@@ -157,27 +473,334 @@ func execInternal(msg kernel.Message, goExec *goexec.State, cmdStr string, statu
 	case "args":
 		// Set arguments for execution, allows one to set flags, etc.
 		goExec.Args = parts[1:]
-		log.Printf("args=%+q", parts)
+		kernel.Debugf("args=%+q", parts)
 	case "env":
 		// Set environment variables.
 		if len(parts) != 3 {
 			return errors.Errorf("`%%env FOO bar` takes 2 arguments, the variable name and it's content. %d were given", len(parts))
 		}
 		os.Setenv(parts[1], parts[2])
+		goExec.UnsetSecret(parts[1]) // The latest setting of the variable takes precedence.
+		if goExec.Env == nil {
+			goExec.Env = make(map[string]string)
+		}
+		goExec.Env[parts[1]] = parts[2]
 	case "autoget":
 		goExec.AutoGet = true
 	case "noautoget":
 		goExec.AutoGet = false
+	case "autoprint":
+		if len(parts) != 2 || (parts[1] != "on" && parts[1] != "off") {
+			return errors.Errorf("`%%autoprint on|off` takes 1 argument, \"on\" or \"off\"")
+		}
+		goExec.AutoPrint = parts[1] == "on"
+	case "verbose":
+		if len(parts) != 2 || (parts[1] != "on" && parts[1] != "off") {
+			return errors.Errorf("`%%verbose on|off` takes 1 argument, \"on\" or \"off\"")
+		}
+		goExec.Verbose = parts[1] == "on"
+	case "fmt":
+		if len(parts) != 1 {
+			return errors.Errorf("`%%fmt` takes no arguments")
+		}
+		// Cells with `%fmt` are formatted instead of executed, see IsFormatCell.
+	case "incremental":
+		if len(parts) != 2 || (parts[1] != "on" && parts[1] != "off") {
+			return errors.Errorf("`%%incremental on|off` takes 1 argument, \"on\" or \"off\"")
+		}
+		goExec.Incremental = parts[1] == "on"
+	case "godebug":
+		if len(parts) == 1 {
+			if len(goExec.GoDebug) == 0 {
+				return kernel.PublishWriteStream(msg, kernel.StreamStdout, "No GODEBUG settings.\n")
+			}
+			return kernel.PublishWriteStream(msg, kernel.StreamStdout,
+				fmt.Sprintf("GODEBUG=%s\n", strings.Join(goExec.GoDebug, ",")))
+		}
+		if len(parts) == 2 && parts[1] == "reset" {
+			goExec.GoDebug = nil
+			return nil
+		}
+		return goExec.SetGoDebug(parts[1:])
+	case "debug":
+		switch {
+		case len(parts) == 2 && parts[1] == "off":
+			goExec.DebugAddress = ""
+		case (len(parts) == 2 || len(parts) == 3) && parts[1] == "on":
+			goExec.DebugAddress = goexec.DefaultDebugAddress
+			if len(parts) == 3 {
+				goExec.DebugAddress = parts[2]
+			}
+		default:
+			return errors.Errorf("`%%debug on [<address>]|off` takes \"on\" (with an optional address) or \"off\"")
+		}
+	case "prelude":
+		switch {
+		case len(parts) == 1:
+			keys := goExec.PreludeKeys()
+			if len(keys) == 0 {
+				return kernel.PublishWriteStream(msg, kernel.StreamStdout, "No prelude defined.\n")
+			}
+			return kernel.PublishWriteStream(msg, kernel.StreamStdout,
+				fmt.Sprintf("Prelude declarations: %s\n", strings.Join(keys, ", ")))
+		case len(parts) == 2 && parts[1] == "clear":
+			goExec.ClearPrelude()
+		default:
+			return errors.Errorf("`%%prelude [clear]` takes no arguments or \"clear\"")
+		}
+	case "cleanup":
+		switch {
+		case len(parts) == 1:
+			if len(goExec.Cleanups) == 0 {
+				return kernel.PublishWriteStream(msg, kernel.StreamStdout, "No cleanups registered.\n")
+			}
+			var report strings.Builder
+			report.WriteString("Cleanups executed at shutdown, in order:\n")
+			for ii := len(goExec.Cleanups) - 1; ii >= 0; ii-- {
+				fmt.Fprintf(&report, "* %s\n", firstCodeLine(goExec.Cleanups[ii].Lines))
+			}
+			return kernel.PublishWriteStream(msg, kernel.StreamStdout, report.String())
+		case len(parts) == 2 && parts[1] == "clear":
+			goExec.ClearCleanups()
+		default:
+			return errors.Errorf("`%%cleanup [clear]` takes no arguments or \"clear\"")
+		}
+	case "output":
+		switch {
+		case len(parts) == 1:
+			if goExec.OutputPath == "" {
+				return kernel.PublishWriteStream(msg, kernel.StreamStdout, "Programs are compiled to the temporary directory.\n")
+			}
+			return kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf("Programs are compiled to %s\n", goExec.OutputPath))
+		case len(parts) == 2 && parts[1] == "reset":
+			return goExec.SetOutputPath("")
+		case len(parts) == 2:
+			return goExec.SetOutputPath(parts[1])
+		default:
+			return errors.Errorf("`%%output <path>|reset` takes 1 argument, the path of the compiled program")
+		}
+	case "seed":
+		return execSeed(msg, goExec, parts[1:])
+	case "ldvar":
+		return execLinkerVar(msg, goExec, parts[1:])
+	case "vet":
+		if len(parts) != 2 || (parts[1] != "on" && parts[1] != "off") {
+			return errors.Errorf("`%%vet on|off` takes 1 argument, \"on\" or \"off\"")
+		}
+		goExec.Vet = parts[1] == "on"
+	case "goroutinedump":
+		if len(parts) != 2 || (parts[1] != "on" && parts[1] != "off") {
+			return errors.Errorf("`%%goroutinedump on|off` takes 1 argument, \"on\" or \"off\"")
+		}
+		goExec.GoroutineDump = parts[1] == "on"
+	case "goimports":
+		if len(parts) != 2 || (parts[1] != "on" && parts[1] != "off" && parts[1] != "warn") {
+			return errors.Errorf("`%%goimports on|off|warn` takes 1 argument, \"on\", \"off\" or \"warn\"")
+		}
+		goExec.SkipGoImports = parts[1] == "off"
+		goExec.WarnGoImportsRewrites = parts[1] == "warn"
+	case "gobin":
+		if len(parts) == 1 {
+			return kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf("go binary: %s (%s)\n", goExec.GoBinary, goExec.GoVersion))
+		}
+		if len(parts) != 2 {
+			return errors.Errorf("`%%gobin /path/to/go` takes 1 argument, the path to the go binary. %d were given", len(parts)-1)
+		}
+		return goExec.SetGoBinary(parts[1])
+	case "gowork":
+		if len(parts) == 1 {
+			if goExec.GoWork == "" {
+				return kernel.PublishWriteStream(msg, kernel.StreamStdout, "Not using a go.work workspace.\n")
+			}
+			return kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf("Using go.work workspace %s\n", goExec.GoWork))
+		}
+		if len(parts) != 2 {
+			return errors.Errorf("`%%gowork <path/to/go.work>|reset` takes 1 argument. %d were given", len(parts)-1)
+		}
+		if parts[1] == "reset" {
+			return goExec.ResetGoWork()
+		}
+		modules, err := goExec.SetGoWork(parts[1])
+		if err != nil {
+			return err
+		}
+		var report strings.Builder
+		fmt.Fprintf(&report, "Using go.work workspace %s, with modules:\n", goExec.GoWork)
+		for _, module := range modules {
+			fmt.Fprintf(&report, "- %s (%s)\n", module.Path, module.Dir)
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, report.String())
+	case "goget_retries":
+		if len(parts) != 2 {
+			return errors.Errorf("`%%goget_retries <n>` takes 1 argument, the number of retries. %d were given", len(parts)-1)
+		}
+		retries, err := strconv.Atoi(parts[1])
+		if err != nil || retries < 0 || retries > goexec.MaxGoGetRetries {
+			return errors.Errorf("`%%goget_retries <n>` requires an integer from 0 to %d, got %q",
+				goexec.MaxGoGetRetries, parts[1])
+		}
+		goExec.GoGetRetries = retries
 	case "help":
 		_ = kernel.PublishWriteStream(msg, kernel.StreamStdout, HelpMessage)
 	case "main":
 		// Handled by goexec, nothing to do here.
+	case "size_warning":
+		return execSizeWarning(msg, goExec, parts[1:])
+	case "output_limit":
+		return execOutputLimit(msg, goExec, parts[1:])
+	case "ansi":
+		if len(parts) != 2 {
+			return errors.Errorf("`%%ansi raw|strip|html` takes 1 argument")
+		}
+		mode, err := kernel.ParseANSIMode(parts[1])
+		if err != nil {
+			return err
+		}
+		goExec.ANSIMode = mode
+	case "limit":
+		return execLimit(msg, goExec, parts[1:])
+	case "dot-import":
+		return execDotImport(msg, goExec, parts[1:])
+	case "importpref":
+		if len(parts) == 1 {
+			prefs := goExec.ListImportPreferences()
+			if len(prefs) == 0 {
+				return kernel.PublishWriteStream(msg, kernel.StreamStdout, "No import preferences set.\n")
+			}
+			return kernel.PublishWriteStream(msg, kernel.StreamStdout, strings.Join(prefs, "\n")+"\n")
+		}
+		for _, arg := range parts[1:] {
+			name, importPath, found := strings.Cut(arg, "=")
+			if !found {
+				return errors.Errorf("`%%importpref name=path` arguments must be in the form name=path, got %q", arg)
+			}
+			if err := goExec.SetImportPreference(name, importPath); err != nil {
+				return err
+			}
+		}
+	case "source_on_error":
+		if len(parts) != 2 {
+			return errors.Errorf("`%%source_on_error <off|context|full>` takes 1 argument. %d were given", len(parts)-1)
+		}
+		switch parts[1] {
+		case "off":
+			goExec.SourceOnError = goexec.SourceOnErrorOff
+		case "context":
+			goExec.SourceOnError = goexec.SourceOnErrorContext
+		case "full":
+			goExec.SourceOnError = goexec.SourceOnErrorFull
+		default:
+			return errors.Errorf("`%%source_on_error` takes one of off, context or full, got %q", parts[1])
+		}
+	case "fuzz":
+		if len(parts) != 2 && len(parts) != 3 {
+			return errors.Errorf("`%%fuzz FuzzName [fuzztime]` takes 1 or 2 arguments. %d were given", len(parts)-1)
+		}
+		goExec.Cell.Fuzz = parts[1]
+		if len(parts) == 3 {
+			fuzzTime, err := time.ParseDuration(parts[2])
+			if err != nil || fuzzTime <= 0 {
+				return errors.Errorf("`%%fuzz FuzzName [fuzztime]` requires a positive duration (e.g. 30s), got %q", parts[2])
+			}
+			goExec.Cell.FuzzTime = fuzzTime
+		}
+	case "append":
+		if len(parts) != 2 {
+			return errors.Errorf("`%%append <funcName>` takes 1 argument, the name of the function. %d were given", len(parts)-1)
+		}
+		goExec.Cell.Append = parts[1]
+	case "files":
+		if len(parts) == 2 && parts[1] == "clear" {
+			return goExec.ClearFiles()
+		}
+		if len(parts) != 1 {
+			return errors.Errorf("`%%files` takes no arguments, or \"clear\" to remove the files")
+		}
+		files := goExec.ListFiles()
+		if len(files) == 0 {
+			return kernel.PublishWriteStream(msg, kernel.StreamStdout, "No files defined.\n")
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, strings.Join(files, "\n")+"\n")
+	case "stubmain":
+		body := strings.TrimSpace(strings.TrimPrefix(cmdStr, parts[0]))
+		switch body {
+		case "":
+			return kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf("stub main body: %s\n", goExec.StubMainBody))
+		case "reset":
+			goExec.StubMainBody = goexec.DefaultStubMainBody
+		default:
+			goExec.StubMainBody = body
+		}
+	case "watch":
+		if len(parts) == 1 {
+			if dir := goExec.WatchedDir(); dir != "" {
+				return kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf("Watching %s\n", dir))
+			}
+			return kernel.PublishWriteStream(msg, kernel.StreamStdout, "Not watching any directory.\n")
+		}
+		if len(parts) != 2 {
+			return errors.Errorf("`%%watch <dir>` takes 1 argument, the directory to watch. %d were given", len(parts)-1)
+		}
+		if parts[1] == "stop" {
+			goExec.StopWatch()
+			return kernel.PublishWriteStream(msg, kernel.StreamStdout, "* Watch stopped.\n")
+		}
+		goExec.Cell.Watch = parts[1]
+	case "parameters":
+		goExec.Cell.Parameters = true
+	case "export":
+		if len(parts) != 2 {
+			return errors.Errorf("`%%export <dir>` takes 1 argument, the directory to export to. %d were given", len(parts)-1)
+		}
+		return goExec.Export(msg, parts[1])
+	case "display":
+		if len(parts) == 1 {
+			names := goExec.ListCaptured()
+			if len(names) == 0 {
+				return kernel.PublishWriteStream(msg, kernel.StreamStdout, "No displays captured.\n")
+			}
+			return kernel.PublishWriteStream(msg, kernel.StreamStdout, strings.Join(names, "\n")+"\n")
+		}
+		for _, name := range parts[1:] {
+			if err := goExec.DisplayCaptured(msg, name); err != nil {
+				return err
+			}
+		}
+	case "freeze":
+		return goExec.Freeze(msg, parts[1:])
+	case "source":
+		if len(parts) != 1 {
+			return errors.Errorf("`%%source` takes no arguments")
+		}
+		return goExec.DisplaySource(msg)
+	case "store":
+		return execStore(msg, parts[1:])
+	case "session":
+		return execSession(msg, goExec, parts[1:])
+	case "rebuild":
+		return goExec.Rebuild(msg)
+	case "refresh":
+		return goExec.Refresh(msg)
+	case "who":
+		return execWho(msg, goExec)
+	case "share":
+		return goExec.Share(msg)
+	case "kill":
+		if err := goExec.KillAll(); err != nil {
+			return err
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, "* Last program, programs in background and any processes they spawned killed.\n")
 	case "reset":
 		goExec.Reset()
 		err := kernel.PublishWriteStream(msg, kernel.StreamStdout, "* State reset: all memorized declarations discarded.\n")
 		if err != nil {
-			log.Printf("Error while reseting kernel: %+v", err)
+			kernel.Logf(kernel.LogLevelError, "Error while reseting kernel: %+v", err)
+		}
+	case "secret":
+		if len(parts) != 2 {
+			return errors.Errorf("`%%secret VAR` takes 1 argument, the variable name -- the value is prompted for. %d were given", len(parts)-1)
 		}
+		return promptSecret(msg, goExec, parts[1])
 	case "with_inputs":
 		allowInput := content["allow_stdin"].(bool)
 		if !allowInput && (status.withInputs || status.withPassword) {
@@ -193,13 +816,284 @@ func execInternal(msg kernel.Message, goExec *goexec.State, cmdStr string, statu
 	default:
 		err := kernel.PublishWriteStream(msg, kernel.StreamStderr, fmt.Sprintf("\"%%%s\" unknown or not implemented yet.", parts[0]))
 		if err != nil {
-			log.Printf("Error while reporting back on unimplmented message command \"%%%s\" kernel: %+v", parts[0], err)
+			kernel.Logf(kernel.LogLevelError, "Error while reporting back on unimplmented message command \"%%%s\" kernel: %+v", parts[0], err)
 		}
 	}
 	return nil
 }
 
-// execInternal executes internal configuration commands, see HelpMessage for details.
+// execOutputLimit handles the `%output_limit` special command.
+func execOutputLimit(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) == 1 && args[0] == "off" {
+		goExec.OutputLimits = kernel.OutputLimits{}
+		args = nil
+	}
+	limits := goExec.OutputLimits
+	for _, arg := range args {
+		key, value, found := strings.Cut(arg, "=")
+		if !found {
+			return errors.Errorf("%%output_limit arguments must be in the form key=value, got %q", arg)
+		}
+		var err error
+		switch key {
+		case "lines":
+			limits.MaxLines, err = strconv.Atoi(value)
+		case "bytes":
+			limits.MaxBytes, err = strconv.Atoi(value)
+		case "spill":
+			limits.ServeSpilled = value == "link"
+			if limits.ServeSpilled {
+				limits.SpillToFile = true
+			} else {
+				limits.SpillToFile, err = strconv.ParseBool(value)
+			}
+		default:
+			return errors.Errorf("%%output_limit unknown key %q, valid keys are lines, bytes and spill", key)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "%%output_limit invalid value for %q", key)
+		}
+	}
+	goExec.OutputLimits = limits
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf("Output limits: %s\n", limits))
+}
+
+// execSizeWarning handles the `%size_warning` special command.
+func execSizeWarning(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) == 1 && args[0] == "off" {
+		goExec.SizeWarning = goexec.SizeWarning{}
+		args = nil
+	}
+	sizeWarning := goExec.SizeWarning
+	for _, arg := range args {
+		key, value, found := strings.Cut(arg, "=")
+		if !found {
+			return errors.Errorf("%%size_warning arguments must be in the form key=value, got %q", arg)
+		}
+		var err error
+		switch key {
+		case "lines":
+			sizeWarning.Lines, err = strconv.Atoi(value)
+		case "decls":
+			sizeWarning.Decls, err = strconv.Atoi(value)
+		default:
+			return errors.Errorf("%%size_warning unknown key %q, valid keys are lines and decls", key)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "%%size_warning invalid value for %q", key)
+		}
+	}
+	goExec.SizeWarning = sizeWarning
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf("Size warning: %s\n", sizeWarning))
+}
+
+// execStore handles the `%store` special command.
+func execStore(msg kernel.Message, args []string) error {
+	k := msg.Kernel()
+	if k == nil {
+		return errors.Errorf("%%store not available, no kernel")
+	}
+	store, err := k.Store()
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 && args[0] == "reset" {
+		return store.Reset()
+	} else if len(args) != 0 {
+		return errors.Errorf("%%store takes no arguments, or \"reset\", got %q", args)
+	}
+	keys, sizes := store.Sizes()
+	if len(keys) == 0 {
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, "Store is empty.\n")
+	}
+	var buf strings.Builder
+	for ii, key := range keys {
+		fmt.Fprintf(&buf, "%q: %s\n", key, kernel.FormatMemorySize(int64(sizes[ii])))
+	}
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout, buf.String())
+}
+
+// execDotImport handles the `%dot-import` special command.
+func execDotImport(msg kernel.Message, goExec *goexec.State, args []string) error {
+	switch {
+	case len(args) == 0:
+		paths := goExec.DotImports()
+		if len(paths) == 0 {
+			return kernel.PublishWriteStream(msg, kernel.StreamStdout, "No dot imports.\n")
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, strings.Join(paths, "\n")+"\n")
+	case len(args) == 2 && args[0] == "remove":
+		return goExec.RemoveDotImport(args[1])
+	case len(args) == 1:
+		if err := goExec.DotImport(args[0]); err != nil {
+			return err
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStderr, fmt.Sprintf(
+			"* Names exported by %q can now be used unqualified. Beware they may collide with names declared\n"+
+				"  in the cells or in other dot-imported packages, causing confusing compilation errors.\n"+
+				"  Use \"%%dot-import remove %s\" to undo it.\n", args[0], args[0]))
+	}
+	return errors.Errorf("`%%dot-import [remove] <path>` takes an import path, got %q", args)
+}
+
+// execSeed handles the `%seed` special command.
+func execSeed(msg kernel.Message, goExec *goexec.State, args []string) error {
+	switch {
+	case len(args) == 0:
+		if goExec.Seed == nil {
+			return kernel.PublishWriteStream(msg, kernel.StreamStdout, "No seed set.\n")
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf("%s = %d\n", goexec.SeedVariable, *goExec.Seed))
+	case len(args) == 1 && args[0] == "off":
+		goExec.ClearSeed()
+		return nil
+	case len(args) <= 2:
+		seed, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			break
+		}
+		var goMaxProcs int
+		if len(args) == 2 {
+			value, found := strings.CutPrefix(args[1], "gomaxprocs=")
+			if goMaxProcs, err = strconv.Atoi(value); !found || err != nil || goMaxProcs < 1 {
+				break
+			}
+		}
+		goExec.SetSeed(seed, goMaxProcs)
+		return nil
+	}
+	return errors.Errorf("`%%seed <n> [gomaxprocs=<k>]|off` takes an integer seed, got %q", args)
+}
+
+// execLinkerVar handles the `%ldvar` special command.
+func execLinkerVar(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) == 0 {
+		keys := goExec.LinkerVarsKeys()
+		if len(keys) == 0 {
+			return kernel.PublishWriteStream(msg, kernel.StreamStdout, "No variables set by the linker.\n")
+		}
+		var report strings.Builder
+		for _, name := range keys {
+			fmt.Fprintf(&report, "%s = %q\n", name, goExec.LinkerVars[name])
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, report.String())
+	}
+	if len(args) == 1 && args[0] == "reset" {
+		goExec.ClearLinkerVars()
+		return nil
+	}
+	for _, arg := range args {
+		name, value, found := strings.Cut(arg, "=")
+		if !found {
+			return errors.Errorf("`%%ldvar <name>=<value> ...` arguments must be in the form name=value, got %q", arg)
+		}
+		if err := goExec.SetLinkerVar(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execSession handles the `%session` special command.
+func execSession(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) == 2 && args[0] == "save" {
+		if err := goExec.SaveSession(args[1]); err != nil {
+			return err
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf("* Session %q saved.\n", args[1]))
+	} else if len(args) == 2 && args[0] == "load" {
+		unsetEnv, err := goExec.LoadSession(msg, args[1])
+		if err != nil {
+			return err
+		}
+		loaded := fmt.Sprintf("* Session %q loaded.\n", args[1])
+		if len(unsetEnv) > 0 {
+			loaded += fmt.Sprintf("* Environment variable values are not saved, set them again with %%env: %s\n",
+				strings.Join(unsetEnv, ", "))
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, loaded)
+	} else if len(args) != 1 || args[0] != "list" {
+		return errors.Errorf("`%%session save <name>|load <name>|list` takes a command, got %q", args)
+	}
+	sessions, err := goExec.ListSessions()
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, "No sessions saved.\n")
+	}
+	var buf strings.Builder
+	for _, session := range sessions {
+		fmt.Fprintf(&buf, "%s (saved %s)\n", session.Name, session.ModTime.Format("2006-01-02 15:04:05"))
+	}
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout, buf.String())
+}
+
+// execLimit handles the `%limit` special command.
+func execLimit(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) == 1 && args[0] == "off" {
+		goExec.ResourceLimits = kernel.ResourceLimits{}
+		args = nil
+	}
+	limits := goExec.ResourceLimits
+	for _, arg := range args {
+		key, value, found := strings.Cut(arg, "=")
+		if !found {
+			return errors.Errorf("%%limit arguments must be in the form key=value, got %q", arg)
+		}
+		var err error
+		switch key {
+		case "mem":
+			limits.Memory, err = kernel.ParseMemorySize(value)
+		case "cpu":
+			if value == "0" {
+				limits.CPU = 0
+			} else {
+				limits.CPU, err = time.ParseDuration(value)
+			}
+		default:
+			return errors.Errorf("%%limit unknown key %q, valid keys are mem and cpu", key)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "%%limit invalid value for %q", key)
+		}
+	}
+	goExec.ResourceLimits = limits
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf("Resource limits: %s\n", limits))
+}
+
+// promptSecret asks the user for the value of the secret `name`, with a password (hidden) input
+// prompt, and waits for the answer.
+func promptSecret(msg kernel.Message, goExec *goexec.State, name string) error {
+	content := msg.ComposedMsg().Content.(map[string]any)
+	if allowInput, _ := content["allow_stdin"].(bool); !allowInput {
+		return errors.Errorf("%%secret not available in this notebook, it doesn't allow input prompting")
+	}
+	valueChan := make(chan string, 1)
+	err := msg.PromptInput(fmt.Sprintf("Value for secret %s: ", name), true,
+		func(original, input *kernel.MessageImpl) error {
+			content := input.Composed.Content.(map[string]any)
+			value, _ := content["value"].(string)
+			select {
+			case valueChan <- value:
+			default:
+				// Secret already received, ignore.
+			}
+			return nil
+		})
+	if err != nil {
+		return errors.WithMessagef(err, "prompting for secret %q", name)
+	}
+	select {
+	case value := <-valueChan:
+		goExec.SetSecret(name, value)
+	case <-msg.Kernel().StoppedChan():
+		return errors.Errorf("kernel stopped while waiting for secret %q", name)
+	}
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf("* Secret %s set.\n", name))
+}
+
+// execShell executes shell commands, see HelpMessage for details.
 //
 // It only returns errors for system errors that will lead to the kernel restart. Syntax errors
 // on the command themselves are simply reported back to jupyter and are not returned here.
@@ -209,17 +1103,18 @@ func execShell(msg kernel.Message, goExec *goexec.State, cmdStr string, status *
 		cmdStr = cmdStr[1:]
 		execDir = goExec.TempDir
 	}
+	builder := kernel.NewPipeExecToJupyterBuilder(msg, "/bin/bash", "-c", cmdStr).
+		InDir(execDir).
+		WithOutputLimits(goExec.OutputLimits).
+		WithANSIMode(goExec.ANSIMode)
 	if status.withInputs {
-		status.withInputs = false
-		status.withPassword = false
-		return kernel.PipeExecToJupyterWithInput(msg, execDir, "/bin/bash", "-c", cmdStr)
+		builder.WithInputs(500)
 	} else if status.withPassword {
-		status.withInputs = false
-		status.withPassword = false
-		return kernel.PipeExecToJupyterWithPassword(msg, execDir, "/bin/bash", "-c", cmdStr)
-	} else {
-		return kernel.PipeExecToJupyter(msg, execDir, "/bin/bash", "-c", cmdStr)
+		builder.WithPassword()
 	}
+	status.withInputs = false
+	status.withPassword = false
+	return builder.Exec()
 }
 
 // splitCmd split the special command into it's parts separated by space(s). It also
@@ -280,3 +1175,30 @@ func splitCmd(cmd string) (parts []string) {
 	}
 	return
 }
+
+// execWho implements `%who`: it lists the variables declared in the cells, in a table.
+func execWho(msg kernel.Message, goExec *goexec.State) error {
+	vars := goExec.ListVariables()
+	if len(vars) == 0 {
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, "No variables defined.\n")
+	}
+	var buf strings.Builder
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "Variable\tType\tValue")
+	fmt.Fprintln(w, "--------\t----\t-----")
+	for _, v := range vars {
+		typeStr := v.Type
+		if typeStr == "" {
+			typeStr = "(inferred)"
+		}
+		value := strings.Join(strings.Fields(v.Value), " ")
+		if runes := []rune(value); len(runes) > 40 {
+			value = string(runes[:37]) + "..."
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", v.Name, typeStr, value)
+	}
+	if err := w.Flush(); err != nil {
+		return errors.Wrapf(err, "formatting variables table")
+	}
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout, buf.String())
+}
@@ -2,9 +2,13 @@ package specialcmd
 
 import (
 	"fmt"
+	"github.com/janpfeifer/gonb/goexec"
+	"github.com/janpfeifer/gonb/kernel"
 	"github.com/stretchr/testify/require"
 	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -25,3 +29,240 @@ func TestSplitCmd(t *testing.T) {
 	assert.Equal(t, "--msg2=it replied \"\nhello\t\"", parts[1])
 	assert.Equal(t, "", parts[2])
 }
+
+func TestIsCellMagic(t *testing.T) {
+	assert.True(t, isCellMagic("%%package mymath"))
+	assert.True(t, isCellMagic("  %%package mymath  "))
+	assert.False(t, isCellMagic("%%"))
+	assert.False(t, isCellMagic("%% "))
+	assert.False(t, isCellMagic("%main"))
+	assert.False(t, isCellMagic("%% fmt.Println()"))
+}
+
+func TestParseSkipsLiterals(t *testing.T) {
+	lines := []string{
+		"%env X 1",
+		"var s = `",
+		"%env Y 2",
+		"!ls",
+		"`",
+		"!ls",
+	}
+	usedLines := make(map[int]bool)
+	require.NoError(t, Parse(nil, nil, false, lines, usedLines))
+	assert.Equal(t, map[int]bool{0: true, 5: true}, usedLines)
+}
+
+func TestParseConditionals(t *testing.T) {
+	t.Setenv("GONB_TEST_FLAG", "yes")
+	lines := []string{
+		"%%if env.GONB_TEST_FLAG == yes", // 0
+		"a",                              // 1
+		"%%if goos == plan9_or_not",      // 2
+		"b",                              // 3
+		"%%else",                         // 4
+		"c",                              // 5
+		"%%endif",                        // 6
+		"%%else",                         // 7
+		"d",                              // 8
+		"%%if goos",                      // 9: nested in excluded region.
+		"e",                              // 10
+		"%%endif",                        // 11
+		"%%endif",                        // 12
+		"%%if !env.GONB_TEST_UNDEFINED",  // 13
+		"f",                              // 14
+		"%%endif",                        // 15
+	}
+	usedLines := make(map[int]bool)
+	require.NoError(t, Parse(nil, nil, false, lines, usedLines))
+	var included []string
+	for ii, line := range lines {
+		if !usedLines[ii] {
+			included = append(included, line)
+		}
+	}
+	assert.Equal(t, []string{"a", "c", "f"}, included)
+
+	for _, lines := range [][]string{
+		{"%%if goos", "a"},
+		{"%%endif"},
+		{"%%else"},
+		{"%%if goos <> x"},
+		{"%%if goos != x", "%%else", "a"},
+	} {
+		assert.Error(t, Parse(nil, nil, true, lines, make(map[int]bool)), "lines: %q", lines)
+		// Not executing (e.g.: auto-complete of a cell being edited): errors are tolerated.
+		assert.NoError(t, Parse(nil, nil, false, lines, make(map[int]bool)), "lines: %q", lines)
+	}
+}
+
+func TestCellAutoGet(t *testing.T) {
+	goExec := &goexec.State{AutoGet: false}
+	require.NoError(t, Parse(nil, goExec, true, []string{"%%autoget on", "import _ \"example.com/dep\""}, make(map[int]bool)))
+	require.NotNil(t, goExec.Cell.AutoGet)
+	assert.True(t, *goExec.Cell.AutoGet)
+	assert.False(t, goExec.AutoGet, "%%autoget shouldn't change the default of the session")
+	goExec.ResetCell()
+	assert.Nil(t, goExec.Cell.AutoGet)
+
+	assert.Error(t, Parse(nil, goExec, true, []string{"%%autoget maybe"}, make(map[int]bool)))
+}
+
+func TestSkipIf(t *testing.T) {
+	lines := []string{"%%skipif goversion<1.21", "%env X 1", "func f() {}"}
+	goExec := &goexec.State{GoVersion: "go1.20.5"}
+	usedLines := make(map[int]bool)
+	require.NoError(t, Parse(nil, goExec, true, lines, usedLines))
+	assert.Equal(t, map[int]bool{0: true, 1: true, 2: true}, usedLines)
+
+	goExec.GoVersion = "go1.21.0"
+	usedLines = make(map[int]bool)
+	require.NoError(t, Parse(nil, goExec, false, lines, usedLines))
+	assert.Equal(t, map[int]bool{0: true, 1: true}, usedLines)
+
+	assert.Error(t, Parse(nil, goExec, true, []string{"%%skipif"}, make(map[int]bool)))
+	assert.Error(t, Parse(nil, goExec, true, []string{"%%skipif goos < 1.21"}, make(map[int]bool)))
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.21", "1.21.0", 0},
+		{"go1.20.5", "1.21", -1},
+		{"1.21rc1", "1.20.14", 1},
+		{"2", "1.99", 1},
+	} {
+		got, err := compareVersions(tc.a, tc.b)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, got, "compareVersions(%q, %q)", tc.a, tc.b)
+	}
+	_, err := compareVersions("linux", "1.21")
+	assert.Error(t, err)
+}
+
+func TestCellSkip(t *testing.T) {
+	goExec := &goexec.State{}
+	usedLines := make(map[int]bool)
+	require.NoError(t, Parse(nil, goExec, true, []string{"%%skip", "func f() {}"}, usedLines))
+	assert.True(t, goExec.Cell.Skip)
+	assert.Equal(t, map[int]bool{0: true}, usedLines)
+	assert.Error(t, Parse(nil, goExec, true, []string{"%%skip now"}, make(map[int]bool)))
+}
+
+// inputMessage is a kernel.Message that answers input prompts with a fixed value, and records
+// what is published. Methods not implemented panic.
+type inputMessage struct {
+	kernel.Message
+	allowStdin bool
+	input      string
+	prompts    []string
+	published  []string
+}
+
+func (m *inputMessage) ComposedMsg() kernel.ComposedMsg {
+	return kernel.ComposedMsg{Content: map[string]any{"allow_stdin": m.allowStdin}}
+}
+
+func (m *inputMessage) Kernel() *kernel.Kernel { return &kernel.Kernel{} }
+
+func (m *inputMessage) PromptInput(prompt string, password bool, onInput kernel.OnInputFn) error {
+	m.prompts = append(m.prompts, prompt)
+	input := &kernel.MessageImpl{Composed: kernel.ComposedMsg{Content: map[string]any{"value": m.input}}}
+	return onInput(nil, input)
+}
+
+func (m *inputMessage) Publish(msgType string, content any) error {
+	m.published = append(m.published, fmt.Sprintf("%s: %+v", msgType, content))
+	return nil
+}
+
+func TestSecret(t *testing.T) {
+	goExec := &goexec.State{}
+	msg := &inputMessage{allowStdin: true, input: "s3cr3t"}
+	require.NoError(t, Parse(msg, goExec, true, []string{"%secret API_TOKEN"}, make(map[int]bool)))
+	assert.Equal(t, []string{"Value for secret API_TOKEN: "}, msg.prompts)
+	assert.Equal(t, map[string]string{"API_TOKEN": "s3cr3t"}, goExec.Secrets)
+	assert.NotContains(t, strings.Join(msg.published, ""), "s3cr3t")
+	assert.Equal(t, "token="+goexec.RedactedSecret, goExec.RedactSecrets("token=s3cr3t"))
+
+	// A later %env of the same variable takes precedence.
+	t.Setenv("API_TOKEN", "")
+	require.NoError(t, Parse(msg, goExec, true, []string{"%env API_TOKEN public"}, make(map[int]bool)))
+	assert.Empty(t, goExec.Secrets)
+
+	assert.Error(t, Parse(msg, goExec, true, []string{"%secret"}, make(map[int]bool)))
+	assert.Error(t, Parse(&inputMessage{}, goExec, true, []string{"%secret API_TOKEN"}, make(map[int]bool)))
+}
+
+func TestKill(t *testing.T) {
+	goExec := &goexec.State{}
+	msg := &inputMessage{}
+	require.NoError(t, Parse(msg, goExec, true, []string{"%kill"}, make(map[int]bool)))
+	assert.Contains(t, strings.Join(msg.published, ""), "killed")
+}
+
+func TestWho(t *testing.T) {
+	goExec := &goexec.State{Decls: goexec.NewDeclarations()}
+	msg := &inputMessage{}
+	require.NoError(t, Parse(msg, goExec, true, []string{"%who"}, make(map[int]bool)))
+	assert.Contains(t, strings.Join(msg.published, ""), "No variables defined.")
+
+	// Long values are truncated on rune boundaries.
+	value := `"` + strings.Repeat("é", 50) + `"`
+	goExec.Decls.Variables["s"] = &goexec.Variable{Key: "s", Name: "s", ValueDefinition: value}
+	msg = &inputMessage{}
+	require.NoError(t, Parse(msg, goExec, true, []string{"%who"}, make(map[int]bool)))
+	published := strings.Join(msg.published, "")
+	assert.True(t, utf8.ValidString(published))
+	assert.Contains(t, published, `"`+strings.Repeat("é", 36)+"...")
+}
+
+func TestParseCellTransformer(t *testing.T) {
+	// Special commands before the transformer are executed, and the rest of the cell is its body.
+	usedLines := make(map[int]bool)
+	require.NoError(t, Parse(nil, nil, false, []string{"%env X 1", "%%html", "<b>%env Y 2</b>"}, usedLines))
+	assert.Equal(t, map[int]bool{0: true}, usedLines)
+
+	// A transformer after Go code is an error, the same as when the cell is executed.
+	lines := []string{"var x = 1", "%%html", "<b>bold</b>"}
+	assert.Error(t, Parse(nil, nil, false, lines, make(map[int]bool)))
+	_, err := goexec.CellTransformerLine(lines, nil)
+	assert.Error(t, err)
+}
+
+func TestFuzzArgs(t *testing.T) {
+	goExec := &goexec.State{}
+	msg := &inputMessage{}
+	require.NoError(t, Parse(msg, goExec, true, []string{"%fuzz FuzzX"}, make(map[int]bool)))
+	assert.Equal(t, "FuzzX", goExec.Cell.Fuzz)
+	assert.Equal(t, time.Duration(0), goExec.Cell.FuzzTime)
+	require.NoError(t, Parse(msg, goExec, true, []string{"%fuzz FuzzY 30s"}, make(map[int]bool)))
+	assert.Equal(t, "FuzzY", goExec.Cell.Fuzz)
+	assert.Equal(t, 30*time.Second, goExec.Cell.FuzzTime)
+
+	for _, line := range []string{"%fuzz", "%fuzz FuzzX 30s extra", "%fuzz FuzzX soon", "%fuzz FuzzX -1s"} {
+		assert.Error(t, Parse(msg, &goexec.State{}, true, []string{line}, make(map[int]bool)), "line: %q", line)
+	}
+}
+
+func TestCellDryRun(t *testing.T) {
+	goExec := &goexec.State{}
+	usedLines := make(map[int]bool)
+	require.NoError(t, Parse(nil, goExec, true, []string{"%%dryrun", "func f() {}"}, usedLines))
+	assert.True(t, goExec.Cell.DryRun)
+	assert.Equal(t, map[int]bool{0: true}, usedLines)
+}
+
+func TestRebuildCommand(t *testing.T) {
+	goExec, err := goexec.NewState(goexec.WithTempDir(t.TempDir()), goexec.WithAutoGet(false))
+	require.NoError(t, err)
+	if goExec.GoToolchainError() != nil {
+		t.Skipf("go toolchain not available: %v", goExec.GoToolchainError())
+	}
+	goExec.SkipGoImports = true // goimports may not be installed.
+	msg := &inputMessage{}
+	require.NoError(t, Parse(msg, goExec, true, []string{"%rebuild"}, make(map[int]bool)))
+	assert.Contains(t, strings.Join(msg.published, ""), "Rebuilt successfully")
+}